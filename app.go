@@ -14,6 +14,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
+	"github.com/zohu/zgin/zch"
 	"github.com/zohu/zgin/zutil"
 	"github.com/zohu/zlog"
 	"golang.org/x/net/http2"
@@ -102,6 +103,8 @@ func (app *App) Listen() {
 		f()
 	}
 	_ = app.server.Shutdown(ctx)
+	// 请求处理完后再保存内存快照
+	zch.Close()
 	zlog.Infof("serve closed")
 }
 
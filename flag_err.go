@@ -11,23 +11,28 @@ package zgin
 // 客户端错误响应 (400–499)
 // 服务端错误响应 (500–599)
 const (
-	MessageSuccess              MessageID = "1:ok"
-	MessageParamInvalid         MessageID = "400:MessageParamInvalid"
-	MessageLoginUnsupportedMode MessageID = "401:MessageLoginUnsupportedMode"
-	MessageLoginFailed          MessageID = "401:MessageLoginFailed"
-	MessageLoginTimeout         MessageID = "401:MessageLoginTimeout"
-	MessageLoginIDUsed          MessageID = "401:MessageLoginIDUsed"
-	MessageLoginTokenInvalid    MessageID = "401:MessageLoginTokenInvalid"
-	MessageLoginSessionInvalid  MessageID = "401:MessageLoginSessionInvalid"
-	MessageActionInvalid        MessageID = "403:MessageActionInvalid"
-	MessagePathInvalid          MessageID = "404:MessagePathInvalid"
-	MessageMethodInvalid        MessageID = "405:MessageMethodInvalid"
-	MessageRequestInvalid       MessageID = "500:MessageRequestInvalid"
-	MessageNotImplemented       MessageID = "501:MessageNotImplemented"
-	MessageTimeout              MessageID = "504:MessageTimeout"
-	MessageCreateFailed         MessageID = "600:MessageCreateFailed"
-	MessageUpdateFailed         MessageID = "601:MessageUpdateFailed"
-	MessageSaveFailed           MessageID = "602:MessageSaveFailed"
-	MessageDeleteFailed         MessageID = "603:MessageDeleteFailed"
-	MessageQueryFailed          MessageID = "604:MessageQueryFailed"
+	MessageSuccess                MessageID = "1:ok"
+	MessageParamInvalid           MessageID = "400:MessageParamInvalid"
+	MessageLoginUnsupportedMode   MessageID = "401:MessageLoginUnsupportedMode"
+	MessageLoginFailed            MessageID = "401:MessageLoginFailed"
+	MessageLoginTimeout           MessageID = "401:MessageLoginTimeout"
+	MessageLoginIDUsed            MessageID = "401:MessageLoginIDUsed"
+	MessageLoginTokenInvalid      MessageID = "401:MessageLoginTokenInvalid"
+	MessageLoginSessionInvalid    MessageID = "401:MessageLoginSessionInvalid"
+	MessageApiKeyInvalid          MessageID = "401:MessageApiKeyInvalid"
+	MessageApiKeySignatureInvalid MessageID = "401:MessageApiKeySignatureInvalid"
+	MessageActionInvalid          MessageID = "403:MessageActionInvalid"
+	MessageCsrfInvalid            MessageID = "403:MessageCsrfInvalid"
+	MessageTenantInvalid          MessageID = "403:MessageTenantInvalid"
+	MessagePathInvalid            MessageID = "404:MessagePathInvalid"
+	MessageMethodInvalid          MessageID = "405:MessageMethodInvalid"
+	MessageTooManyRequests        MessageID = "429:MessageTooManyRequests"
+	MessageRequestInvalid         MessageID = "500:MessageRequestInvalid"
+	MessageNotImplemented         MessageID = "501:MessageNotImplemented"
+	MessageTimeout                MessageID = "504:MessageTimeout"
+	MessageCreateFailed           MessageID = "600:MessageCreateFailed"
+	MessageUpdateFailed           MessageID = "601:MessageUpdateFailed"
+	MessageSaveFailed             MessageID = "602:MessageSaveFailed"
+	MessageDeleteFailed           MessageID = "603:MessageDeleteFailed"
+	MessageQueryFailed            MessageID = "604:MessageQueryFailed"
 )
@@ -43,10 +43,13 @@ func New(options *Options) {
 // Submit
 // @Description: 提交一个函数到池中执行
 // @param fn
-func Submit(fn func()) {
+// @return error 池已关闭或过载时fn不会执行
+func Submit(fn func()) error {
 	if err := multiPool.Submit(fn); err != nil {
 		zlog.Errorf("submit fn error: %v", err)
+		return err
 	}
+	return nil
 }
 
 // Status
@@ -17,7 +17,16 @@ func Action(actions ...string) gin.HandlerFunc {
 		}
 	}
 	return func(c *gin.Context) {
-		if auth, ok := Auth(c); ok {
+		if key, ok := ApiKey(c); ok {
+			if requireScopes(key.Scopes, actions) && requireOwner(c.Request.Context(), key.Owner, actions) {
+				c.Next()
+				return
+			}
+		} else if auth, ok := Auth(c); ok {
+			if _, imp := Actor(c); imp && impersonateDenied(actions) {
+				zgin.AbortHttpCode(c, http.StatusForbidden, zgin.MessageActionInvalid.Resp(c))
+				return
+			}
 			if require(c.Request.Context(), auth.Userid(), actions) {
 				c.Next()
 				return
@@ -39,3 +48,34 @@ func require(ctx context.Context, userid string, patterns []string) bool {
 	}
 	return true
 }
+
+// requireScopes
+// @Description: 按API Key自身scopes校验
+// @param scopes
+// @param patterns
+// @return bool
+func requireScopes(scopes []string, patterns []string) bool {
+	per := BuildPermissionTrie(scopes)
+	for _, pattern := range patterns {
+		if !per.Match(pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// requireOwner
+// @Description: API Key所属用户的当前权限也需满足，用户被收回权限后Key随之失效
+// @param ctx
+// @param userid
+// @param patterns
+// @return bool
+func requireOwner(ctx context.Context, userid string, patterns []string) bool {
+	per := ownerPermission(ctx, userid)
+	for _, pattern := range patterns {
+		if !per.Match(pattern) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package zauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zdb"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * 机器客户端认证，两种方式：
+ *  - Bearer: X-Api-Key: kid.secret
+ *  - HMAC:   X-Api-Key: kid，X-Api-Timestamp: 秒级时间戳，X-Api-Nonce: 随机串，X-Api-Signature: 签名
+ * 签名原文：METHOD\nPATH\nQUERY(按key排序)\nSHA256(BODY)\nTIMESTAMP\nNONCE，HMAC-SHA256(secret)后hex编码
+ * Key的scopes不能超出所属用户的权限，请求时同时按scopes和用户当前权限校验
+ * 验签用的密钥以SecretKey加密保存，SecretKey只在服务端配置，修改后已有Key需轮换
+ */
+
+const (
+	HeaderApiKey       = "X-Api-Key"
+	HeaderApiTimestamp = "X-Api-Timestamp"
+	HeaderApiNonce     = "X-Api-Nonce"
+	HeaderApiSignature = "X-Api-Signature"
+	LocalsApiKey       = "auth:apikey"
+)
+
+type ApiKeyOptions struct {
+	Window           time.Duration                                     `yaml:"window" note:"签名时间戳允许的偏差，默认5min"`
+	CacheAge         time.Duration                                     `yaml:"cache_age" note:"Key缓存时间，默认10min"`
+	RequireSignature bool                                              `yaml:"require_signature" note:"是否强制HMAC签名，关闭时允许Bearer方式"`
+	SecretKey        string                                            `yaml:"secret_key" validate:"required" note:"加密保存验签密钥的服务端密钥，必填"`
+	MaxBody          int64                                             `yaml:"max_body" note:"验签时读取的请求体上限，默认10MB"`
+	Permission       func(ctx context.Context, userid string) []string `note:"加载用户当前权限，默认读取SavePermission保存的权限，用户会话过期后Key将无权限"`
+}
+
+func (o *ApiKeyOptions) Validate() error {
+	o.Window = zutil.FirstTruth(o.Window, time.Minute*5)
+	o.CacheAge = zutil.FirstTruth(o.CacheAge, time.Minute*10)
+	o.MaxBody = zutil.FirstTruth(o.MaxBody, int64(10<<20))
+	return validator.New().Struct(o)
+}
+
+var apiKeyOptions = &ApiKeyOptions{Window: time.Minute * 5, CacheAge: time.Minute * 10, MaxBody: 10 << 20}
+
+// NewApiKeyMiddleware
+// @Description: API Key认证，需放在NewMiddleware之前，无X-Api-Key时交给会话认证
+// @param opts
+// @return gin.HandlerFunc
+func NewApiKeyMiddleware(opts *ApiKeyOptions) gin.HandlerFunc {
+	opts = zutil.FirstTruth(opts, &ApiKeyOptions{})
+	if err := opts.Validate(); err != nil {
+		zlog.Fatalf("api key options is invalid: %v", err)
+	}
+	apiKeyOptions = opts
+	zdb.AutoMigrate([]any{&ZauthApiKey{}})
+	zlog.Infof("middleware api key enabled")
+	return func(c *gin.Context) {
+		if c.GetHeader(HeaderApiKey) == "" {
+			c.Next()
+			return
+		}
+		user, msgID := ScanApiKey(c)
+		if msgID != zgin.MessageSuccess {
+			zgin.AbortHttpCode(c, http.StatusUnauthorized, msgID.Resp(c))
+			return
+		}
+		if !bindTenant(c, user) {
+			zgin.AbortHttpCode(c, http.StatusForbidden, zgin.MessageTenantInvalid.Resp(c))
+			return
+		}
+		c.Set(LocalsUserPrefix, Userinfo(user))
+		c.Set(LocalsApiKey, user)
+		c.Next()
+	}
+}
+
+// ApiKey
+// @Description: 当前请求是否由API Key认证
+// @param c
+// @return *ApiKeyUser
+// @return bool
+func ApiKey(c *gin.Context) (*ApiKeyUser, bool) {
+	if u, ok := c.Get(LocalsApiKey); ok {
+		return u.(*ApiKeyUser), true
+	}
+	return nil, false
+}
+
+func ScanApiKey(c *gin.Context) (*ApiKeyUser, zgin.MessageID) {
+	header := strings.TrimSpace(c.GetHeader(HeaderApiKey))
+	signature := strings.TrimSpace(c.GetHeader(HeaderApiSignature))
+	kid, secret, bearer := strings.Cut(header, ".")
+	if bearer && signature == "" && apiKeyOptions.RequireSignature {
+		zlog.Warnf("api key %s signature required", kid)
+		return nil, zgin.MessageApiKeySignatureInvalid
+	}
+	if !bearer && signature == "" {
+		return nil, zgin.MessageApiKeySignatureInvalid
+	}
+	key, err := loadApiKey(c.Request.Context(), kid)
+	if err != nil {
+		zlog.Warnf("api key load err: %v", err)
+		return nil, zgin.MessageApiKeyInvalid
+	}
+	if !key.Valid() {
+		zlog.Warnf("api key %s revoked or expired", kid)
+		return nil, zgin.MessageApiKeyInvalid
+	}
+	if signature == "" {
+		if subtle.ConstantTimeCompare([]byte(apiKeyHash(secret)), []byte(key.Hash)) != 1 {
+			zlog.Warnf("api key %s secret mismatch", kid)
+			return nil, zgin.MessageApiKeyInvalid
+		}
+	} else if msgID := verifySignature(c, key, signature); msgID != zgin.MessageSuccess {
+		return nil, msgID
+	}
+	return &ApiKeyUser{
+		Kid:    key.Kid,
+		Owner:  key.Userid,
+		Name:   key.Name,
+		Tenant: key.Tenant,
+		Scopes: key.Scopes.StringArray,
+	}, zgin.MessageSuccess
+}
+
+func verifySignature(c *gin.Context, key *ZauthApiKey, signature string) zgin.MessageID {
+	ts, err := strconv.ParseInt(c.GetHeader(HeaderApiTimestamp), 10, 64)
+	if err != nil {
+		zlog.Warnf("api key %s timestamp invalid", key.Kid)
+		return zgin.MessageApiKeySignatureInvalid
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > apiKeyOptions.Window || d < -apiKeyOptions.Window {
+		zlog.Warnf("api key %s timestamp out of window: %s", key.Kid, d)
+		return zgin.MessageApiKeySignatureInvalid
+	}
+	nonce := c.GetHeader(HeaderApiNonce)
+	if nonce == "" || len(nonce) > 64 {
+		zlog.Warnf("api key %s nonce invalid", key.Kid)
+		return zgin.MessageApiKeySignatureInvalid
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, apiKeyOptions.MaxBody)
+	body, err := c.GetRawData()
+	if err != nil {
+		zlog.Warnf("api key %s read body err: %v", key.Kid, err)
+		return zgin.MessageApiKeySignatureInvalid
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	secret := key.Secret.Decrypt(apiKeySecretKey(key.Kid))
+	expect := ApiKeySign(secret, c.Request.Method, c.Request.URL.Path, c.Request.URL.Query(), body, ts, nonce)
+	if !hmac.Equal([]byte(expect), []byte(strings.ToLower(signature))) {
+		zlog.Warnf("api key %s signature mismatch", key.Kid)
+		return zgin.MessageApiKeySignatureInvalid
+	}
+	// 签名通过后再占用nonce，防止伪造请求耗尽nonce
+	ok, err := zch.R().SetNX(c.Request.Context(), zch.PrefixAuthNonce.Key(key.Kid, nonce), ts, apiKeyOptions.Window*2).Result()
+	if err != nil || !ok {
+		zlog.Warnf("api key %s nonce replayed: %s", key.Kid, nonce)
+		return zgin.MessageApiKeySignatureInvalid
+	}
+	return zgin.MessageSuccess
+}
+
+// ApiKeySign
+// @Description: 计算请求签名，客户端与服务端共用
+// @param secret
+// @param method
+// @param path
+// @param query
+// @param body
+// @param timestamp 秒
+// @param nonce
+// @return string
+func ApiKeySign(secret, method, path string, query url.Values, body []byte, timestamp int64, nonce string) string {
+	sum := sha256.Sum256(body)
+	payload := fmt.Sprintf("%s\n%s\n%s\n%s\n%d\n%s",
+		strings.ToUpper(method),
+		path,
+		query.Encode(),
+		hex.EncodeToString(sum[:]),
+		timestamp,
+		nonce,
+	)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
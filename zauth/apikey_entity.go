@@ -0,0 +1,81 @@
+package zauth
+
+import (
+	"time"
+
+	"github.com/dromara/carbon/v2"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zdb"
+)
+
+// ZauthApiKey
+// @Description: 机器客户端API Key，明文密钥只在创建/轮换时返回一次
+type ZauthApiKey struct {
+	Id        uint64           `json:"id" gorm:"->;primarykey"`
+	Kid       string           `json:"kid" gorm:"unique;comment:公开的Key ID"`
+	Userid    string           `json:"userid" gorm:"index;comment:所属用户"`
+	Tenant    string           `json:"tenant" gorm:"comment:所属租户，创建时取自ctx"`
+	Name      string           `json:"name" gorm:"comment:名称"`
+	Hash      string           `json:"-" gorm:"comment:密钥SHA256"`
+	Secret    *zdb.CptString   `json:"-" gorm:"comment:加密的密钥，用于HMAC验签"`
+	Scopes    *zdb.StringArray `json:"scopes" gorm:"comment:权限范围，同Action格式"`
+	ExpiredAt *carbon.Carbon   `json:"expired_at,omitempty" gorm:"comment:过期时间，空则永久"`
+	RevokedAt *carbon.Carbon   `json:"revoked_at,omitempty" gorm:"comment:吊销时间"`
+	CreatedAt *carbon.Carbon   `json:"created_at,omitempty" gorm:"autoCreateTime"`
+	UpdatedAt *carbon.Carbon   `json:"updated_at,omitempty" gorm:"autoUpdateTime"`
+}
+
+func (k *ZauthApiKey) Valid() bool {
+	if k.RevokedAt != nil && !k.RevokedAt.IsZero() {
+		return false
+	}
+	if k.ExpiredAt != nil && !k.ExpiredAt.IsZero() && k.ExpiredAt.Lt(carbon.Now()) {
+		return false
+	}
+	return true
+}
+
+// ApiKeyUser
+// @Description: API Key 认证通过后的身份，Userid为Key所属用户
+type ApiKeyUser struct {
+	Kid    string   `json:"kid"`
+	Owner  string   `json:"owner"`
+	Name   string   `json:"name"`
+	Tenant string   `json:"tenant"`
+	Scopes []string `json:"scopes"`
+}
+
+func (u *ApiKeyUser) Userid() string {
+	return u.Owner
+}
+func (u *ApiKeyUser) UserName() string {
+	return u.Name
+}
+func (u *ApiKeyUser) UserNickname() string {
+	return u.Kid
+}
+func (u *ApiKeyUser) UserAvatar() string {
+	return ""
+}
+func (u *ApiKeyUser) TenantID() string {
+	return u.Tenant
+}
+func (u *ApiKeyUser) Validate() zgin.MessageID {
+	return zgin.MessageSuccess
+}
+
+type ApiKeyCreated struct {
+	Kid       string   `json:"kid"`
+	Key       string   `json:"key" note:"Bearer方式使用，kid.secret"`
+	Secret    string   `json:"secret" note:"HMAC签名密钥"`
+	Scopes    []string `json:"scopes"`
+	ExpiredAt int64    `json:"expired_at,omitempty"`
+}
+type ParamApiKeyCreate struct {
+	Name   string        `json:"name" binding:"required"`
+	Scopes []string      `json:"scopes" binding:"required,min=1"`
+	Expire time.Duration `json:"expire" note:"有效期，0则永久"`
+}
+type ParamApiKeyID struct {
+	Kid string `json:"kid" binding:"required"`
+}
@@ -0,0 +1,306 @@
+package zauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/bytedance/sonic"
+	"github.com/dromara/carbon/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zdb"
+	"github.com/zohu/zgin/ztenant"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zid"
+)
+
+const apiKeySecretLength = 40
+
+// ApiKeyCreate
+// @Description: 为用户创建API Key，返回的明文密钥只出现这一次
+// @param ctx
+// @param userid
+// @param h
+// @return *ApiKeyCreated
+// @return error
+func ApiKeyCreate(ctx context.Context, userid string, h *ParamApiKeyCreate) (*ApiKeyCreated, error) {
+	if userid == "" {
+		return nil, fmt.Errorf("userid is empty")
+	}
+	if apiKeyOptions.SecretKey == "" {
+		return nil, fmt.Errorf("api key secret key is not configured")
+	}
+	scopes := mergePatterns(h.Scopes)
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("scopes is invalid, should be [*:*:*]")
+	}
+	per := ownerPermission(ctx, userid)
+	for _, scope := range scopes {
+		if !per.Match(scope) {
+			return nil, fmt.Errorf("scope %s exceeds the permissions of user", scope)
+		}
+	}
+	kid := "ak" + zid.NextBase36()
+	secret := zutil.RandomStr(apiKeySecretLength)
+	tenant, _ := ztenant.From(ctx)
+	key := &ZauthApiKey{
+		Kid:    kid,
+		Userid: userid,
+		Tenant: tenant,
+		Name:   h.Name,
+		Hash:   apiKeyHash(secret),
+		Secret: zdb.NewCptString(secret, apiKeySecretKey(kid)),
+		Scopes: zdb.NewStringArray(scopes),
+	}
+	if h.Expire > 0 {
+		key.ExpiredAt = carbon.Now().AddDuration(h.Expire.String())
+	}
+	if err := zdb.NewDB(ctx).Create(key).Error; err != nil {
+		return nil, fmt.Errorf("create api key failed: %w", err)
+	}
+	return apiKeyCreated(key, secret), nil
+}
+
+// ApiKeyRotate
+// @Description: 轮换密钥，旧密钥立即失效
+// @param ctx
+// @param userid 为空时不校验归属
+// @param kid
+// @return *ApiKeyCreated
+// @return error
+func ApiKeyRotate(ctx context.Context, userid, kid string) (*ApiKeyCreated, error) {
+	key, err := apiKeyOwned(ctx, userid, kid)
+	if err != nil {
+		return nil, err
+	}
+	if !key.Valid() {
+		return nil, fmt.Errorf("api key %s is revoked or expired", kid)
+	}
+	if apiKeyOptions.SecretKey == "" {
+		return nil, fmt.Errorf("api key secret key is not configured")
+	}
+	secret := zutil.RandomStr(apiKeySecretLength)
+	key.Hash = apiKeyHash(secret)
+	key.Secret = zdb.NewCptString(secret, apiKeySecretKey(kid))
+	if err = zdb.NewDB(ctx).Model(key).Select("hash", "secret").Updates(key).Error; err != nil {
+		return nil, fmt.Errorf("rotate api key failed: %w", err)
+	}
+	zch.R().Del(ctx, zch.PrefixAuthApiKey.Key(kid))
+	return apiKeyCreated(key, secret), nil
+}
+
+// ApiKeyRevoke
+// @Description: 吊销API Key
+// @param ctx
+// @param userid 为空时不校验归属
+// @param kid
+// @return error
+func ApiKeyRevoke(ctx context.Context, userid, kid string) error {
+	key, err := apiKeyOwned(ctx, userid, kid)
+	if err != nil {
+		return err
+	}
+	key.RevokedAt = carbon.Now()
+	if err = zdb.NewDB(ctx).Model(key).Select("revoked_at").Updates(key).Error; err != nil {
+		return fmt.Errorf("revoke api key failed: %w", err)
+	}
+	zch.R().Del(ctx, zch.PrefixAuthApiKey.Key(kid))
+	return nil
+}
+
+func ApiKeyList(ctx context.Context, userid string) ([]ZauthApiKey, error) {
+	var keys []ZauthApiKey
+	err := zdb.NewDB(ctx).Where("userid=?", userid).Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// ApiKeyRouteRegister
+// @Description: API Key 管理接口，需挂在会话认证之后，只能管理自己的Key
+// @param r
+func ApiKeyRouteRegister(r *gin.RouterGroup) {
+	r.GET("/apikey", func(c *gin.Context) {
+		auth, ok := Auth(c)
+		if !ok {
+			zgin.AbortHttpCode(c, http.StatusUnauthorized, zgin.MessageLoginTokenInvalid.Resp(c))
+			return
+		}
+		keys, err := ApiKeyList(c.Request.Context(), auth.Userid())
+		if err != nil {
+			zgin.Abort(c, zgin.MessageQueryFailed.Resp(c).AddMessage(err.Error()))
+			return
+		}
+		zgin.Abort(c, zgin.NewRespWithData(c, keys))
+	})
+	r.POST("/apikey", zgin.Bind(func(c *gin.Context, h *ParamApiKeyCreate) *zgin.RespBean {
+		auth, ok := Auth(c)
+		if !ok {
+			return zgin.MessageLoginTokenInvalid.Resp(c)
+		}
+		if _, isKey := auth.(*ApiKeyUser); isKey {
+			return zgin.MessageActionInvalid.Resp(c)
+		}
+		resp, err := ApiKeyCreate(c.Request.Context(), auth.Userid(), h)
+		if err != nil {
+			return zgin.MessageCreateFailed.Resp(c).AddMessage(err.Error())
+		}
+		return zgin.NewRespWithData(c, resp)
+	}))
+	r.POST("/apikey/rotate", zgin.Bind(func(c *gin.Context, h *ParamApiKeyID) *zgin.RespBean {
+		auth, ok := Auth(c)
+		if !ok {
+			return zgin.MessageLoginTokenInvalid.Resp(c)
+		}
+		if _, isKey := auth.(*ApiKeyUser); isKey {
+			return zgin.MessageActionInvalid.Resp(c)
+		}
+		resp, err := ApiKeyRotate(c.Request.Context(), auth.Userid(), h.Kid)
+		if err != nil {
+			return zgin.MessageUpdateFailed.Resp(c).AddMessage(err.Error())
+		}
+		return zgin.NewRespWithData(c, resp)
+	}))
+	r.POST("/apikey/revoke", zgin.Bind(func(c *gin.Context, h *ParamApiKeyID) *zgin.RespBean {
+		auth, ok := Auth(c)
+		if !ok {
+			return zgin.MessageLoginTokenInvalid.Resp(c)
+		}
+		if _, isKey := auth.(*ApiKeyUser); isKey {
+			return zgin.MessageActionInvalid.Resp(c)
+		}
+		if err := ApiKeyRevoke(c.Request.Context(), auth.Userid(), h.Kid); err != nil {
+			return zgin.MessageDeleteFailed.Resp(c).AddMessage(err.Error())
+		}
+		return zgin.MessageSuccess.Resp(c)
+	}))
+}
+
+// loadApiKey
+// @Description: 优先从缓存读取，吊销/轮换时会删除缓存
+// @param ctx
+// @param kid
+// @return *ZauthApiKey
+// @return error
+func loadApiKey(ctx context.Context, kid string) (*ZauthApiKey, error) {
+	ck := zch.PrefixAuthApiKey.Key(kid)
+	var cached apiKeyCache
+	if str := zch.R().Get(ctx, ck).Val(); str != "" {
+		if err := sonic.UnmarshalString(str, &cached); err == nil {
+			return cached.entity(), nil
+		}
+	}
+	var key ZauthApiKey
+	if err := zdb.NewDB(ctx).Where("kid=?", kid).First(&key).Error; err != nil {
+		return nil, fmt.Errorf("api key %s not found: %w", kid, err)
+	}
+	str, _ := sonic.MarshalString(newApiKeyCache(&key))
+	zch.R().Set(ctx, ck, str, apiKeyOptions.CacheAge)
+	return &key, nil
+}
+
+func apiKeyOwned(ctx context.Context, userid, kid string) (*ZauthApiKey, error) {
+	var key ZauthApiKey
+	db := zdb.NewDB(ctx).Where("kid=?", kid)
+	if userid != "" {
+		db = db.Where("userid=?", userid)
+	}
+	if err := db.First(&key).Error; err != nil {
+		return nil, fmt.Errorf("api key %s not found: %w", kid, err)
+	}
+	return &key, nil
+}
+
+func apiKeyCreated(key *ZauthApiKey, secret string) *ApiKeyCreated {
+	resp := &ApiKeyCreated{
+		Kid:    key.Kid,
+		Key:    fmt.Sprintf("%s.%s", key.Kid, secret),
+		Secret: secret,
+		Scopes: key.Scopes.StringArray,
+	}
+	if key.ExpiredAt != nil {
+		resp.ExpiredAt = key.ExpiredAt.Timestamp()
+	}
+	return resp
+}
+
+// apiKeySecretKey
+// @Description: 加密验签密钥的key，由服务端SecretKey和kid派生，kid公开，单独不能解密
+// @param kid
+// @return string
+func apiKeySecretKey(kid string) string {
+	return apiKeyOptions.SecretKey + ":" + kid
+}
+
+// ownerPermission
+// @Description: Key所属用户的当前权限
+// @param ctx
+// @param userid
+// @return *PermTrie
+func ownerPermission(ctx context.Context, userid string) *PermTrie {
+	if apiKeyOptions.Permission != nil {
+		return BuildPermissionTrie(apiKeyOptions.Permission(ctx, userid))
+	}
+	return LoadPermission(ctx, userid)
+}
+
+func apiKeyHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyCache
+// @Description: 缓存中的API Key，CptString不可序列化，单独保存密文
+type apiKeyCache struct {
+	Kid       string   `json:"kid"`
+	Userid    string   `json:"userid"`
+	Tenant    string   `json:"tenant"`
+	Name      string   `json:"name"`
+	Hash      string   `json:"hash"`
+	Secret    []byte   `json:"secret"`
+	Scopes    []string `json:"scopes"`
+	ExpiredAt int64    `json:"expired_at"`
+	Revoked   bool     `json:"revoked"`
+}
+
+func newApiKeyCache(key *ZauthApiKey) *apiKeyCache {
+	c := &apiKeyCache{
+		Kid:     key.Kid,
+		Userid:  key.Userid,
+		Tenant:  key.Tenant,
+		Name:    key.Name,
+		Hash:    key.Hash,
+		Revoked: key.RevokedAt != nil && !key.RevokedAt.IsZero(),
+	}
+	if key.Secret != nil {
+		c.Secret = []byte(key.Secret.String())
+	}
+	if key.Scopes != nil {
+		c.Scopes = key.Scopes.StringArray
+	}
+	if key.ExpiredAt != nil && !key.ExpiredAt.IsZero() {
+		c.ExpiredAt = key.ExpiredAt.Timestamp()
+	}
+	return c
+}
+func (c *apiKeyCache) entity() *ZauthApiKey {
+	key := &ZauthApiKey{
+		Kid:    c.Kid,
+		Userid: c.Userid,
+		Tenant: c.Tenant,
+		Name:   c.Name,
+		Hash:   c.Hash,
+		Secret: new(zdb.CptString),
+		Scopes: zdb.NewStringArray(c.Scopes),
+	}
+	_ = key.Secret.Scan(c.Secret)
+	if c.ExpiredAt > 0 {
+		key.ExpiredAt = carbon.CreateFromTimestamp(c.ExpiredAt)
+	}
+	if c.Revoked {
+		key.RevokedAt = carbon.Now()
+	}
+	return key
+}
@@ -0,0 +1,160 @@
+package zauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zdb"
+)
+
+func setupApiKey(t *testing.T, kid, secret, owner string, scopes ...string) {
+	t.Helper()
+	old := apiKeyOptions
+	apiKeyOptions = &ApiKeyOptions{SecretKey: "server-secret"}
+	if err := apiKeyOptions.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { apiKeyOptions = old })
+	key := &ZauthApiKey{
+		Kid:    kid,
+		Userid: owner,
+		Hash:   apiKeyHash(secret),
+		Secret: zdb.NewCptString(secret, apiKeySecretKey(kid)),
+		Scopes: zdb.NewStringArray(scopes),
+	}
+	str, _ := sonic.MarshalString(newApiKeyCache(key))
+	zch.R().Set(context.Background(), zch.PrefixAuthApiKey.Key(kid), str, time.Minute)
+}
+
+func signedRequest(kid, secret, body, nonce string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/items?b=2&a=1", strings.NewReader(body))
+	ts := time.Now().Unix()
+	req.Header.Set(HeaderApiKey, kid)
+	req.Header.Set(HeaderApiTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderApiNonce, nonce)
+	req.Header.Set(HeaderApiSignature, ApiKeySign(secret, req.Method, req.URL.Path, url.Values{"a": {"1"}, "b": {"2"}}, []byte(body), ts, nonce))
+	return req
+}
+
+func TestApiKeyBearer(t *testing.T) {
+	mr.FlushAll()
+	setupApiKey(t, "akbearer", "s3cret", "u1", "item:read:*")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderApiKey, "akbearer.s3cret")
+	c, _ := testContext(req)
+	user, msgID := ScanApiKey(c)
+	if msgID != zgin.MessageSuccess || user.Userid() != "u1" || user.Kid != "akbearer" {
+		t.Fatalf("bearer: %+v %s", user, msgID)
+	}
+
+	req.Header.Set(HeaderApiKey, "akbearer.wrong")
+	c, _ = testContext(req)
+	if _, msgID = ScanApiKey(c); msgID != zgin.MessageApiKeyInvalid {
+		t.Fatalf("wrong secret: %s", msgID)
+	}
+
+	apiKeyOptions.RequireSignature = true
+	req.Header.Set(HeaderApiKey, "akbearer.s3cret")
+	c, _ = testContext(req)
+	if _, msgID = ScanApiKey(c); msgID != zgin.MessageApiKeySignatureInvalid {
+		t.Fatalf("signature required: %s", msgID)
+	}
+}
+
+func TestApiKeySignature(t *testing.T) {
+	mr.FlushAll()
+	setupApiKey(t, "akhmac", "s3cret", "u1", "item:read:*")
+
+	c, _ := testContext(signedRequest("akhmac", "s3cret", `{"a":1}`, "n1"))
+	if _, msgID := ScanApiKey(c); msgID != zgin.MessageSuccess {
+		t.Fatalf("signed: %s", msgID)
+	}
+	// 签名后请求体仍可读取
+	if body, _ := c.GetRawData(); string(body) != `{"a":1}` {
+		t.Fatalf("body not restored: %s", body)
+	}
+
+	c, _ = testContext(signedRequest("akhmac", "s3cret", `{"a":1}`, "n1"))
+	if _, msgID := ScanApiKey(c); msgID != zgin.MessageApiKeySignatureInvalid {
+		t.Fatalf("nonce replay: %s", msgID)
+	}
+
+	req := signedRequest("akhmac", "s3cret", `{"a":1}`, "n2")
+	req.Header.Set(HeaderApiNonce, "n3")
+	c, _ = testContext(req)
+	if _, msgID := ScanApiKey(c); msgID != zgin.MessageApiKeySignatureInvalid {
+		t.Fatalf("tampered: %s", msgID)
+	}
+
+	apiKeyOptions.MaxBody = 4
+	c, _ = testContext(signedRequest("akhmac", "s3cret", `{"a":1}`, "n4"))
+	if _, msgID := ScanApiKey(c); msgID != zgin.MessageApiKeySignatureInvalid {
+		t.Fatalf("body over limit: %s", msgID)
+	}
+}
+
+func TestApiKeySecretEncryption(t *testing.T) {
+	mr.FlushAll()
+	setupApiKey(t, "akenc", "s3cret", "u1", "item:read:*")
+	key, err := loadApiKey(context.Background(), "akenc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Secret.Decrypt(apiKeySecretKey("akenc")) != "s3cret" {
+		t.Fatal("decrypt with server key failed")
+	}
+	// 只知道公开的kid时无法解密，错误的key可能在去填充时panic
+	decrypted := func() (s string) {
+		defer func() { _ = recover() }()
+		return key.Secret.Decrypt("akenc")
+	}()
+	if decrypted == "s3cret" {
+		t.Fatal("secret decrypted with kid only")
+	}
+}
+
+func TestApiKeyScopes(t *testing.T) {
+	mr.FlushAll()
+	setupApiKey(t, "akscope", "s3cret", "u1", "*:*:*")
+	ctx := context.Background()
+	SavePermission(ctx, "u1", []string{"item:read:*"})
+
+	// 创建时scopes不能超出用户权限
+	if _, err := ApiKeyCreate(ctx, "u1", &ParamApiKeyCreate{Name: "k", Scopes: []string{"*:*:*"}}); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("create with escalated scope: %v", err)
+	}
+	if _, err := ApiKeyCreate(ctx, "u1", &ParamApiKeyCreate{Name: "k", Scopes: []string{"item:write:1"}}); err == nil {
+		t.Fatal("create with scope outside permission")
+	}
+
+	// 请求时同时校验scopes和用户当前权限
+	check := func(action string) int {
+		c, w := testContext(httptest.NewRequest(http.MethodGet, "/", nil))
+		c.Set(LocalsApiKey, &ApiKeyUser{Kid: "akscope", Owner: "u1", Scopes: []string{"*:*:*"}})
+		Action(action)(c)
+		if c.IsAborted() {
+			return w.Code
+		}
+		return http.StatusOK
+	}
+	if code := check("item:read:1"); code != http.StatusOK {
+		t.Fatalf("allowed action: %d", code)
+	}
+	if code := check("item:write:1"); code != http.StatusForbidden {
+		t.Fatalf("action outside owner permission: %d", code)
+	}
+	SavePermission(ctx, "u1", nil)
+	if code := check("item:read:1"); code != http.StatusForbidden {
+		t.Fatalf("owner permission revoked: %d", code)
+	}
+}
@@ -2,11 +2,14 @@ package zauth
 
 import (
 	"encoding/base64"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/zohu/zgin"
 	"github.com/zohu/zgin/zch"
 	"github.com/zohu/zgin/zcpt"
@@ -19,6 +22,7 @@ const (
 	LocalsUserPrefix    = "auth:user"
 	LocalsSessionPrefix = "auth:session"
 	LocalsToken         = "auth:token"
+	LocalsActor         = "auth:actor"
 )
 
 var options *Options
@@ -45,6 +49,11 @@ func NewMiddleware[T Userinfo](opts *Options) gin.HandlerFunc {
 				return
 			}
 		}
+		// 已由API Key认证
+		if _, ok := ApiKey(c); ok {
+			c.Next()
+			return
+		}
 
 		var auth Authorization[T]
 		if msgID := ScanAuth(c, &auth); msgID != zgin.MessageSuccess {
@@ -52,9 +61,17 @@ func NewMiddleware[T Userinfo](opts *Options) gin.HandlerFunc {
 			return
 		}
 
+		if !bindTenant(c, auth.Value) {
+			zgin.AbortHttpCode(c, http.StatusForbidden, zgin.MessageTenantInvalid.Resp(c))
+			return
+		}
+
 		// 临时存储用户资料
 		c.Set(LocalsUserPrefix, zutil.Ptr(auth.Value))
 		c.Set(LocalsSessionPrefix, auth.Session)
+		if auth.Actor != nil {
+			c.Set(LocalsActor, auth.Actor)
+		}
 
 		c.Next()
 	}
@@ -70,7 +87,15 @@ func UpdateAuth(c *gin.Context, user Userinfo) {
 		session = zcpt.Md5(token)
 	}
 	c.Set(LocalsUserPrefix, zutil.Ptr(user))
-	uStr, _ := sonic.MarshalString(&Authorization[Userinfo]{Session: session.(string), Value: user})
+	auth := &Authorization[Userinfo]{Session: session.(string), Value: user}
+	// 代登录会话单独存储且不续期
+	if actor, ok := Actor(c); ok {
+		auth.Actor = actor
+		uStr, _ := sonic.MarshalString(auth)
+		zch.R().Set(c.Request.Context(), zch.PrefixAuthImp.Key(auth.Session), uStr, redis.KeepTTL)
+		return
+	}
+	uStr, _ := sonic.MarshalString(auth)
 	vKey := zch.PrefixAuthToken.Key(user.Userid())
 	zch.R().Set(c.Request.Context(), vKey, uStr, options.Age)
 }
@@ -83,76 +108,190 @@ func Auth(c *gin.Context) (Userinfo, bool) {
 }
 
 func Token(c *gin.Context) string {
+	token, _ := tokenFrom(c)
+	return token
+}
+
+// FromCookie
+// @Description: 登录态是否来自cookie，来自cookie时需要CSRF防护
+// @param c
+// @return bool
+func FromCookie(c *gin.Context) bool {
+	_, cookie := tokenFrom(c)
+	return cookie
+}
+
+func tokenFrom(c *gin.Context) (string, bool) {
 	if token, ok := c.Get(LocalsToken); ok {
-		return strings.TrimSpace(token.(string))
+		return strings.TrimSpace(token.(string)), false
+	}
+	// 代登录会话单独的cookie，过期或结束后回落到操作人自己的会话
+	if token, _ := c.Cookie(impersonateCookieName()); token != "" {
+		return strings.TrimSpace(token), true
 	}
-	if token, _ := c.Cookie("auth"); token != "" {
-		return strings.TrimSpace(token)
+	if token, _ := c.Cookie(cookieName()); token != "" {
+		return strings.TrimSpace(token), true
 	}
 	if token := c.GetHeader("Authorization"); token != "" {
-		return strings.TrimSpace(token)
+		return strings.TrimSpace(token), false
 	}
-	return ""
+	return "", false
 }
 
-func ScanAuth[T Userinfo](c *gin.Context, auth *Authorization[T]) zgin.MessageID {
-	token := Token(c)
+func cookieName() string {
+	if options == nil {
+		return "auth"
+	}
+	return options.CookieName
+}
+
+func impersonateCookieName() string {
+	return cookieName() + "_imp"
+}
+
+func setCookie(c *gin.Context, token string, age time.Duration) {
+	setNamedCookie(c, cookieName(), token, age)
+}
+func setNamedCookie(c *gin.Context, name, token string, age time.Duration) {
+	maxAge := int(age.Seconds())
 	if token == "" {
-		return zgin.MessageLoginTokenInvalid
+		maxAge = -1
 	}
-	// 解析登录态
+	if options == nil {
+		c.SetCookie(name, token, maxAge, "", "", true, true)
+		return
+	}
+	c.SetSameSite(options.SameSite())
+	c.SetCookie(name, token, maxAge, options.CookiePath, options.CookieDomain, *options.CookieSecure, true)
+}
+func clearCookie(c *gin.Context) {
+	setCookie(c, "", 0)
+}
+
+// decodeToken
+// @Description: 解析登录态，格式 随机串##UA##IP##userid##签发毫秒时间戳
+// @param token
+// @return []string
+// @return error
+func decodeToken(token string) ([]string, error) {
 	d, err := base64.StdEncoding.DecodeString(token)
 	if err != nil {
-		zlog.Warnf("auth token decode err: %v, token=%s", err, token)
-		return zgin.MessageLoginTokenInvalid
+		return nil, fmt.Errorf("token decode err: %v", err)
 	}
 	d, err = zcpt.AesDecryptCBC(d, []byte(AESKey))
 	if err != nil {
-		zlog.Warnf("auth token decrypt err: %v, token=%s", err, token)
-		return zgin.MessageLoginTokenInvalid
+		return nil, fmt.Errorf("token decrypt err: %v", err)
 	}
 	tks := strings.Split(string(d), "##")
 	if len(tks) != 5 {
-		zlog.Warnf("auth token len err: 5 != [%d]", len(tks))
+		return nil, fmt.Errorf("token len err: 5 != [%d]", len(tks))
+	}
+	return tks, nil
+}
+
+func ScanAuth[T Userinfo](c *gin.Context, auth *Authorization[T]) zgin.MessageID {
+	token := Token(c)
+	session, msgID := verifyToken(c, token)
+	if msgID != zgin.MessageSuccess {
+		return msgID
+	}
+	userid := session.userid
+	if err := sonic.UnmarshalString(session.value, &auth); err != nil {
+		zlog.Warnf("auth token userid=%s unmarshal err: %v", userid, err)
 		return zgin.MessageLoginTokenInvalid
 	}
+	// 用户状态是否正常
+	if vali := auth.Value.Validate(); vali != zgin.MessageSuccess {
+		zlog.Warnf("auth token userid=%s status invalid: %s", userid, vali)
+		emit(c, AuthEventTokenInvalid, userid, string(vali))
+		return vali
+	}
+	// 刷新Token有效期，代登录会话不续期
+	if session.impersonated {
+		return zgin.MessageSuccess
+	}
+	setCookie(c, token, options.Age)
+	pipe := zch.R().Pipeline()
+	pipe.Set(c.Request.Context(), session.key, session.value, options.Age)
+	pipe.Expire(c.Request.Context(), zch.PrefixAuthSession.Key(userid), options.Age)
+	if _, err := pipe.Exec(c.Request.Context()); err != nil {
+		zlog.Warnf("auth token userid=%s refresh err: %v", userid, err)
+	}
+	return zgin.MessageSuccess
+}
+
+type tokenSession struct {
+	tks          []string
+	userid       string
+	key          string // 登录态的key
+	value        string
+	impersonated bool
+}
 
-	agent := tks[1]
-	ip := tks[2]
-	userid := tks[3]
+// verifyToken
+// @Description: 校验token对应的会话真实存在且有效，登出等只需确认会话的操作同样需要校验，不能只解密token
+// @param c
+// @param token
+// @return *tokenSession
+// @return zgin.MessageID
+func verifyToken(c *gin.Context, token string) (*tokenSession, zgin.MessageID) {
+	if token == "" {
+		return nil, zgin.MessageLoginTokenInvalid
+	}
+	// 解析登录态
+	tks, err := decodeToken(token)
+	if err != nil {
+		zlog.Warnf("auth %v, token=%s", err, token)
+		emit(c, AuthEventTokenInvalid, "", "token invalid")
+		return nil, zgin.MessageLoginTokenInvalid
+	}
+	ctx := c.Request.Context()
+	agent, ip, userid, session := tks[1], tks[2], tks[3], zcpt.Md5(token)
+	// 是否已登出
+	if Revoked(ctx, userid, session, tks[4]) {
+		zlog.Warnf("auth token userid=%s revoked", userid)
+		emit(c, AuthEventTokenInvalid, userid, "token revoked")
+		return nil, zgin.MessageLoginTokenInvalid
+	}
 	// 校验UA是否变化
 	if !options.AllowUaChange && agent != zcpt.Md5(c.Request.UserAgent()) {
 		zlog.Warnf("auth token userid=%s ua changed", userid)
-		return zgin.MessageLoginTokenInvalid
+		emit(c, AuthEventTokenInvalid, userid, "ua changed")
+		return nil, zgin.MessageLoginTokenInvalid
 	}
 	// 校验IP是否变化
 	if !options.AllowIpChange && ip != c.ClientIP() {
 		zlog.Warnf("auth token userid=%s ip changed", userid)
-		return zgin.MessageLoginTokenInvalid
+		emit(c, AuthEventTokenInvalid, userid, "ip changed")
+		return nil, zgin.MessageLoginTokenInvalid
 	}
-	// 提取用户数据
-	vKey := zch.PrefixAuthToken.Key(userid)
-	uStr := zch.R().Get(c.Request.Context(), vKey).Val()
-	if uStr == "" {
-		zlog.Warnf("auth token userid=%s not found", userid)
-		return zgin.MessageLoginTokenInvalid
+	// 提取用户数据，代登录会话按会话单独存储
+	res := &tokenSession{tks: tks, userid: userid, key: zch.PrefixAuthToken.Key(userid)}
+	if res.impersonated = strings.HasPrefix(tks[0], impersonateNonce); res.impersonated {
+		res.key = zch.PrefixAuthImp.Key(session)
 	}
-	if err = sonic.UnmarshalString(uStr, &auth); err != nil {
-		zlog.Warnf("auth token userid=%s unmarshal err: %v", userid, err)
-		return zgin.MessageLoginTokenInvalid
+	if res.value = zch.R().Get(ctx, res.key).Val(); res.value == "" {
+		zlog.Warnf("auth token userid=%s not found", userid)
+		emit(c, AuthEventTokenInvalid, userid, "session not found")
+		return nil, zgin.MessageLoginTokenInvalid
 	}
-	// 是否允许多设备登录
-	if !options.AllowMultipleDevice && auth.Session != zcpt.Md5(token) {
-		zlog.Warnf("auth token userid=%s device changed", userid)
-		return zgin.MessageLoginSessionInvalid
+	if res.impersonated {
+		return res, zgin.MessageSuccess
 	}
-	// 用户状态是否正常
-	if vali := auth.Value.Validate(); vali != zgin.MessageSuccess {
-		zlog.Warnf("auth token userid=%s status invalid: %s", userid, vali)
-		return vali
+	// 用户的登录态只保存最近的会话，不允许多设备时须为当前会话，允许时须为已登记的会话
+	if !options.AllowMultipleDevice {
+		var auth struct {
+			Session string `json:"session"`
+		}
+		if err = sonic.UnmarshalString(res.value, &auth); err != nil || auth.Session != session {
+			zlog.Warnf("auth token userid=%s device changed", userid)
+			emit(c, AuthEventTokenInvalid, userid, "device changed")
+			return nil, zgin.MessageLoginSessionInvalid
+		}
+	} else if ok, _ := zch.R().HExists(ctx, zch.PrefixAuthSession.Key(userid), session).Result(); !ok {
+		zlog.Warnf("auth token userid=%s session not registered", userid)
+		emit(c, AuthEventTokenInvalid, userid, "session not found")
+		return nil, zgin.MessageLoginTokenInvalid
 	}
-	// 刷新Token有效期
-	c.SetCookie("auth", token, int(options.Age.Seconds()), "", "", true, true)
-	zch.R().Set(c.Request.Context(), vKey, uStr, options.Age)
-	return zgin.MessageSuccess
+	return res, zgin.MessageSuccess
 }
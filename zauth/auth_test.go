@@ -0,0 +1,51 @@
+package zauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zch"
+)
+
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	var err error
+	if mr, err = miniredis.Run(); err != nil {
+		panic(err)
+	}
+	gin.SetMode(gin.TestMode)
+	zch.NewL2(&zch.Options{Addrs: []string{mr.Addr()}, Invalidation: zch.InvalidationNone})
+	options = &Options{}
+	if err = options.Validate(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
+
+type testUser struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func (u testUser) Userid() string           { return u.ID }
+func (u testUser) UserName() string         { return u.ID }
+func (u testUser) UserNickname() string     { return u.ID }
+func (u testUser) UserAvatar() string       { return "" }
+func (u testUser) Validate() zgin.MessageID { return zgin.MessageSuccess }
+
+// testContext
+// @Description: 构造请求上下文，返回的recorder可检查响应
+func testContext(req *http.Request) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	return c, w
+}
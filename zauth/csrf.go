@@ -0,0 +1,127 @@
+package zauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * CSRF防护，仅在登录态来自cookie时生效，Authorization头认证的请求天然不受CSRF影响
+ *  - token: 签名双提交，cookie中的csrf值由会话签名得到，非安全方法需在请求头中原样带回
+ *  - origin: 校验Origin/Referer是否为本站或受信任来源
+ */
+
+type CsrfMode string
+
+const (
+	CsrfModeToken  CsrfMode = "token"
+	CsrfModeOrigin CsrfMode = "origin"
+	CsrfModeBoth   CsrfMode = "both"
+)
+
+type CsrfOptions struct {
+	Mode           CsrfMode `yaml:"mode" note:"token/origin/both，默认token"`
+	CookieName     string   `yaml:"cookie_name" note:"默认csrf"`
+	HeaderName     string   `yaml:"header_name" note:"默认X-Csrf-Token"`
+	TrustedOrigins []string `yaml:"trusted_origins" note:"受信任的来源，如https://a.com，默认只允许同源"`
+	Ignore         []string `yaml:"ignore" note:"不校验的PATH前缀"`
+}
+
+func (o *CsrfOptions) Validate() {
+	o.Mode = zutil.FirstTruth(o.Mode, CsrfModeToken)
+	o.CookieName = zutil.FirstTruth(o.CookieName, "csrf")
+	o.HeaderName = zutil.FirstTruth(o.HeaderName, "X-Csrf-Token")
+}
+
+// NewCsrf
+// @Description: CSRF中间件，需放在NewMiddleware之后
+// @param opts
+// @return gin.HandlerFunc
+func NewCsrf(opts *CsrfOptions) gin.HandlerFunc {
+	zlog.Infof("middleware csrf enabled")
+	opts = zutil.FirstTruth(opts, &CsrfOptions{})
+	opts.Validate()
+	return func(c *gin.Context) {
+		session, ok := c.Get(LocalsSessionPrefix)
+		if !ok || !FromCookie(c) {
+			c.Next()
+			return
+		}
+		for _, ignore := range opts.Ignore {
+			if strings.HasPrefix(strings.TrimPrefix(c.Request.URL.Path, "/"), strings.TrimPrefix(ignore, "/")) {
+				c.Next()
+				return
+			}
+		}
+		expect := CsrfToken(session.(string))
+		if opts.Mode != CsrfModeOrigin {
+			if v, _ := c.Cookie(opts.CookieName); v != expect {
+				c.SetSameSite(options.SameSite())
+				c.SetCookie(opts.CookieName, expect, int(options.Age.Seconds()), options.CookiePath, options.CookieDomain, *options.CookieSecure, false)
+			}
+		}
+		if safeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if opts.Mode != CsrfModeToken && !sameOrigin(c, opts.TrustedOrigins) {
+			zlog.Warnf("csrf origin invalid: origin=%s referer=%s", c.GetHeader("Origin"), c.GetHeader("Referer"))
+			zgin.AbortHttpCode(c, http.StatusForbidden, zgin.MessageCsrfInvalid.Resp(c))
+			return
+		}
+		if opts.Mode != CsrfModeOrigin && !hmac.Equal([]byte(c.GetHeader(opts.HeaderName)), []byte(expect)) {
+			zlog.Warnf("csrf token invalid: path=%s", c.Request.URL.Path)
+			zgin.AbortHttpCode(c, http.StatusForbidden, zgin.MessageCsrfInvalid.Resp(c))
+			return
+		}
+		c.Next()
+	}
+}
+
+// CsrfToken
+// @Description: 由会话签名得到的csrf token，攻击者无法在不知道会话的情况下伪造
+// @param session
+// @return string
+func CsrfToken(session string) string {
+	mac := hmac.New(sha256.New, []byte(AESKey))
+	mac.Write([]byte(session))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+func sameOrigin(c *gin.Context, trusted []string) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		ref, err := url.Parse(c.GetHeader("Referer"))
+		if err != nil || ref.Host == "" {
+			return false
+		}
+		origin = ref.Scheme + "://" + ref.Host
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if u.Host == c.Request.Host {
+		return true
+	}
+	return slices.Contains(trusted, strings.TrimSuffix(origin, "/"))
+}
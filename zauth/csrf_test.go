@@ -0,0 +1,50 @@
+package zauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCsrf(t *testing.T) {
+	mw := NewCsrf(&CsrfOptions{Mode: CsrfModeBoth, TrustedOrigins: []string{"https://admin.example.com"}})
+	run := func(method, origin, header string, cookie bool) int {
+		req := httptest.NewRequest(method, "http://app.example.com/v1/items", nil)
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: cookieName(), Value: "token"})
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if header != "" {
+			req.Header.Set("X-Csrf-Token", header)
+		}
+		c, w := testContext(req)
+		c.Set(LocalsSessionPrefix, "session")
+		mw(c)
+		if c.IsAborted() {
+			return w.Code
+		}
+		return http.StatusOK
+	}
+	token := CsrfToken("session")
+	cases := []struct {
+		name                   string
+		method, origin, header string
+		cookie                 bool
+		want                   int
+	}{
+		{"safe method", http.MethodGet, "", "", true, http.StatusOK},
+		{"header auth", http.MethodPost, "https://evil.com", "", false, http.StatusOK},
+		{"valid", http.MethodPost, "http://app.example.com", token, true, http.StatusOK},
+		{"trusted origin", http.MethodPost, "https://admin.example.com", token, true, http.StatusOK},
+		{"missing token", http.MethodPost, "http://app.example.com", "", true, http.StatusForbidden},
+		{"wrong token", http.MethodPost, "http://app.example.com", CsrfToken("other"), true, http.StatusForbidden},
+		{"cross origin", http.MethodPost, "https://evil.com", token, true, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if code := run(c.method, c.origin, c.header, c.cookie); code != c.want {
+			t.Fatalf("%s: %d want %d", c.name, code, c.want)
+		}
+	}
+}
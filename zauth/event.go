@@ -0,0 +1,179 @@
+package zauth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin/zcpt"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * 认证事件流：登录、登录失败、登录态校验失败、登出
+ * 事件依次写入所有Sink，再交给Detector检测，Detector产生的告警交给AlertHandler
+ * 事件进入有界队列由固定数量的协程处理，队列满时丢弃并记录日志，撞库等突发流量不会无限创建协程
+ */
+
+const (
+	eventQueueSize = 4096
+	eventWorkers   = 8
+)
+
+type AuthEventType string
+
+const (
+	AuthEventLogin        AuthEventType = "login"
+	AuthEventLoginFailed  AuthEventType = "login_failed"
+	AuthEventTokenInvalid AuthEventType = "token_invalid"
+	AuthEventLogout       AuthEventType = "logout"
+	AuthEventImpersonate  AuthEventType = "impersonate"
+)
+
+type AuthResult string
+
+const (
+	AuthResultSuccess AuthResult = "success"
+	AuthResultFailure AuthResult = "failure"
+)
+
+type AuthEvent struct {
+	Type    AuthEventType `json:"type"`
+	Userid  string        `json:"userid,omitempty"`
+	Account string        `json:"account,omitempty"`
+	Mode    LoginMode     `json:"mode,omitempty"`
+	Ip      string        `json:"ip"`
+	Ua      string        `json:"ua"`
+	Result  AuthResult    `json:"result"`
+	Reason  string        `json:"reason,omitempty"`
+	Time    time.Time     `json:"time"`
+}
+
+// Device
+// @Description: 设备指纹，目前按UA区分
+// @receiver e
+// @return string
+func (e *AuthEvent) Device() string {
+	return zcpt.Md5(e.Ua)
+}
+
+type AuthEventSink interface {
+	Record(ctx context.Context, event *AuthEvent) error
+}
+
+type AuthAlert struct {
+	Rule    string     `json:"rule"`
+	Message string     `json:"message"`
+	Event   *AuthEvent `json:"event"`
+}
+
+type AuthDetector interface {
+	// Detect
+	// @Description: 检测事件，无异常返回nil
+	// @param ctx
+	// @param event
+	// @return *AuthAlert
+	Detect(ctx context.Context, event *AuthEvent) *AuthAlert
+}
+
+type AlertHandler func(ctx context.Context, alert *AuthAlert)
+
+var (
+	eventOnce  sync.Once
+	eventQueue chan eventJob
+	sinks      []AuthEventSink
+	detectors  []AuthDetector
+	alert      AlertHandler = func(ctx context.Context, alert *AuthAlert) {
+		zlog.Warnf("auth alert [%s] userid=%s ip=%s: %s", alert.Rule, alert.Event.Userid, alert.Event.Ip, alert.Message)
+	}
+)
+
+// EventSinkAdd
+// @Description: 注册事件存储，需在服务启动前调用
+// @param s
+func EventSinkAdd(s ...AuthEventSink) {
+	sinks = append(sinks, s...)
+}
+
+// EventDetectorAdd
+// @Description: 注册异常检测，需在服务启动前调用
+// @param d
+func EventDetectorAdd(d ...AuthDetector) {
+	detectors = append(detectors, d...)
+}
+
+// EventAlertHandle
+// @Description: 自定义告警处理，默认写日志
+// @param fn
+func EventAlertHandle(fn AlertHandler) {
+	if fn != nil {
+		alert = fn
+	}
+}
+
+// EventEmit
+// @Description: 异步分发事件，自定义登录流程也可调用
+// @param ctx
+// @param event
+func EventEmit(ctx context.Context, event *AuthEvent) {
+	if len(sinks) == 0 && len(detectors) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Result == "" {
+		event.Result = AuthResultFailure
+		if event.Type == AuthEventLogin || event.Type == AuthEventLogout || event.Type == AuthEventImpersonate {
+			event.Result = AuthResultSuccess
+		}
+	}
+	eventOnce.Do(func() {
+		eventQueue = make(chan eventJob, eventQueueSize)
+		for i := 0; i < eventWorkers; i++ {
+			go func() {
+				for job := range eventQueue {
+					dispatch(job.ctx, job.event)
+				}
+			}()
+		}
+	})
+	select {
+	case eventQueue <- eventJob{ctx: context.WithoutCancel(ctx), event: event}:
+	default:
+		zlog.Warnf("auth event queue full, dropped %s userid=%s ip=%s", event.Type, event.Userid, event.Ip)
+	}
+}
+
+type eventJob struct {
+	ctx   context.Context
+	event *AuthEvent
+}
+
+func dispatch(ctx context.Context, event *AuthEvent) {
+	for _, s := range sinks {
+		if err := s.Record(ctx, event); err != nil {
+			zlog.Warnf("auth event record err: %v", err)
+		}
+	}
+	for _, d := range detectors {
+		if a := d.Detect(ctx, event); a != nil {
+			a.Event = event
+			alert(ctx, a)
+		}
+	}
+}
+
+func emit(c *gin.Context, typ AuthEventType, userid, reason string) {
+	EventEmit(c.Request.Context(), newEvent(c, typ, userid, reason))
+}
+func newEvent(c *gin.Context, typ AuthEventType, userid, reason string) *AuthEvent {
+	return &AuthEvent{
+		Type:   typ,
+		Userid: userid,
+		Ip:     c.ClientIP(),
+		Ua:     c.Request.UserAgent(),
+		Reason: reason,
+	}
+}
@@ -0,0 +1,130 @@
+package zauth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zutil"
+)
+
+// BruteForceDetector
+// @Description: 窗口内同一IP或账号失败次数超限时告警
+type BruteForceDetector struct {
+	Window    time.Duration // 统计窗口，默认10min
+	Threshold int64         // 失败次数阈值，默认10
+}
+
+func (d *BruteForceDetector) Detect(ctx context.Context, event *AuthEvent) *AuthAlert {
+	if event.Result != AuthResultFailure {
+		return nil
+	}
+	window := zutil.FirstTruth(d.Window, time.Minute*10)
+	threshold := zutil.FirstTruth(d.Threshold, 10)
+	targets := map[string]string{"ip": event.Ip}
+	if who := zutil.FirstTruth(event.Userid, event.Account); who != "" {
+		targets["user"] = who
+	}
+	// 先累加所有维度再告警，避免提前返回漏计其他维度
+	var hit []string
+	for _, kind := range []string{"ip", "user"} {
+		target, ok := targets[kind]
+		if !ok {
+			continue
+		}
+		key := zch.PrefixAuthEvent.Key("fail", kind, target)
+		count, err := zch.R().Incr(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		if count == 1 {
+			zch.R().Expire(ctx, key, window)
+		}
+		// 只在刚好达到阈值时告警一次，避免告警风暴
+		if count == threshold {
+			hit = append(hit, fmt.Sprintf("%s %s failed %d times in %s", kind, target, count, window))
+		}
+	}
+	if len(hit) == 0 {
+		return nil
+	}
+	return &AuthAlert{
+		Rule:    "brute_force",
+		Message: strings.Join(hit, "; "),
+	}
+}
+
+// NewDeviceDetector
+// @Description: 用户在未见过的设备上登录成功时告警，首次登录不告警
+type NewDeviceDetector struct {
+	Remember time.Duration // 设备记忆时长，默认90天
+}
+
+func (d *NewDeviceDetector) Detect(ctx context.Context, event *AuthEvent) *AuthAlert {
+	if event.Type != AuthEventLogin || event.Userid == "" {
+		return nil
+	}
+	key := zch.PrefixAuthEvent.Key("device", event.Userid)
+	added, err := zch.R().SAdd(ctx, key, event.Device()).Result()
+	if err != nil {
+		return nil
+	}
+	zch.R().Expire(ctx, key, zutil.FirstTruth(d.Remember, time.Hour*24*90))
+	if added == 1 && zch.R().SCard(ctx, key).Val() > 1 {
+		return &AuthAlert{
+			Rule:    "new_device",
+			Message: fmt.Sprintf("login from new device: %s", event.Ua),
+		}
+	}
+	return nil
+}
+
+// Locator
+// @Description: IP定位，返回WGS84经纬度
+type Locator func(ctx context.Context, ip string) (lon, lat float64, ok bool)
+
+// ImpossibleTravelDetector
+// @Description: 两次成功登录之间的移动速度超过MaxSpeed时告警
+type ImpossibleTravelDetector struct {
+	Locate   Locator
+	MaxSpeed float64       // km/h，默认900
+	MinGap   float64       // 公里，忽略IP定位误差，默认100
+	Remember time.Duration // 上次位置保存时长，默认30天
+}
+
+func (d *ImpossibleTravelDetector) Detect(ctx context.Context, event *AuthEvent) *AuthAlert {
+	if event.Type != AuthEventLogin || event.Userid == "" || d.Locate == nil {
+		return nil
+	}
+	lon, lat, ok := d.Locate(ctx, event.Ip)
+	if !ok {
+		return nil
+	}
+	key := zch.PrefixAuthEvent.Key("geo", event.Userid)
+	last := zch.R().Get(ctx, key).Val()
+	zch.R().Set(ctx, key, fmt.Sprintf("%f,%f,%d", lon, lat, event.Time.Unix()), zutil.FirstTruth(d.Remember, time.Hour*24*30))
+
+	arr := strings.Split(last, ",")
+	if len(arr) != 3 {
+		return nil
+	}
+	lastLon, _ := strconv.ParseFloat(arr[0], 64)
+	lastLat, _ := strconv.ParseFloat(arr[1], 64)
+	lastAt, _ := strconv.ParseInt(arr[2], 10, 64)
+	km := zutil.Distance(lastLon, lastLat, lon, lat) / 1000
+	if km < zutil.FirstTruth(d.MinGap, 100) {
+		return nil
+	}
+	hours := event.Time.Sub(time.Unix(lastAt, 0)).Hours()
+	speed := km / max(hours, 1.0/3600)
+	if speed > zutil.FirstTruth(d.MaxSpeed, 900) {
+		return &AuthAlert{
+			Rule:    "impossible_travel",
+			Message: fmt.Sprintf("moved %.0fkm in %.1fh (%.0fkm/h)", km, hours, speed),
+		}
+	}
+	return nil
+}
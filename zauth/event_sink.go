@@ -0,0 +1,69 @@
+package zauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zdb"
+)
+
+// ZauthEvent
+// @Description: 认证事件表
+type ZauthEvent struct {
+	Id        uint64        `json:"id" gorm:"->;primarykey"`
+	Type      AuthEventType `json:"type" gorm:"index;comment:事件类型"`
+	Userid    string        `json:"userid" gorm:"index;comment:用户ID"`
+	Account   string        `json:"account" gorm:"comment:登录账号"`
+	Mode      LoginMode     `json:"mode" gorm:"comment:登录方式"`
+	Ip        string        `json:"ip" gorm:"index;comment:IP"`
+	Ua        string        `json:"ua" gorm:"comment:UserAgent"`
+	Result    AuthResult    `json:"result" gorm:"comment:结果"`
+	Reason    string        `json:"reason" gorm:"comment:原因"`
+	CreatedAt time.Time     `json:"created_at" gorm:"index;comment:发生时间"`
+}
+
+type dbEventSink struct{}
+
+// NewDBEventSink
+// @Description: 事件写入数据库，会自动同步表结构
+// @return AuthEventSink
+func NewDBEventSink() AuthEventSink {
+	zdb.AutoMigrate([]any{&ZauthEvent{}})
+	return &dbEventSink{}
+}
+
+func (s *dbEventSink) Record(ctx context.Context, event *AuthEvent) error {
+	return zdb.NewDB(ctx).Create(&ZauthEvent{
+		Type:      event.Type,
+		Userid:    event.Userid,
+		Account:   event.Account,
+		Mode:      event.Mode,
+		Ip:        event.Ip,
+		Ua:        event.Ua,
+		Result:    event.Result,
+		Reason:    event.Reason,
+		CreatedAt: event.Time,
+	}).Error
+}
+
+type topicEventSink struct {
+	topic *zch.Topic
+}
+
+// NewTopicEventSink
+// @Description: 事件以JSON发布到zch队列，供其他服务消费
+// @param prefix
+// @return AuthEventSink
+func NewTopicEventSink(prefix zch.Prefix) AuthEventSink {
+	return &topicEventSink{topic: zch.NewTopic(prefix)}
+}
+
+func (s *topicEventSink) Record(ctx context.Context, event *AuthEvent) error {
+	msg, err := sonic.MarshalString(event)
+	if err != nil {
+		return err
+	}
+	return s.topic.Publish(ctx, msg)
+}
@@ -0,0 +1,73 @@
+package zauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zohu/zgin/zch"
+)
+
+type chanSink chan *AuthEvent
+
+func (s chanSink) Record(_ context.Context, event *AuthEvent) error {
+	s <- event
+	return nil
+}
+
+func TestBruteForceDetector(t *testing.T) {
+	mr.FlushAll()
+	ctx := context.Background()
+	d := &BruteForceDetector{Threshold: 3}
+	var alerts []*AuthAlert
+	for i := 0; i < 5; i++ {
+		if a := d.Detect(ctx, &AuthEvent{Result: AuthResultFailure, Ip: "1.1.1.1", Account: "bob"}); a != nil {
+			alerts = append(alerts, a)
+		}
+	}
+	// 两个维度同时达到阈值时一次告警，且两个计数都不遗漏
+	if len(alerts) != 1 || !strings.Contains(alerts[0].Message, "ip 1.1.1.1") || !strings.Contains(alerts[0].Message, "user bob") {
+		t.Fatalf("alerts: %+v", alerts)
+	}
+	for _, kind := range [][2]string{{"ip", "1.1.1.1"}, {"user", "bob"}} {
+		if n, _ := zch.R().Get(ctx, zch.PrefixAuthEvent.Key("fail", kind[0], kind[1])).Int(); n != 5 {
+			t.Fatalf("%s count %d", kind[0], n)
+		}
+	}
+	if a := d.Detect(ctx, &AuthEvent{Result: AuthResultSuccess, Ip: "1.1.1.1"}); a != nil {
+		t.Fatalf("success alerted: %+v", a)
+	}
+}
+
+func TestNewDeviceDetector(t *testing.T) {
+	mr.FlushAll()
+	ctx := context.Background()
+	d := &NewDeviceDetector{}
+	if a := d.Detect(ctx, &AuthEvent{Type: AuthEventLogin, Userid: "u1", Ua: "a"}); a != nil {
+		t.Fatal("first device alerted")
+	}
+	if a := d.Detect(ctx, &AuthEvent{Type: AuthEventLogin, Userid: "u1", Ua: "a"}); a != nil {
+		t.Fatal("known device alerted")
+	}
+	if a := d.Detect(ctx, &AuthEvent{Type: AuthEventLogin, Userid: "u1", Ua: "b"}); a == nil {
+		t.Fatal("new device not alerted")
+	}
+}
+
+func TestEventEmit(t *testing.T) {
+	sink := make(chanSink, 1)
+	old := sinks
+	sinks = []AuthEventSink{sink}
+	t.Cleanup(func() { sinks = old })
+
+	EventEmit(context.Background(), &AuthEvent{Type: AuthEventLoginFailed, Ip: "1.1.1.1"})
+	select {
+	case e := <-sink:
+		if e.Result != AuthResultFailure || e.Time.IsZero() {
+			t.Fatalf("event defaults: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not dispatched")
+	}
+}
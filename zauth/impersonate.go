@@ -0,0 +1,130 @@
+package zauth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zcpt"
+	"github.com/zohu/zid"
+	"github.com/zohu/zlog"
+)
+
+// impersonateNonce 代登录token随机段的前缀，base36不含'-'，不会与普通token冲突
+const impersonateNonce = "imp-"
+
+// AuthActor
+// @Description: 代登录时的实际操作人
+type AuthActor struct {
+	Userid   string `json:"userid"`
+	UserName string `json:"username"`
+}
+
+type ParamImpersonate struct {
+	Userid string `json:"userid" binding:"required"`
+	Reason string `json:"reason" binding:"required" note:"代登录原因，写入审计"`
+}
+
+// SubjectLoader
+// @Description: 按userid加载被代登录的用户
+type SubjectLoader func(c *gin.Context, userid string) (Userinfo, error)
+
+// Actor
+// @Description: 当前会话是否为代登录，返回实际操作人，Auth返回的是被代登录的用户
+// @param c
+// @return *AuthActor
+// @return bool
+func Actor(c *gin.Context) (*AuthActor, bool) {
+	if a, ok := c.Get(LocalsActor); ok {
+		return a.(*AuthActor), true
+	}
+	return nil, false
+}
+
+// Impersonate
+// @Description: 以subject身份签发短期会话，需要options.ImpersonateAction权限，结束时调用Logout
+// token写入单独的cookie({CookieName}_imp)并在响应中返回，不覆盖操作人自己的会话
+// @param c
+// @param subject
+// @param reason
+// @return *Tokens
+// @return error
+func Impersonate(c *gin.Context, subject Userinfo, reason string) (*Tokens, error) {
+	actor, ok := Auth(c)
+	if !ok {
+		return nil, fmt.Errorf("not login")
+	}
+	if _, isKey := ApiKey(c); isKey {
+		return nil, fmt.Errorf("api key can not impersonate")
+	}
+	if _, imp := Actor(c); imp {
+		return nil, fmt.Errorf("already impersonating")
+	}
+	if !require(c.Request.Context(), actor.Userid(), []string{options.ImpersonateAction}) {
+		return nil, fmt.Errorf("permission denied: %s", options.ImpersonateAction)
+	}
+	if subject == nil || subject.Userid() == "" || subject.Userid() == actor.Userid() {
+		return nil, fmt.Errorf("subject is invalid")
+	}
+	if vali := subject.Validate(); vali != zgin.MessageSuccess {
+		return nil, vali.Error()
+	}
+	token := newToken(c, impersonateNonce+zid.NextBase36(), subject.Userid())
+	session := zcpt.Md5(token)
+	str, _ := sonic.MarshalString(&Authorization[Userinfo]{
+		Session: session,
+		Value:   subject,
+		Actor:   &AuthActor{Userid: actor.Userid(), UserName: actor.UserName()},
+	})
+	if err := zch.R().Set(c.Request.Context(), zch.PrefixAuthImp.Key(session), str, options.ImpersonateAge).Err(); err != nil {
+		return nil, fmt.Errorf("save impersonate session err: %w", err)
+	}
+	setNamedCookie(c, impersonateCookieName(), token, options.ImpersonateAge)
+
+	zlog.Infof("auth impersonate actor=%s subject=%s reason=%s", actor.Userid(), subject.Userid(), reason)
+	event := newEvent(c, AuthEventImpersonate, subject.Userid(), fmt.Sprintf("actor=%s reason=%s", actor.Userid(), reason))
+	event.Account = actor.Userid()
+	EventEmit(c.Request.Context(), event)
+	return &Tokens{
+		Token:  token,
+		Expire: int64(options.ImpersonateAge / time.Second),
+	}, nil
+}
+
+// ImpersonateRouteRegister
+// @Description: 代登录接口，需挂在会话认证之后，结束代登录调用/logout
+// @param r
+// @param load
+func ImpersonateRouteRegister(r *gin.RouterGroup, load SubjectLoader) {
+	r.POST("/impersonate", zgin.Bind(func(c *gin.Context, h *ParamImpersonate) *zgin.RespBean {
+		subject, err := load(c, h.Userid)
+		if err != nil {
+			return zgin.MessageQueryFailed.Resp(c).AddMessage(err.Error())
+		}
+		tokens, err := Impersonate(c, subject, h.Reason)
+		if err != nil {
+			return zgin.MessageActionInvalid.Resp(c).AddMessage(err.Error())
+		}
+		return zgin.NewRespWithData(c, tokens)
+	}))
+}
+
+// impersonateDenied
+// @Description: 代登录会话禁止访问敏感权限
+// @param actions
+// @return bool
+func impersonateDenied(actions []string) bool {
+	if options == nil || len(options.ImpersonateDeny) == 0 {
+		return false
+	}
+	deny := BuildPermissionTrie(options.ImpersonateDeny)
+	for _, action := range actions {
+		if deny.Match(action) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package zauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/zohu/zgin"
+)
+
+func TestImpersonate(t *testing.T) {
+	mr.FlushAll()
+	ctx := context.Background()
+	SavePermission(ctx, "admin", []string{options.ImpersonateAction})
+
+	c, w := testContext(httptest.NewRequest(http.MethodPost, "/impersonate", nil))
+	c.Set(LocalsUserPrefix, Userinfo(testUser{ID: "admin"}))
+	tokens, err := Impersonate(c, testUser{ID: "u1"}, "support")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != impersonateCookieName() || cookies[0].Value != url.QueryEscape(tokens.Token) {
+		t.Fatalf("impersonate must not overwrite the actor session cookie: %+v", cookies)
+	}
+
+	// 同时带有操作人和代登录cookie时按代登录会话认证
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName(), Value: "actor-token"})
+	req.AddCookie(cookies[0])
+	c, _ = testContext(req)
+	var auth Authorization[testUser]
+	if msgID := ScanAuth(c, &auth); msgID != zgin.MessageSuccess || auth.Value.ID != "u1" || auth.Actor == nil || auth.Actor.Userid != "admin" {
+		t.Fatalf("scan impersonated: %s %+v", msgID, auth)
+	}
+
+	// 结束代登录只清除代登录cookie
+	c, w = testContext(req)
+	if err = Logout(c); err != nil {
+		t.Fatal(err)
+	}
+	cookies = w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != impersonateCookieName() || cookies[0].MaxAge >= 0 {
+		t.Fatalf("logout cleared actor session: %+v", cookies)
+	}
+	c, _ = testContext(req)
+	if msgID := ScanAuth(c, &auth); msgID == zgin.MessageSuccess {
+		t.Fatal("impersonate session still valid after logout")
+	}
+
+	// 没有代登录权限
+	c, _ = testContext(httptest.NewRequest(http.MethodPost, "/impersonate", nil))
+	c.Set(LocalsUserPrefix, Userinfo(testUser{ID: "u2"}))
+	if _, err = Impersonate(c, testUser{ID: "u1"}, "x"); err == nil {
+		t.Fatal("impersonate without permission")
+	}
+}
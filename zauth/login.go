@@ -26,6 +26,20 @@ func LoginMethodAdd(mode LoginMode, login LoginEntity) {
 func LoginRouteRegister(r *gin.RouterGroup) {
 	r.POST("/login", zgin.Bind(preLogin))
 	r.POST("/token", zgin.Bind(postLogin))
+	r.POST("/logout", func(c *gin.Context) {
+		if err := Logout(c); err != nil {
+			zgin.Abort(c, zgin.MessageLoginTokenInvalid.Resp(c).AddMessage(err.Error()))
+			return
+		}
+		zgin.Abort(c, zgin.MessageSuccess.Resp(c))
+	})
+	r.POST("/logout/all", func(c *gin.Context) {
+		if err := LogoutAll(c); err != nil {
+			zgin.Abort(c, zgin.MessageLoginTokenInvalid.Resp(c).AddMessage(err.Error()))
+			return
+		}
+		zgin.Abort(c, zgin.MessageSuccess.Resp(c))
+	})
 }
 
 func preLogin(c *gin.Context, h *ParamLoginPre) *zgin.RespBean {
@@ -33,10 +47,13 @@ func preLogin(c *gin.Context, h *ParamLoginPre) *zgin.RespBean {
 	if entity, ok := logins.Get(h.Mode); ok {
 		resp, err := entity.PreLogin(c, id, h)
 		if err != nil {
+			event := newEvent(c, AuthEventLoginFailed, "", err.Error())
+			event.Account, event.Mode = h.Account, h.Mode
+			EventEmit(c.Request.Context(), event)
 			return zgin.MessageLoginFailed.Resp(c).AddMessage(err.Error())
 		}
 		if resp.User != nil && resp.User.Userid() != "" {
-			return activeToken(c, resp.User)
+			return activeToken(c, h.Mode, resp.User)
 		}
 		expire := zutil.When(resp.PreExpire > 0, resp.PreExpire, time.Minute*5)
 		zch.R().Set(c.Request.Context(), zch.PrefixAuthPreID.Key(id), "waiting", expire)
@@ -56,6 +73,9 @@ func postLogin(c *gin.Context, h *ParamLoginPost) *zgin.RespBean {
 	if entity, ok := logins.Get(h.Mode); ok {
 		resp, err := entity.PostLogin(c, h.Mode, h.ID)
 		if err != nil {
+			event := newEvent(c, AuthEventLoginFailed, "", err.Error())
+			event.Mode = h.Mode
+			EventEmit(c.Request.Context(), event)
 			return zgin.MessageLoginFailed.Resp(c).AddMessage(err.Error())
 		}
 		if !resp.IsDone {
@@ -63,30 +83,56 @@ func postLogin(c *gin.Context, h *ParamLoginPost) *zgin.RespBean {
 		}
 		zch.R().Set(c.Request.Context(), zch.PrefixAuthPreID.Key(h.ID), "done", time.Minute*5)
 		if resp.User != nil && resp.User.Userid() != "" {
-			return activeToken(c, resp.User)
+			return activeToken(c, h.Mode, resp.User)
 		}
 		return zgin.MessageLoginFailed.Resp(c)
 	}
 	return zgin.MessageLoginUnsupportedMode.Resp(c)
 }
-func activeToken(c *gin.Context, user Userinfo) *zgin.RespBean {
+func activeToken(c *gin.Context, mode LoginMode, user Userinfo) *zgin.RespBean {
+	event := newEvent(c, AuthEventLogin, user.Userid(), "")
+	event.Mode = mode
 	if vali := user.Validate(); vali != zgin.MessageSuccess {
+		event.Type, event.Reason = AuthEventLoginFailed, string(vali)
+		EventEmit(c.Request.Context(), event)
 		return vali.Resp(c)
 	}
-	vKey := zch.PrefixAuthToken.Key(user.Userid())
+	ctx := c.Request.Context()
+	vKey, sKey := zch.PrefixAuthToken.Key(user.Userid()), zch.PrefixAuthSession.Key(user.Userid())
+	// 生成登录态，会话登记到用户的会话列表，校验时须存在
+	token := newToken(c, zid.NextBase36(), user.Userid())
+	session := zcpt.Md5(token)
+	userStr, _ := sonic.MarshalString(&Authorization[Userinfo]{Session: session, Value: user})
+	pipe := zch.R().TxPipeline()
 	// 是否允许多设备登录
 	if !options.AllowMultipleDevice {
-		zch.R().Del(c.Request.Context(), vKey)
+		pipe.Del(ctx, vKey, sKey)
 	}
-	// 生成登录态
-	tk := fmt.Sprintf("%s##%s##%s##%s##%d", zid.NextBase36(), zcpt.Md5(c.Request.UserAgent()), c.ClientIP(), user.Userid(), time.Now().Unix())
-	d, _ := zcpt.AesEncryptCBC([]byte(tk), []byte(AESKey))
-	token := base64.StdEncoding.EncodeToString(d)
-	c.SetCookie("auth", token, int(options.Age.Seconds()), "", "", true, true)
-	userStr, _ := sonic.MarshalString(&Authorization[Userinfo]{Session: zcpt.Md5(token), Value: user})
-	zch.R().Set(c.Request.Context(), vKey, userStr, options.Age)
+	pipe.Set(ctx, vKey, userStr, options.Age)
+	pipe.HSet(ctx, sKey, session, time.Now().UnixMilli())
+	pipe.Expire(ctx, sKey, options.Age)
+	if _, err := pipe.Exec(ctx); err != nil {
+		zlog.Errorf("auth save session userid=%s err: %v", user.Userid(), err)
+		event.Type, event.Reason = AuthEventLoginFailed, err.Error()
+		EventEmit(ctx, event)
+		return zgin.MessageLoginFailed.Resp(c)
+	}
+	setCookie(c, token, options.Age)
+	EventEmit(c.Request.Context(), event)
 	return zgin.MessageSuccess.Resp(c).WithData(&Tokens{
 		Token:  token,
 		Expire: int64(options.Age.Seconds()),
 	})
 }
+
+// newToken
+// @Description: 生成登录态，格式见decodeToken
+// @param c
+// @param nonce
+// @param userid
+// @return string
+func newToken(c *gin.Context, nonce, userid string) string {
+	tk := fmt.Sprintf("%s##%s##%s##%s##%d", nonce, zcpt.Md5(c.Request.UserAgent()), c.ClientIP(), userid, time.Now().UnixMilli())
+	d, _ := zcpt.AesEncryptCBC([]byte(tk), []byte(AESKey))
+	return base64.StdEncoding.EncodeToString(d)
+}
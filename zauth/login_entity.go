@@ -22,8 +22,9 @@ type Userinfo interface {
 }
 
 type Authorization[T Userinfo] struct {
-	Session string `json:"session"`
-	Value   T      `json:"value"`
+	Session string     `json:"session"`
+	Value   T          `json:"value"`
+	Actor   *AuthActor `json:"actor,omitempty"` // 代登录时的实际操作人
 }
 type Tokens struct {
 	ID       string `json:"id,omitempty"`
@@ -0,0 +1,227 @@
+package zauth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zcpt"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * 吊销列表：auth:revoke:{userid} 为hash
+ *  - field 为会话(md5(token))，表示该会话已登出
+ *  - field before 为毫秒时间戳，不晚于该时间签发的token全部失效
+ * 吊销列表与登录态同生命周期，校验时顺带续期
+ * 登出前按ScanAuth的规则校验会话真实有效，不能凭解密得到的userid登出他人
+ * 读取吊销列表失败时默认视为已吊销，Options.RevokeFailOpen可改为放行
+ */
+
+const revokeBefore = "before"
+
+type LogoutEvent struct {
+	Userid  string `json:"userid"`
+	Session string `json:"session,omitempty" note:"为空表示全部会话"`
+}
+
+func (e *LogoutEvent) All() bool {
+	return e.Session == ""
+}
+
+type LogoutHook func(ctx context.Context, event *LogoutEvent)
+
+var (
+	logoutHooks []LogoutHook
+	logoutOnce  sync.Once
+)
+
+// LogoutHookAdd
+// @Description: 登出时回调，通过redis广播到所有实例，可用于断开websocket等长连接
+// @param hooks
+func LogoutHookAdd(hooks ...LogoutHook) {
+	logoutHooks = append(logoutHooks, hooks...)
+	logoutOnce.Do(func() {
+		go subscribeLogout()
+	})
+}
+
+// Logout
+// @Description: 登出当前会话并清除cookie
+// @param c
+// @return error
+func Logout(c *gin.Context) error {
+	token := Token(c)
+	if token == "" {
+		clearCookie(c)
+		return fmt.Errorf("token is empty")
+	}
+	tks, _ := decodeToken(token)
+	clearSessionCookie(c, tks)
+	session, msgID := verifyToken(c, token)
+	if msgID != zgin.MessageSuccess {
+		return fmt.Errorf("token invalid: %s", msgID)
+	}
+	userid := session.userid
+	if err := LogoutSession(c.Request.Context(), userid, zcpt.Md5(token)); err != nil {
+		return err
+	}
+	emit(c, AuthEventLogout, userid, "")
+	return nil
+}
+
+// LogoutAll
+// @Description: 登出当前用户的全部会话并清除cookie
+// @param c
+// @return error
+func LogoutAll(c *gin.Context) error {
+	token := Token(c)
+	if token == "" {
+		clearCookie(c)
+		return fmt.Errorf("token is empty")
+	}
+	tks, _ := decodeToken(token)
+	clearSessionCookie(c, tks)
+	session, msgID := verifyToken(c, token)
+	if msgID != zgin.MessageSuccess {
+		return fmt.Errorf("token invalid: %s", msgID)
+	}
+	if err := LogoutUser(c.Request.Context(), session.userid); err != nil {
+		return err
+	}
+	emit(c, AuthEventLogout, session.userid, "all")
+	return nil
+}
+
+// LogoutSession
+// @Description: 吊销指定会话
+// @param ctx
+// @param userid
+// @param session md5(token)
+// @return error
+func LogoutSession(ctx context.Context, userid, session string) error {
+	vKey := zch.PrefixAuthToken.Key(userid)
+	// 不允许多设备时只有一个会话，直接删除
+	if options != nil && !options.AllowMultipleDevice {
+		var auth Authorization[Userinfo]
+		if str := zch.R().Get(ctx, vKey).Val(); str != "" {
+			_ = sonic.UnmarshalString(str, &auth)
+			if auth.Session == session {
+				zch.R().Del(ctx, vKey)
+			}
+		}
+	}
+	rKey := zch.PrefixAuthRevoke.Key(userid)
+	pipe := zch.R().TxPipeline()
+	pipe.HSet(ctx, rKey, session, time.Now().Unix())
+	pipe.Expire(ctx, rKey, revokeAge())
+	pipe.Del(ctx, zch.PrefixAuthImp.Key(session))
+	pipe.HDel(ctx, zch.PrefixAuthSession.Key(userid), session)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("revoke session err: %w", err)
+	}
+	publishLogout(ctx, &LogoutEvent{Userid: userid, Session: session})
+	return nil
+}
+
+// LogoutUser
+// @Description: 吊销用户全部会话，可用于管理员踢人
+// @param ctx
+// @param userid
+// @return error
+func LogoutUser(ctx context.Context, userid string) error {
+	rKey := zch.PrefixAuthRevoke.Key(userid)
+	pipe := zch.R().TxPipeline()
+	pipe.Del(ctx, zch.PrefixAuthToken.Key(userid), zch.PrefixAuthSession.Key(userid), rKey)
+	pipe.HSet(ctx, rKey, revokeBefore, time.Now().UnixMilli())
+	pipe.Expire(ctx, rKey, revokeAge())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("revoke user err: %w", err)
+	}
+	publishLogout(ctx, &LogoutEvent{Userid: userid})
+	return nil
+}
+
+// Revoked
+// @Description: 会话是否已被吊销
+// @param ctx
+// @param userid
+// @param session md5(token)
+// @param issued token签发毫秒时间戳
+// @return bool
+func Revoked(ctx context.Context, userid, session, issued string) bool {
+	rKey := zch.PrefixAuthRevoke.Key(userid)
+	pipe := zch.R().Pipeline()
+	get := pipe.HMGet(ctx, rKey, session, revokeBefore)
+	pipe.Expire(ctx, rKey, revokeAge())
+	if _, err := pipe.Exec(ctx); err != nil {
+		failOpen := options != nil && options.RevokeFailOpen
+		zlog.Errorf("auth revoke check userid=%s err: %v, fail open=%t", userid, err, failOpen)
+		return !failOpen
+	}
+	vals := get.Val()
+	if len(vals) != 2 {
+		return false
+	}
+	if vals[0] != nil {
+		return true
+	}
+	if before, ok := vals[1].(string); ok {
+		b, _ := strconv.ParseInt(before, 10, 64)
+		i, _ := strconv.ParseInt(issued, 10, 64)
+		return i <= b
+	}
+	return false
+}
+
+// clearSessionCookie
+// @Description: 清除当前会话的cookie，结束代登录时只清除代登录cookie，操作人的会话保持不变
+// @param c
+// @param tks
+func clearSessionCookie(c *gin.Context, tks []string) {
+	if len(tks) > 0 && strings.HasPrefix(tks[0], impersonateNonce) {
+		setNamedCookie(c, impersonateCookieName(), "", 0)
+		return
+	}
+	clearCookie(c)
+}
+
+func revokeAge() time.Duration {
+	if options == nil {
+		return time.Hour * 2
+	}
+	return options.Age
+}
+
+func publishLogout(ctx context.Context, event *LogoutEvent) {
+	msg, _ := sonic.MarshalString(event)
+	if err := zch.R().Publish(ctx, zch.PrefixAuthLogout.Key(), msg).Err(); err != nil {
+		zlog.Warnf("publish logout err: %v", err)
+	}
+}
+
+func subscribeLogout() {
+	ctx := context.Background()
+	for {
+		sub := zch.R().Subscribe(ctx, zch.PrefixAuthLogout.Key())
+		for msg := range sub.Channel() {
+			var event LogoutEvent
+			if err := sonic.UnmarshalString(msg.Payload, &event); err != nil {
+				continue
+			}
+			for _, hook := range logoutHooks {
+				hook(ctx, &event)
+			}
+		}
+		_ = sub.Close()
+		zlog.Warnf("logout subscribe closed, retry after 3s")
+		time.Sleep(time.Second * 3)
+	}
+}
@@ -0,0 +1,90 @@
+package zauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+)
+
+func TestRevoked(t *testing.T) {
+	mr.FlushAll()
+	ctx := context.Background()
+	ms := func(t time.Time) string { return strconv.FormatInt(t.UnixMilli(), 10) }
+
+	issued := ms(time.Now())
+	if Revoked(ctx, "u1", "s1", issued) {
+		t.Fatal("fresh session revoked")
+	}
+	if err := LogoutSession(ctx, "u1", "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if !Revoked(ctx, "u1", "s1", issued) || Revoked(ctx, "u1", "s2", issued) {
+		t.Fatal("logout session")
+	}
+
+	before := time.Now()
+	if err := LogoutUser(ctx, "u2"); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+	// 同一秒内签发的token同样失效
+	if !Revoked(ctx, "u2", "s1", ms(before)) || !Revoked(ctx, "u2", "s1", ms(before.Add(-time.Minute))) {
+		t.Fatal("logout all kept older token")
+	}
+	if Revoked(ctx, "u2", "s1", ms(after.Add(time.Millisecond))) {
+		t.Fatal("logout all revoked newer token")
+	}
+
+	// 读取失败时默认拒绝
+	mr.SetError("down")
+	defer mr.SetError("")
+	if !Revoked(ctx, "u3", "s1", issued) {
+		t.Fatal("revoke check failed open")
+	}
+	options.RevokeFailOpen = true
+	defer func() { options.RevokeFailOpen = false }()
+	if Revoked(ctx, "u3", "s1", issued) {
+		t.Fatal("fail open not honored")
+	}
+}
+
+func TestLogoutForged(t *testing.T) {
+	defer func(multiple bool) { options.AllowMultipleDevice = multiple }(options.AllowMultipleDevice)
+	for _, multiple := range []bool{false, true} {
+		mr.FlushAll()
+		options.AllowMultipleDevice = multiple
+		request := func(token string) *gin.Context {
+			c, _ := testContext(httptest.NewRequest(http.MethodPost, "/logout", nil))
+			c.Set(LocalsToken, token)
+			return c
+		}
+		c, _ := testContext(httptest.NewRequest(http.MethodPost, "/token", nil))
+		resp := activeToken(c, "test", testUser{ID: "victim"})
+		victim := resp.Data.(*Tokens).Token
+
+		// 用公开的密钥伪造他人的token不能登出
+		forged := newToken(request(""), "x", "victim")
+		if err := LogoutAll(request(forged)); err == nil {
+			t.Fatalf("multiple=%t: forged token logged out all", multiple)
+		}
+		if err := Logout(request(forged)); err == nil {
+			t.Fatalf("multiple=%t: forged token logged out", multiple)
+		}
+		var auth Authorization[testUser]
+		if msgID := ScanAuth(request(victim), &auth); msgID != zgin.MessageSuccess || auth.Value.ID != "victim" {
+			t.Fatalf("multiple=%t: victim session lost: %s", multiple, msgID)
+		}
+		if err := LogoutAll(request(victim)); err != nil {
+			t.Fatal(err)
+		}
+		if msgID := ScanAuth(request(victim), &auth); msgID == zgin.MessageSuccess {
+			t.Fatalf("multiple=%t: session valid after logout", multiple)
+		}
+	}
+}
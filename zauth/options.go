@@ -1,6 +1,8 @@
 package zauth
 
 import (
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -14,14 +16,47 @@ type Options struct {
 	AllowUaChange       bool                   `yaml:"allow_ua_change" note:"是否允许UA变化"`
 	WhiteList           []string               `yaml:"white_list"`
 	PathSkip            func(path string) bool `note:"是否跳过校验"`
+	RevokeFailOpen      bool                   `yaml:"revoke_fail_open" note:"吊销列表读取失败时是否放行，默认拒绝"`
+	ImpersonateAction   string                 `yaml:"impersonate_action" note:"代登录所需权限"`
+	ImpersonateAge      time.Duration          `yaml:"impersonate_age" note:"代登录会话有效期"`
+	ImpersonateDeny     []string               `yaml:"impersonate_deny" note:"代登录时禁止的权限，同Action格式"`
+	CookieName          string                 `yaml:"cookie_name" note:"登录态cookie名，默认auth"`
+	CookieDomain        string                 `yaml:"cookie_domain" note:"cookie域名，默认当前域"`
+	CookiePath          string                 `yaml:"cookie_path" note:"cookie路径，默认/"`
+	CookieSameSite      string                 `yaml:"cookie_same_site" validate:"omitempty,oneof=lax strict none" note:"lax/strict/none，默认lax，none需cookie_secure"`
+	CookieSecure        *bool                  `yaml:"cookie_secure" note:"是否仅https，默认true"`
 }
 
 func (o *Options) Validate() error {
 	o.Age = zutil.FirstTruth(o.Age, time.Hour*2)
+	o.ImpersonateAction = zutil.FirstTruth(o.ImpersonateAction, "auth:impersonate:user")
+	o.ImpersonateAge = zutil.FirstTruth(o.ImpersonateAge, time.Minute*30)
+	o.CookieName = zutil.FirstTruth(o.CookieName, "auth")
+	o.CookiePath = zutil.FirstTruth(o.CookiePath, "/")
+	o.CookieSameSite = zutil.FirstTruth(o.CookieSameSite, "lax")
+	o.CookieSecure = zutil.FirstTruth(o.CookieSecure, zutil.Ptr(true))
 	if o.PathSkip == nil {
 		o.PathSkip = func(path string) bool {
 			return false
 		}
 	}
-	return validator.New().Struct(o)
+	if err := validator.New().Struct(o); err != nil {
+		return err
+	}
+	// 浏览器会丢弃没有Secure的SameSite=None cookie
+	if o.CookieSameSite == "none" && !*o.CookieSecure {
+		return fmt.Errorf("cookie_same_site none requires cookie_secure")
+	}
+	return nil
+}
+
+func (o *Options) SameSite() http.SameSite {
+	switch o.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
 }
@@ -0,0 +1,33 @@
+package zauth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/zohu/zgin/zutil"
+)
+
+func TestOptionsCookie(t *testing.T) {
+	cases := []struct {
+		site   string
+		secure *bool
+		ok     bool
+		want   http.SameSite
+	}{
+		{"", nil, true, http.SameSiteLaxMode},
+		{"strict", nil, true, http.SameSiteStrictMode},
+		{"none", nil, true, http.SameSiteNoneMode},
+		{"none", zutil.Ptr(false), false, 0},
+		{"stirct", nil, false, 0},
+	}
+	for _, c := range cases {
+		o := &Options{CookieSameSite: c.site, CookieSecure: c.secure}
+		err := o.Validate()
+		if (err == nil) != c.ok {
+			t.Fatalf("%q secure=%v: %v", c.site, c.secure, err)
+		}
+		if c.ok && o.SameSite() != c.want {
+			t.Fatalf("%q: %v", c.site, o.SameSite())
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package zauth
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin/ztenant"
+	"github.com/zohu/zlog"
+)
+
+// bindTenant
+// @Description: 登录用户实现ztenant.Member时把其租户写入请求上下文，与请求头、子域名解析的租户不一致时拒绝；
+// 不属于任何租户的用户不能通过请求头、子域名指定租户，跨租户访问需由服务端ztenant.Bypass
+// @param c
+// @param user
+// @return bool
+func bindTenant(c *gin.Context, user Userinfo) bool {
+	member, ok := user.(ztenant.Member)
+	if !ok || member.TenantID() == "" {
+		if current, ok := ztenant.From(c); ok {
+			zlog.Warnf("auth userid=%s has no tenant but request tenant %s", user.Userid(), current)
+			emit(c, AuthEventTokenInvalid, user.Userid(), "tenant mismatch")
+			return false
+		}
+		return true
+	}
+	if current, ok := ztenant.From(c); ok && current != member.TenantID() {
+		zlog.Warnf("auth userid=%s tenant %s mismatch request tenant %s", user.Userid(), member.TenantID(), current)
+		emit(c, AuthEventTokenInvalid, user.Userid(), "tenant mismatch")
+		return false
+	}
+	ztenant.Set(c, member.TenantID())
+	return true
+}
@@ -0,0 +1,43 @@
+package zauth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zohu/zgin/ztenant"
+)
+
+type tenantUser struct {
+	testUser
+}
+
+func (u tenantUser) TenantID() string { return u.Tenant }
+
+func TestBindTenant(t *testing.T) {
+	cases := []struct {
+		name    string
+		user    Userinfo
+		request string
+		ok      bool
+		want    string
+	}{
+		{"member", tenantUser{testUser{ID: "u1", Tenant: "a"}}, "", true, "a"},
+		{"member same", tenantUser{testUser{ID: "u1", Tenant: "a"}}, "a", true, "a"},
+		{"member mismatch", tenantUser{testUser{ID: "u1", Tenant: "a"}}, "b", false, "b"},
+		{"no tenant", testUser{ID: "u2"}, "", true, ""},
+		{"no tenant request", testUser{ID: "u2"}, "b", false, "b"},
+		{"empty tenant request", tenantUser{testUser{ID: "u3"}}, "b", false, "b"},
+	}
+	for _, tc := range cases {
+		c, _ := testContext(httptest.NewRequest("GET", "/", nil))
+		if tc.request != "" {
+			ztenant.Set(c, tc.request)
+		}
+		if ok := bindTenant(c, tc.user); ok != tc.ok {
+			t.Fatalf("%s: want %t, got %t", tc.name, tc.ok, ok)
+		}
+		if got, _ := ztenant.From(c.Request.Context()); got != tc.want {
+			t.Fatalf("%s: tenant want %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zutil"
+)
+
+/**
+ * 布隆过滤器，用于拦截一定不存在的key，防止缓存穿透
+ *  - NewBloom: redis位图 bloom:{name}，多实例共享
+ *  - NewMemoryBloom: 进程内位图
+ * 过滤器未构建(redis中不存在或内存中从未写入)时Test一律返回true，不会误拦截
+ * 新增数据时需要调用Add；删除的数据无法移除，定期Rebuild即可
+ * Rebuild先写入临时位图再整体替换，期间的Add同时写入新旧位图
+ * 同一个过滤器同时只能有一个Rebuild，已在重建时返回ErrBloomRebuilding；临时位图在构建中持续续期
+ */
+
+var ErrBloomRebuilding = errors.New("zch: bloom is rebuilding")
+
+const bloomRebuildTTL = time.Minute * 10
+
+type BloomOptions struct {
+	Capacity      int64   // 预计元素数量，默认100万
+	FalsePositive float64 // 误判率，默认0.01
+}
+
+func (o *BloomOptions) Validate() error {
+	o.Capacity = zutil.FirstTruth(o.Capacity, 1000000)
+	o.FalsePositive = zutil.FirstTruth(o.FalsePositive, 0.01)
+	if o.Capacity < 0 {
+		return errors.New("capacity must be greater than 0")
+	}
+	if o.FalsePositive >= 1 || o.FalsePositive < 0 {
+		return errors.New("false positive must be between 0 and 1")
+	}
+	return nil
+}
+
+// BloomLoader
+// @Description: 重建时提供全部元素，数据量大时分批调用add
+type BloomLoader func(ctx context.Context, add func(items ...string) error) error
+
+type bloomBackend interface {
+	add(ctx context.Context, positions []uint64) error
+	// test 未构建时返回true
+	test(ctx context.Context, positions []uint64) (bool, error)
+	rebuild(ctx context.Context, fill func(add func(positions []uint64) error) error) error
+	reset(ctx context.Context) error
+}
+
+type Bloom struct {
+	bits    uint64
+	hashes  int
+	backend bloomBackend
+}
+
+// NewBloom
+// @Description: 基于redis位图的布隆过滤器
+// @param name
+// @param opts
+// @return *Bloom
+// @return error
+func NewBloom(name string, opts *BloomOptions) (*Bloom, error) {
+	b, err := newBloom(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	// 位图和重建用的临时位图在同一个slot
+	key := PrefixBloom.Key("{" + name + "}")
+	b.backend = &redisBloom{name: name, key: key, tmp: key + ":rebuild"}
+	return b, nil
+}
+
+// NewMemoryBloom
+// @Description: 进程内的布隆过滤器
+// @param name
+// @param opts
+// @return *Bloom
+// @return error
+func NewMemoryBloom(name string, opts *BloomOptions) (*Bloom, error) {
+	b, err := newBloom(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	b.backend = &memoryBloom{}
+	return b, nil
+}
+
+func newBloom(name string, opts *BloomOptions) (*Bloom, error) {
+	opts = zutil.FirstTruth(opts, &BloomOptions{})
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	// m = -n*ln(p)/ln(2)^2, k = m/n*ln(2)
+	m := math.Ceil(-float64(opts.Capacity) * math.Log(opts.FalsePositive) / (math.Ln2 * math.Ln2))
+	if m > math.MaxUint32 {
+		return nil, fmt.Errorf("bloom %s needs %.0f bits, exceeds redis bitmap limit", name, m)
+	}
+	return &Bloom{
+		bits:   uint64(m),
+		hashes: max(1, int(math.Round(m/float64(opts.Capacity)*math.Ln2))),
+	}, nil
+}
+
+// Add
+// @Description: 添加元素
+// @receiver b
+// @param ctx
+// @param items
+// @return error
+func (b *Bloom) Add(ctx context.Context, items ...string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return b.backend.add(ctx, b.positions(items...))
+}
+
+// Test
+// @Description: 元素是否可能存在，返回false时一定不存在；出错时返回true
+// @receiver b
+// @param ctx
+// @param item
+// @return bool
+// @return error
+func (b *Bloom) Test(ctx context.Context, item string) (bool, error) {
+	ok, err := b.backend.test(ctx, b.positions(item))
+	if err != nil {
+		return true, err
+	}
+	return ok, nil
+}
+
+// Rebuild
+// @Description: 由loader重新构建，完成后整体替换，构建期间旧过滤器照常使用
+// @receiver b
+// @param ctx
+// @param loader
+// @return error
+func (b *Bloom) Rebuild(ctx context.Context, loader BloomLoader) error {
+	return b.backend.rebuild(ctx, func(add func(positions []uint64) error) error {
+		return loader(ctx, func(items ...string) error {
+			if len(items) == 0 {
+				return nil
+			}
+			return add(b.positions(items...))
+		})
+	})
+}
+
+// Reset
+// @Description: 清空，清空后Test一律返回true
+// @receiver b
+// @param ctx
+// @return error
+func (b *Bloom) Reset(ctx context.Context) error {
+	return b.backend.reset(ctx)
+}
+
+// positions
+// @Description: 双重哈希 h1+i*h2 得到每个元素的k个位置
+// @receiver b
+// @param items
+// @return []uint64
+func (b *Bloom) positions(items ...string) []uint64 {
+	res := make([]uint64, 0, len(items)*b.hashes)
+	for _, item := range items {
+		h := fnv.New128a()
+		_, _ = h.Write([]byte(item))
+		sum := h.Sum(nil)
+		var h1, h2 uint64
+		for i := 0; i < 8; i++ {
+			h1 = h1<<8 | uint64(sum[i])
+			h2 = h2<<8 | uint64(sum[i+8])
+		}
+		h2 |= 1
+		for i := 0; i < b.hashes; i++ {
+			res = append(res, (h1+uint64(i)*h2)%b.bits)
+		}
+	}
+	return res
+}
+
+var bloomAddScript = redis.NewScript(`
+local tmp = redis.call('EXISTS', KEYS[2]) == 1
+for i = 1, #ARGV do
+	redis.call('SETBIT', KEYS[1], ARGV[i], 1)
+	if tmp then
+		redis.call('SETBIT', KEYS[2], ARGV[i], 1)
+	end
+end
+return 1
+`)
+
+var bloomTestScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 1
+end
+for i = 1, #ARGV do
+	if redis.call('GETBIT', KEYS[1], ARGV[i]) == 0 then
+		return 0
+	end
+end
+return 1
+`)
+
+type redisBloom struct {
+	name string
+	key  string
+	tmp  string
+}
+
+func (r *redisBloom) add(ctx context.Context, positions []uint64) error {
+	return bloomAddScript.Run(ctx, R(), []string{r.key, r.tmp}, bloomArgs(positions)...).Err()
+}
+
+func (r *redisBloom) test(ctx context.Context, positions []uint64) (bool, error) {
+	res, err := bloomTestScript.Run(ctx, R(), []string{r.key}, bloomArgs(positions)...).Int()
+	return res == 1, err
+}
+
+func (r *redisBloom) rebuild(ctx context.Context, fill func(add func(positions []uint64) error) error) error {
+	// 多实例共用临时位图，加锁避免互相删除或混入对方的数据
+	lock := NewLock("bloom:"+r.name, nil)
+	ok, err := lock.TryLock(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBloomRebuilding
+	}
+	defer func() { _ = lock.Unlock(context.WithoutCancel(ctx)) }()
+	// 临时位图先创建出来，Add才会同时写入；设置过期避免中断后残留，每批写入时续期
+	_, err = R().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.tmp)
+		pipe.SetBit(ctx, r.tmp, 0, 0)
+		pipe.Expire(ctx, r.tmp, bloomRebuildTTL)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = fill(func(positions []uint64) error {
+		_, err := R().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, p := range positions {
+				pipe.SetBit(ctx, r.tmp, int64(p), 1)
+			}
+			pipe.Expire(ctx, r.tmp, bloomRebuildTTL)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		R().Del(ctx, r.tmp)
+		return err
+	}
+	_, err = R().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Rename(ctx, r.tmp, r.key)
+		pipe.Persist(ctx, r.key)
+		return nil
+	})
+	return err
+}
+
+func (r *redisBloom) reset(ctx context.Context) error {
+	return R().Del(ctx, r.key).Err()
+}
+
+func bloomArgs(positions []uint64) []any {
+	args := make([]any, len(positions))
+	for i, p := range positions {
+		args[i] = strconv.FormatUint(p, 10)
+	}
+	return args
+}
@@ -0,0 +1,89 @@
+package zch
+
+import (
+	"context"
+	"sync"
+)
+
+/**
+ * 进程内的布隆过滤器位图
+ */
+
+type memoryBloom struct {
+	mu         sync.RWMutex
+	set        []uint64
+	building   []uint64
+	rebuilding sync.Mutex
+}
+
+func (m *memoryBloom) add(_ context.Context, positions []uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set = setBits(m.set, positions)
+	if m.building != nil {
+		m.building = setBits(m.building, positions)
+	}
+	return nil
+}
+
+func (m *memoryBloom) test(_ context.Context, positions []uint64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.set == nil {
+		return true, nil
+	}
+	for _, p := range positions {
+		if p/64 >= uint64(len(m.set)) || m.set[p/64]&(1<<(p%64)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *memoryBloom) rebuild(_ context.Context, fill func(add func(positions []uint64) error) error) error {
+	if !m.rebuilding.TryLock() {
+		return ErrBloomRebuilding
+	}
+	defer m.rebuilding.Unlock()
+	m.mu.Lock()
+	m.building = []uint64{}
+	m.mu.Unlock()
+	err := fill(func(positions []uint64) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.building = setBits(m.building, positions)
+		return nil
+	})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.set = m.building
+	}
+	m.building = nil
+	return err
+}
+
+func (m *memoryBloom) reset(_ context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set = nil
+	return nil
+}
+
+// setBits
+// @Description: 按需扩容，未写入的高位视为0，同redis位图
+// @param set
+// @param positions
+// @return []uint64
+func setBits(set []uint64, positions []uint64) []uint64 {
+	if set == nil {
+		set = []uint64{}
+	}
+	for _, p := range positions {
+		if i := p / 64; i >= uint64(len(set)) {
+			set = append(set, make([]uint64, i-uint64(len(set))+1)...)
+		}
+		set[p/64] |= 1 << (p % 64)
+	}
+	return set
+}
@@ -0,0 +1,157 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBloom(t *testing.T) {
+	mr := testRedis(t)
+	ctx := context.Background()
+	opts := &BloomOptions{Capacity: 1000, FalsePositive: 0.01}
+	r, err := NewBloom("ids", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := NewMemoryBloom("ids", opts)
+	for _, b := range []*Bloom{r, m} {
+		// 未构建时放行
+		if ok, _ := b.Test(ctx, "x"); !ok {
+			t.Fatal("unbuilt bloom must pass")
+		}
+		err = b.Rebuild(ctx, func(ctx context.Context, add func(items ...string) error) error {
+			for i := 0; i < 1000; i += 100 {
+				var batch []string
+				for j := i; j < i+100; j++ {
+					batch = append(batch, strconv.Itoa(j))
+				}
+				if err := add(batch...); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if ok, _ := b.Test(ctx, strconv.Itoa(i)); !ok {
+				t.Fatalf("false negative %d", i)
+			}
+		}
+		fp := 0
+		for i := 1000; i < 3000; i++ {
+			if ok, _ := b.Test(ctx, strconv.Itoa(i)); ok {
+				fp++
+			}
+		}
+		if fp > 100 {
+			t.Fatalf("false positive rate too high: %d/2000", fp)
+		}
+		_ = b.Add(ctx, "new")
+		if ok, _ := b.Test(ctx, "new"); !ok {
+			t.Fatal("added item missing")
+		}
+		// 重建失败时保留旧过滤器
+		if err = b.Rebuild(ctx, func(ctx context.Context, add func(items ...string) error) error {
+			return errors.New("boom")
+		}); err == nil {
+			t.Fatal("expected rebuild error")
+		}
+		if ok, _ := b.Test(ctx, "new"); !ok {
+			t.Fatal("failed rebuild replaced filter")
+		}
+		// 同时只能有一个重建
+		if err = b.Rebuild(ctx, func(ctx context.Context, add func(items ...string) error) error {
+			if err := b.Rebuild(ctx, func(ctx context.Context, add func(items ...string) error) error { return nil }); !errors.Is(err, ErrBloomRebuilding) {
+				t.Fatalf("concurrent rebuild: %v", err)
+			}
+			return add("new")
+		}); err != nil {
+			t.Fatal(err)
+		}
+		_ = b.Reset(ctx)
+		if ok, _ := b.Test(ctx, "missing"); !ok {
+			t.Fatal("reset bloom must pass")
+		}
+	}
+
+	// 重建期间的Add同时写入新位图
+	err = r.Rebuild(ctx, func(ctx context.Context, add func(items ...string) error) error {
+		_ = r.Add(ctx, "during")
+		return add("a")
+	})
+	if ok, _ := r.Test(ctx, "during"); err != nil || !ok {
+		t.Fatalf("add during rebuild lost: %v", err)
+	}
+	// 构建中临时位图续期，不会中途过期
+	tmp := r.backend.(*redisBloom).tmp
+	err = r.Rebuild(ctx, func(ctx context.Context, add func(items ...string) error) error {
+		mr.SetTTL(tmp, time.Second)
+		if err := add("b"); err != nil {
+			return err
+		}
+		if ttl := mr.TTL(tmp); ttl != bloomRebuildTTL {
+			t.Fatalf("tmp ttl not refreshed: %s", ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewBloom("bad", &BloomOptions{FalsePositive: 2}); err == nil {
+		t.Fatal("expected error for invalid false positive")
+	}
+}
+
+func TestBloomLookups(t *testing.T) {
+	testRedis(t)
+	ctx := context.Background()
+	b, _ := NewMemoryBloom("users", nil)
+	_ = b.Rebuild(ctx, func(ctx context.Context, add func(items ...string) error) error {
+		return add("1", "2")
+	})
+	var loads atomic.Int64
+	c := NewCache[string](L(), &CacheOptions{Prefix: "user", Bloom: b})
+	loader := func(k string) Loader[string] {
+		return func(ctx context.Context) (string, error) {
+			loads.Add(1)
+			return "user" + k, nil
+		}
+	}
+	if v, err := c.GetOrLoad(ctx, "1", loader("1"), time.Minute); err != nil || v != "user1" {
+		t.Fatalf("existing key: %v %s", err, v)
+	}
+	if _, err := c.GetOrLoad(ctx, "999", loader("999"), time.Minute); !IsNotFound(err) || loads.Load() != 1 {
+		t.Fatalf("bloom did not short circuit: %v, loads %d", err, loads.Load())
+	}
+
+	var queries atomic.Int64
+	NewDict("bloom", func(ctx context.Context, prefix string) map[string]string {
+		queries.Add(1)
+		res := map[string]string{}
+		for i := 10; i < 20; i++ {
+			if k := strconv.Itoa(i); len(prefix) == 0 || k[:len(prefix)] == prefix {
+				res[k] = fmt.Sprintf("v%d", i)
+			}
+		}
+		return res
+	})
+	db, _ := NewBloom("dict", nil)
+	if err := DictBloom(ctx, "bloom", db); err != nil {
+		t.Fatal(err)
+	}
+	queries.Store(0)
+	if v, err := Dict(ctx, "bloom", "15"); err != nil || v != "v15" {
+		t.Fatalf("dict: %v %s", err, v)
+	}
+	if _, err := Dict(ctx, "bloom", "99999"); err == nil || queries.Load() != 1 {
+		t.Fatalf("dict bloom did not short circuit: %v, queries %d", err, queries.Load())
+	}
+}
@@ -0,0 +1,271 @@
+package zch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+	"golang.org/x/sync/singleflight"
+)
+
+/**
+ * 泛型缓存：在Store之上完成编解码、批量读写、回源与空值缓存
+ *  - 后端可以是L()、StoreMemory(M())、StoreRedis(R())或任意Store实现
+ *  - GetOrLoad同一key的并发回源只执行一次
+ *  - 回源返回ErrNotFound时写入空值标记，NotFoundExpiration内不再回源
+ *  - 配置Bloom时，过滤器判定一定不存在的key不回源，直接返回ErrNotFound
+ */
+
+// notFoundValue 空值标记，编码后的数据不会以\x00开头后接该串
+const notFoundValue = "\x00zch:notfound"
+
+type CacheOptions struct {
+	Prefix             Prefix        // key前缀，为空时直接使用传入的key
+	Codec              Codec         // 默认CodecJSON
+	Expiration         time.Duration // 默认过期时间，默认1h
+	NotFoundExpiration time.Duration // 空值缓存时长，0表示不缓存空值
+	Bloom              *Bloom        // 回源前检查，按未加前缀的key判断
+}
+
+type Cache[T any] struct {
+	store Store
+	opts  *CacheOptions
+	group singleflight.Group
+}
+
+type Loader[T any] func(ctx context.Context) (T, error)
+
+// NewCache
+// @Description: 创建泛型缓存
+// @param store
+// @param opts
+// @return *Cache[T]
+func NewCache[T any](store Store, opts *CacheOptions) *Cache[T] {
+	if store == nil {
+		zlog.Fatalf("zch cache store is nil")
+		return nil
+	}
+	opts = zutil.FirstTruth(opts, &CacheOptions{})
+	if opts.Codec == nil {
+		opts.Codec = CodecJSON
+	}
+	opts.Expiration = zutil.FirstTruth(opts.Expiration, time.Hour)
+	return &Cache[T]{
+		store: store,
+		opts:  opts,
+	}
+}
+
+// Get
+// @Description: 读取缓存，未命中或命中空值时返回ErrNotFound
+// @receiver c
+// @param ctx
+// @param k
+// @return T
+// @return error
+func (c *Cache[T]) Get(ctx context.Context, k string) (T, error) {
+	v, hit, err := c.lookup(ctx, k)
+	if !hit && err == nil {
+		err = ErrNotFound
+	}
+	return v, err
+}
+
+// Set
+// @Description: 写入缓存
+// @receiver c
+// @param ctx
+// @param k
+// @param v
+// @param ttl 小于等于0时使用默认过期时间
+// @return error
+func (c *Cache[T]) Set(ctx context.Context, k string, v T, ttl time.Duration) error {
+	data, err := c.opts.Codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("zch cache %s marshal err: %w", c.opts.Codec.Name(), err)
+	}
+	return c.store.Set(ctx, c.key(k), string(data), c.ttl(ttl))
+}
+
+// SetNotFound
+// @Description: 写入空值标记，未开启空值缓存时删除该key
+// @receiver c
+// @param ctx
+// @param k
+// @return error
+func (c *Cache[T]) SetNotFound(ctx context.Context, k string) error {
+	if c.opts.NotFoundExpiration <= 0 {
+		return c.store.Del(ctx, c.key(k))
+	}
+	return c.store.Set(ctx, c.key(k), notFoundValue, c.opts.NotFoundExpiration)
+}
+
+// Del
+// @Description: 删除缓存
+// @receiver c
+// @param ctx
+// @param ks
+// @return error
+func (c *Cache[T]) Del(ctx context.Context, ks ...string) error {
+	keys := make([]string, len(ks))
+	for i, k := range ks {
+		keys[i] = c.key(k)
+	}
+	return c.store.Del(ctx, keys...)
+}
+
+// MGet
+// @Description: 批量读取，结果只包含命中且非空值的key
+// @receiver c
+// @param ctx
+// @param ks
+// @return map[string]T
+// @return error
+func (c *Cache[T]) MGet(ctx context.Context, ks ...string) (map[string]T, error) {
+	res := make(map[string]T, len(ks))
+	bs, ok := c.store.(BatchStore)
+	if !ok {
+		for _, k := range ks {
+			v, hit, err := c.lookup(ctx, k)
+			if err != nil && !IsNotFound(err) {
+				return nil, err
+			}
+			if hit && err == nil {
+				res[k] = v
+			}
+		}
+		return res, nil
+	}
+	keys := make([]string, len(ks))
+	for i, k := range ks {
+		keys[i] = c.key(k)
+	}
+	vals, err := bs.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range ks {
+		str, hit := vals[keys[i]]
+		if !hit || str == notFoundValue {
+			continue
+		}
+		var v T
+		if err = c.opts.Codec.Unmarshal([]byte(str), &v); err != nil {
+			zlog.Warnf("zch cache %s unmarshal %s err: %v", c.opts.Codec.Name(), keys[i], err)
+			continue
+		}
+		res[k] = v
+	}
+	return res, nil
+}
+
+// MSet
+// @Description: 批量写入
+// @receiver c
+// @param ctx
+// @param kvs
+// @param ttl 小于等于0时使用默认过期时间
+// @return error
+func (c *Cache[T]) MSet(ctx context.Context, kvs map[string]T, ttl time.Duration) error {
+	data := make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		b, err := c.opts.Codec.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("zch cache %s marshal err: %w", c.opts.Codec.Name(), err)
+		}
+		data[c.key(k)] = string(b)
+	}
+	if bs, ok := c.store.(BatchStore); ok {
+		return bs.MSet(ctx, data, c.ttl(ttl))
+	}
+	for k, v := range data {
+		if err := c.store.Set(ctx, k, v, c.ttl(ttl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOrLoad
+// @Description: 读取缓存，未命中时回源并写入，同一key的并发回源只执行一次
+// @receiver c
+// @param ctx
+// @param k
+// @param loader 返回ErrNotFound时写入空值标记
+// @param ttl 小于等于0时使用默认过期时间
+// @return T
+// @return error 不存在时返回ErrNotFound
+func (c *Cache[T]) GetOrLoad(ctx context.Context, k string, loader Loader[T], ttl time.Duration) (T, error) {
+	if v, hit, err := c.lookup(ctx, k); hit {
+		return v, err
+	}
+	if c.opts.Bloom != nil {
+		if ok, err := c.opts.Bloom.Test(ctx, k); !ok && err == nil {
+			var v T
+			return v, ErrNotFound
+		}
+	}
+	res, err, _ := c.group.Do(c.key(k), func() (any, error) {
+		// 回源结果由所有等待者共享，不随首个调用者取消
+		ctx := context.WithoutCancel(ctx)
+		// 排队期间可能已被其他实例写入
+		if v, hit, err := c.lookup(ctx, k); hit {
+			return v, err
+		}
+		v, err := loader(ctx)
+		if IsNotFound(err) {
+			if e := c.SetNotFound(ctx, k); e != nil {
+				zlog.Warnf("zch cache set not found %s err: %v", c.key(k), e)
+			}
+			return v, ErrNotFound
+		}
+		if err != nil {
+			return v, err
+		}
+		if e := c.Set(ctx, k, v, ttl); e != nil {
+			zlog.Warnf("zch cache set %s err: %v", c.key(k), e)
+		}
+		return v, nil
+	})
+	v, _ := res.(T)
+	return v, err
+}
+
+// lookup
+// @Description: 读取并解码，hit表示后端存在该key（含空值标记），后端异常视为未命中以便回源
+// @receiver c
+// @param ctx
+// @param k
+// @return v
+// @return hit
+// @return err 命中空值时为ErrNotFound
+func (c *Cache[T]) lookup(ctx context.Context, k string) (v T, hit bool, err error) {
+	str, err := c.store.Get(ctx, c.key(k))
+	if err != nil {
+		if !IsNotFound(err) {
+			zlog.Warnf("zch cache get %s err: %v", c.key(k), err)
+		}
+		return v, false, nil
+	}
+	if str == notFoundValue {
+		return v, true, ErrNotFound
+	}
+	if err = c.opts.Codec.Unmarshal([]byte(str), &v); err != nil {
+		zlog.Warnf("zch cache %s unmarshal %s err: %v", c.opts.Codec.Name(), c.key(k), err)
+		return v, false, nil
+	}
+	return v, true, nil
+}
+
+func (c *Cache[T]) key(k string) string {
+	if c.opts.Prefix == "" {
+		return k
+	}
+	return c.opts.Prefix.Key(k)
+}
+
+func (c *Cache[T]) ttl(ttl time.Duration) time.Duration {
+	return zutil.When(ttl > 0, ttl, c.opts.Expiration)
+}
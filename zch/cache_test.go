@@ -0,0 +1,111 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type cacheUser struct {
+	Id   int64  `json:"id" msgpack:"id"`
+	Name string `json:"name" msgpack:"name"`
+}
+
+func TestCacheCodec(t *testing.T) {
+	ctx := context.Background()
+	for _, codec := range []Codec{CodecJSON, CodecMsgpack, CodecGob} {
+		c := NewCache[*cacheUser](StoreMemory(NewMemory(time.Minute, 0, "")), &CacheOptions{Prefix: "user", Codec: codec})
+		if err := c.Set(ctx, "1", &cacheUser{Id: 1, Name: "a"}, 0); err != nil {
+			t.Fatalf("%s set: %v", codec.Name(), err)
+		}
+		v, err := c.Get(ctx, "1")
+		if err != nil || v.Name != "a" {
+			t.Fatalf("%s get: %v %+v", codec.Name(), err, v)
+		}
+		if err = c.MSet(ctx, map[string]*cacheUser{"2": {Id: 2}, "3": {Id: 3}}, 0); err != nil {
+			t.Fatalf("%s mset: %v", codec.Name(), err)
+		}
+		vs, err := c.MGet(ctx, "1", "2", "3", "4")
+		if err != nil || len(vs) != 3 || vs["3"].Id != 3 {
+			t.Fatalf("%s mget: %v %v", codec.Name(), err, vs)
+		}
+	}
+}
+
+func TestCacheRedisStore(t *testing.T) {
+	testRedis(t)
+	ctx := context.Background()
+	c := NewCache[*cacheUser](StoreRedis(R()), &CacheOptions{Prefix: "user"})
+	if err := c.MSet(ctx, map[string]*cacheUser{"1": {Id: 1}, "2": {Id: 2}, "3": {Id: 3}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := c.MGet(ctx, "1", "2", "3", "4")
+	if err != nil || len(vs) != 3 || vs["2"].Id != 2 {
+		t.Fatalf("mget: %v %v", err, vs)
+	}
+	if err = c.Del(ctx, "1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if vs, err = c.MGet(ctx, "1", "2", "3"); err != nil || len(vs) != 1 || vs["3"].Id != 3 {
+		t.Fatalf("del: %v %v", err, vs)
+	}
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache[cacheUser](StoreMemory(NewMemory(time.Minute, 0, "")), &CacheOptions{NotFoundExpiration: time.Minute})
+
+	var loads atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad(ctx, "1", func(ctx context.Context) (cacheUser, error) {
+				loads.Add(1)
+				time.Sleep(time.Millisecond * 50)
+				return cacheUser{Id: 1}, nil
+			}, 0)
+			if err != nil || v.Id != 1 {
+				t.Errorf("get or load: %v %+v", err, v)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Fatalf("loader called %d times", loads.Load())
+	}
+
+	// 首个调用者取消不影响共享同一次回源的其他调用者
+	first, cancel := context.WithCancel(ctx)
+	slow := func(ctx context.Context) (cacheUser, error) {
+		time.Sleep(time.Millisecond * 50)
+		return cacheUser{Id: 3}, ctx.Err()
+	}
+	go func() { _, _ = c.GetOrLoad(first, "3", slow, 0) }()
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+	if v, err := c.GetOrLoad(ctx, "3", slow, 0); err != nil || v.Id != 3 {
+		t.Fatalf("canceled caller leaked into shared load: %v %+v", err, v)
+	}
+
+	loads.Store(0)
+	for i := 0; i < 3; i++ {
+		_, err := c.GetOrLoad(ctx, "2", func(ctx context.Context) (cacheUser, error) {
+			loads.Add(1)
+			return cacheUser{}, ErrNotFound
+		}, 0)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect not found, got %v", err)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("not found loader called %d times", loads.Load())
+	}
+	if _, err := c.Get(ctx, "2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect not found, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package zch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * L1失效通知，避免其他实例的内存缓存在l1()时间内读到旧值
+ *  - pubsub: 写入、删除后广播key，其他实例收到后删除本地缓存
+ *  - tracking: redis6+的客户端缓存(BCAST)，任何客户端写入都会通知，仅支持单节点，不可用时回退到pubsub
+ *  - none: 不通知
+ */
+
+type Invalidation string
+
+const (
+	InvalidationPubSub   Invalidation = "pubsub"
+	InvalidationTracking Invalidation = "tracking"
+	InvalidationNone     Invalidation = "none"
+)
+
+const trackingChannel = "__redis__:invalidate"
+
+type invalidateMessage struct {
+	From string   `json:"from"`
+	Keys []string `json:"keys"`
+}
+
+func (l *L2) invalidation(options *Options) {
+	ctx := context.Background()
+	switch options.Invalidation {
+	case InvalidationNone:
+		l.channel = ""
+	case InvalidationTracking:
+		err := l.track(ctx, options.Prefix)
+		if err == nil {
+			// 服务端会通知所有写入，无需再广播
+			l.channel = ""
+			zlog.Infof("zch l1 invalidation by client tracking")
+			return
+		}
+		zlog.Warnf("zch client tracking unavailable, fallback to pubsub: %v", err)
+		fallthrough
+	default:
+		sub := l.r.Subscribe(ctx, l.channel)
+		// 等待订阅生效，之后的写入一定能收到
+		if _, err := sub.Receive(ctx); err != nil {
+			zlog.Warnf("zch l1 invalidation subscribe err: %v", err)
+		}
+		go l.subscribe(sub)
+	}
+}
+
+func (l *L2) publish(ctx context.Context, keys ...string) {
+	if l.channel == "" || len(keys) == 0 {
+		return
+	}
+	msg, _ := sonic.MarshalString(&invalidateMessage{From: l.id, Keys: keys})
+	if err := l.r.Publish(ctx, l.channel, msg).Err(); err != nil {
+		zlog.Warnf("zch l1 invalidation publish err: %v", err)
+	}
+}
+
+func (l *L2) subscribe(sub *redis.PubSub) {
+	for {
+		for msg := range sub.Channel() {
+			var m invalidateMessage
+			if err := sonic.UnmarshalString(msg.Payload, &m); err != nil || m.From == l.id {
+				continue
+			}
+			for _, k := range m.Keys {
+				l.m.Delete(k)
+			}
+		}
+		_ = sub.Close()
+		// 断开期间可能漏掉通知
+		l.m.Flush()
+		zlog.Warnf("zch l1 invalidation subscribe closed, retry after 3s")
+		time.Sleep(time.Second * 3)
+		sub = l.r.Subscribe(context.Background(), l.channel)
+	}
+}
+
+// track
+// @Description: 独立连接订阅__redis__:invalidate，另一条固定连接开启BCAST并重定向到订阅连接
+// @receiver l
+// @param ctx
+// @param prefix
+// @return error
+func (l *L2) track(ctx context.Context, prefix string) error {
+	client, ok := l.r.UniversalClient.(*redis.Client)
+	if !ok {
+		return fmt.Errorf("tracking only supports single node")
+	}
+	ids := make(chan int64, 8)
+	opt := *client.Options()
+	opt.Protocol = 2
+	opt.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		id, err := cn.ClientID(ctx).Result()
+		if err == nil {
+			select {
+			case ids <- id:
+			default:
+			}
+		}
+		return err
+	}
+	sc := redis.NewClient(&opt)
+	sub := sc.Subscribe(ctx, trackingChannel)
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		_ = sc.Close()
+		return err
+	}
+	var redirect int64
+	select {
+	case redirect = <-ids:
+	case <-time.After(time.Second * 3):
+		_ = sub.Close()
+		_ = sc.Close()
+		return fmt.Errorf("client id of subscriber not received")
+	}
+	conn := client.Conn()
+	args := []any{"client", "tracking", "on", "redirect", redirect, "bcast"}
+	if prefix != "" {
+		args = append(args, "prefix", prefix+":")
+	}
+	if err := conn.Do(ctx, args...).Err(); err != nil {
+		_ = conn.Close()
+		_ = sub.Close()
+		_ = sc.Close()
+		return err
+	}
+	go l.tracking(sub, conn, ids, args)
+	return nil
+}
+
+func (l *L2) tracking(sub *redis.PubSub, conn *redis.Conn, ids chan int64, args []any) {
+	ctx := context.Background()
+	retrack := func() {
+		if err := conn.Do(ctx, args...).Err(); err != nil {
+			zlog.Warnf("zch client tracking err: %v", err)
+		}
+		// 重连期间可能漏掉通知
+		l.m.Flush()
+	}
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+	ch := sub.Channel()
+	for {
+		select {
+		case msg := <-ch:
+			// 空消息表示FLUSHALL/FLUSHDB
+			if len(msg.PayloadSlice) == 0 {
+				l.m.Flush()
+				continue
+			}
+			for _, k := range msg.PayloadSlice {
+				l.m.Delete(k)
+			}
+		case id := <-ids:
+			// 订阅连接重连后id变化
+			args[4] = id
+			retrack()
+		case <-ticker.C:
+			// 开启tracking的连接重连后会丢失设置
+			redir, err := conn.Do(ctx, "client", "getredir").Int64()
+			if err != nil || redir != args[4].(int64) {
+				retrack()
+			}
+		}
+	}
+}
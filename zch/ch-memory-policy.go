@@ -0,0 +1,352 @@
+package zch
+
+import (
+	"container/heap"
+	"container/list"
+	"hash/maphash"
+)
+
+/**
+ * 内存缓存淘汰策略，由memory在写锁内调用
+ *  - lru: 最近最少使用
+ *  - lfu: 最不经常使用，频次相同时淘汰更早访问的
+ *  - tinylfu: W-TinyLFU，1%窗口LRU + 分段LRU(probation/protected)，主区未满时窗口溢出者直接进入probation，
+ *    主区满后窗口淘汰者与probation尾部按频次竞争准入
+ */
+
+type EvictPolicy string
+
+const (
+	PolicyLRU     EvictPolicy = "lru"
+	PolicyLFU     EvictPolicy = "lfu"
+	PolicyTinyLFU EvictPolicy = "tinylfu"
+)
+
+type evictPolicy interface {
+	add(k string)
+	access(k string)
+	remove(k string)
+	// victim 下一个淘汰的key
+	victim() (string, bool)
+	reset()
+}
+
+func newEvictPolicy(policy EvictPolicy, capacity int) evictPolicy {
+	switch policy {
+	case PolicyLRU:
+		return newLruPolicy()
+	case PolicyLFU:
+		return newLfuPolicy()
+	default:
+		return newTinyLfuPolicy(capacity)
+	}
+}
+
+/**
+ * lru
+ */
+
+type lruPolicy struct {
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newLruPolicy() *lruPolicy {
+	return &lruPolicy{ll: list.New(), items: make(map[string]*list.Element)}
+}
+func (p *lruPolicy) add(k string) {
+	if e, ok := p.items[k]; ok {
+		p.ll.MoveToFront(e)
+		return
+	}
+	p.items[k] = p.ll.PushFront(k)
+}
+func (p *lruPolicy) access(k string) {
+	if e, ok := p.items[k]; ok {
+		p.ll.MoveToFront(e)
+	}
+}
+func (p *lruPolicy) remove(k string) {
+	if e, ok := p.items[k]; ok {
+		p.ll.Remove(e)
+		delete(p.items, k)
+	}
+}
+func (p *lruPolicy) victim() (string, bool) {
+	if e := p.ll.Back(); e != nil {
+		return e.Value.(string), true
+	}
+	return "", false
+}
+func (p *lruPolicy) reset() {
+	p.ll.Init()
+	clear(p.items)
+}
+
+/**
+ * lfu
+ */
+
+type lfuEntry struct {
+	key   string
+	freq  uint64
+	tick  uint64
+	index int
+}
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].tick < h[j].tick
+	}
+	return h[i].freq < h[j].freq
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *lfuHeap) Push(x any) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *lfuHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+type lfuPolicy struct {
+	h     lfuHeap
+	items map[string]*lfuEntry
+	tick  uint64
+}
+
+func newLfuPolicy() *lfuPolicy {
+	return &lfuPolicy{items: make(map[string]*lfuEntry)}
+}
+func (p *lfuPolicy) add(k string) {
+	if _, ok := p.items[k]; ok {
+		p.access(k)
+		return
+	}
+	p.tick++
+	e := &lfuEntry{key: k, freq: 1, tick: p.tick}
+	heap.Push(&p.h, e)
+	p.items[k] = e
+}
+func (p *lfuPolicy) access(k string) {
+	if e, ok := p.items[k]; ok {
+		p.tick++
+		e.freq++
+		e.tick = p.tick
+		heap.Fix(&p.h, e.index)
+	}
+}
+func (p *lfuPolicy) remove(k string) {
+	if e, ok := p.items[k]; ok {
+		heap.Remove(&p.h, e.index)
+		delete(p.items, k)
+	}
+}
+func (p *lfuPolicy) victim() (string, bool) {
+	if len(p.h) == 0 {
+		return "", false
+	}
+	return p.h[0].key, true
+}
+func (p *lfuPolicy) reset() {
+	p.h = nil
+	clear(p.items)
+}
+
+/**
+ * w-tinylfu
+ */
+
+type tinySegment int
+
+const (
+	segWindow tinySegment = iota
+	segProbation
+	segProtected
+)
+
+type tinyEntry struct {
+	key string
+	seg tinySegment
+}
+
+type tinyLfuPolicy struct {
+	capacity  int // 0表示只按字节限制，窗口和主区按当前条目数计算
+	sketch    *cmSketch
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	items     map[string]*list.Element
+}
+
+func newTinyLfuPolicy(capacity int) *tinyLfuPolicy {
+	return &tinyLfuPolicy{
+		capacity:  capacity,
+		sketch:    newCmSketch(capacity),
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		items:     make(map[string]*list.Element),
+	}
+}
+func (p *tinyLfuPolicy) segment(seg tinySegment) *list.List {
+	switch seg {
+	case segWindow:
+		return p.window
+	case segProbation:
+		return p.probation
+	default:
+		return p.protected
+	}
+}
+func (p *tinyLfuPolicy) move(e *list.Element, seg tinySegment) {
+	t := e.Value.(*tinyEntry)
+	p.segment(t.seg).Remove(e)
+	t.seg = seg
+	p.items[t.key] = p.segment(seg).PushFront(t)
+}
+func (p *tinyLfuPolicy) add(k string) {
+	p.sketch.increment(k)
+	if _, ok := p.items[k]; ok {
+		p.access(k)
+		return
+	}
+	p.items[k] = p.window.PushFront(&tinyEntry{key: k, seg: segWindow})
+	// 主区未满时窗口溢出者直接进入probation，满了之后由victim竞争准入
+	if p.window.Len() > p.windowCap() && (p.capacity == 0 || p.probation.Len()+p.protected.Len() < p.capacity-p.windowCap()) {
+		p.move(p.window.Back(), segProbation)
+	}
+}
+func (p *tinyLfuPolicy) windowCap() int {
+	if p.capacity > 0 {
+		return max(1, p.capacity/100)
+	}
+	return max(1, len(p.items)/100)
+}
+func (p *tinyLfuPolicy) access(k string) {
+	e, ok := p.items[k]
+	if !ok {
+		return
+	}
+	p.sketch.increment(k)
+	switch e.Value.(*tinyEntry).seg {
+	case segWindow:
+		p.window.MoveToFront(e)
+	case segProbation:
+		p.move(e, segProtected)
+		// protected占主区的80%，超出部分降级回probation
+		if p.protected.Len() > len(p.items)*8/10 {
+			p.move(p.protected.Back(), segProbation)
+		}
+	case segProtected:
+		p.protected.MoveToFront(e)
+	}
+}
+func (p *tinyLfuPolicy) remove(k string) {
+	if e, ok := p.items[k]; ok {
+		p.segment(e.Value.(*tinyEntry).seg).Remove(e)
+		delete(p.items, k)
+	}
+}
+func (p *tinyLfuPolicy) victim() (string, bool) {
+	main := p.probation.Back()
+	if main == nil {
+		main = p.protected.Back()
+	}
+	if p.window.Len() > p.windowCap() && main != nil {
+		// 窗口溢出，窗口淘汰者与主区淘汰者竞争，频次高者留下
+		candidate := p.window.Back()
+		if p.sketch.estimate(candidate.Value.(*tinyEntry).key) > p.sketch.estimate(main.Value.(*tinyEntry).key) {
+			p.move(candidate, segProbation)
+			return main.Value.(*tinyEntry).key, true
+		}
+		return candidate.Value.(*tinyEntry).key, true
+	}
+	if main != nil {
+		return main.Value.(*tinyEntry).key, true
+	}
+	if e := p.window.Back(); e != nil {
+		return e.Value.(*tinyEntry).key, true
+	}
+	return "", false
+}
+func (p *tinyLfuPolicy) reset() {
+	p.window.Init()
+	p.probation.Init()
+	p.protected.Init()
+	clear(p.items)
+	p.sketch.reset()
+}
+
+/**
+ * count-min sketch，4行，计数饱和于15，累计增加到10倍宽度后全部减半以适应访问模式变化
+ */
+
+const cmDepth = 4
+
+type cmSketch struct {
+	seed     maphash.Seed
+	width    uint64
+	counters [cmDepth][]uint8
+	adds     uint64
+}
+
+func newCmSketch(capacity int) *cmSketch {
+	width := uint64(64)
+	for width < uint64(capacity) {
+		width <<= 1
+	}
+	s := &cmSketch{seed: maphash.MakeSeed(), width: width}
+	for i := range s.counters {
+		s.counters[i] = make([]uint8, width)
+	}
+	return s
+}
+func (s *cmSketch) index(k string) [cmDepth]uint64 {
+	h := maphash.String(s.seed, k)
+	h1, h2 := h&0xffffffff, h>>32
+	var idx [cmDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & (s.width - 1)
+	}
+	return idx
+}
+func (s *cmSketch) increment(k string) {
+	for i, j := range s.index(k) {
+		if s.counters[i][j] < 15 {
+			s.counters[i][j]++
+		}
+	}
+	s.adds++
+	if s.adds >= s.width*10 {
+		for i := range s.counters {
+			for j := range s.counters[i] {
+				s.counters[i][j] >>= 1
+			}
+		}
+		s.adds /= 2
+	}
+}
+func (s *cmSketch) estimate(k string) uint8 {
+	est := uint8(15)
+	for i, j := range s.index(k) {
+		est = min(est, s.counters[i][j])
+	}
+	return est
+}
+func (s *cmSketch) reset() {
+	for i := range s.counters {
+		clear(s.counters[i])
+	}
+	s.adds = 0
+}
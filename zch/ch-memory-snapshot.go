@@ -0,0 +1,226 @@
+package zch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zohu/zlog"
+)
+
+/**
+ * 内存缓存快照，格式(v1)：
+ *  magic "ZCHM" | version(1B) | count(uvarint) | count * [keyLen(uvarint) key valLen(uvarint) val expiration(varint,unix nano,0不过期)] | crc32(4B,大端)
+ * 加载时先整体校验，跳过已过期的条目，并按容量限制淘汰
+ */
+
+const (
+	snapshotMagic   = "ZCHM"
+	snapshotVersion = 1
+)
+
+type snapshotEntry struct {
+	key string
+	Item
+}
+
+// Save
+// @Description: 将未过期的条目写入w
+// @receiver c
+// @param w
+// @return error
+func (c *memory) Save(w io.Writer) error {
+	var entries []snapshotEntry
+	c.cmap.IterCb(func(key string, item Item) {
+		if !item.Expired() {
+			entries = append(entries, snapshotEntry{key: key, Item: item})
+		}
+	})
+	hash := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, hash))
+	buf := make([]byte, binary.MaxVarintLen64)
+	writeString := func(s string) {
+		_, _ = bw.Write(buf[:binary.PutUvarint(buf, uint64(len(s)))])
+		_, _ = bw.WriteString(s)
+	}
+	_, _ = bw.WriteString(snapshotMagic)
+	_ = bw.WriteByte(snapshotVersion)
+	_, _ = bw.Write(buf[:binary.PutUvarint(buf, uint64(len(entries)))])
+	for _, e := range entries {
+		writeString(e.key)
+		writeString(e.value)
+		_, _ = bw.Write(buf[:binary.PutVarint(buf, e.expiration)])
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write snapshot err: %w", err)
+	}
+	if err := binary.Write(w, binary.BigEndian, hash.Sum32()); err != nil {
+		return fmt.Errorf("write snapshot err: %w", err)
+	}
+	return nil
+}
+
+// Load
+// @Description: 从r加载快照，校验通过后才写入，与已有条目合并
+// @receiver c
+// @param r
+// @return int 加载的条目数
+// @return error
+func (c *memory) Load(r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("read snapshot err: %w", err)
+	}
+	if len(data) < len(snapshotMagic)+1+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, fmt.Errorf("not a zch snapshot")
+	}
+	if v := data[len(snapshotMagic)]; v != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version: %d", v)
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(data)-4:]) {
+		return 0, fmt.Errorf("snapshot checksum mismatch")
+	}
+	br := bytes.NewReader(body[len(snapshotMagic)+1:])
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, fmt.Errorf("read snapshot count err: %w", err)
+	}
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return "", err
+		}
+		if n > uint64(br.Len()) {
+			return "", io.ErrUnexpectedEOF
+		}
+		b := make([]byte, n)
+		if _, err = io.ReadFull(br, b); err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	entries := make([]snapshotEntry, 0, min(count, 1<<16))
+	for i := uint64(0); i < count; i++ {
+		var e snapshotEntry
+		if e.key, err = readString(); err != nil {
+			return 0, fmt.Errorf("read snapshot entry err: %w", err)
+		}
+		if e.value, err = readString(); err != nil {
+			return 0, fmt.Errorf("read snapshot entry err: %w", err)
+		}
+		if e.expiration, err = binary.ReadVarint(br); err != nil {
+			return 0, fmt.Errorf("read snapshot entry err: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if br.Len() != 0 {
+		return 0, fmt.Errorf("snapshot has trailing data")
+	}
+
+	var evicted []kv
+	loaded := 0
+	c.mu.Lock()
+	for _, e := range entries {
+		if e.Expired() {
+			continue
+		}
+		evicted = append(evicted, c.set(c.key(e.key), e.Item)...)
+		loaded++
+	}
+	c.mu.Unlock()
+	c.evicted(evicted, EvictCapacity)
+	return loaded, nil
+}
+
+// SaveFile
+// @Description: 写入临时文件后替换，避免中途退出损坏旧快照
+// @receiver c
+// @param path
+// @return error
+func (c *memory) SaveFile(path string) error {
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadFile
+// @Description: 从文件加载快照，文件不存在时忽略
+// @receiver c
+// @param path
+// @return int
+// @return error
+func (c *memory) LoadFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return c.Load(f)
+}
+
+// Close
+// @Description: 停止定时快照并保存最后一次，L2的内存层由zch.Close关闭，独立创建的Memory可注册到zgin.App.WithShutdown
+// @receiver c
+func (c *memory) Close() {
+	if c.snapshotPath == "" {
+		return
+	}
+	c.closeOnce.Do(func() {
+		if c.snapshotStop != nil {
+			close(c.snapshotStop)
+		}
+		if err := c.SaveFile(c.snapshotPath); err != nil {
+			zlog.Warnf("zch memory snapshot save err: %v", err)
+			return
+		}
+		zlog.Infof("zch memory snapshot saved: %s", c.snapshotPath)
+	})
+}
+
+func (c *memory) warmStart(path string, interval time.Duration) {
+	c.snapshotPath = path
+	if n, err := c.LoadFile(path); err != nil {
+		zlog.Warnf("zch memory snapshot load err: %v", err)
+	} else if n > 0 {
+		zlog.Infof("zch memory warm start with %d items from %s", n, path)
+	}
+	if interval <= 0 {
+		return
+	}
+	c.snapshotStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.SaveFile(path); err != nil {
+					zlog.Warnf("zch memory snapshot save err: %v", err)
+				}
+			case <-c.snapshotStop:
+				return
+			}
+		}
+	}()
+}
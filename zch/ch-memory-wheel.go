@@ -0,0 +1,68 @@
+package zch
+
+import "time"
+
+/**
+ * 过期时间轮，按过期时刻落槽，每次只检查走过的槽，代替全量扫描
+ * 槽中记录的是写入时的过期时刻，key被覆盖或删除后旧记录在检查时丢弃
+ */
+
+const wheelSlots = 512
+
+type wheelEntry struct {
+	key        string
+	expiration int64
+}
+
+type timerWheel struct {
+	tick   int64
+	slots  [wheelSlots]map[string]int64
+	cursor int64
+}
+
+func newTimerWheel(tick time.Duration) *timerWheel {
+	return &timerWheel{
+		tick:   int64(tick),
+		cursor: time.Now().UnixNano()/int64(tick) - 1,
+	}
+}
+
+func (w *timerWheel) add(k string, expiration int64) {
+	if expiration <= 0 {
+		return
+	}
+	slot := (expiration / w.tick) % wheelSlots
+	if w.slots[slot] == nil {
+		w.slots[slot] = make(map[string]int64)
+	}
+	w.slots[slot][k] = expiration
+}
+
+// advance
+// @Description: 检查已走完的刻度，返回到期的记录
+// @receiver w
+// @param now
+// @return []wheelEntry
+func (w *timerWheel) advance(now int64) []wheelEntry {
+	var out []wheelEntry
+	target := now/w.tick - 1
+	// 超过一圈时每个槽只需检查一次
+	from := max(w.cursor+1, target-wheelSlots+1)
+	for t := from; t <= target; t++ {
+		slot := w.slots[t%wheelSlots]
+		for k, exp := range slot {
+			if exp <= now {
+				delete(slot, k)
+				out = append(out, wheelEntry{key: k, expiration: exp})
+			}
+		}
+	}
+	w.cursor = max(w.cursor, target)
+	return out
+}
+
+func (w *timerWheel) reset() {
+	for i := range w.slots {
+		w.slots[i] = nil
+	}
+}
@@ -2,10 +2,14 @@ package zch
 
 import (
 	"fmt"
-	"github.com/zohu/zgin/zmap"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/zohu/zgin/zmap"
+	"github.com/zohu/zgin/zutil"
 )
 
 type Item struct {
@@ -28,13 +32,81 @@ type memory struct {
 	cmap       zmap.ConcurrentMap[string, Item]
 	janitor    *janitor
 	prefix     string
+
+	// 写操作、淘汰策略、时间轮都在mu内
+	mu         sync.Mutex
+	entries    int
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+	policy     evictPolicy
+	wheel      *timerWheel
+	onEvict    func(key, value string, reason EvictReason)
+
+	snapshotPath string
+	snapshotStop chan struct{}
+	closeOnce    sync.Once
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+type EvictReason string
+
+const (
+	EvictCapacity EvictReason = "capacity"
+	EvictExpired  EvictReason = "expired"
+)
+
+type MemoryOptions struct {
+	Expiration    time.Duration                               `yaml:"expiration" note:"默认过期时间，默认5min"`
+	CleanInterval time.Duration                               `yaml:"clean_interval" note:"过期检查间隔，即时间轮刻度，0不主动清理"`
+	Prefix        string                                      `yaml:"prefix"`
+	MaxEntries    int                                         `yaml:"max_entries" note:"最大条目数，0不限制"`
+	MaxBytes      int64                                       `yaml:"max_bytes" note:"最大字节数，按key+value计算，0不限制"`
+	Policy        EvictPolicy                                 `yaml:"policy" note:"lru/lfu/tinylfu，默认tinylfu"`
+	OnEvict       func(key, value string, reason EvictReason) `yaml:"-" note:"淘汰或过期清理时回调，不含主动删除"`
+
+	SnapshotPath     string        `yaml:"snapshot_path" note:"快照文件，启动时加载，Close时保存，为空不开启"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval" note:"定时快照间隔，0只在Close时保存"`
+}
+
+func (o *MemoryOptions) Validate() {
+	o.Expiration = zutil.FirstTruth(o.Expiration, time.Minute*5)
+	o.Policy = zutil.FirstTruth(o.Policy, PolicyTinyLFU)
+}
+
+type MemoryStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+}
+
+func (s MemoryStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
 }
 
 func (c *memory) Get(k string) (string, bool) {
-	item, found := c.cmap.Get(c.key(k))
+	key := c.key(k)
+	item, found := c.cmap.Get(key)
 	if !found || item.Expired() {
+		c.misses.Add(1)
 		return "", false
 	}
+	c.hits.Add(1)
+	if c.policy != nil {
+		c.mu.Lock()
+		c.policy.access(key)
+		c.mu.Unlock()
+	}
 	return item.value, true
 }
 
@@ -47,36 +119,50 @@ func (c *memory) GetWithExpiration(k string) (string, time.Time, bool) {
 }
 
 func (c *memory) Delete(k string) {
-	c.cmap.Remove(c.key(k))
+	c.mu.Lock()
+	c.remove(c.key(k))
+	c.mu.Unlock()
 }
 
 func (c *memory) Set(k string, x string, d time.Duration) {
-	var e int64
-	if d <= 0 {
-		d = c.expiration
-	}
-	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
-	}
-	c.cmap.Set(c.key(k), Item{
+	c.mu.Lock()
+	evicted := c.set(c.key(k), Item{
 		value:      x,
-		expiration: e,
+		expiration: c.deadline(d),
 	})
+	c.mu.Unlock()
+	c.evicted(evicted, EvictCapacity)
 }
 func (c *memory) SetNX(k, v string, d time.Duration) error {
-	_, ok := c.Get(k)
-	if ok {
-		return fmt.Errorf("key already exists")
-	}
-	c.Set(k, v, d)
-	return nil
+	return c.setIf(k, v, d, false)
 }
 func (c *memory) Replace(k, v string, d time.Duration) error {
-	_, ok := c.Get(k)
-	if !ok {
-		return fmt.Errorf("key does not exist")
+	return c.setIf(k, v, d, true)
+}
+
+// setIf
+// @Description: 检查与写入在同一把锁内完成
+// @receiver c
+// @param k
+// @param v
+// @param d
+// @param exists 要求key已存在
+// @return error
+func (c *memory) setIf(k, v string, d time.Duration, exists bool) error {
+	key := c.key(k)
+	c.mu.Lock()
+	item, ok := c.cmap.Get(key)
+	ok = ok && !item.Expired()
+	if ok != exists {
+		c.mu.Unlock()
+		if exists {
+			return fmt.Errorf("key does not exist")
+		}
+		return fmt.Errorf("key already exists")
 	}
-	c.Set(k, v, d)
+	evicted := c.set(key, Item{value: v, expiration: c.deadline(d)})
+	c.mu.Unlock()
+	c.evicted(evicted, EvictCapacity)
 	return nil
 }
 func (c *memory) Items() map[string]string {
@@ -90,17 +176,145 @@ func (c *memory) Items() map[string]string {
 }
 func (c *memory) Count() int {
 	c.expired()
-	return c.cmap.Count()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries
 }
 func (c *memory) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.cmap.Clear()
+	c.entries, c.bytes = 0, 0
+	if c.policy != nil {
+		c.policy.reset()
+	}
+	if c.wheel != nil {
+		c.wheel.reset()
+	}
+}
+func (c *memory) Stats() MemoryStats {
+	c.mu.Lock()
+	entries, bytes := c.entries, c.bytes
+	c.mu.Unlock()
+	return MemoryStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+		Entries:     entries,
+		Bytes:       bytes,
+	}
 }
 func (c *memory) expired() {
-	c.cmap.IterCb(func(key string, item Item) {
-		if item.Expired() {
-			c.cmap.Remove(key)
+	var expired []kv
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	if c.wheel != nil {
+		for _, e := range c.wheel.advance(now) {
+			// 已被覆盖的旧记录直接丢弃
+			if item, ok := c.cmap.Get(e.key); ok && item.expiration == e.expiration {
+				c.remove(e.key)
+				expired = append(expired, kv{Key: e.key, Val: item.value})
+			}
 		}
-	})
+	} else {
+		c.cmap.IterCb(func(key string, item Item) {
+			if item.Expired() {
+				expired = append(expired, kv{Key: key, Val: item.value})
+			}
+		})
+		for _, t := range expired {
+			c.remove(t.Key)
+		}
+	}
+	c.mu.Unlock()
+	c.expirations.Add(uint64(len(expired)))
+	c.evicted(expired, EvictExpired)
+}
+
+func (c *memory) deadline(d time.Duration) int64 {
+	if d <= 0 {
+		d = c.expiration
+	}
+	if d > 0 {
+		return time.Now().Add(d).UnixNano()
+	}
+	return 0
+}
+
+// set
+// @Description: 写入并按容量淘汰，需持有mu
+// @receiver c
+// @param key
+// @param item
+// @return []kv 被淘汰的条目
+func (c *memory) set(key string, item Item) []kv {
+	if old, ok := c.cmap.Get(key); ok {
+		c.bytes -= size(key, old.value)
+		if c.policy != nil {
+			c.policy.access(key)
+		}
+	} else {
+		c.entries++
+		if c.policy != nil {
+			c.policy.add(key)
+		}
+	}
+	c.cmap.Set(key, item)
+	c.bytes += size(key, item.value)
+	if c.wheel != nil {
+		c.wheel.add(key, item.expiration)
+	}
+	if c.policy == nil {
+		return nil
+	}
+	var evicted []kv
+	for (c.maxEntries > 0 && c.entries > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		k, ok := c.policy.victim()
+		if !ok {
+			break
+		}
+		if v, ok := c.cmap.Get(k); ok {
+			evicted = append(evicted, kv{Key: k, Val: v.value})
+		}
+		c.remove(k)
+	}
+	c.evictions.Add(uint64(len(evicted)))
+	return evicted
+}
+
+// remove
+// @Description: 删除，需持有mu
+// @receiver c
+// @param key
+func (c *memory) remove(key string) {
+	if c.policy != nil {
+		c.policy.remove(key)
+	}
+	if item, ok := c.cmap.Pop(key); ok {
+		c.entries--
+		c.bytes -= size(key, item.value)
+	}
+}
+
+// evicted
+// @Description: 在锁外执行回调，回调中可以再次读写缓存
+// @receiver c
+// @param items
+// @param reason
+func (c *memory) evicted(items []kv, reason EvictReason) {
+	if c.onEvict == nil {
+		return
+	}
+	for _, t := range items {
+		c.onEvict(t.Key, t.Val, reason)
+	}
+}
+
+type kv = zmap.Tuple[string, string]
+
+func size(key, value string) int64 {
+	return int64(len(key) + len(value))
 }
 func (c *memory) key(k string) string {
 	if c.prefix != "" && !strings.HasPrefix(k, fmt.Sprintf("%s:", c.prefix)) {
@@ -140,37 +354,64 @@ func runJanitor(c *memory, ci time.Duration) {
 	go j.Run(c)
 }
 
-func newMemory(de time.Duration, prefix string, m zmap.ConcurrentMap[string, Item]) *memory {
-	if de == 0 {
-		de = time.Minute * 5
-	}
+func newMemory(opts *MemoryOptions, m zmap.ConcurrentMap[string, Item]) *memory {
 	c := &memory{
-		prefix:     prefix,
-		expiration: de,
-		cmap:       m,
+		prefix:     opts.Prefix,
+		expiration: opts.Expiration,
+		cmap:       zmap.New[Item](),
+		maxEntries: opts.MaxEntries,
+		maxBytes:   opts.MaxBytes,
+		onEvict:    opts.OnEvict,
 	}
-	if c.prefix != "" {
-		c.cmap.IterCb(func(key string, item Item) {
-			c.cmap.Set(c.key(key), item)
-		})
+	if c.maxEntries > 0 || c.maxBytes > 0 {
+		c.policy = newEvictPolicy(opts.Policy, c.maxEntries)
+	}
+	if opts.CleanInterval > 0 {
+		c.wheel = newTimerWheel(opts.CleanInterval)
 	}
+	m.IterCb(func(key string, item Item) {
+		if !item.Expired() {
+			c.set(c.key(key), item)
+		}
+	})
 	return c
 }
 
-func newMemoryWithJanitor(de time.Duration, ci time.Duration, prefix string, m zmap.ConcurrentMap[string, Item]) *Memory {
-	c := newMemory(de, prefix, m)
+func newMemoryWithJanitor(opts *MemoryOptions, m zmap.ConcurrentMap[string, Item]) *Memory {
+	opts.Validate()
+	c := newMemory(opts, m)
 	C := &Memory{c}
-	if ci > 0 {
-		runJanitor(c, ci)
+	if opts.SnapshotPath != "" {
+		c.warmStart(opts.SnapshotPath, opts.SnapshotInterval)
+	}
+	if opts.CleanInterval > 0 {
+		runJanitor(c, opts.CleanInterval)
 		runtime.SetFinalizer(C, stopJanitor)
 	}
 	return C
 }
 
 func NewMemory(defaultExpiration, cleanupInterval time.Duration, prefix string) *Memory {
-	return newMemoryWithJanitor(defaultExpiration, cleanupInterval, prefix, zmap.New[Item]())
+	return NewMemoryWithOptions(&MemoryOptions{
+		Expiration:    defaultExpiration,
+		CleanInterval: cleanupInterval,
+		Prefix:        prefix,
+	})
 }
 
 func NewMemoryFrom(defaultExpiration, cleanupInterval time.Duration, prefix string, m zmap.ConcurrentMap[string, Item]) *Memory {
-	return newMemoryWithJanitor(defaultExpiration, cleanupInterval, prefix, m)
+	return newMemoryWithJanitor(&MemoryOptions{
+		Expiration:    defaultExpiration,
+		CleanInterval: cleanupInterval,
+		Prefix:        prefix,
+	}, m)
+}
+
+// NewMemoryWithOptions
+// @Description: 可限制容量的内存缓存，超出MaxEntries或MaxBytes时按Policy淘汰
+// @param opts
+// @return *Memory
+func NewMemoryWithOptions(opts *MemoryOptions) *Memory {
+	opts = zutil.FirstTruth(opts, &MemoryOptions{})
+	return newMemoryWithJanitor(opts, zmap.New[Item]())
 }
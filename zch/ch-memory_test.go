@@ -0,0 +1,137 @@
+package zch
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryEvict(t *testing.T) {
+	for _, policy := range []EvictPolicy{PolicyLRU, PolicyLFU, PolicyTinyLFU} {
+		var evicted atomic.Int32
+		m := NewMemoryWithOptions(&MemoryOptions{
+			MaxEntries: 100,
+			Policy:     policy,
+			OnEvict: func(key, value string, reason EvictReason) {
+				if reason == EvictCapacity {
+					evicted.Add(1)
+				}
+			},
+		})
+		// 热点key被反复访问，不应被淘汰
+		m.Set("hot", "1", 0)
+		for i := 0; i < 1000; i++ {
+			m.Set(fmt.Sprintf("k%d", i), "v", 0)
+			m.Get("hot")
+		}
+		if n := m.Count(); n != 100 {
+			t.Fatalf("%s count %d", policy, n)
+		}
+		if _, ok := m.Get("hot"); !ok {
+			t.Fatalf("%s hot key evicted", policy)
+		}
+		st := m.Stats()
+		if st.Evictions != 901 || evicted.Load() != 901 {
+			t.Fatalf("%s evictions %d callback %d", policy, st.Evictions, evicted.Load())
+		}
+		if st.Hits < 1000 {
+			t.Fatalf("%s hits %d", policy, st.Hits)
+		}
+	}
+}
+
+func TestMemoryMaxBytes(t *testing.T) {
+	m := NewMemoryWithOptions(&MemoryOptions{MaxBytes: 1000, Policy: PolicyLRU})
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("k%03d", i), "0123456789012345", 0)
+	}
+	// 每条 4+16 字节
+	if st := m.Stats(); st.Bytes > 1000 || st.Entries != 50 {
+		t.Fatalf("bytes %d entries %d", st.Bytes, st.Entries)
+	}
+	if _, ok := m.Get("k000"); ok {
+		t.Fatal("oldest key should be evicted")
+	}
+	if _, ok := m.Get("k099"); !ok {
+		t.Fatal("newest key should exist")
+	}
+}
+
+func TestMemoryWheelExpire(t *testing.T) {
+	var expired atomic.Int32
+	m := NewMemoryWithOptions(&MemoryOptions{
+		CleanInterval: time.Millisecond * 20,
+		OnEvict: func(key, value string, reason EvictReason) {
+			if reason == EvictExpired {
+				expired.Add(1)
+			}
+		},
+	})
+	m.Set("a", "1", time.Millisecond*50)
+	m.Set("b", "1", time.Millisecond*50)
+	m.Set("b", "2", time.Hour)
+	m.Set("c", "1", time.Hour)
+	time.Sleep(time.Millisecond * 200)
+	if n := expired.Load(); n != 1 {
+		t.Fatalf("expired %d", n)
+	}
+	if st := m.Stats(); st.Entries != 2 || st.Expirations != 1 {
+		t.Fatalf("entries %d expirations %d", st.Entries, st.Expirations)
+	}
+	if v, _ := m.Get("b"); v != "2" {
+		t.Fatalf("overwritten key expired by stale wheel entry")
+	}
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	path := t.TempDir() + "/l1.snap"
+	m := NewMemoryWithOptions(&MemoryOptions{Prefix: "cs", SnapshotPath: path})
+	m.Set("a", "1", time.Hour)
+	m.Set("b", "2", time.Millisecond)
+	m.Set("c", "", -1)
+	m.Close()
+
+	time.Sleep(time.Millisecond * 5)
+	w := NewMemoryWithOptions(&MemoryOptions{Prefix: "cs", MaxEntries: 10, SnapshotPath: path})
+	if v, exp, ok := w.GetWithExpiration("a"); !ok || v != "1" || time.Until(exp) < time.Minute*59 {
+		t.Fatalf("a not restored: %q %v", v, exp)
+	}
+	if _, ok := w.Get("b"); ok {
+		t.Fatal("expired item restored")
+	}
+	if _, ok := w.Get("c"); !ok {
+		t.Fatal("empty value not restored")
+	}
+
+	var buf bytes.Buffer
+	_ = w.Save(&buf)
+	data := buf.Bytes()
+	data[len(data)-5] ^= 0xff
+	if _, err := w.Load(bytes.NewReader(data)); err == nil {
+		t.Fatal("corrupted snapshot loaded")
+	}
+}
+
+func TestMemoryTinyLfuScan(t *testing.T) {
+	// 热点key之后只有一次性扫描，LRU会淘汰热点，W-TinyLFU按频次保留
+	scan := func(policy EvictPolicy) bool {
+		m := NewMemoryWithOptions(&MemoryOptions{MaxEntries: 100, Policy: policy})
+		m.Set("hot", "1", 0)
+		for i := 0; i < 10; i++ {
+			m.Get("hot")
+		}
+		for i := 0; i < 1000; i++ {
+			m.Set(fmt.Sprintf("s%d", i), "v", 0)
+		}
+		_, ok := m.Get("hot")
+		return ok
+	}
+	if scan(PolicyLRU) {
+		t.Fatal("lru kept hot key, scan is not effective")
+	}
+	if !scan(PolicyTinyLFU) {
+		t.Fatal("tinylfu evicted hot key on scan")
+	}
+}
@@ -0,0 +1,294 @@
+package zch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/**
+ * redis prefix hook
+ *  - 请求：按keySpecs找到参数中的key并加前缀，已带前缀的不重复添加
+ *  - 响应：SCAN、KEYS、RANDOMKEY以及阻塞弹出等返回key的命令去掉前缀
+ *  - SCAN/KEYS的pattern加前缀，SCAN未指定MATCH时过滤掉其他前缀的key
+ *  - pub/sub的channel不属于key，不做处理
+ */
+
+type PrefixHook struct {
+	prefix string
+}
+
+func NewPrefixHook(prefix string) PrefixHook {
+	return PrefixHook{
+		prefix: prefix,
+	}
+}
+
+func (h PrefixHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+func (h PrefixHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		addPrefix(h.prefix, cmd)
+		err := next(ctx, cmd)
+		stripPrefix(h.prefix, cmd)
+		return err
+	}
+}
+func (h PrefixHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			addPrefix(h.prefix, cmd)
+		}
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			stripPrefix(h.prefix, cmd)
+		}
+		return err
+	}
+}
+
+// keyPositions
+// @Description: 返回args中key所在的下标，args[0]为命令名
+type keyPositions func(args []any) []int
+
+// keys
+// @Description: 固定位置的key，同redis COMMAND的first/last/step
+// @param first
+// @param last 小于0时从末尾倒数，-1为最后一个参数
+// @param step
+// @return keyPositions
+func keys(first, last, step int) keyPositions {
+	return func(args []any) []int {
+		end := last
+		if end < 0 {
+			end = len(args) + end
+		}
+		var res []int
+		for i := first; i <= end && i < len(args); i += step {
+			res = append(res, i)
+		}
+		return res
+	}
+}
+
+// numkeys
+// @Description: 由args[at]指定数量、从first开始的key，如EVAL script numkeys key...
+// @param at
+// @param first
+// @return keyPositions
+func numkeys(at, first int) keyPositions {
+	return func(args []any) []int {
+		if at >= len(args) {
+			return nil
+		}
+		n, _ := strconv.Atoi(fmt.Sprint(args[at]))
+		return keys(first, first+n-1, 1)(args)
+	}
+}
+
+// keyword
+// @Description: 关键字之后的一个key，如SORT key STORE dest
+// @param words
+// @return keyPositions
+func keyword(words ...string) keyPositions {
+	return func(args []any) []int {
+		var res []int
+		for i := 2; i < len(args)-1; i++ {
+			for _, w := range words {
+				if strings.EqualFold(fmt.Sprint(args[i]), w) {
+					res = append(res, i+1)
+				}
+			}
+		}
+		return res
+	}
+}
+
+// streams
+// @Description: XREAD/XREADGROUP的STREAMS key... id...
+// @param args
+// @return []int
+func streams(args []any) []int {
+	for i := 1; i < len(args); i++ {
+		if strings.EqualFold(fmt.Sprint(args[i]), "streams") {
+			n := (len(args) - i - 1) / 2
+			return keys(i+1, i+n, 1)(args)
+		}
+	}
+	return nil
+}
+
+func join(fns ...keyPositions) keyPositions {
+	return func(args []any) []int {
+		var res []int
+		for _, fn := range fns {
+			res = append(res, fn(args)...)
+		}
+		return res
+	}
+}
+
+var keySpecs = func() map[string]keyPositions {
+	first := keys(1, 1, 1)
+	all := keys(1, -1, 1)
+	two := keys(1, 2, 1)
+	second := keys(2, 2, 1)
+	specs := map[string]keyPositions{
+		// 多key
+		"DEL": all, "UNLINK": all, "EXISTS": all, "TOUCH": all, "MGET": all, "WATCH": all,
+		"SDIFF": all, "SINTER": all, "SUNION": all, "SDIFFSTORE": all, "SINTERSTORE": all, "SUNIONSTORE": all,
+		"PFCOUNT": all, "PFMERGE": all,
+		"MSET": keys(1, -1, 2), "MSETNX": keys(1, -1, 2),
+		"BLPOP": keys(1, -2, 1), "BRPOP": keys(1, -2, 1), "BZPOPMIN": keys(1, -2, 1), "BZPOPMAX": keys(1, -2, 1),
+		"RENAME": two, "RENAMENX": two, "COPY": two, "SMOVE": two, "RPOPLPUSH": two, "BRPOPLPUSH": two,
+		"LMOVE": two, "BLMOVE": two, "LCS": two, "ZRANGESTORE": two, "GEOSEARCHSTORE": two,
+		"BITOP": keys(2, -1, 1),
+		// numkeys
+		"EVAL": numkeys(2, 3), "EVALSHA": numkeys(2, 3), "EVAL_RO": numkeys(2, 3), "EVALSHA_RO": numkeys(2, 3),
+		"FCALL": numkeys(2, 3), "FCALL_RO": numkeys(2, 3),
+		"ZUNION": numkeys(1, 2), "ZINTER": numkeys(1, 2), "ZDIFF": numkeys(1, 2),
+		"ZINTERCARD": numkeys(1, 2), "SINTERCARD": numkeys(1, 2),
+		"ZUNIONSTORE": join(first, numkeys(2, 3)), "ZINTERSTORE": join(first, numkeys(2, 3)), "ZDIFFSTORE": join(first, numkeys(2, 3)),
+		"LMPOP": numkeys(1, 2), "ZMPOP": numkeys(1, 2), "BLMPOP": numkeys(2, 3), "BZMPOP": numkeys(2, 3),
+		// 关键字
+		"SORT": join(first, keyword("store")), "SORT_RO": first,
+		"GEORADIUS":         join(first, keyword("store", "storedist")),
+		"GEORADIUSBYMEMBER": join(first, keyword("store", "storedist")),
+		"XREAD":             streams, "XREADGROUP": streams,
+		// 子命令 CMD SUBCMD key
+		"XGROUP": second, "XINFO": second, "OBJECT": second, "MEMORY": second,
+	}
+	for _, name := range []string{
+		// string
+		"GET", "SET", "SETNX", "SETEX", "PSETEX", "GETSET", "GETDEL", "GETEX", "GETRANGE", "SETRANGE",
+		"APPEND", "STRLEN", "INCR", "INCRBY", "INCRBYFLOAT", "DECR", "DECRBY",
+		"GETBIT", "SETBIT", "BITCOUNT", "BITPOS", "BITFIELD", "BITFIELD_RO",
+		// generic
+		"TYPE", "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME",
+		"PERSIST", "DUMP", "RESTORE",
+		// list
+		"LPUSH", "RPUSH", "LPUSHX", "RPUSHX", "LPOP", "RPOP", "LLEN", "LRANGE", "LINDEX", "LSET",
+		"LINSERT", "LREM", "LTRIM", "LPOS",
+		// set
+		"SADD", "SREM", "SISMEMBER", "SMISMEMBER", "SMEMBERS", "SCARD", "SPOP", "SRANDMEMBER", "SSCAN",
+		// hash
+		"HSET", "HSETNX", "HMSET", "HGET", "HMGET", "HGETALL", "HDEL", "HEXISTS", "HLEN", "HKEYS", "HVALS",
+		"HINCRBY", "HINCRBYFLOAT", "HSTRLEN", "HRANDFIELD", "HSCAN", "HGETDEL", "HGETEX", "HSETEX",
+		"HEXPIRE", "HPEXPIRE", "HEXPIREAT", "HPEXPIREAT", "HTTL", "HPTTL", "HEXPIRETIME", "HPEXPIRETIME", "HPERSIST",
+		// zset
+		"ZADD", "ZINCRBY", "ZREM", "ZCARD", "ZCOUNT", "ZLEXCOUNT", "ZSCORE", "ZMSCORE", "ZRANK", "ZREVRANK",
+		"ZRANGE", "ZREVRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX",
+		"ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX", "ZPOPMIN", "ZPOPMAX", "ZRANDMEMBER", "ZSCAN",
+		// stream
+		"XADD", "XACK", "XDEL", "XLEN", "XRANGE", "XREVRANGE", "XTRIM", "XPENDING", "XCLAIM", "XAUTOCLAIM", "XSETID",
+		// hyperloglog, geo
+		"PFADD", "GEOADD", "GEODIST", "GEOHASH", "GEOPOS", "GEOSEARCH",
+		"GEORADIUS_RO", "GEORADIUSBYMEMBER_RO",
+	} {
+		specs[name] = first
+	}
+	return specs
+}()
+
+// addPrefix
+// @Description: 给命令中的key加前缀，shared开头的key不加
+// @param prefix
+// @param cmd
+// @param shared
+func addPrefix(prefix string, cmd redis.Cmder, shared ...string) {
+	args := cmd.Args()
+	if len(args) <= 1 {
+		return
+	}
+	rewrite := func(i int) {
+		key := fmt.Sprint(args[i])
+		for _, s := range shared {
+			if strings.HasPrefix(key, s) {
+				return
+			}
+		}
+		args[i] = withPrefix(prefix, key)
+	}
+	name := strings.ToUpper(cmd.Name())
+	switch name {
+	case "KEYS":
+		rewrite(1)
+	case "SCAN":
+		for i := 2; i < len(args)-1; i++ {
+			if strings.EqualFold(fmt.Sprint(args[i]), "match") {
+				rewrite(i + 1)
+				break
+			}
+		}
+	default:
+		if spec, ok := keySpecs[name]; ok {
+			for _, i := range spec(args) {
+				rewrite(i)
+			}
+		}
+	}
+}
+
+func stripPrefix(prefix string, cmd redis.Cmder) {
+	if cmd.Err() != nil {
+		return
+	}
+	switch c := cmd.(type) {
+	case *redis.ScanCmd:
+		if !strings.EqualFold(c.Name(), "scan") {
+			return
+		}
+		page, cursor := c.Val()
+		res := make([]string, 0, len(page))
+		for _, k := range page {
+			// 未指定MATCH时会扫到其他前缀的key
+			if strings.HasPrefix(k, prefix+":") {
+				res = append(res, withoutPrefix(prefix, k))
+			}
+		}
+		c.SetVal(res, cursor)
+	case *redis.StringSliceCmd:
+		switch strings.ToUpper(c.Name()) {
+		case "KEYS":
+			page := c.Val()
+			for i := range page {
+				page[i] = withoutPrefix(prefix, page[i])
+			}
+		case "BLPOP", "BRPOP":
+			if v := c.Val(); len(v) > 0 {
+				v[0] = withoutPrefix(prefix, v[0])
+			}
+		}
+	case *redis.StringCmd:
+		if strings.EqualFold(c.Name(), "randomkey") {
+			c.SetVal(withoutPrefix(prefix, c.Val()))
+		}
+	case *redis.ZWithKeyCmd:
+		if v := c.Val(); v != nil {
+			v.Key = withoutPrefix(prefix, v.Key)
+		}
+	case *redis.KeyValuesCmd:
+		k, v := c.Val()
+		c.SetVal(withoutPrefix(prefix, k), v)
+	case *redis.ZSliceWithKeyCmd:
+		k, v := c.Val()
+		c.SetVal(withoutPrefix(prefix, k), v)
+	}
+}
+
+func withPrefix(prefix string, key string) string {
+	if strings.HasPrefix(key, prefix+":") {
+		return key
+	}
+	return prefix + ":" + key
+}
+func withoutPrefix(prefix string, key string) string {
+	return strings.TrimPrefix(key, prefix+":")
+}
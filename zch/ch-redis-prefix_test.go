@@ -0,0 +1,120 @@
+package zch
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestAddPrefix(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		args []any
+		want []any
+	}{
+		{[]any{"get", "k"}, []any{"get", "p:k"}},
+		{[]any{"get", "p:k"}, []any{"get", "p:k"}},
+		{[]any{"expire", "k", 10}, []any{"expire", "p:k", 10}},
+		{[]any{"expiretime", "k"}, []any{"expiretime", "p:k"}},
+		{[]any{"hdel", "h", "f1", "f2"}, []any{"hdel", "p:h", "f1", "f2"}},
+		{[]any{"hincrby", "h", "f", 1}, []any{"hincrby", "p:h", "f", 1}},
+		{[]any{"zrangebyscore", "z", "-inf", "+inf", "withscores"}, []any{"zrangebyscore", "p:z", "-inf", "+inf", "withscores"}},
+		{[]any{"del", "a", "b"}, []any{"del", "p:a", "p:b"}},
+		{[]any{"mset", "a", "1", "b", "2"}, []any{"mset", "p:a", "1", "p:b", "2"}},
+		{[]any{"brpop", "a", "b", 0}, []any{"brpop", "p:a", "p:b", 0}},
+		{[]any{"rename", "a", "b"}, []any{"rename", "p:a", "p:b"}},
+		{[]any{"bitop", "and", "d", "a", "b"}, []any{"bitop", "and", "p:d", "p:a", "p:b"}},
+		{[]any{"eval", "return 1", 2, "a", "b", "arg"}, []any{"eval", "return 1", 2, "p:a", "p:b", "arg"}},
+		{[]any{"evalsha", "sha", 0, "arg"}, []any{"evalsha", "sha", 0, "arg"}},
+		{[]any{"zunionstore", "d", 2, "a", "b", "weights", 1, 2}, []any{"zunionstore", "p:d", 2, "p:a", "p:b", "weights", 1, 2}},
+		{[]any{"blmpop", 1, 2, "a", "b", "left"}, []any{"blmpop", 1, 2, "p:a", "p:b", "left"}},
+		{[]any{"sort", "a", "limit", 0, 1, "store", "d"}, []any{"sort", "p:a", "limit", 0, 1, "store", "p:d"}},
+		{[]any{"xreadgroup", "group", "g", "c", "count", 1, "streams", "a", "b", ">", ">"}, []any{"xreadgroup", "group", "g", "c", "count", 1, "streams", "p:a", "p:b", ">", ">"}},
+		{[]any{"xgroup", "create", "s", "g", "$"}, []any{"xgroup", "create", "p:s", "g", "$"}},
+		{[]any{"object", "encoding", "k"}, []any{"object", "encoding", "p:k"}},
+		{[]any{"scan", 0, "match", "a*", "count", 10}, []any{"scan", 0, "match", "p:a*", "count", 10}},
+		{[]any{"sscan", "s", 0, "match", "a*"}, []any{"sscan", "p:s", 0, "match", "a*"}},
+		{[]any{"keys", "*"}, []any{"keys", "p:*"}},
+		{[]any{"publish", "ch", "msg"}, []any{"publish", "ch", "msg"}},
+		{[]any{"ping"}, []any{"ping"}},
+	}
+	for _, c := range cases {
+		cmd := redis.NewCmd(ctx, c.args...)
+		addPrefix("p", cmd)
+		if !reflect.DeepEqual(cmd.Args(), c.want) {
+			t.Fatalf("%v: want %v, got %v", c.args[0], c.want, cmd.Args())
+		}
+	}
+}
+
+func TestPrefixHook(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+	r := NewRedis(&Options{Addrs: []string{s.Addr()}, Prefix: "p"})
+	_ = s.Set("other", "x")
+
+	if err := r.Set(ctx, "a", "1", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	r.MSet(ctx, "b", "2", "c", "3")
+	r.HSet(ctx, "h", "f", 1)
+	r.HIncrBy(ctx, "h", "f", 2)
+	r.ZAdd(ctx, "z", redis.Z{Score: 1, Member: "m"})
+	r.LPush(ctx, "l", "v")
+	r.Eval(ctx, "return redis.call('SET', KEYS[1], ARGV[1])", []string{"e"}, "4")
+	r.Rename(ctx, "c", "d")
+	pipe := r.Pipeline()
+	pipe.Expire(ctx, "a", time.Hour)
+	pipe.SAdd(ctx, "s", "m")
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"other", "p:a", "p:b", "p:d", "p:e", "p:h", "p:l", "p:s", "p:z"}
+	if got := s.Keys(); !slices.Equal(got, want) {
+		t.Fatalf("raw keys: want %v, got %v", want, got)
+	}
+	if v, _ := r.HGet(ctx, "h", "f").Result(); v != "3" {
+		t.Fatalf("hincrby: %s", v)
+	}
+	if ttl := r.TTL(ctx, "a").Val(); ttl != time.Hour {
+		t.Fatalf("expire in pipeline: %s", ttl)
+	}
+
+	// 返回的key去掉前缀
+	got := r.Keys(ctx, "*").Val()
+	slices.Sort(got)
+	if want := []string{"a", "b", "d", "e", "h", "l", "s", "z"}; !slices.Equal(got, want) {
+		t.Fatalf("keys: %v", got)
+	}
+	var scanned []string
+	iter := r.Scan(ctx, 0, "", 100).Iterator()
+	for iter.Next(ctx) {
+		scanned = append(scanned, iter.Val())
+	}
+	slices.Sort(scanned)
+	if !slices.Equal(scanned, got) {
+		t.Fatalf("scan without match: %v", scanned)
+	}
+	if v, _ := r.Scan(ctx, 0, "[ab]", 100).Val(); len(v) != 2 || slices.Contains(v, "other") {
+		t.Fatalf("scan with match: %v", v)
+	}
+	if v := r.BLPop(ctx, time.Second, "none", "l").Val(); !slices.Equal(v, []string{"l", "v"}) {
+		t.Fatalf("blpop: %v", v)
+	}
+	if k := r.RandomKey(ctx).Val(); k == "" || strings.HasPrefix(k, "p:") {
+		t.Fatalf("randomkey: %s", k)
+	}
+	if n, err := r.BatchDelete(ctx, "*"); err != nil || n != 7 {
+		t.Fatalf("batch delete: %v %d", err, n)
+	}
+	if got := s.Keys(); !slices.Equal(got, []string{"other"}) {
+		t.Fatalf("batch delete left: %v", got)
+	}
+}
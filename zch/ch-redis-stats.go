@@ -0,0 +1,113 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/**
+ * redis客户端指标：连接池状态来自go-redis，命令和建连的计数来自metricsHook
+ * 集群和哨兵模式下连接池为所有节点的合计
+ */
+
+type RedisStats struct {
+	// 连接池
+	Hits         uint32        `json:"hits"`     // 从池中取到空闲连接
+	Misses       uint32        `json:"misses"`   // 池中没有空闲连接
+	Timeouts     uint32        `json:"timeouts"` // 等待连接超时
+	WaitCount    uint32        `json:"wait_count"`
+	WaitDuration time.Duration `json:"wait_duration"`
+	TotalConns   uint32        `json:"total_conns"`
+	IdleConns    uint32        `json:"idle_conns"`
+	StaleConns   uint32        `json:"stale_conns"`
+
+	// 命令和建连
+	Commands   uint64        `json:"commands"`    // 管道中的每条命令分别计数
+	Errors     uint64        `json:"errors"`      // 不含redis.Nil
+	Latency    time.Duration `json:"latency"`     // 命令和管道的累计耗时
+	Dials      uint64        `json:"dials"`       // 新建连接次数
+	DialErrors uint64        `json:"dial_errors"` // 新建连接失败次数
+}
+
+func (s RedisStats) AvgLatency() time.Duration {
+	if s.Commands == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Commands)
+}
+
+// Stats
+// @Description: 连接池和命令指标
+// @receiver r
+// @return RedisStats
+func (r *Redis) Stats() RedisStats {
+	p := r.PoolStats()
+	s := RedisStats{
+		Hits:         p.Hits,
+		Misses:       p.Misses,
+		Timeouts:     p.Timeouts,
+		WaitCount:    p.WaitCount,
+		WaitDuration: time.Duration(p.WaitDurationNs),
+		TotalConns:   p.TotalConns,
+		IdleConns:    p.IdleConns,
+		StaleConns:   p.StaleConns,
+	}
+	if m := r.metrics; m != nil {
+		s.Commands = m.commands.Load()
+		s.Errors = m.errors.Load()
+		s.Latency = time.Duration(m.latency.Load())
+		s.Dials = m.dials.Load()
+		s.DialErrors = m.dialErrors.Load()
+	}
+	return s
+}
+
+type metricsHook struct {
+	commands   atomic.Uint64
+	errors     atomic.Uint64
+	latency    atomic.Int64
+	dials      atomic.Uint64
+	dialErrors atomic.Uint64
+}
+
+func (h *metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		h.dials.Add(1)
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			h.dialErrors.Add(1)
+		}
+		return conn, err
+	}
+}
+func (h *metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.latency.Add(int64(time.Since(start)))
+		h.commands.Add(1)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			h.errors.Add(1)
+		}
+		return err
+	}
+}
+func (h *metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.latency.Add(int64(time.Since(start)))
+		h.commands.Add(uint64(len(cmds)))
+		for _, cmd := range cmds {
+			if e := cmd.Err(); e != nil && !errors.Is(e, redis.Nil) {
+				h.errors.Add(1)
+			}
+		}
+		return err
+	}
+}
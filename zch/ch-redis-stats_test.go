@@ -0,0 +1,52 @@
+package zch
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRedisStats(t *testing.T) {
+	s := testRedis(t)
+	ctx := context.Background()
+	r := NewRedis(&Options{Addrs: []string{s.Addr()}, Protocol: 2})
+	defer r.Close()
+	before := r.Stats()
+	if before.Dials == 0 || before.Commands == 0 {
+		t.Fatalf("startup ping not counted: %+v", before)
+	}
+	r.Set(ctx, "a", "1", 0)
+	r.Get(ctx, "missing")
+	r.Do(ctx, "NOSUCHCMD")
+	pipe := r.Pipeline()
+	pipe.Get(ctx, "a")
+	pipe.Get(ctx, "missing")
+	_, _ = pipe.Exec(ctx)
+	st := r.Stats()
+	if st.Commands-before.Commands != 5 || st.Errors-before.Errors != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if st.TotalConns == 0 || st.Latency <= 0 || st.AvgLatency() <= 0 {
+		t.Fatalf("unexpected pool stats: %+v", st)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	for _, o := range []*Options{
+		{Addrs: []string{"a:1"}, Protocol: 4},
+		{Addrs: []string{"a:1", "b:1"}, Database: 1},
+		{Addrs: []string{"a:1"}, Cluster: true, Database: 1},
+	} {
+		if err := o.Validate(); err == nil {
+			t.Fatalf("expected error: %+v", o)
+		}
+	}
+	if err := (&Options{Addrs: []string{"a:1", "b:1"}, MasterName: "m", Database: 1}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&TLSOptions{CAFile: "/nonexistent"}).Config(); err == nil {
+		t.Fatal("expected error for missing ca file")
+	}
+	if conf, err := (&TLSOptions{ServerName: "redis"}).Config(); err != nil || conf.ServerName != "redis" {
+		t.Fatalf("tls config: %v", err)
+	}
+}
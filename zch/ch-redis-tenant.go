@@ -0,0 +1,96 @@
+package zch
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/ztenant"
+)
+
+/**
+ * 租户隔离，Options.Tenant开启
+ *  - ctx中有租户时key加前缀 t:{id}，在全局Prefix之内，即 {prefix}:t:{id}:{key}
+ *  - TenantShared开头的key不隔离，如登录态、定时任务、消息流和Topic等全局数据
+ *  - L2的内存层同样按租户区分，失效消息中是带租户前缀的key
+ *  - ztenant.Bypass的ctx不加前缀，可访问全局数据；M()直接访问内存层时不隔离
+ */
+
+var defaultTenantShared = []string{
+	string(PrefixL2Invalidate),
+	string(PrefixCron) + ":",
+	string(PrefixI18n),
+	string(PrefixStream) + ":",
+	"{", // Topic的key为 {prefix}:stream 等，消费协程不带租户
+	"auth:",
+}
+
+type TenantHook struct {
+	shared []string
+}
+
+func NewTenantHook(shared ...string) TenantHook {
+	return TenantHook{shared: shared}
+}
+
+func (h TenantHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+func (h TenantHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		prefix, ok := h.prefix(ctx)
+		if !ok {
+			return next(ctx, cmd)
+		}
+		addPrefix(prefix, cmd, h.shared...)
+		err := next(ctx, cmd)
+		stripPrefix(prefix, cmd)
+		return err
+	}
+}
+func (h TenantHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		prefix, ok := h.prefix(ctx)
+		if !ok {
+			return next(ctx, cmds)
+		}
+		for _, cmd := range cmds {
+			addPrefix(prefix, cmd, h.shared...)
+		}
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			stripPrefix(prefix, cmd)
+		}
+		return err
+	}
+}
+
+func (h TenantHook) prefix(ctx context.Context) (string, bool) {
+	if ztenant.Bypassed(ctx) {
+		return "", false
+	}
+	id, ok := ztenant.From(ctx)
+	return ztenant.Prefix(id), ok
+}
+
+// key
+// @Description: 内存层的key，与redis中去掉全局前缀后的key一致
+// @receiver h
+// @param ctx
+// @param k
+// @return string
+func (h TenantHook) key(ctx context.Context, k string) string {
+	prefix, ok := h.prefix(ctx)
+	if !ok {
+		return k
+	}
+	for _, s := range h.shared {
+		if strings.HasPrefix(k, s) {
+			return k
+		}
+	}
+	return withPrefix(prefix, k)
+}
@@ -0,0 +1,58 @@
+package zch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/ztenant"
+)
+
+func TestTenantHook(t *testing.T) {
+	s := miniredis.RunT(t)
+	l := newL2(&Options{Addrs: []string{s.Addr()}, Prefix: "p", Tenant: true, Invalidation: InvalidationNone})
+	ctx := context.Background()
+	a, b := ztenant.With(ctx, "a"), ztenant.With(ctx, "b")
+	for c, v := range map[context.Context]string{ctx: "g", a: "a", b: "b"} {
+		if err := l.Set(c, "k", v, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, want := range map[string]string{"p:k": "g", "p:t:a:k": "a", "p:t:b:k": "b"} {
+		if got, _ := s.Get(key); got != want {
+			t.Fatalf("%s: want %q, got %q", key, want, got)
+		}
+	}
+	// 内存层同样按租户区分
+	for c, want := range map[context.Context]string{ctx: "g", a: "a", b: "b", ztenant.Bypass(a): "g"} {
+		if got, _ := l.Get(c, "k"); got != want {
+			t.Fatalf("l2 get: want %q, got %q", want, got)
+		}
+	}
+	l.FlushMemory()
+	if got, _ := l.MGet(b, "k"); got["k"] != "b" {
+		t.Fatalf("mget: %v", got)
+	}
+
+	// 共享前缀不隔离
+	l.r.Set(a, PrefixAuthToken.Key("1"), "u", 0)
+	if !s.Exists("p:auth:user:1") {
+		t.Fatalf("shared key isolated: %v", s.Keys())
+	}
+	// 消息流和Topic由不带租户的消费协程读取，不隔离
+	l.r.XAdd(a, &redis.XAddArgs{Stream: PrefixStream.Key("s"), Values: []string{"k", "v"}})
+	l.r.XAdd(a, &redis.XAddArgs{Stream: (&Topic{prefix: "q"}).stream(), Values: []string{"k", "v"}})
+	if !s.Exists("p:stream:s") || !s.Exists("p:{q}:stream") {
+		t.Fatalf("stream key isolated: %v", s.Keys())
+	}
+	// SCAN只返回当前租户的key，且去掉前缀
+	keys, _, err := l.r.Scan(a, 0, "*", 100).Result()
+	if err != nil || len(keys) != 1 || keys[0] != "k" {
+		t.Fatalf("scan: %v %v", keys, err)
+	}
+	if err = l.Del(a, "k"); err != nil || s.Exists("p:t:a:k") || !s.Exists("p:t:b:k") {
+		t.Fatalf("del: %v %v", err, s.Keys())
+	}
+}
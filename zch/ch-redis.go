@@ -2,16 +2,19 @@ package zch
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"strings"
+
 	"github.com/redis/go-redis/v9"
 	"github.com/zohu/zgin/zutil"
 	"github.com/zohu/zlog"
-	"net"
-	"strings"
 )
 
 type Redis struct {
 	redis.UniversalClient
+	metrics *metricsHook
+	tenant  *TenantHook
 }
 
 func NewRedis(options *Options) *Redis {
@@ -20,18 +23,75 @@ func NewRedis(options *Options) *Redis {
 		zlog.Fatalf("options is invalid: %v", err)
 		return nil
 	}
+	var tlsConfig *tls.Config
+	if options.TLS != nil {
+		var err error
+		if tlsConfig, err = options.TLS.Config(); err != nil {
+			zlog.Fatalf("redis tls is invalid: %v", err)
+			return nil
+		}
+	}
 	client := redis.NewUniversalClient(&redis.UniversalOptions{
-		Addrs:      options.Addrs,
-		ClientName: options.ClientName,
-		DB:         options.Database,
-		Password:   options.Password,
+		Addrs:            options.Addrs,
+		ClientName:       options.ClientName,
+		DB:               options.Database,
+		Protocol:         options.Protocol,
+		Username:         options.Username,
+		Password:         options.Password,
+		SentinelUsername: options.SentinelUsername,
+		SentinelPassword: options.SentinelPassword,
+		MasterName:       options.MasterName,
+		IsClusterMode:    options.Cluster,
+		TLSConfig:        tlsConfig,
+
+		ReadOnly:       options.ReadOnly,
+		RouteByLatency: options.RouteByLatency,
+		RouteRandomly:  options.RouteRandomly,
+
+		PoolSize:        options.PoolSize,
+		PoolTimeout:     options.PoolTimeout,
+		MinIdleConns:    options.MinIdleConns,
+		MaxIdleConns:    options.MaxIdleConns,
+		MaxActiveConns:  options.MaxActiveConns,
+		ConnMaxIdleTime: options.ConnMaxIdleTime,
+		ConnMaxLifetime: options.ConnMaxLifetime,
+		DialTimeout:     options.DialTimeout,
+		ReadTimeout:     options.ReadTimeout,
+		WriteTimeout:    options.WriteTimeout,
+		MaxRetries:      options.MaxRetries,
 	})
+	r := &Redis{
+		UniversalClient: client,
+		metrics:         &metricsHook{},
+	}
+	client.AddHook(r.metrics)
+	if options.Tenant {
+		// 在全局前缀之前处理，key为 {prefix}:t:{id}:{key}
+		hook := NewTenantHook(options.TenantShared...)
+		r.tenant = &hook
+		client.AddHook(hook)
+	}
 	if options.Prefix != "" {
 		hook := NewPrefixHook(options.Prefix)
 		client.AddHook(hook)
 	}
-	return &Redis{
-		UniversalClient: client,
+	ctx, cancel := context.WithTimeout(context.Background(), options.DialTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		zlog.Fatalf("redis %s %s connect failed: %v", redisMode(options), strings.Join(options.Addrs, ","), err)
+		return nil
+	}
+	return r
+}
+
+func redisMode(options *Options) string {
+	switch {
+	case options.MasterName != "":
+		return "sentinel(" + options.MasterName + ")"
+	case options.Cluster || len(options.Addrs) > 1:
+		return "cluster"
+	default:
+		return "standalone"
 	}
 }
 
@@ -59,89 +119,3 @@ func (r *Redis) BatchDelete(ctx context.Context, pattern string, batchSize ...in
 	}
 	return total, nil
 }
-
-/**
- * redis prefix hook
- */
-
-type PrefixHook struct {
-	prefix string
-}
-
-func NewPrefixHook(prefix string) PrefixHook {
-	return PrefixHook{
-		prefix: prefix,
-	}
-}
-
-func (h PrefixHook) DialHook(next redis.DialHook) redis.DialHook {
-	return func(ctx context.Context, network, addr string) (net.Conn, error) {
-		return next(ctx, network, addr)
-	}
-}
-func (h PrefixHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
-	return func(ctx context.Context, cmd redis.Cmder) error {
-		addPrefix(h.prefix, cmd)
-		return next(ctx, cmd)
-	}
-}
-func (h PrefixHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
-	return func(ctx context.Context, cmds []redis.Cmder) error {
-		for _, cmd := range cmds {
-			addPrefix(h.prefix, cmd)
-		}
-		return next(ctx, cmds)
-	}
-}
-
-func addPrefix(prefix string, cmd redis.Cmder) {
-	args := cmd.Args()
-	if len(args) <= 1 {
-		return
-	}
-	name := strings.ToUpper(cmd.Name())
-	switch name {
-	case "MGET", "DEL":
-		for i := 1; i < len(args); i++ {
-			args[i] = withPrefix(prefix, args[i].(string))
-		}
-	case "MSET":
-		for i := 1; i < len(args); i += 2 {
-			args[i] = withPrefix(prefix, args[i].(string))
-		}
-	case "SCAN":
-		if len(args) > 2 {
-			for i := 2; i < len(args); i += 2 {
-				if args[i] == "match" && len(args) > i+1 {
-					args[i+1] = withPrefix(prefix, args[i+1].(string))
-					break
-				}
-			}
-		}
-	default:
-		if canWithPrefix(name) {
-			args[1] = withPrefix(prefix, args[1].(string))
-		}
-	}
-}
-
-func canWithPrefix(name string) bool {
-	switch name {
-	case "GET", "SET", "EXISTS", "DEL", "TYPE",
-		"RPUSH", "LPOP", "RPOP", "LLEN", "LRANGE",
-		"SADD", "SREM", "SISMEMBER", "SMEMBERS", "SCARD",
-		"HSET", "HMSET", "HGET", "HGETALL",
-		"ZADD", "ZRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZREM",
-		"INCR", "INCRBY", "INCRBYFLOAT",
-		"WATCH", "MULTI", "EXEC", "EXPIRE":
-		return true
-	default:
-		return false
-	}
-}
-func withPrefix(prefix string, key string) string {
-	if strings.HasPrefix(key, prefix+":") {
-		return key
-	}
-	return prefix + ":" + key
-}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+
 	"github.com/zohu/zgin/zutil"
 	"github.com/zohu/zlog"
 )
@@ -11,6 +13,9 @@ import (
 type L2 struct {
 	m *Memory
 	r *Redis
+
+	id      string // 实例标识，忽略自己发出的失效消息
+	channel string
 }
 
 var l2 *L2
@@ -22,18 +27,55 @@ func NewL2(options *Options) *L2 {
 		return nil
 	}
 	if l2 == nil {
-		l2 = &L2{
-			m: NewMemory(options.Expiration, options.CleanInterval, options.Prefix),
-			r: NewRedis(options),
-		}
+		l2 = newL2(options)
 	}
 	zlog.Infof("zch init success!")
 	return l2
 }
 
+func newL2(options *Options) *L2 {
+	l := &L2{
+		m: NewMemoryWithOptions(&MemoryOptions{
+			Expiration:    options.Expiration,
+			CleanInterval: options.CleanInterval,
+			Prefix:        options.Prefix,
+			MaxEntries:    options.MaxEntries,
+			MaxBytes:      options.MaxBytes,
+			Policy:        options.Policy,
+
+			SnapshotPath:     options.SnapshotPath,
+			SnapshotInterval: options.SnapshotInterval,
+		}),
+		r:       NewRedis(options),
+		id:      zutil.RandomStr(16),
+		channel: PrefixL2Invalidate.Key(),
+	}
+	if options.Prefix != "" {
+		l.channel = PrefixL2Invalidate.Key(options.Prefix)
+	}
+	l.invalidation(options)
+	return l
+}
+
 func L() *L2 {
 	return l2
 }
+
+// Close
+// @Description: 停止Topic的后台协程，关闭L2并保存内存快照，zgin.App退出时自动调用，未使用App时需在退出前调用
+func Close() {
+	closeTopics()
+	if l2 != nil {
+		l2.Close()
+	}
+}
+
+// Close
+// @Description: 停止定时快照并保存最后一次
+// @receiver l
+func (l *L2) Close() {
+	l.m.Close()
+}
 func M() *Memory {
 	return l2.m
 }
@@ -42,27 +84,128 @@ func R() *Redis {
 }
 
 func (l *L2) Set(ctx context.Context, k, v string, exp time.Duration) error {
-	if err := l.r.Set(ctx, k, v, exp).Err(); err == nil {
+	if err := l.r.Set(ctx, k, v, exp).Err(); err != nil {
 		return err
 	}
-	l.m.Set(k, v, l1(exp))
+	mk := l.key(ctx, k)
+	l.m.Set(mk, v, l1(exp))
+	l.publish(ctx, mk)
 	return nil
 }
 func (l *L2) Get(ctx context.Context, k string) (string, error) {
-	if v, ok := l.m.Get(k); ok {
+	mk := l.key(ctx, k)
+	if v, ok := l.m.Get(mk); ok {
 		return v, nil
 	}
 	v, err := l.r.Get(ctx, k).Result()
 	if err != nil {
 		return "", err
 	}
-	exp := l.r.ExpireTime(ctx, k).Val()
-	l.m.Set(k, v, l1(exp))
+	l.m.Set(mk, v, l1(l.r.PTTL(ctx, k).Val()))
 	return v, nil
 }
-func (l *L2) Del(ctx context.Context, k string) error {
-	l.m.Delete(k)
-	return l.r.Del(ctx, k).Err()
+func (l *L2) Del(ctx context.Context, ks ...string) error {
+	if len(ks) == 0 {
+		return nil
+	}
+	mks := make([]string, len(ks))
+	for i, k := range ks {
+		mks[i] = l.key(ctx, k)
+		l.m.Delete(mks[i])
+	}
+	// 集群下多key命令要求同一slot，逐个删除
+	pipe := l.r.Pipeline()
+	for _, k := range ks {
+		pipe.Del(ctx, k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	l.publish(ctx, mks...)
+	return nil
+}
+
+// MGet
+// @Description: 批量读取，先读内存，未命中的从redis批量读取并回填内存
+// @receiver l
+// @param ctx
+// @param ks
+// @return map[string]string 只包含命中的key
+// @return error
+func (l *L2) MGet(ctx context.Context, ks ...string) (map[string]string, error) {
+	res := make(map[string]string, len(ks))
+	var miss []string
+	for _, k := range ks {
+		if v, ok := l.m.Get(l.key(ctx, k)); ok {
+			res[k] = v
+		} else {
+			miss = append(miss, k)
+		}
+	}
+	if len(miss) == 0 {
+		return res, nil
+	}
+	pipe := l.r.Pipeline()
+	gets := make([]*redis.SliceCmd, len(miss))
+	ttls := make([]*redis.DurationCmd, len(miss))
+	for i, k := range miss {
+		// 单key的MGET未命中时不返回redis.Nil，首个命令出错会覆盖pipeline中其余命令的结果
+		gets[i] = pipe.MGet(ctx, k)
+		ttls[i] = pipe.PTTL(ctx, k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, k := range miss {
+		v, ok := gets[i].Val()[0].(string)
+		if !ok {
+			continue
+		}
+		res[k] = v
+		l.m.Set(l.key(ctx, k), v, l1(ttls[i].Val()))
+	}
+	return res, nil
+}
+
+// MSet
+// @Description: 批量写入
+// @receiver l
+// @param ctx
+// @param kvs
+// @param exp
+// @return error
+func (l *L2) MSet(ctx context.Context, kvs map[string]string, exp time.Duration) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	pipe := l.r.Pipeline()
+	for k, v := range kvs {
+		pipe.Set(ctx, k, v, exp)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(kvs))
+	for k, v := range kvs {
+		mk := l.key(ctx, k)
+		l.m.Set(mk, v, l1(exp))
+		keys = append(keys, mk)
+	}
+	l.publish(ctx, keys...)
+	return nil
+}
+
+// key
+// @Description: 内存层的key，开启租户隔离时带租户前缀
+// @receiver l
+// @param ctx
+// @param k
+// @return string
+func (l *L2) key(ctx context.Context, k string) string {
+	if l.r.tenant == nil {
+		return k
+	}
+	return l.r.tenant.key(ctx, k)
 }
 
 func (l *L2) FlushMemory() {
@@ -74,5 +217,5 @@ func (l *L2) FlushMemory() {
 // @param expiration
 // @return time.Duration
 func l1(expiration time.Duration) time.Duration {
-	return zutil.When(expiration > 10*time.Minute, 10*time.Minute, expiration)
+	return zutil.When(expiration <= 0 || expiration > 10*time.Minute, 10*time.Minute, expiration)
 }
@@ -0,0 +1,125 @@
+package zch
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestL2Invalidation(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+	// miniredis不支持CLIENT TRACKING，tracking会回退到pubsub
+	for _, mode := range []Invalidation{InvalidationPubSub, InvalidationTracking} {
+		opts := func() *Options {
+			o := &Options{Addrs: []string{s.Addr()}, Prefix: "cs", Invalidation: mode}
+			if err := o.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			return o
+		}
+		a, b := newL2(opts()), newL2(opts())
+
+		if err := a.Set(ctx, "k", "v1", time.Minute); err != nil {
+			t.Fatalf("%s set: %v", mode, err)
+		}
+		if v, _ := a.m.Get("k"); v != "v1" {
+			t.Fatalf("%s l1 not written on set: %q", mode, v)
+		}
+		if v, err := b.Get(ctx, "k"); err != nil || v != "v1" {
+			t.Fatalf("%s get: %v %q", mode, err, v)
+		}
+		if _, ok := b.m.Get("k"); !ok {
+			t.Fatalf("%s l1 not filled on get", mode)
+		}
+
+		_ = a.Set(ctx, "k", "v2", time.Minute)
+		eventually(t, func() bool {
+			v, _ := b.Get(ctx, "k")
+			return v == "v2"
+		})
+		_ = a.Del(ctx, "k")
+		eventually(t, func() bool {
+			_, err := b.Get(ctx, "k")
+			return err != nil
+		})
+		if _, ok := a.m.Get("k"); ok {
+			t.Fatalf("%s own l1 not deleted", mode)
+		}
+
+		// 未命中的key排在前面不影响其他key
+		_ = a.MSet(ctx, map[string]string{"m1": "1", "m2": "2"}, time.Minute)
+		b.m.Delete("m1")
+		b.m.Delete("m2")
+		if got, err := b.MGet(ctx, "missing", "m1", "m2"); err != nil || len(got) != 2 || got["m2"] != "2" {
+			t.Fatalf("%s mget: %v %v", mode, err, got)
+		}
+		if err := a.Del(ctx, "m1", "m2"); err != nil || len(s.Keys()) != 0 {
+			t.Fatalf("%s del: %v %v", mode, err, s.Keys())
+		}
+	}
+}
+
+func eventually(t *testing.T, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("condition not met in 2s")
+}
+
+var testServer *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	testServer = miniredis.NewMiniRedis()
+	if err := testServer.Start(); err != nil {
+		panic(err)
+	}
+	l2 = newL2(&Options{Addrs: []string{testServer.Addr()}, Invalidation: InvalidationNone})
+	code := m.Run()
+	closeTopics()
+	testServer.Close()
+	os.Exit(code)
+}
+
+// testRedis
+// @Description: 清空共享的miniredis和内存层，全局L2只在TestMain中设置一次，不与后台协程竞争；测试结束时停止Topic的搬运协程
+// @param t
+// @return *miniredis.Miniredis
+func testRedis(t *testing.T) *miniredis.Miniredis {
+	testServer.FlushAll()
+	testServer.SetTime(time.Time{})
+	l2.FlushMemory()
+	t.Cleanup(closeTopics)
+	return testServer
+}
+
+func TestL2CloseSnapshot(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+	path := t.TempDir() + "/l2.snap"
+	opts := func() *Options {
+		o := &Options{Addrs: []string{s.Addr()}, Invalidation: InvalidationNone, SnapshotPath: path, SnapshotInterval: time.Hour}
+		if err := o.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+	a := newL2(opts())
+	if err := a.Set(ctx, "k", "v", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// 定时快照未触发，关闭时保存最后一次
+	a.Close()
+	b := newL2(opts())
+	if v, ok := b.m.Get("k"); !ok || v != "v" {
+		t.Fatalf("warm start from close snapshot: %q %v", v, ok)
+	}
+}
@@ -0,0 +1,50 @@
+package zch
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/bytedance/sonic"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec
+// @Description: 缓存值的编解码器
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	CodecJSON    Codec = jsonCodec{}
+	CodecMsgpack Codec = msgpackCodec{}
+	CodecGob     Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return sonic.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return sonic.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string                       { return "msgpack" }
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+// gobCodec 接口类型的值需要提前gob.Register
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,326 @@
+package zch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zutil"
+)
+
+/**
+ * 按时间分桶的计数器，key为 counter:{name:key}:{granularity}:{period}
+ * 写入时同时累加所有配置的粒度(写时汇总)，每个桶在结束后保留Retention再过期
+ * 同一计数对象的桶带相同的hash tag，集群模式下可以一次MGET
+ */
+
+type Granularity string
+
+const (
+	GranularityMinute Granularity = "minute"
+	GranularityHour   Granularity = "hour"
+	GranularityDay    Granularity = "day"
+	GranularityWeek   Granularity = "week" // 周一开始
+	GranularityMonth  Granularity = "month"
+)
+
+// 单次范围查询最多的桶数
+const maxBuckets = 10000
+
+var defaultRetention = map[Granularity]time.Duration{
+	GranularityMinute: time.Hour * 24,
+	GranularityHour:   time.Hour * 24 * 7,
+	GranularityDay:    time.Hour * 24 * 90,
+	GranularityWeek:   time.Hour * 24 * 730,
+	GranularityMonth:  time.Hour * 24 * 1095,
+}
+
+type CounterOptions struct {
+	Granularities []Granularity                 // 默认只按天
+	Retention     map[Granularity]time.Duration // 桶结束后保留的时长，未配置的使用默认值：分钟1天、小时7天、天90天、周2年、月3年
+	Timezone      string                        // 分桶使用的时区，默认本地时区
+
+	location *time.Location
+}
+
+func (o *CounterOptions) Validate() error {
+	if len(o.Granularities) == 0 {
+		o.Granularities = []Granularity{GranularityDay}
+	}
+	retention := make(map[Granularity]time.Duration, len(o.Granularities))
+	for _, g := range o.Granularities {
+		d, ok := defaultRetention[g]
+		if !ok {
+			return fmt.Errorf("unknown granularity: %s", g)
+		}
+		retention[g] = zutil.FirstTruth(o.Retention[g], d)
+	}
+	o.Retention = retention
+	o.location = time.Local
+	if o.Timezone != "" {
+		loc, err := time.LoadLocation(o.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %s: %w", o.Timezone, err)
+		}
+		o.location = loc
+	}
+	return nil
+}
+
+// start
+// @Description: t所在桶的开始时间
+// @receiver g
+// @param t
+// @param loc
+// @return time.Time
+func (g Granularity) start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	switch g {
+	case GranularityMinute:
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, loc)
+	case GranularityHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+	case GranularityWeek:
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case GranularityMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+}
+
+func (g Granularity) next(start time.Time) time.Time {
+	switch g {
+	case GranularityMinute:
+		return start.Add(time.Minute)
+	case GranularityHour:
+		return start.Add(time.Hour)
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func (g Granularity) period(start time.Time) string {
+	switch g {
+	case GranularityMinute:
+		return start.Format("200601021504")
+	case GranularityHour:
+		return start.Format("2006010215")
+	case GranularityMonth:
+		return start.Format("200601")
+	default:
+		return start.Format("20060102")
+	}
+}
+
+type bucket struct {
+	start  time.Time
+	key    string
+	expire time.Time
+}
+
+// buckets
+// @Description: from到to(含)之间的所有桶
+// @param prefix
+// @param name
+// @param key
+// @param opts
+// @param g
+// @param from
+// @param to
+// @return []bucket
+// @return error
+func buckets(prefix Prefix, name, key string, opts *CounterOptions, g Granularity, from, to time.Time) ([]bucket, error) {
+	retention, ok := opts.Retention[g]
+	if !ok {
+		return nil, fmt.Errorf("granularity %s is not enabled", g)
+	}
+	var res []bucket
+	for s := g.start(from, opts.location); !s.After(to); s = g.next(s) {
+		if len(res) >= maxBuckets {
+			return nil, fmt.Errorf("too many %s buckets between %s and %s", g, from, to)
+		}
+		res = append(res, bucket{
+			start:  s,
+			key:    counterKey(prefix, name, key, string(g), g.period(s)),
+			expire: g.next(s).Add(retention),
+		})
+	}
+	return res, nil
+}
+
+// counterKey
+// @Description: 同一计数对象的key使用相同的hash tag
+// @param prefix
+// @param name
+// @param key
+// @param args
+// @return string
+func counterKey(prefix Prefix, name, key string, args ...string) string {
+	return prefix.Key(append([]string{"{" + name + ":" + key + "}"}, args...)...)
+}
+
+type Counter struct {
+	name string
+	opts *CounterOptions
+}
+
+type CounterPoint struct {
+	Time  time.Time `json:"time"`
+	Value int64     `json:"value"`
+}
+
+// NewCounter
+// @Description: 按时间分桶的计数器，如每日、每周的访问次数
+// @param name
+// @param opts
+// @return *Counter
+// @return error
+func NewCounter(name string, opts ...*CounterOptions) (*Counter, error) {
+	opt := zutil.FirstTruth(append(opts, &CounterOptions{})...)
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+	return &Counter{name: name, opts: opt}, nil
+}
+
+// Incr
+// @Description: 当前时间的所有粒度累加n
+// @receiver c
+// @param ctx
+// @param key 计数对象，如文章ID
+// @param n
+// @return error
+func (c *Counter) Incr(ctx context.Context, key string, n int64) error {
+	return c.IncrAt(ctx, key, time.Now(), n)
+}
+
+// IncrAt
+// @Description: 指定时间的所有粒度累加n，用于补录
+// @receiver c
+// @param ctx
+// @param key
+// @param t
+// @param n
+// @return error
+func (c *Counter) IncrAt(ctx context.Context, key string, t time.Time, n int64) error {
+	_, err := R().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, g := range c.opts.Granularities {
+			bs, err := buckets(PrefixCounter, c.name, key, c.opts, g, t, t)
+			if err != nil {
+				return err
+			}
+			pipe.IncrBy(ctx, bs[0].key, n)
+			pipe.ExpireAt(ctx, bs[0].key, bs[0].expire)
+		}
+		return nil
+	})
+	return err
+}
+
+// Get
+// @Description: t所在桶的计数
+// @receiver c
+// @param ctx
+// @param key
+// @param g
+// @param t
+// @return int64
+// @return error
+func (c *Counter) Get(ctx context.Context, key string, g Granularity, t time.Time) (int64, error) {
+	points, err := c.Range(ctx, key, g, t, t)
+	if err != nil {
+		return 0, err
+	}
+	return points[0].Value, nil
+}
+
+// Range
+// @Description: from到to(含)之间每个桶的计数，没有数据的桶为0
+// @receiver c
+// @param ctx
+// @param key
+// @param g
+// @param from
+// @param to
+// @return []CounterPoint
+// @return error
+func (c *Counter) Range(ctx context.Context, key string, g Granularity, from, to time.Time) ([]CounterPoint, error) {
+	bs, err := buckets(PrefixCounter, c.name, key, c.opts, g, from, to)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]CounterPoint, len(bs))
+	if len(bs) == 0 {
+		return res, nil
+	}
+	keys := make([]string, len(bs))
+	for i, b := range bs {
+		keys[i] = b.key
+		res[i].Time = b.start
+	}
+	values, err := R().MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			if res[i].Value, err = strconv.ParseInt(s, 10, 64); err != nil {
+				return nil, fmt.Errorf("counter %s: %w", keys[i], err)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Sum
+// @Description: from到to(含)之间的合计
+// @receiver c
+// @param ctx
+// @param key
+// @param g
+// @param from
+// @param to
+// @return int64
+// @return error
+func (c *Counter) Sum(ctx context.Context, key string, g Granularity, from, to time.Time) (int64, error) {
+	points, err := c.Range(ctx, key, g, from, to)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, p := range points {
+		total += p.Value
+	}
+	return total, nil
+}
+
+// Reset
+// @Description: 删除t所在的各粒度桶
+// @receiver c
+// @param ctx
+// @param key
+// @param t
+// @return error
+func (c *Counter) Reset(ctx context.Context, key string, t time.Time) error {
+	return resetBuckets(ctx, PrefixCounter, c.name, key, c.opts, t)
+}
+
+func resetBuckets(ctx context.Context, prefix Prefix, name, key string, opts *CounterOptions, t time.Time) error {
+	var keys []string
+	for _, g := range opts.Granularities {
+		bs, err := buckets(prefix, name, key, opts, g, t, t)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, bs[0].key)
+	}
+	return R().Del(ctx, keys...).Err()
+}
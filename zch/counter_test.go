@@ -0,0 +1,95 @@
+package zch
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	s := testRedis(t)
+	ctx := context.Background()
+	c, err := NewCounter("pv", &CounterOptions{
+		Granularities: []Granularity{GranularityDay, GranularityWeek},
+		Timezone:      "Asia/Shanghai",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	// 本周一和上周日，过期时间按桶结束计算，不能用太早的日期
+	monday := GranularityWeek.start(time.Now(), loc)
+	sun := monday.Add(-time.Hour)
+	mon := monday.Add(time.Hour)
+	_ = c.IncrAt(ctx, "home", sun, 2)
+	_ = c.IncrAt(ctx, "home", mon, 3)
+	_ = c.IncrAt(ctx, "home", mon, 1)
+	if v, _ := c.Get(ctx, "home", GranularityDay, mon); v != 4 {
+		t.Fatalf("day: %d", v)
+	}
+	if v, _ := c.Get(ctx, "home", GranularityWeek, mon); v != 4 {
+		t.Fatalf("week: %d", v)
+	}
+	if v, _ := c.Sum(ctx, "home", GranularityWeek, sun, mon); v != 6 {
+		t.Fatalf("weeks: %d", v)
+	}
+	points, _ := c.Range(ctx, "home", GranularityDay, sun.AddDate(0, 0, -1), mon)
+	if len(points) != 3 || points[0].Value != 0 || points[1].Value != 2 || points[2].Value != 4 ||
+		!points[2].Time.Equal(monday) {
+		t.Fatalf("range: %+v", points)
+	}
+	if _, err = c.Get(ctx, "home", GranularityHour, mon); err == nil {
+		t.Fatal("expected error for disabled granularity")
+	}
+	key := counterKey(PrefixCounter, "pv", "home", "day", monday.Format("20060102"))
+	if ttl := s.TTL(key); ttl <= 0 {
+		t.Fatalf("bucket without expiry: %v", ttl)
+	}
+	if err = c.Reset(ctx, "home", mon); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get(ctx, "home", GranularityWeek, mon); v != 0 {
+		t.Fatalf("reset: %d", v)
+	}
+	if _, err = NewCounter("bad", &CounterOptions{Granularities: []Granularity{"year"}}); err == nil {
+		t.Fatal("expected error for unknown granularity")
+	}
+	if _, err = NewCounter("nil", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewUniqueCounter("nil", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniqueCounter(t *testing.T) {
+	testRedis(t)
+	ctx := context.Background()
+	c, err := NewUniqueCounter("uv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day2 := time.Now()
+	day1 := day2.AddDate(0, 0, -1)
+	for i := 0; i < 100; i++ {
+		_ = c.AddAt(ctx, "home", day1, strconv.Itoa(i))
+	}
+	for i := 50; i < 150; i++ {
+		_ = c.AddAt(ctx, "home", day2, strconv.Itoa(i))
+	}
+	if n, _ := c.Count(ctx, "home", GranularityDay, day1); n < 98 || n > 102 {
+		t.Fatalf("day1: %d", n)
+	}
+	// miniredis的多key PFCOUNT是各自相加而不是合并，合并结果通过Merge(PFMERGE)验证
+	if n, _ := c.CountRange(ctx, "home", GranularityDay, day2, day2); n < 98 || n > 102 {
+		t.Fatalf("range: %d", n)
+	}
+	n, err := c.Merge(ctx, "home", GranularityDay, day1, day2, "mar", time.Hour)
+	if err != nil || n < 147 || n > 153 {
+		t.Fatalf("merge: %d %v", n, err)
+	}
+	if m, _ := c.Merged(ctx, "home", "mar"); m != n {
+		t.Fatalf("merged: %d != %d", m, n)
+	}
+}
@@ -0,0 +1,170 @@
+package zch
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zutil"
+)
+
+/**
+ * 基于HyperLogLog的去重计数(UV)，key为 uv:{name:key}:{granularity}:{period}
+ * 分桶和过期规则与Counter相同；跨桶的去重数由PFCOUNT多个key合并得到，误差约0.81%
+ */
+
+type UniqueCounter struct {
+	name string
+	opts *CounterOptions
+}
+
+// NewUniqueCounter
+// @Description: 去重计数器，如每日UV
+// @param name
+// @param opts
+// @return *UniqueCounter
+// @return error
+func NewUniqueCounter(name string, opts ...*CounterOptions) (*UniqueCounter, error) {
+	opt := zutil.FirstTruth(append(opts, &CounterOptions{})...)
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+	return &UniqueCounter{name: name, opts: opt}, nil
+}
+
+// Add
+// @Description: 当前时间的所有粒度记录成员
+// @receiver c
+// @param ctx
+// @param key 计数对象，如页面
+// @param members 如用户ID
+// @return error
+func (c *UniqueCounter) Add(ctx context.Context, key string, members ...string) error {
+	return c.AddAt(ctx, key, time.Now(), members...)
+}
+
+// AddAt
+// @Description: 指定时间的所有粒度记录成员
+// @receiver c
+// @param ctx
+// @param key
+// @param t
+// @param members
+// @return error
+func (c *UniqueCounter) AddAt(ctx context.Context, key string, t time.Time, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	args := make([]any, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	_, err := R().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, g := range c.opts.Granularities {
+			bs, err := buckets(PrefixUnique, c.name, key, c.opts, g, t, t)
+			if err != nil {
+				return err
+			}
+			pipe.PFAdd(ctx, bs[0].key, args...)
+			pipe.ExpireAt(ctx, bs[0].key, bs[0].expire)
+		}
+		return nil
+	})
+	return err
+}
+
+// Count
+// @Description: t所在桶的去重数
+// @receiver c
+// @param ctx
+// @param key
+// @param g
+// @param t
+// @return int64
+// @return error
+func (c *UniqueCounter) Count(ctx context.Context, key string, g Granularity, t time.Time) (int64, error) {
+	return c.CountRange(ctx, key, g, t, t)
+}
+
+// CountRange
+// @Description: from到to(含)之间合并后的去重数，同一成员在多个桶中只计一次
+// @receiver c
+// @param ctx
+// @param key
+// @param g
+// @param from
+// @param to
+// @return int64
+// @return error
+func (c *UniqueCounter) CountRange(ctx context.Context, key string, g Granularity, from, to time.Time) (int64, error) {
+	bs, err := buckets(PrefixUnique, c.name, key, c.opts, g, from, to)
+	if err != nil || len(bs) == 0 {
+		return 0, err
+	}
+	keys := make([]string, len(bs))
+	for i, b := range bs {
+		keys[i] = b.key
+	}
+	return R().PFCount(ctx, keys...).Result()
+}
+
+// Merge
+// @Description: 将from到to(含)的桶合并保存到dest，用于缓存月活等大范围的结果
+// @receiver c
+// @param ctx
+// @param key
+// @param g
+// @param from
+// @param to
+// @param dest 合并结果的名称，保存为 uv:{name:key}:merge:{dest}
+// @param exp 合并结果的有效期
+// @return int64 合并后的去重数
+// @return error
+func (c *UniqueCounter) Merge(ctx context.Context, key string, g Granularity, from, to time.Time, dest string, exp time.Duration) (int64, error) {
+	bs, err := buckets(PrefixUnique, c.name, key, c.opts, g, from, to)
+	if err != nil {
+		return 0, err
+	}
+	keys := make([]string, len(bs))
+	for i, b := range bs {
+		keys[i] = b.key
+	}
+	dst := counterKey(PrefixUnique, c.name, key, "merge", dest)
+	var count *redis.IntCmd
+	_, err = R().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, dst)
+		pipe.PFMerge(ctx, dst, keys...)
+		if exp > 0 {
+			pipe.Expire(ctx, dst, exp)
+		}
+		count = pipe.PFCount(ctx, dst)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// Merged
+// @Description: 读取Merge保存的结果
+// @receiver c
+// @param ctx
+// @param key
+// @param dest
+// @return int64 不存在时为0
+// @return error
+func (c *UniqueCounter) Merged(ctx context.Context, key, dest string) (int64, error) {
+	return R().PFCount(ctx, counterKey(PrefixUnique, c.name, key, "merge", dest)).Result()
+}
+
+// Reset
+// @Description: 删除t所在的各粒度桶
+// @receiver c
+// @param ctx
+// @param key
+// @param t
+// @return error
+func (c *UniqueCounter) Reset(ctx context.Context, key string, t time.Time) error {
+	return resetBuckets(ctx, PrefixUnique, c.name, key, c.opts, t)
+}
@@ -2,13 +2,29 @@ package zch
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"github.com/zohu/zgin/zmap"
-	"github.com/zohu/zgin/zutil"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/zohu/zgin/zmap"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+	"golang.org/x/sync/singleflight"
 )
 
+/**
+ * 字典
+ *  - NewDict: 按前缀查询，每个key单独缓存，适合数据量大、只按code翻译的字典；DictBloom可拦截一定不存在的编码
+ *  - NewDictLoader: 整个字典一次加载，以 dict:{name}:@all 缓存在L2，dict:{name}:@version 为内容哈希
+ *    每次读取先比较版本，版本未变时使用本地解析好的DictSet；支持列表、树、反查
+ * DictInvalidate删除缓存，L2的失效广播会让其他实例在下次读取时重新加载
+ */
+
 type DictName string
 
 func (p DictName) String() string {
@@ -23,12 +39,39 @@ func (p DictName) Key(args ...string) string {
 }
 
 type DictQuery func(ctx context.Context, prefix string) map[string]string
+type DictLoader func(ctx context.Context) ([]DictItem, error)
 type options struct {
 	query  DictQuery
+	loader DictLoader
 	expire time.Duration
+	bloom  *Bloom
+}
+
+type DictItem struct {
+	Code   string `json:"code" note:"编码"`
+	Label  string `json:"label" note:"名称"`
+	Parent string `json:"parent,omitempty" note:"上级编码，树形字典使用"`
+	Sort   int    `json:"sort,omitempty" note:"排序，升序"`
+	Extra  string `json:"extra,omitempty" note:"附加数据，一般为json"`
+}
+
+type DictSet struct {
+	Name    DictName   `json:"name"`
+	Version string     `json:"version"`
+	Items   []DictItem `json:"items"`
+
+	byCode   map[string]int
+	byLabel  map[string]int
+	children map[string][]int
 }
 
 var ds = zmap.NewStringer[DictName, *options]()
+var dictSets = zmap.NewStringer[DictName, *DictSet]()
+var dictGroup singleflight.Group
+var dictWatchers struct {
+	sync.RWMutex
+	fns []func(name DictName, version string)
+}
 
 func NewDict(name DictName, query DictQuery, expire ...time.Duration) {
 	expire = append(expire, time.Hour*24)
@@ -37,14 +80,43 @@ func NewDict(name DictName, query DictQuery, expire ...time.Duration) {
 		expire: zutil.FirstTruth(expire...),
 	})
 }
+
+// NewDictLoader
+// @Description: 注册全量加载的字典
+// @param name
+// @param loader 返回字典全部条目
+// @param expire 缓存时长，过期后重新加载，默认24h
+func NewDictLoader(name DictName, loader DictLoader, expire ...time.Duration) {
+	expire = append(expire, time.Hour*24)
+	ds.Set(name, &options{
+		loader: loader,
+		expire: zutil.FirstTruth(expire...),
+	})
+}
+
 func Dict(ctx context.Context, name DictName, key string) (string, error) {
 	if key == "" {
 		return "", fmt.Errorf("key is empty")
 	}
+	if opt, ok := ds.Get(name); ok && opt.loader != nil {
+		set, err := DictAll(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		if item, ok := set.Get(key); ok {
+			return item.Label, nil
+		}
+		return "", fmt.Errorf("not found: %s", key)
+	}
 	if v, err := L().Get(ctx, name.Key(key)); err == nil {
 		return v, nil
 	}
 	if opt, ok := ds.Get(name); ok {
+		if opt.bloom != nil {
+			if ok, err := opt.bloom.Test(ctx, key); !ok && err == nil {
+				return "", fmt.Errorf("not found: %s", key)
+			}
+		}
 		resp := opt.query(ctx, dictPrefix(key))
 		for k, v := range resp {
 			_ = L().Set(ctx, name.Key(k), v, opt.expire)
@@ -58,6 +130,206 @@ func Dict(ctx context.Context, name DictName, key string) (string, error) {
 	return "", fmt.Errorf("not found: %s", key)
 }
 
+// DictBloom
+// @Description: 为NewDict注册的字典配置布隆过滤器，用全部编码构建，一定不存在的编码不再查询
+// @param ctx
+// @param name
+// @param b
+// @return error
+func DictBloom(ctx context.Context, name DictName, b *Bloom) error {
+	opt, ok := ds.Get(name)
+	if !ok || opt.query == nil {
+		return fmt.Errorf("dict not found: %s, please call zch.NewDict", name)
+	}
+	err := b.Rebuild(ctx, func(ctx context.Context, add func(items ...string) error) error {
+		items := opt.query(ctx, "")
+		codes := make([]string, 0, len(items))
+		for k := range items {
+			codes = append(codes, k)
+		}
+		return add(codes...)
+	})
+	if err != nil {
+		return fmt.Errorf("dict %s bloom err: %w", name, err)
+	}
+	o := *opt
+	o.bloom = b
+	ds.Set(name, &o)
+	return nil
+}
+
+// DictReverse
+// @Description: 按名称反查编码，只支持NewDictLoader注册的字典
+// @param ctx
+// @param name
+// @param label
+// @return string
+// @return error
+func DictReverse(ctx context.Context, name DictName, label string) (string, error) {
+	set, err := DictAll(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if code, ok := set.Code(label); ok {
+		return code, nil
+	}
+	return "", fmt.Errorf("not found: %s", label)
+}
+
+// DictAll
+// @Description: 获取整个字典，只支持NewDictLoader注册的字典
+// @param ctx
+// @param name
+// @return *DictSet 共享的只读数据，不要修改
+// @return error
+func DictAll(ctx context.Context, name DictName) (*DictSet, error) {
+	opt, ok := ds.Get(name)
+	if !ok || opt.loader == nil {
+		return nil, fmt.Errorf("dict not found: %s, please call zch.NewDictLoader", name)
+	}
+	if version, err := L().Get(ctx, name.Key("@version")); err == nil {
+		if set, ok := dictSets.Get(name); ok && set.Version == version {
+			return set, nil
+		}
+	}
+	v, err, _ := dictGroup.Do(string(name), func() (any, error) {
+		return loadDictSet(ctx, name, opt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*DictSet), nil
+}
+
+func loadDictSet(ctx context.Context, name DictName, opt *options) (*DictSet, error) {
+	var set *DictSet
+	if data, err := L().Get(ctx, name.Key("@all")); err == nil {
+		if err = sonic.UnmarshalString(data, &set); err != nil {
+			zlog.Warnf("dict %s decode err: %v", name, err)
+			set = nil
+		}
+	}
+	if set == nil {
+		items, err := opt.loader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("dict %s load err: %w", name, err)
+		}
+		set = &DictSet{Name: name, Items: items}
+		sort.SliceStable(set.Items, func(i, j int) bool {
+			return set.Items[i].Sort < set.Items[j].Sort
+		})
+		data, _ := sonic.MarshalString(set.Items)
+		sum := sha256.Sum256([]byte(data))
+		set.Version = hex.EncodeToString(sum[:8])
+		data, _ = sonic.MarshalString(set)
+		// 先写数据再写版本，读到新版本时一定能读到对应的数据
+		if err = L().Set(ctx, name.Key("@all"), data, opt.expire); err == nil {
+			err = L().Set(ctx, name.Key("@version"), set.Version, opt.expire)
+		}
+		if err != nil {
+			zlog.Warnf("dict %s cache err: %v", name, err)
+		}
+	}
+	set.index()
+	old, ok := dictSets.Get(name)
+	dictSets.Set(name, set)
+	if ok && old.Version != set.Version {
+		dictWatchers.RLock()
+		defer dictWatchers.RUnlock()
+		for _, fn := range dictWatchers.fns {
+			fn(name, set.Version)
+		}
+	}
+	return set, nil
+}
+
+// DictInvalidate
+// @Description: 删除字典缓存，所有实例在下次读取时重新加载
+// @param ctx
+// @param name
+// @return error
+func DictInvalidate(ctx context.Context, name DictName) error {
+	dictSets.Remove(name)
+	keys := []string{name.Key("@version"), name.Key("@all")}
+	if opt, ok := ds.Get(name); ok && opt.query != nil {
+		iter := R().Scan(ctx, 0, name.Key("*"), 1000).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return L().Del(ctx, keys...)
+}
+
+// DictWatch
+// @Description: 本实例加载到新版本字典时回调，首次加载不回调
+// @param fn
+func DictWatch(fn func(name DictName, version string)) {
+	dictWatchers.Lock()
+	defer dictWatchers.Unlock()
+	dictWatchers.fns = append(dictWatchers.fns, fn)
+}
+
+func (s *DictSet) index() {
+	s.byCode = make(map[string]int, len(s.Items))
+	s.byLabel = make(map[string]int, len(s.Items))
+	s.children = make(map[string][]int)
+	for i, item := range s.Items {
+		s.byCode[item.Code] = i
+		if _, ok := s.byLabel[item.Label]; !ok {
+			s.byLabel[item.Label] = i
+		}
+	}
+	for i, item := range s.Items {
+		p := item.Parent
+		if _, ok := s.byCode[p]; !ok || p == item.Code {
+			p = ""
+		}
+		s.children[p] = append(s.children[p], i)
+	}
+}
+
+// Get
+// @Description: 按编码查找
+// @receiver s
+// @param code
+// @return DictItem
+// @return bool
+func (s *DictSet) Get(code string) (DictItem, bool) {
+	if i, ok := s.byCode[code]; ok {
+		return s.Items[i], true
+	}
+	return DictItem{}, false
+}
+
+// Code
+// @Description: 按名称反查编码，名称重复时返回排序靠前的
+// @receiver s
+// @param label
+// @return string
+// @return bool
+func (s *DictSet) Code(label string) (string, bool) {
+	if i, ok := s.byLabel[label]; ok {
+		return s.Items[i].Code, true
+	}
+	return "", false
+}
+
+// Children
+// @Description: 下级条目，parent为空时返回顶级条目(上级不存在的也视为顶级)
+// @receiver s
+// @param parent
+// @return []DictItem
+func (s *DictSet) Children(parent string) []DictItem {
+	res := make([]DictItem, 0, len(s.children[parent]))
+	for _, i := range s.children[parent] {
+		res = append(res, s.Items[i])
+	}
+	return res
+}
+
 func dictPrefix(key string) string {
 	arr := []rune(key)
 	if len(arr) <= 2 {
@@ -70,3 +342,41 @@ func dictPrefix(key string) string {
 		return string(arr[:len(arr)/3*2])
 	}
 }
+
+// DictWarmup
+// @Description: 预热热点字典到缓存，可在启动时异步调用
+// @param ctx
+// @param hot 字典及其热点key，key为空时加载整个字典
+func DictWarmup(ctx context.Context, hot map[DictName][]string) {
+	total := 0
+	for name, keys := range hot {
+		opt, ok := ds.Get(name)
+		if !ok {
+			zlog.Warnf("dict warmup skipped, dict not found: %s", name)
+			continue
+		}
+		if opt.loader != nil {
+			set, err := DictAll(ctx, name)
+			if err != nil {
+				zlog.Warnf("dict warmup %s err: %v", name, err)
+				continue
+			}
+			total += len(set.Items)
+			continue
+		}
+		if len(keys) == 0 {
+			for k, v := range opt.query(ctx, "") {
+				if L().Set(ctx, name.Key(k), v, opt.expire) == nil {
+					total++
+				}
+			}
+			continue
+		}
+		for _, k := range keys {
+			if _, err := Dict(ctx, name, k); err == nil {
+				total++
+			}
+		}
+	}
+	zlog.Infof("dict warmup %d entries", total)
+}
@@ -1,8 +1,12 @@
 package zch
 
 import (
-	"github.com/zohu/zgin/zutil"
+	"context"
+	"slices"
+	"sync/atomic"
 	"testing"
+
+	"github.com/zohu/zgin/zutil"
 )
 
 func TestDict(t *testing.T) {
@@ -11,3 +15,79 @@ func TestDict(t *testing.T) {
 		t.Logf("%d => %d", len(k), len(dictPrefix(k)))
 	}
 }
+
+func TestDictLoader(t *testing.T) {
+	testRedis(t)
+	ctx := context.Background()
+	var loads atomic.Int32
+	label := "北京"
+	NewDictLoader("region", func(ctx context.Context) ([]DictItem, error) {
+		loads.Add(1)
+		return []DictItem{
+			{Code: "110100", Label: "市辖区", Parent: "110000", Sort: 2},
+			{Code: "110000", Label: label, Sort: 1},
+			{Code: "120000", Label: "天津", Sort: 3},
+			{Code: "110101", Label: "东城区", Parent: "110100"},
+		}, nil
+	})
+	var changed atomic.Value
+	DictWatch(func(name DictName, version string) {
+		changed.Store(version)
+	})
+
+	set, err := DictAll(ctx, "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Items[0].Code != "110101" || set.Items[1].Code != "110000" {
+		t.Fatalf("not sorted: %+v", set.Items)
+	}
+	if v, err := Dict(ctx, "region", "120000"); err != nil || v != "天津" {
+		t.Fatalf("dict: %v %s", err, v)
+	}
+	if code, err := DictReverse(ctx, "region", "北京"); err != nil || code != "110000" {
+		t.Fatalf("reverse: %v %s", err, code)
+	}
+	var roots []string
+	for _, item := range set.Children("") {
+		roots = append(roots, item.Code)
+	}
+	if !slices.Equal(roots, []string{"110000", "120000"}) {
+		t.Fatalf("roots: %v", roots)
+	}
+	if c := set.Children("110100"); len(c) != 1 || c[0].Code != "110101" {
+		t.Fatalf("children: %+v", c)
+	}
+	if again, _ := DictAll(ctx, "region"); again != set || loads.Load() != 1 {
+		t.Fatalf("not cached, loads=%d", loads.Load())
+	}
+
+	// 其他实例只读到缓存，不调用loader
+	dictSets.Remove("region")
+	if again, _ := DictAll(ctx, "region"); again.Version != set.Version || loads.Load() != 1 {
+		t.Fatalf("snapshot not shared, loads=%d", loads.Load())
+	}
+
+	label = "北京市"
+	if err := DictInvalidate(ctx, "region"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DictAll(ctx, "region"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := Dict(ctx, "region", "110000"); v != "北京市" || loads.Load() != 2 {
+		t.Fatalf("not reloaded: %s loads=%d", v, loads.Load())
+	}
+
+	// 版本变化时通知
+	label = "Beijing"
+	_ = L().Del(ctx, DictName("region").Key("@version"), DictName("region").Key("@all"))
+	next, _ := DictAll(ctx, "region")
+	if v, _ := changed.Load().(string); v != next.Version {
+		t.Fatalf("watch not called: %s %s", v, next.Version)
+	}
+
+	if _, err := DictAll(ctx, "missing"); err == nil {
+		t.Fatal("expected error for unregistered dict")
+	}
+}
@@ -0,0 +1,260 @@
+package zch
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zutil"
+)
+
+/**
+ * 排行榜，基于有序集合 rank:{name}
+ * 默认分数越高排名越靠前，同分时按redis有序集合的顺序：降序榜按成员逆字典序，升序榜按字典序；Rank从1开始
+ * RankPage的字段与zgin.RespListBean一致，可直接转换: (*zgin.RespListBean[zch.Rank])(page)
+ */
+
+type LeaderboardOptions struct {
+	Ascending bool          // 分数越低排名越靠前，如用时榜
+	MaxSize   int64         // 只保留排名前MaxSize的成员，0不限制
+	TTL       time.Duration // 每次写入后续期，0不过期
+}
+
+type Leaderboard struct {
+	key  string
+	opts *LeaderboardOptions
+}
+
+type Rank struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+	Rank   int64   `json:"rank"`
+}
+
+type RankPage struct {
+	Page  int    `json:"page" xml:"page"`
+	Size  int    `json:"size" xml:"size"`
+	Total int64  `json:"total" xml:"total"`
+	List  []Rank `json:"list" xml:"list"`
+}
+
+// NewLeaderboard
+// @Description: 排行榜
+// @param name
+// @param opts
+// @return *Leaderboard
+func NewLeaderboard(name string, opts ...*LeaderboardOptions) *Leaderboard {
+	opt := zutil.FirstTruth(append(opts, &LeaderboardOptions{})...)
+	return &Leaderboard{
+		key:  PrefixRank.Key(name),
+		opts: opt,
+	}
+}
+
+// Add
+// @Description: 设置成员分数
+// @receiver l
+// @param ctx
+// @param member
+// @param score
+// @return error
+func (l *Leaderboard) Add(ctx context.Context, member string, score float64) error {
+	return l.write(ctx, func(pipe redis.Pipeliner) {
+		pipe.ZAdd(ctx, l.key, redis.Z{Score: score, Member: member})
+	})
+}
+
+// Best
+// @Description: 只在分数更好时更新，用于保留历史最佳成绩
+// @receiver l
+// @param ctx
+// @param member
+// @param score
+// @return error
+func (l *Leaderboard) Best(ctx context.Context, member string, score float64) error {
+	return l.write(ctx, func(pipe redis.Pipeliner) {
+		pipe.ZAddArgs(ctx, l.key, redis.ZAddArgs{
+			GT:      !l.opts.Ascending,
+			LT:      l.opts.Ascending,
+			Members: []redis.Z{{Score: score, Member: member}},
+		})
+	})
+}
+
+// Incr
+// @Description: 累加成员分数
+// @receiver l
+// @param ctx
+// @param member
+// @param delta
+// @return float64 累加后的分数
+// @return error
+func (l *Leaderboard) Incr(ctx context.Context, member string, delta float64) (float64, error) {
+	var cmd *redis.FloatCmd
+	err := l.write(ctx, func(pipe redis.Pipeliner) {
+		cmd = pipe.ZIncrBy(ctx, l.key, delta, member)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return cmd.Val(), nil
+}
+
+func (l *Leaderboard) write(ctx context.Context, fn func(pipe redis.Pipeliner)) error {
+	_, err := R().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		fn(pipe)
+		if l.opts.MaxSize > 0 {
+			if l.opts.Ascending {
+				pipe.ZRemRangeByRank(ctx, l.key, l.opts.MaxSize, -1)
+			} else {
+				pipe.ZRemRangeByRank(ctx, l.key, 0, -l.opts.MaxSize-1)
+			}
+		}
+		if l.opts.TTL > 0 {
+			pipe.Expire(ctx, l.key, l.opts.TTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// Remove
+// @Description: 移除成员
+// @receiver l
+// @param ctx
+// @param members
+// @return error
+func (l *Leaderboard) Remove(ctx context.Context, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	args := make([]any, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return R().ZRem(ctx, l.key, args...).Err()
+}
+
+// Rank
+// @Description: 成员的排名
+// @receiver l
+// @param ctx
+// @param member
+// @return *Rank
+// @return error 不在榜上时返回ErrNotFound
+func (l *Leaderboard) Rank(ctx context.Context, member string) (*Rank, error) {
+	var rank *redis.IntCmd
+	var score *redis.FloatCmd
+	_, err := R().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if l.opts.Ascending {
+			rank = pipe.ZRank(ctx, l.key, member)
+		} else {
+			rank = pipe.ZRevRank(ctx, l.key, member)
+		}
+		score = pipe.ZScore(ctx, l.key, member)
+		return nil
+	})
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &Rank{Member: member, Score: score.Val(), Rank: rank.Val() + 1}, nil
+}
+
+// Top
+// @Description: 前n名
+// @receiver l
+// @param ctx
+// @param n
+// @return []Rank
+// @return error
+func (l *Leaderboard) Top(ctx context.Context, n int64) ([]Rank, error) {
+	if n <= 0 {
+		return []Rank{}, nil
+	}
+	return l.rang(ctx, 0, n-1)
+}
+
+// Around
+// @Description: 成员及其前后各n名，用于展示"我的排名"
+// @receiver l
+// @param ctx
+// @param member
+// @param n
+// @return []Rank
+// @return error 不在榜上时返回ErrNotFound
+func (l *Leaderboard) Around(ctx context.Context, member string, n int64) ([]Rank, error) {
+	r, err := l.Rank(ctx, member)
+	if err != nil {
+		return nil, err
+	}
+	i := r.Rank - 1
+	return l.rang(ctx, max(0, i-n), i+n)
+}
+
+// Page
+// @Description: 分页查询，page从1开始，size默认50最大1000，与zgin.Pages一致
+// @receiver l
+// @param ctx
+// @param page
+// @param size
+// @return *RankPage
+// @return error
+func (l *Leaderboard) Page(ctx context.Context, page, size int) (*RankPage, error) {
+	page = max(page, 1)
+	if size <= 0 {
+		size = 50
+	}
+	size = min(size, 1000)
+	total, err := l.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &RankPage{Page: page, Size: size, Total: total, List: []Rank{}}
+	start := int64((page - 1) * size)
+	if start >= total {
+		return res, nil
+	}
+	if res.List, err = l.rang(ctx, start, start+int64(size)-1); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Count
+// @Description: 榜上成员数
+// @receiver l
+// @param ctx
+// @return int64
+// @return error
+func (l *Leaderboard) Count(ctx context.Context) (int64, error) {
+	return R().ZCard(ctx, l.key).Result()
+}
+
+// Reset
+// @Description: 清空排行榜
+// @receiver l
+// @param ctx
+// @return error
+func (l *Leaderboard) Reset(ctx context.Context) error {
+	return R().Del(ctx, l.key).Err()
+}
+
+func (l *Leaderboard) rang(ctx context.Context, start, stop int64) ([]Rank, error) {
+	zs, err := R().ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
+		Key:   l.key,
+		Start: start,
+		Stop:  stop,
+		Rev:   !l.opts.Ascending,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Rank, len(zs))
+	for i, z := range zs {
+		res[i] = Rank{Member: z.Member.(string), Score: z.Score, Rank: start + int64(i) + 1}
+	}
+	return res, nil
+}
@@ -0,0 +1,76 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLeaderboard(t *testing.T) {
+	testRedis(t)
+	ctx := context.Background()
+	l := NewLeaderboard("score", &LeaderboardOptions{MaxSize: 5})
+	for i, m := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := l.Add(ctx, m, float64(i*10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := l.Count(ctx); n != 5 {
+		t.Fatalf("max size: %d", n)
+	}
+	if _, err := l.Rank(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a should be trimmed: %v", err)
+	}
+	if r, err := l.Rank(ctx, "f"); err != nil || r.Rank != 1 || r.Score != 50 {
+		t.Fatalf("rank f: %+v %v", r, err)
+	}
+	if err := l.Best(ctx, "f", 1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := l.Incr(ctx, "b", 100); v != 110 {
+		t.Fatalf("incr: %v", v)
+	}
+	top, _ := l.Top(ctx, 2)
+	if len(top) != 2 || top[0].Member != "b" || top[1].Member != "f" || top[1].Score != 50 {
+		t.Fatalf("top: %+v", top)
+	}
+	around, _ := l.Around(ctx, "e", 1)
+	if len(around) != 3 || around[0].Member != "f" || around[1].Rank != 3 || around[2].Member != "d" {
+		t.Fatalf("around: %+v", around)
+	}
+	page, _ := l.Page(ctx, 2, 2)
+	if page.Total != 5 || len(page.List) != 2 || page.List[0].Rank != 3 || page.List[0].Member != "e" {
+		t.Fatalf("page: %+v", page)
+	}
+	if page, _ = l.Page(ctx, 4, 2); len(page.List) != 0 || page.List == nil {
+		t.Fatalf("empty page: %+v", page)
+	}
+
+	asc := NewLeaderboard("time", &LeaderboardOptions{Ascending: true})
+	_ = asc.Add(ctx, "x", 30)
+	_ = asc.Add(ctx, "y", 20)
+	_ = asc.Best(ctx, "x", 40)
+	_ = asc.Best(ctx, "y", 10)
+	top, _ = asc.Top(ctx, 10)
+	if len(top) != 2 || top[0].Member != "y" || top[0].Score != 10 || top[1].Score != 30 {
+		t.Fatalf("ascending: %+v", top)
+	}
+	if err := asc.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := asc.Count(ctx); n != 0 {
+		t.Fatalf("reset: %d", n)
+	}
+
+	// nil选项使用默认值，同分时降序榜按成员逆字典序
+	tie := NewLeaderboard("tie", nil)
+	_ = tie.Add(ctx, "m", 1)
+	_ = tie.Add(ctx, "n", 1)
+	top, _ = tie.Top(ctx, 2)
+	if len(top) != 2 || top[0].Member != "n" || top[1].Member != "m" {
+		t.Fatalf("tie: %+v", top)
+	}
+	if r, _ := tie.Rank(ctx, "n"); r == nil || r.Rank != 1 {
+		t.Fatalf("tie rank: %+v", r)
+	}
+}
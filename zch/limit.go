@@ -0,0 +1,283 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * 限流，key为 limit:{name}:{key}，时间统一使用redis服务端时间(微秒)
+ *  - LimitSlidingLog: 记录每次请求的时间，精确但占用与Limit成正比，适合验证码等低频场景
+ *  - LimitSlidingWindow: 当前窗口和上一窗口按时间加权估算，占用固定
+ *  - LimitTokenBucket: 令牌桶，按Limit/Period补充，最多Burst个
+ *  - LimitGCRA: 通用信元速率算法，效果同令牌桶，只需保存一个时间戳
+ * redis不可用时退回到进程内限流，此时额度按实例计算
+ */
+
+type LimitAlgorithm string
+
+const (
+	LimitSlidingLog    LimitAlgorithm = "sliding_log"
+	LimitSlidingWindow LimitAlgorithm = "sliding_window"
+	LimitTokenBucket   LimitAlgorithm = "token_bucket"
+	LimitGCRA          LimitAlgorithm = "gcra"
+)
+
+type LimitOptions struct {
+	Algorithm       LimitAlgorithm // 默认LimitGCRA
+	Limit           int64          // 每个Period允许的次数
+	Period          time.Duration  // 默认1s
+	Burst           int64          // 令牌桶和GCRA允许的突发，默认等于Limit
+	DisableFallback bool           // redis出错时直接返回错误，不退回进程内限流
+}
+
+func (o *LimitOptions) Validate() error {
+	o.Algorithm = zutil.FirstTruth(o.Algorithm, LimitGCRA)
+	o.Period = zutil.FirstTruth(o.Period, time.Second)
+	o.Burst = zutil.FirstTruth(o.Burst, o.Limit)
+	if o.Limit <= 0 {
+		return errors.New("limit must be greater than 0")
+	}
+	switch o.Algorithm {
+	case LimitSlidingLog, LimitSlidingWindow, LimitTokenBucket, LimitGCRA:
+		return nil
+	default:
+		return fmt.Errorf("unknown limit algorithm %s", o.Algorithm)
+	}
+}
+
+// capacity
+// @Description: 单次最多可获取的数量
+// @receiver o
+// @return int64
+func (o *LimitOptions) capacity() int64 {
+	switch o.Algorithm {
+	case LimitTokenBucket, LimitGCRA:
+		return o.Burst
+	default:
+		return o.Limit
+	}
+}
+
+type LimitResult struct {
+	Allowed    bool          `json:"allowed" note:"是否放行"`
+	Limit      int64         `json:"limit" note:"额度上限"`
+	Remaining  int64         `json:"remaining" note:"剩余额度"`
+	RetryAfter time.Duration `json:"retry_after" note:"被拒绝时多久后可重试，-1表示请求数超过上限永远不会成功"`
+	ResetAfter time.Duration `json:"reset_after" note:"多久后额度完全恢复"`
+}
+
+type limitBackend interface {
+	take(ctx context.Context, key string, opts *LimitOptions, n int64) (*LimitResult, error)
+	reset(ctx context.Context, key string) error
+}
+
+type Limiter struct {
+	name     string
+	opts     *LimitOptions
+	backend  limitBackend
+	fallback limitBackend
+}
+
+// NewLimiter
+// @Description: 基于redis的限流器，集群共享额度
+// @param name
+// @param opts
+// @return *Limiter
+// @return error
+func NewLimiter(name string, opts *LimitOptions) (*Limiter, error) {
+	l, err := newLimiter(name, opts, redisLimits{})
+	if err != nil {
+		return nil, err
+	}
+	if !l.opts.DisableFallback {
+		l.fallback = newMemoryLimits()
+	}
+	return l, nil
+}
+
+// NewMemoryLimiter
+// @Description: 进程内的限流器，语义与NewLimiter一致
+// @param name
+// @param opts
+// @return *Limiter
+// @return error
+func NewMemoryLimiter(name string, opts *LimitOptions) (*Limiter, error) {
+	return newLimiter(name, opts, newMemoryLimits())
+}
+
+func newLimiter(name string, opts *LimitOptions, backend limitBackend) (*Limiter, error) {
+	o := *zutil.FirstTruth(opts, &LimitOptions{})
+	if err := o.Validate(); err != nil {
+		return nil, fmt.Errorf("limiter %s: %w", name, err)
+	}
+	return &Limiter{
+		name:    name,
+		opts:    &o,
+		backend: backend,
+	}, nil
+}
+
+// Allow
+// @Description: 获取1个额度
+// @receiver l
+// @param ctx
+// @param key 限流维度，如ip、用户ID、手机号
+// @return *LimitResult
+// @return error
+func (l *Limiter) Allow(ctx context.Context, key string) (*LimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN
+// @Description: 获取n个额度，不足时不扣减
+// @receiver l
+// @param ctx
+// @param key
+// @param n
+// @return *LimitResult
+// @return error
+func (l *Limiter) AllowN(ctx context.Context, key string, n int64) (*LimitResult, error) {
+	if n > l.opts.capacity() {
+		return &LimitResult{Limit: l.opts.capacity(), RetryAfter: -1}, nil
+	}
+	k := PrefixLimit.Key(l.name, key)
+	res, err := l.backend.take(ctx, k, l.opts, n)
+	if err != nil && l.fallback != nil {
+		zlog.Warnf("limiter %s fallback to memory: %v", l.name, err)
+		return l.fallback.take(ctx, k, l.opts, n)
+	}
+	return res, err
+}
+
+// Reset
+// @Description: 清空key的限流记录
+// @receiver l
+// @param ctx
+// @param key
+// @return error
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	k := PrefixLimit.Key(l.name, key)
+	if l.fallback != nil {
+		_ = l.fallback.reset(ctx, k)
+	}
+	return l.backend.reset(ctx, k)
+}
+
+/**
+ * redis，脚本返回 {allowed, remaining, retry_us, reset_us}
+ */
+
+const limitNow = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local limit, burst, period, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+`
+
+var limitScripts = map[LimitAlgorithm]*redis.Script{
+	LimitSlidingLog: redis.NewScript(limitNow + `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - period)
+local count = redis.call('ZCARD', KEYS[1])
+if count + n <= limit then
+	for i = 1, n do
+		redis.call('ZADD', KEYS[1], now, now .. ':' .. (count + i))
+	end
+	redis.call('PEXPIRE', KEYS[1], math.ceil(period / 1000))
+	return {1, limit - count - n, 0, period}
+end
+local first = redis.call('ZRANGE', KEYS[1], count + n - limit - 1, count + n - limit - 1, 'WITHSCORES')
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+return {0, limit - count, tonumber(first[2]) + period - now, tonumber(last[2]) + period - now}
+`),
+	LimitSlidingWindow: redis.NewScript(limitNow + `
+local window = math.floor(now / period)
+local elapsed = now - window * period
+local s = redis.call('HMGET', KEYS[1], 'w', 'c', 'p')
+local w, c, p = tonumber(s[1]) or window, tonumber(s[2]) or 0, tonumber(s[3]) or 0
+if w == window - 1 then
+	p, c = c, 0
+elseif w < window - 1 then
+	p, c = 0, 0
+end
+local est = p * (period - elapsed) / period + c
+local reset = 0
+if c > 0 then
+	reset = period * 2 - elapsed
+elseif p > 0 then
+	reset = period - elapsed
+end
+if est + n <= limit then
+	c = c + n
+	redis.call('HSET', KEYS[1], 'w', window, 'c', c, 'p', p)
+	redis.call('PEXPIRE', KEYS[1], math.ceil(period * 2 / 1000))
+	return {1, math.floor(limit - est - n), 0, period * 2 - elapsed}
+end
+local retry
+if p > 0 and c + n <= limit then
+	retry = (est + n - limit) * period / p
+else
+	retry = period - elapsed + math.max(0, period * (1 - (limit - n) / c))
+end
+return {0, math.max(0, math.floor(limit - est)), math.ceil(retry), reset}
+`),
+	LimitTokenBucket: redis.NewScript(limitNow + `
+local rate = limit / period
+local s = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens, ts = tonumber(s[1]) or burst, tonumber(s[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed, retry = 0, 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 't', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate / 1000) + 1)
+return {allowed, math.floor(tokens), retry, math.ceil((burst - tokens) / rate)}
+`),
+	LimitGCRA: redis.NewScript(limitNow + `
+local interval = period / limit
+local tau = interval * burst
+local tat = math.max(tonumber(redis.call('GET', KEYS[1]) or now), now)
+local new = tat + n * interval
+if now < new - tau then
+	return {0, math.max(0, math.floor((now - tat + tau) / interval)), math.ceil(new - tau - now), math.ceil(tat - now)}
+end
+redis.call('SET', KEYS[1], new, 'PX', math.ceil((new - now) / 1000) + 1)
+return {1, math.floor((now - new + tau) / interval), 0, math.ceil(new - now)}
+`),
+}
+
+type redisLimits struct{}
+
+func (redisLimits) take(ctx context.Context, key string, opts *LimitOptions, n int64) (*LimitResult, error) {
+	res, err := limitScripts[opts.Algorithm].Run(ctx, R(), []string{key},
+		opts.Limit, opts.Burst, opts.Period.Microseconds(), n).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 4 {
+		return nil, fmt.Errorf("unexpected limit script result: %v", res)
+	}
+	return limitResult(opts, res[0] == 1, res[1], res[2], res[3]), nil
+}
+func (redisLimits) reset(ctx context.Context, key string) error {
+	return R().Del(ctx, key).Err()
+}
+
+func limitResult(opts *LimitOptions, allowed bool, remaining, retry, reset int64) *LimitResult {
+	return &LimitResult{
+		Allowed:    allowed,
+		Limit:      opts.capacity(),
+		Remaining:  max(remaining, 0),
+		RetryAfter: time.Duration(max(retry, 0)) * time.Microsecond,
+		ResetAfter: time.Duration(max(reset, 0)) * time.Microsecond,
+	}
+}
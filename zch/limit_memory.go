@@ -0,0 +1,169 @@
+package zch
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+ * 进程内的限流实现，算法与redis脚本一一对应
+ */
+
+type limitState struct {
+	expire int64 // 微秒
+
+	log []int64 // sliding log
+
+	w, c, p int64 // sliding window
+
+	tokens float64 // token bucket
+	ts     int64
+
+	tat float64 // gcra
+}
+
+type memoryLimitTable struct {
+	mu     sync.Mutex
+	states map[string]*limitState
+	swept  int64
+	now    func() int64
+}
+
+func newMemoryLimits() *memoryLimitTable {
+	return &memoryLimitTable{
+		states: make(map[string]*limitState),
+		now: func() int64 {
+			return time.Now().UnixMicro()
+		},
+	}
+}
+
+func (t *memoryLimitTable) take(_ context.Context, key string, opts *LimitOptions, n int64) (*LimitResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now)
+	s, ok := t.states[key]
+	if !ok || s.expire <= now {
+		s = &limitState{}
+		t.states[key] = s
+	}
+	var allowed bool
+	var remaining, retry, reset int64
+	period := opts.Period.Microseconds()
+	switch opts.Algorithm {
+	case LimitSlidingLog:
+		allowed, remaining, retry, reset = s.slidingLog(now, opts.Limit, period, n)
+	case LimitSlidingWindow:
+		allowed, remaining, retry, reset = s.slidingWindow(now, opts.Limit, period, n)
+	case LimitTokenBucket:
+		allowed, remaining, retry, reset = s.tokenBucket(now, opts.Limit, opts.Burst, period, n)
+	case LimitGCRA:
+		allowed, remaining, retry, reset = s.gcra(now, opts.Limit, opts.Burst, period, n)
+	}
+	return limitResult(opts, allowed, remaining, retry, reset), nil
+}
+
+func (t *memoryLimitTable) reset(_ context.Context, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, key)
+	return nil
+}
+
+// sweep
+// @Description: 每分钟清理一次过期的key
+// @receiver t
+// @param now
+func (t *memoryLimitTable) sweep(now int64) {
+	if now-t.swept < time.Minute.Microseconds() {
+		return
+	}
+	t.swept = now
+	for k, s := range t.states {
+		if s.expire <= now {
+			delete(t.states, k)
+		}
+	}
+}
+
+func (s *limitState) slidingLog(now, limit, period, n int64) (bool, int64, int64, int64) {
+	i := sort.Search(len(s.log), func(i int) bool { return s.log[i] > now-period })
+	s.log = s.log[i:]
+	count := int64(len(s.log))
+	if count+n <= limit {
+		for range n {
+			s.log = append(s.log, now)
+		}
+		s.expire = now + period
+		return true, limit - count - n, 0, period
+	}
+	return false, limit - count, s.log[count+n-limit-1] + period - now, s.log[count-1] + period - now
+}
+
+func (s *limitState) slidingWindow(now, limit, period, n int64) (bool, int64, int64, int64) {
+	window := now / period
+	elapsed := now - window*period
+	if s.expire == 0 {
+		s.w = window
+	}
+	if s.w == window-1 {
+		s.p, s.c = s.c, 0
+	} else if s.w < window-1 {
+		s.p, s.c = 0, 0
+	}
+	s.w = window
+	est := float64(s.p)*float64(period-elapsed)/float64(period) + float64(s.c)
+	var reset int64
+	if s.c > 0 {
+		reset = period*2 - elapsed
+	} else if s.p > 0 {
+		reset = period - elapsed
+	}
+	if est+float64(n) <= float64(limit) {
+		s.c += n
+		s.expire = now + period*2
+		return true, int64(math.Floor(float64(limit) - est - float64(n))), 0, period*2 - elapsed
+	}
+	var retry float64
+	if s.p > 0 && s.c+n <= limit {
+		retry = (est + float64(n-limit)) * float64(period) / float64(s.p)
+	} else {
+		retry = float64(period-elapsed) + max(0, float64(period)*(1-float64(limit-n)/float64(s.c)))
+	}
+	return false, max(0, int64(math.Floor(float64(limit)-est))), int64(math.Ceil(retry)), reset
+}
+
+func (s *limitState) tokenBucket(now, limit, burst, period, n int64) (bool, int64, int64, int64) {
+	rate := float64(limit) / float64(period)
+	if s.expire == 0 {
+		s.tokens, s.ts = float64(burst), now
+	}
+	s.tokens = min(float64(burst), s.tokens+float64(max(0, now-s.ts))*rate)
+	s.ts = now
+	allowed, retry := false, int64(0)
+	if s.tokens >= float64(n) {
+		s.tokens -= float64(n)
+		allowed = true
+	} else {
+		retry = int64(math.Ceil((float64(n) - s.tokens) / rate))
+	}
+	s.expire = now + int64(math.Ceil(float64(burst)/rate)) + 1000
+	return allowed, int64(math.Floor(s.tokens)), retry, int64(math.Ceil((float64(burst) - s.tokens) / rate))
+}
+
+func (s *limitState) gcra(now, limit, burst, period, n int64) (bool, int64, int64, int64) {
+	interval := float64(period) / float64(limit)
+	tau := interval * float64(burst)
+	tat := max(s.tat, float64(now))
+	next := tat + float64(n)*interval
+	if float64(now) < next-tau {
+		return false, max(0, int64(math.Floor((float64(now)-tat+tau)/interval))), int64(math.Ceil(next - tau - float64(now))), int64(math.Ceil(tat - float64(now)))
+	}
+	s.tat = next
+	s.expire = int64(math.Ceil(next)) + 1000
+	return true, int64(math.Floor((float64(now) - next + tau) / interval)), 0, int64(math.Ceil(next - float64(now)))
+}
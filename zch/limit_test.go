@@ -0,0 +1,97 @@
+package zch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	s := testRedis(t)
+	ctx := context.Background()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).UnixMicro()
+	for _, algo := range []LimitAlgorithm{LimitSlidingLog, LimitSlidingWindow, LimitTokenBucket, LimitGCRA} {
+		opts := &LimitOptions{Algorithm: algo, Limit: 5, Period: time.Second}
+		r, err := NewLimiter("test", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _ := NewMemoryLimiter("test", opts)
+		clock := base
+		m.backend.(*memoryLimitTable).now = func() int64 { return clock }
+
+		// redis和内存实现的结果一致
+		allow := func(n int64) *LimitResult {
+			s.SetTime(time.UnixMicro(clock))
+			a, err := r.AllowN(ctx, string(algo), n)
+			if err != nil {
+				t.Fatalf("%s: %v", algo, err)
+			}
+			b, _ := m.AllowN(ctx, string(algo), n)
+			if *a != *b {
+				t.Fatalf("%s: redis %+v != memory %+v", algo, a, b)
+			}
+			return a
+		}
+		for i := int64(0); i < 5; i++ {
+			if res := allow(1); !res.Allowed || res.Remaining != 4-i || res.Limit != 5 {
+				t.Fatalf("%s: request %d %+v", algo, i, res)
+			}
+		}
+		res := allow(1)
+		if res.Allowed || res.Remaining != 0 || res.RetryAfter <= 0 || res.ResetAfter <= 0 {
+			t.Fatalf("%s: not limited %+v", algo, res)
+		}
+		clock += res.RetryAfter.Microseconds()
+		if res = allow(1); !res.Allowed {
+			t.Fatalf("%s: not allowed after retry: %+v", algo, res)
+		}
+		if res = allow(6); res.Allowed || res.RetryAfter != -1 {
+			t.Fatalf("%s: over capacity %+v", algo, res)
+		}
+		clock += time.Second.Microseconds() * 3
+		if res = allow(5); !res.Allowed || res.Remaining != 0 {
+			t.Fatalf("%s: not recovered %+v", algo, res)
+		}
+		if err := r.Reset(ctx, string(algo)); err != nil {
+			t.Fatal(err)
+		}
+		if res, _ = r.Allow(ctx, string(algo)); !res.Allowed {
+			t.Fatalf("%s: not reset %+v", algo, res)
+		}
+	}
+
+	// 突发和速率分开设置
+	opts := &LimitOptions{Algorithm: LimitGCRA, Limit: 10, Period: time.Second, Burst: 2}
+	m, _ := NewMemoryLimiter("burst", opts)
+	clock := base
+	m.backend.(*memoryLimitTable).now = func() int64 { return clock }
+	for i, want := range []bool{true, true, false} {
+		if res, _ := m.Allow(ctx, "k"); res.Allowed != want {
+			t.Fatalf("burst %d: %+v", i, res)
+		}
+	}
+	clock += (time.Millisecond * 100).Microseconds()
+	if res, _ := m.Allow(ctx, "k"); !res.Allowed {
+		t.Fatalf("rate: %+v", res)
+	}
+
+	// redis不可用时退回进程内限流
+	r, _ := NewLimiter("fallback", &LimitOptions{Limit: 1})
+	s.SetError("down")
+	defer s.SetError("")
+	if res, err := r.Allow(ctx, "k"); err != nil || !res.Allowed {
+		t.Fatalf("fallback: %v %+v", err, res)
+	}
+	if res, _ := r.Allow(ctx, "k"); res.Allowed {
+		t.Fatalf("fallback not limited: %+v", res)
+	}
+	r, _ = NewLimiter("strict", &LimitOptions{Limit: 1, DisableFallback: true})
+	if _, err := r.Allow(ctx, "k"); err == nil {
+		t.Fatal("expected error without fallback")
+	}
+
+	if _, err := NewMemoryLimiter("invalid", &LimitOptions{}); err == nil {
+		t.Fatal("expected error for zero limit")
+	}
+}
@@ -0,0 +1,414 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * 分布式锁，读写锁共用同一结构：lock:{name} 为hash
+ *  - mode: w写锁/r读锁
+ *  - n: 持有者数量，归零时删除
+ *  - fence: 写锁的fencing token，每次首次获取写锁时由lock:{name}:fence自增得到
+ *  - {owner}: 持有者的重入次数
+ * 同一个Lock值视为同一个持有者，可重入；不同协程需要互斥时应各自NewLock
+ * 获取、释放、续期都由Lua原子完成，释放前校验持有者，不会误删他人的锁
+ */
+
+var ErrLockNotHeld = errors.New("zch: lock not held")
+
+type LockOptions struct {
+	TTL             time.Duration // 租约，默认30s
+	RetryInterval   time.Duration // 等待时的重试间隔，默认100ms
+	DisableWatchdog bool          // 关闭自动续期，持有超过TTL后锁自动释放
+}
+
+func (o *LockOptions) Validate() {
+	o.TTL = zutil.FirstTruth(o.TTL, time.Second*30)
+	o.RetryInterval = zutil.FirstTruth(o.RetryInterval, time.Millisecond*100)
+}
+
+type lockMode string
+
+const (
+	lockWrite lockMode = "w"
+	lockRead  lockMode = "r"
+)
+
+type lockBackend interface {
+	acquire(ctx context.Context, name, owner string, mode lockMode, ttl time.Duration) (ok bool, fence int64, err error)
+	// release 返回剩余重入次数，未持有时返回ErrLockNotHeld
+	release(ctx context.Context, name, owner string, ttl time.Duration) (int64, error)
+	renew(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+}
+
+type Lock struct {
+	name    string
+	owner   string
+	opts    *LockOptions
+	backend lockBackend
+
+	mu    sync.Mutex
+	holds int
+	fence int64
+	stop  chan struct{}
+}
+
+// NewLock
+// @Description: 基于redis的分布式锁
+// @param name
+// @param opts
+// @return *Lock
+func NewLock(name string, opts *LockOptions) *Lock {
+	return newLock(name, opts, redisLocks{})
+}
+
+// NewMemoryLock
+// @Description: 进程内的锁，语义与NewLock一致，用于单机模式和测试
+// @param name
+// @param opts
+// @return *Lock
+func NewMemoryLock(name string, opts *LockOptions) *Lock {
+	return newLock(name, opts, memoryLocks)
+}
+
+func newLock(name string, opts *LockOptions, backend lockBackend) *Lock {
+	opts = zutil.FirstTruth(opts, &LockOptions{})
+	opts.Validate()
+	return &Lock{
+		name:    name,
+		owner:   zutil.RandomStr(20),
+		opts:    opts,
+		backend: backend,
+	}
+}
+
+// Lock
+// @Description: 获取写锁，阻塞到成功或ctx结束
+// @receiver l
+// @param ctx
+// @return error
+func (l *Lock) Lock(ctx context.Context) error {
+	return l.wait(ctx, lockWrite, -1)
+}
+
+// TryLock
+// @Description: 在wait内尝试获取写锁，wait为0时只尝试一次
+// @receiver l
+// @param ctx
+// @param wait
+// @return bool
+// @return error
+func (l *Lock) TryLock(ctx context.Context, wait time.Duration) (bool, error) {
+	return l.try(ctx, lockWrite, wait)
+}
+
+func (l *Lock) Unlock(ctx context.Context) error {
+	return l.release(ctx)
+}
+
+// RLock
+// @Description: 获取读锁，与其他读锁共享，与写锁互斥；持有写锁时可再获取读锁
+// @receiver l
+// @param ctx
+// @return error
+func (l *Lock) RLock(ctx context.Context) error {
+	return l.wait(ctx, lockRead, -1)
+}
+
+func (l *Lock) TryRLock(ctx context.Context, wait time.Duration) (bool, error) {
+	return l.try(ctx, lockRead, wait)
+}
+
+func (l *Lock) RUnlock(ctx context.Context) error {
+	return l.release(ctx)
+}
+
+// Fence
+// @Description: 当前写锁的fencing token，单调递增，写入下游时携带以拒绝过期持有者
+// @receiver l
+// @return int64
+func (l *Lock) Fence() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fence
+}
+
+func (l *Lock) try(ctx context.Context, mode lockMode, wait time.Duration) (bool, error) {
+	err := l.wait(ctx, mode, wait)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// wait
+// @Description: 轮询获取，wait小于0时不限时
+// @receiver l
+// @param ctx
+// @param mode
+// @param wait
+// @return error 超时返回context.DeadlineExceeded
+func (l *Lock) wait(ctx context.Context, mode lockMode, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		ok, fence, err := l.backend.acquire(ctx, l.name, l.owner, mode, l.opts.TTL)
+		if err != nil {
+			return fmt.Errorf("acquire lock %s err: %w", l.name, err)
+		}
+		if ok {
+			l.acquired(fence)
+			return nil
+		}
+		sleep := l.opts.RetryInterval
+		if wait >= 0 {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return context.DeadlineExceeded
+			}
+			sleep = min(sleep, left)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+	}
+}
+
+func (l *Lock) acquired(fence int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.holds++
+	if fence > 0 {
+		l.fence = fence
+	}
+	if l.holds == 1 && !l.opts.DisableWatchdog {
+		l.stop = make(chan struct{})
+		go l.watchdog(l.stop)
+	}
+}
+
+func (l *Lock) release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holds == 0 {
+		return ErrLockNotHeld
+	}
+	remain, err := l.backend.release(ctx, l.name, l.owner, l.opts.TTL)
+	if err != nil && !errors.Is(err, ErrLockNotHeld) {
+		return fmt.Errorf("release lock %s err: %w", l.name, err)
+	}
+	l.holds--
+	// 锁已过期被释放时本地也一并清空
+	if errors.Is(err, ErrLockNotHeld) || remain == 0 {
+		l.holds = 0
+	}
+	if l.holds == 0 && l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	return err
+}
+
+// watchdog
+// @Description: 每TTL/3续期一次，续期失败说明锁已丢失
+// @receiver l
+// @param stop
+func (l *Lock) watchdog(stop chan struct{}) {
+	ticker := time.NewTicker(l.opts.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ok, err := l.backend.renew(context.Background(), l.name, l.owner, l.opts.TTL)
+			if err != nil {
+				zlog.Warnf("renew lock %s err: %v", l.name, err)
+				continue
+			}
+			if !ok {
+				zlog.Warnf("lock %s lost before unlock", l.name)
+				return
+			}
+		}
+	}
+}
+
+/**
+ * redis
+ */
+
+var (
+	lockAcquireScript = redis.NewScript(`
+local mode = redis.call('HGET', KEYS[1], 'mode')
+if not mode then
+	redis.call('HSET', KEYS[1], 'mode', ARGV[3], 'n', 1, ARGV[1], 1)
+	if ARGV[3] == 'w' then
+		redis.call('HSET', KEYS[1], 'fence', redis.call('INCR', KEYS[2]))
+	end
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return tonumber(redis.call('HGET', KEYS[1], 'fence')) or 0
+end
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+	if mode == 'r' and ARGV[3] == 'w' then
+		return -1
+	end
+	redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return tonumber(redis.call('HGET', KEYS[1], 'fence')) or 0
+end
+if mode == 'r' and ARGV[3] == 'r' then
+	redis.call('HSET', KEYS[1], ARGV[1], 1)
+	redis.call('HINCRBY', KEYS[1], 'n', 1)
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 0
+end
+return -1
+`)
+	lockReleaseScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return -1
+end
+local c = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+if c > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return c
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+if redis.call('HINCRBY', KEYS[1], 'n', -1) <= 0 then
+	redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+	lockRenewScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+)
+
+type redisLocks struct{}
+
+// lockKeys 使用hash tag保证集群下在同一slot
+func lockKeys(name string) []string {
+	key := PrefixLock.Key("{" + name + "}")
+	return []string{key, key + ":fence"}
+}
+
+func (redisLocks) acquire(ctx context.Context, name, owner string, mode lockMode, ttl time.Duration) (bool, int64, error) {
+	fence, err := lockAcquireScript.Run(ctx, R(), lockKeys(name), owner, ttl.Milliseconds(), string(mode)).Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	return fence >= 0, fence, nil
+}
+func (redisLocks) release(ctx context.Context, name, owner string, ttl time.Duration) (int64, error) {
+	remain, err := lockReleaseScript.Run(ctx, R(), lockKeys(name)[:1], owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if remain < 0 {
+		return 0, ErrLockNotHeld
+	}
+	return remain, nil
+}
+func (redisLocks) renew(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	ok, err := lockRenewScript.Run(ctx, R(), lockKeys(name)[:1], owner, ttl.Milliseconds()).Int64()
+	return ok == 1, err
+}
+
+/**
+ * memory
+ */
+
+type memoryLockState struct {
+	mode     lockMode
+	fence    int64
+	holders  map[string]int
+	expireAt time.Time
+}
+
+type memoryLockTable struct {
+	mu     sync.Mutex
+	locks  map[string]*memoryLockState
+	fences map[string]int64
+}
+
+var memoryLocks = &memoryLockTable{
+	locks:  make(map[string]*memoryLockState),
+	fences: make(map[string]int64),
+}
+
+func (t *memoryLockTable) get(name string) *memoryLockState {
+	s, ok := t.locks[name]
+	if ok && time.Now().After(s.expireAt) {
+		delete(t.locks, name)
+		return nil
+	}
+	return s
+}
+
+func (t *memoryLockTable) acquire(_ context.Context, name, owner string, mode lockMode, ttl time.Duration) (bool, int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(name)
+	switch {
+	case s == nil:
+		s = &memoryLockState{mode: mode, holders: map[string]int{owner: 1}}
+		if mode == lockWrite {
+			t.fences[name]++
+			s.fence = t.fences[name]
+		}
+		t.locks[name] = s
+	case s.holders[owner] > 0:
+		if s.mode == lockRead && mode == lockWrite {
+			return false, 0, nil
+		}
+		s.holders[owner]++
+	case s.mode == lockRead && mode == lockRead:
+		s.holders[owner] = 1
+	default:
+		return false, 0, nil
+	}
+	s.expireAt = time.Now().Add(ttl)
+	return true, s.fence, nil
+}
+func (t *memoryLockTable) release(_ context.Context, name, owner string, ttl time.Duration) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(name)
+	if s == nil || s.holders[owner] == 0 {
+		return 0, ErrLockNotHeld
+	}
+	s.holders[owner]--
+	if c := s.holders[owner]; c > 0 {
+		s.expireAt = time.Now().Add(ttl)
+		return int64(c), nil
+	}
+	delete(s.holders, owner)
+	if len(s.holders) == 0 {
+		delete(t.locks, name)
+	}
+	return 0, nil
+}
+func (t *memoryLockTable) renew(_ context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(name)
+	if s == nil || s.holders[owner] == 0 {
+		return false, nil
+	}
+	s.expireAt = time.Now().Add(ttl)
+	return true, nil
+}
@@ -0,0 +1,103 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	s := testRedis(t)
+
+	backends := map[string]func(string, *LockOptions) *Lock{"redis": NewLock, "memory": NewMemoryLock}
+	for name, newLock := range backends {
+		ctx := context.Background()
+		opts := &LockOptions{TTL: time.Second, RetryInterval: time.Millisecond * 10}
+		a, b := newLock("order", opts), newLock("order", opts)
+
+		// 互斥与重入
+		if err := a.Lock(ctx); err != nil {
+			t.Fatalf("%s lock: %v", name, err)
+		}
+		fence := a.Fence()
+		if ok, _ := a.TryLock(ctx, 0); !ok || a.Fence() != fence {
+			t.Fatalf("%s reentrant lock failed", name)
+		}
+		if ok, _ := b.TryLock(ctx, time.Millisecond*30); ok {
+			t.Fatalf("%s lock not exclusive", name)
+		}
+		if err := b.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+			t.Fatalf("%s unlock by non-owner: %v", name, err)
+		}
+		_ = a.Unlock(ctx)
+		if ok, _ := b.TryLock(ctx, 0); ok {
+			t.Fatalf("%s released before reentrant count reaches zero", name)
+		}
+		_ = a.Unlock(ctx)
+		if ok, _ := b.TryLock(ctx, 0); !ok || b.Fence() <= fence {
+			t.Fatalf("%s fence not increased: %d <= %d", name, b.Fence(), fence)
+		}
+		_ = b.Unlock(ctx)
+
+		// 看门狗续期超过TTL
+		if err := a.Lock(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// miniredis的过期时间只随FastForward流逝
+		for i := 0; i < 3; i++ {
+			if name == "redis" {
+				s.FastForward(time.Millisecond * 600)
+			}
+			time.Sleep(time.Millisecond * 400)
+		}
+		if ok, _ := b.TryLock(ctx, 0); ok {
+			t.Fatalf("%s lease not renewed by watchdog", name)
+		}
+		_ = a.Unlock(ctx)
+
+		// 读写锁
+		r1, r2, w := newLock("doc", opts), newLock("doc", opts), newLock("doc", opts)
+		if err := r1.RLock(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := r2.TryRLock(ctx, 0); !ok {
+			t.Fatalf("%s read lock not shared", name)
+		}
+		if ok, _ := w.TryLock(ctx, 0); ok {
+			t.Fatalf("%s write lock acquired while reading", name)
+		}
+		_ = r1.RUnlock(ctx)
+		_ = r2.RUnlock(ctx)
+		if ok, _ := w.TryLock(ctx, 0); !ok {
+			t.Fatalf("%s write lock not acquired after readers left", name)
+		}
+		if ok, _ := r1.TryRLock(ctx, 0); ok {
+			t.Fatalf("%s read lock acquired while writing", name)
+		}
+		_ = w.Unlock(ctx)
+	}
+}
+
+func TestMemorySetNX(t *testing.T) {
+	m := NewMemory(time.Minute, 0, "")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.SetNX("k", "v", 0) == nil {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Fatalf("SetNX succeeded %d times", won)
+	}
+}
@@ -1,19 +1,69 @@
 package zch
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/zohu/zgin/zutil"
-	"time"
 )
 
 type Options struct {
-	Expiration    time.Duration `yaml:"expiration"`
-	CleanInterval time.Duration `yaml:"clean_interval"`
-	Addrs         []string      `binding:"required" yaml:"addrs"`
-	Database      int           `yaml:"database"`
-	Password      string        `yaml:"password"`
-	Prefix        string        `yaml:"prefix"`
-	ClientName    string        `yaml:"client_name"`
+	Expiration       time.Duration `yaml:"expiration"`
+	CleanInterval    time.Duration `yaml:"clean_interval"`
+	Addrs            []string      `binding:"required" yaml:"addrs"`
+	Database         int           `yaml:"database"`
+	Username         string        `yaml:"username"`
+	Password         string        `yaml:"password"`
+	Prefix           string        `yaml:"prefix"`
+	ClientName       string        `yaml:"client_name"`
+	Invalidation     Invalidation  `yaml:"invalidation"`
+	MaxEntries       int           `yaml:"max_entries"`
+	MaxBytes         int64         `yaml:"max_bytes"`
+	Policy           EvictPolicy   `yaml:"policy"`
+	SnapshotPath     string        `yaml:"snapshot_path"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+
+	// 租户隔离：ctx中有租户时key加前缀 t:{id}，TenantShared开头的key不隔离，默认登录态、定时任务、多语言、消息流和Topic等
+	Tenant       bool     `yaml:"tenant"`
+	TenantShared []string `yaml:"tenant_shared"`
+
+	// 部署模式：MasterName不为空时为哨兵(Addrs为哨兵地址)，Cluster或多个Addrs时为集群，否则为单机
+	MasterName       string      `yaml:"master_name"`
+	SentinelUsername string      `yaml:"sentinel_username"`
+	SentinelPassword string      `yaml:"sentinel_password"`
+	Cluster          bool        `yaml:"cluster"`
+	Protocol         int         `yaml:"protocol"` // RESP版本，2或3，默认3
+	TLS              *TLSOptions `yaml:"tls"`      // 为空时不启用
+
+	// 读写路由：集群模式下只读命令发往副本；哨兵模式下ReadOnly会把所有命令发往副本，只适合只读客户端
+	ReadOnly       bool `yaml:"read_only"`
+	RouteByLatency bool `yaml:"route_by_latency"`
+	RouteRandomly  bool `yaml:"route_randomly"`
+
+	// 连接池和超时，为0时使用go-redis的默认值
+	PoolSize        int           `yaml:"pool_size"`
+	PoolTimeout     time.Duration `yaml:"pool_timeout"`
+	MinIdleConns    int           `yaml:"min_idle_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	MaxActiveConns  int           `yaml:"max_active_conns"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	DialTimeout     time.Duration `yaml:"dial_timeout"` // 默认5s，也用于启动时的连通性检查
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	MaxRetries      int           `yaml:"max_retries"`
+}
+
+type TLSOptions struct {
+	CAFile             string `yaml:"ca_file"`   // 为空时使用系统根证书
+	CertFile           string `yaml:"cert_file"` // 双向认证时配置
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 }
 
 func (o *Options) Validate() error {
@@ -21,5 +71,47 @@ func (o *Options) Validate() error {
 	o.CleanInterval = zutil.FirstTruth(o.CleanInterval, time.Minute*5)
 	o.Database = zutil.FirstTruth(o.Database, 0)
 	o.ClientName = zutil.FirstTruth(o.ClientName, "zch")
+	o.Invalidation = zutil.FirstTruth(o.Invalidation, InvalidationPubSub)
+	o.DialTimeout = zutil.FirstTruth(o.DialTimeout, time.Second*5)
+	if o.Tenant && len(o.TenantShared) == 0 {
+		o.TenantShared = defaultTenantShared
+	}
+	if o.Protocol != 0 && o.Protocol != 2 && o.Protocol != 3 {
+		return fmt.Errorf("protocol must be 2 or 3, got %d", o.Protocol)
+	}
+	if o.Database != 0 && (o.Cluster || (o.MasterName == "" && len(o.Addrs) > 1)) {
+		return fmt.Errorf("cluster mode does not support database %d", o.Database)
+	}
 	return validator.New().Struct(o)
 }
+
+// Config
+// @Description: 转为tls.Config，读取证书失败时返回错误
+// @receiver t
+// @return *tls.Config
+// @return error
+func (t *TLSOptions) Config() (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		ca, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
@@ -16,8 +16,25 @@ func (p Prefix) Key(args ...string) string {
 
 // 系统预留前缀
 const (
-	PrefixI18n       Prefix = "z18n"
-	PrefixAuthPreID  Prefix = "auth:pre"
-	PrefixAuthToken  Prefix = "auth:user"
-	PrefixAuthAction Prefix = "auth:action"
+	PrefixL2Invalidate Prefix = "zch:invalidate"
+	PrefixLock         Prefix = "lock"
+	PrefixStream       Prefix = "stream"
+	PrefixCron         Prefix = "cron"
+	PrefixLimit        Prefix = "limit"
+	PrefixRank         Prefix = "rank"
+	PrefixCounter      Prefix = "counter"
+	PrefixUnique       Prefix = "uv"
+	PrefixBloom        Prefix = "bloom"
+	PrefixI18n         Prefix = "z18n"
+	PrefixDBCache      Prefix = "db"
+	PrefixAuthPreID    Prefix = "auth:pre"
+	PrefixAuthToken    Prefix = "auth:user"
+	PrefixAuthSession  Prefix = "auth:session"
+	PrefixAuthAction   Prefix = "auth:action"
+	PrefixAuthApiKey   Prefix = "auth:apikey"
+	PrefixAuthNonce    Prefix = "auth:nonce"
+	PrefixAuthEvent    Prefix = "auth:event"
+	PrefixAuthRevoke   Prefix = "auth:revoke"
+	PrefixAuthLogout   Prefix = "auth:logout"
+	PrefixAuthImp      Prefix = "auth:imp"
 )
@@ -4,76 +4,436 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
 	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zutil"
 	"github.com/zohu/zlog"
-	"time"
 )
 
+/**
+ * 可靠队列，至少投递一次
+ *  - {prefix}:stream 消息流，所有消费者共享同一个消费组，每条消息只被一个消费者处理
+ *  - {prefix}:delayed 延迟及重试消息，zset，成员为 attempt:id:body，分数为到期毫秒
+ *  - {prefix}:dead 死信，list，超过最大尝试次数的消息
+ * 处理成功后ack并删除；失败按退避进入delayed；超过Visibility未ack的消息视为消费者失联，重新入队并计一次尝试
+ * 延迟消息的搬运、重试、死信都由Lua原子完成，多个实例同时搬运不会重复投递
+ */
+
+const topicGroup = "topic"
+
+type TopicOptions struct {
+	Visibility  time.Duration                   // 处理超时，超时未ack的消息重新入队，默认30s
+	MaxAttempts int                             // 最大尝试次数，超出后进入死信，默认5
+	Backoff     func(attempt int) time.Duration // 第attempt次失败后的重试间隔，默认1s*2^(attempt-1)，最长10min
+	MaxLen      int64                           // 消息流的近似最大长度，默认100000
+}
+
+func (o *TopicOptions) Validate() {
+	o.Visibility = zutil.FirstTruth(o.Visibility, time.Second*30)
+	o.MaxAttempts = zutil.FirstTruth(o.MaxAttempts, 5)
+	o.MaxLen = zutil.FirstTruth(o.MaxLen, 100000)
+	if o.Backoff == nil {
+		o.Backoff = func(attempt int) time.Duration {
+			return min(time.Second<<min(attempt-1, 10), time.Minute*10)
+		}
+	}
+}
+
 type Topic struct {
-	prefix Prefix
+	prefix   Prefix
+	opts     *TopicOptions
+	consumer string
 }
 
-var process = make(map[string]bool)
+type TopicMessage struct {
+	ID      string
+	Body    string
+	Attempt int // 已失败的次数，首次投递为0
 
-func NewTopic(prefix Prefix) *Topic {
+	topic *Topic
+	done  bool
+}
+
+type DeadMessage struct {
+	Body    string    `json:"body"`
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
+}
+
+// 每个prefix一个搬运协程，Close时停止
+var (
+	process   = make(map[string]context.CancelFunc)
+	processMu sync.Mutex
+	processWg sync.WaitGroup
+)
+
+func NewTopic(prefix Prefix, opts ...*TopicOptions) *Topic {
+	opt := zutil.FirstTruth(append(opts, &TopicOptions{})...)
+	opt.Validate()
+	host, _ := os.Hostname()
 	t := &Topic{
-		prefix: prefix,
+		prefix:   prefix,
+		opts:     opt,
+		consumer: fmt.Sprintf("%s-%s", host, zutil.RandomStr(6)),
 	}
+	processMu.Lock()
+	defer processMu.Unlock()
 	if _, ok := process[t.prefix.Key()]; !ok {
-		go t.processDelayed(t.prefix)
-		process[t.prefix.Key()] = true
+		ctx, cancel := context.WithCancel(context.Background())
+		process[t.prefix.Key()] = cancel
+		processWg.Add(1)
+		go func() {
+			defer processWg.Done()
+			t.processDelayed(ctx, t.prefix)
+		}()
 	}
 	return t
 }
 
+// 同一topic的key带相同hash tag，集群模式下脚本可同时操作
+func (t *Topic) stream() string  { return t.key("stream") }
+func (t *Topic) delayed() string { return t.key("delayed") }
+func (t *Topic) dead() string    { return t.key("dead") }
+func (t *Topic) key(name string) string {
+	return Prefix("{" + string(t.prefix) + "}").Key(name)
+}
+
 func (t *Topic) Publish(ctx context.Context, message string, delay ...time.Duration) error {
-	if len(delay) > 0 {
-		return R().ZAdd(ctx, t.prefix.Key(), redis.Z{
-			Score:  float64(time.Now().Unix() + int64(delay[0].Seconds())),
-			Member: message,
+	if len(delay) > 0 && delay[0] > 0 {
+		return R().ZAdd(ctx, t.delayed(), redis.Z{
+			Score:  float64(time.Now().Add(delay[0]).UnixMilli()),
+			Member: delayedMember(0, message),
 		}).Err()
 	}
-	return R().LPush(ctx, t.prefix.Key(), message).Err()
+	return R().XAdd(ctx, &redis.XAddArgs{
+		Stream: t.stream(),
+		MaxLen: t.opts.MaxLen,
+		Approx: true,
+		Values: []any{"body", message, "attempt", 0},
+	}).Err()
 }
 
 // Subscribe
-// @Description: 订阅消息，handler返回err时，消息不消费
+// @Description: 订阅消息，handler返回err时按退避重试，超过最大次数进入死信
 // @receiver t
 // @param ctx
 // @param handler
 func (t *Topic) Subscribe(ctx context.Context, handler func(string) error) {
-	for {
-		result, err := R().BRPop(ctx, 0, t.prefix.Key()).Result()
+	t.Consume(ctx, func(ctx context.Context, m *TopicMessage) error {
+		return handler(m.Body)
+	})
+}
+
+// Consume
+// @Description: 消费消息，阻塞到ctx结束；handler返回nil时ack，返回err时重试，也可在handler内显式Ack/Nack
+// @receiver t
+// @param ctx
+// @param handler ctx在Visibility后超时
+func (t *Topic) Consume(ctx context.Context, handler func(ctx context.Context, m *TopicMessage) error) {
+	for ctx.Err() == nil {
+		err := R().XGroupCreateMkStream(ctx, t.stream(), topicGroup, "0").Err()
+		if err == nil || strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			break
+		}
+		zlog.Warnf("zch queue create group err: %v", err)
+		time.Sleep(time.Second * 3)
+	}
+	for ctx.Err() == nil {
+		streams, err := R().XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    topicGroup,
+			Consumer: t.consumer,
+			Streams:  []string{t.stream(), ">"},
+			Count:    1, // 逐条读取，批量读取时排在后面的消息会在等待中超过Visibility被重新入队
+			Block:    time.Second * 2,
+		}).Result()
 		if err != nil {
-			if errors.Is(err, redis.Nil) {
-				time.Sleep(time.Millisecond * 100)
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
 				continue
 			}
 			zlog.Warnf("zch queue subscribe err: %v", err)
 			time.Sleep(time.Second * 3)
 			continue
 		}
-		if handler(result[1]) != nil {
-			zlog.Warnf("zch queue subscribe handler err: %v", err)
-			time.Sleep(time.Second * 3)
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				t.handle(ctx, msg, handler)
+			}
+		}
+	}
+}
+
+func (t *Topic) handle(ctx context.Context, msg redis.XMessage, handler func(ctx context.Context, m *TopicMessage) error) {
+	m := &TopicMessage{ID: msg.ID, topic: t}
+	m.Body, _ = msg.Values["body"].(string)
+	m.Attempt, _ = strconv.Atoi(fmt.Sprint(msg.Values["attempt"]))
+
+	hctx, cancel := context.WithTimeout(ctx, t.opts.Visibility)
+	defer cancel()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return handler(hctx, m)
+	}()
+	if m.done {
+		return
+	}
+	// 使用独立ctx，保证退出时也能完成ack
+	actx := context.WithoutCancel(ctx)
+	if err == nil {
+		if e := m.Ack(actx); e != nil {
+			zlog.Warnf("zch queue ack err: %v", e)
+		}
+		return
+	}
+	zlog.Warnf("zch queue subscribe handler err: %v", err)
+	if e := m.Nack(actx, err); e != nil {
+		zlog.Warnf("zch queue nack err: %v", e)
+	}
+}
+
+// Ack
+// @Description: 确认消息已处理
+// @receiver m
+// @param ctx
+// @return error
+func (m *TopicMessage) Ack(ctx context.Context) error {
+	m.done = true
+	return topicAckScript.Run(ctx, R(), []string{m.topic.stream()}, topicGroup, m.ID).Err()
+}
+
+// Nack
+// @Description: 处理失败，按退避重试，超过最大次数进入死信
+// @receiver m
+// @param ctx
+// @param cause
+// @return error
+func (m *TopicMessage) Nack(ctx context.Context, cause error) error {
+	m.done = true
+	return m.topic.retry(ctx, m.ID, m.Body, m.Attempt+1, fmt.Sprint(cause))
+}
+
+func (t *Topic) retry(ctx context.Context, id, body string, attempt int, cause string) error {
+	due := time.Now().Add(t.opts.Backoff(attempt)).UnixMilli()
+	dead, _ := sonic.MarshalString(&DeadMessage{Body: body, Attempt: attempt, Error: cause, Time: time.Now()})
+	return topicRetryScript.Run(ctx, R(),
+		[]string{t.stream(), t.delayed(), t.dead()},
+		topicGroup, id, attempt, t.opts.MaxAttempts, due, delayedMember(attempt, body), dead,
+	).Err()
+}
+
+// DeadLetters
+// @Description: 查看死信，最新的在前
+// @receiver t
+// @param ctx
+// @param limit
+// @return []DeadMessage
+// @return error
+func (t *Topic) DeadLetters(ctx context.Context, limit int64) ([]DeadMessage, error) {
+	vals, err := R().LRange(ctx, t.dead(), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]DeadMessage, 0, len(vals))
+	for _, v := range vals {
+		var d DeadMessage
+		if err = sonic.UnmarshalString(v, &d); err == nil {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+// Redrive
+// @Description: 将最早的n条死信重新投递，尝试次数清零
+// @receiver t
+// @param ctx
+// @param n
+// @return int 重新投递的条数
+// @return error
+func (t *Topic) Redrive(ctx context.Context, n int) (int, error) {
+	for i := 0; i < n; i++ {
+		v, err := R().RPop(ctx, t.dead()).Result()
+		if errors.Is(err, redis.Nil) {
+			return i, nil
+		}
+		if err != nil {
+			return i, err
+		}
+		var d DeadMessage
+		if err = sonic.UnmarshalString(v, &d); err != nil {
 			continue
 		}
-		R().RPop(ctx, t.prefix.Key())
+		if err = t.Publish(ctx, d.Body); err != nil {
+			R().RPush(ctx, t.dead(), v)
+			return i, err
+		}
+	}
+	return n, nil
+}
+
+// processDelayed
+// @Description: 搬运到期的延迟消息，并把超过Visibility未ack的消息重新入队
+// @receiver t
+// @param ctx 结束时退出
+// @param p
+func (t *Topic) processDelayed(ctx context.Context, p Prefix) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastClaim := time.Now()
+	for {
+		err := topicMoveScript.Run(ctx, R(),
+			[]string{t.delayed(), t.stream()},
+			time.Now().UnixMilli(), 100, t.opts.MaxLen,
+		).Err()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			zlog.Warnf("zch queue move delayed err: %v", err)
+		}
+		t.moveLegacy(ctx, p.Key())
+		if time.Since(lastClaim) > t.opts.Visibility/2 {
+			t.reclaim(ctx)
+			lastClaim = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
-func (t *Topic) processDelayed(p Prefix) {
-	ctx := context.Background()
+// closeTopics
+// @Description: 停止所有搬运协程并等待退出，之后NewTopic会重新启动
+func closeTopics() {
+	processMu.Lock()
+	for key, cancel := range process {
+		cancel()
+		delete(process, key)
+	}
+	processMu.Unlock()
+	processWg.Wait()
+}
+
+// moveLegacy
+// @Description: 旧版本以list存放的消息搬到stream，以zset存放的延迟消息（秒级score）搬到延迟队列，不同slot不使用脚本
+// @receiver t
+// @param ctx
+// @param legacy
+func (t *Topic) moveLegacy(ctx context.Context, legacy string) {
+	switch R().Type(ctx, legacy).Val() {
+	case "list":
+		for i := 0; i < 100; i++ {
+			m, err := R().RPop(ctx, legacy).Result()
+			if err != nil {
+				return
+			}
+			if err = R().XAdd(ctx, &redis.XAddArgs{
+				Stream: t.stream(),
+				MaxLen: t.opts.MaxLen,
+				Approx: true,
+				Values: []any{"body", m, "attempt", 0},
+			}).Err(); err != nil {
+				R().RPush(ctx, legacy, m)
+				zlog.Warnf("zch queue move legacy err: %v", err)
+				return
+			}
+		}
+	case "zset":
+		entries, err := R().ZRangeByScoreWithScores(ctx, legacy, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   "+inf",
+			Count: 100,
+		}).Result()
+		if err != nil || len(entries) == 0 {
+			return
+		}
+		zs := make([]redis.Z, len(entries))
+		members := make([]any, len(entries))
+		for i, entry := range entries {
+			zs[i] = redis.Z{Score: entry.Score * 1000, Member: delayedMember(0, fmt.Sprint(entry.Member))}
+			members[i] = entry.Member
+		}
+		if err = R().ZAdd(ctx, t.delayed(), zs...).Err(); err != nil {
+			zlog.Warnf("zch queue move legacy err: %v", err)
+			return
+		}
+		R().ZRem(ctx, legacy, members...)
+	}
+}
+
+func (t *Topic) reclaim(ctx context.Context) {
+	start := "0-0"
 	for {
-		now := time.Now().Unix()
-		entries := R().ZRangeByScoreWithScores(ctx, p.Key(), &redis.ZRangeBy{
-			Min: "-inf",
-			Max: fmt.Sprintf("%d", now),
-		}).Val()
-		for _, entry := range entries {
-			R().LPush(ctx, p.Key(), entry.Member)
-			R().ZRem(ctx, p.Key(), entry.Member)
+		msgs, next, err := R().XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   t.stream(),
+			Group:    topicGroup,
+			Consumer: "reclaimer",
+			MinIdle:  t.opts.Visibility,
+			Start:    start,
+			Count:    100,
+		}).Result()
+		if err != nil {
+			// 还没有消费者时消费组不存在
+			if !strings.HasPrefix(err.Error(), "NOGROUP") {
+				zlog.Warnf("zch queue reclaim err: %v", err)
+			}
+			return
+		}
+		for _, msg := range msgs {
+			body, _ := msg.Values["body"].(string)
+			attempt, _ := strconv.Atoi(fmt.Sprint(msg.Values["attempt"]))
+			if err = t.retry(ctx, msg.ID, body, attempt+1, "visibility timeout"); err != nil {
+				zlog.Warnf("zch queue reclaim retry err: %v", err)
+			}
 		}
-		time.Sleep(1 * time.Second)
+		if next == "0-0" || len(msgs) == 0 {
+			return
+		}
+		start = next
 	}
 }
+
+func delayedMember(attempt int, body string) string {
+	return fmt.Sprintf("%d:%s:%s", attempt, zutil.RandomStr(8), body)
+}
+
+var (
+	topicAckScript = redis.NewScript(`
+redis.call('XACK', KEYS[1], ARGV[1], ARGV[2])
+redis.call('XDEL', KEYS[1], ARGV[2])
+return 1
+`)
+	// KEYS: stream delayed dead; ARGV: group id attempt max due member dead
+	topicRetryScript = redis.NewScript(`
+if redis.call('XACK', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call('XDEL', KEYS[1], ARGV[2])
+if tonumber(ARGV[3]) >= tonumber(ARGV[4]) then
+	redis.call('LPUSH', KEYS[3], ARGV[7])
+else
+	redis.call('ZADD', KEYS[2], ARGV[5], ARGV[6])
+end
+return 1
+`)
+	// KEYS: delayed stream; ARGV: now limit maxlen
+	topicMoveScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, m in ipairs(due) do
+	if redis.call('ZREM', KEYS[1], m) == 1 then
+		local attempt, body = string.match(m, '^(%d+):[^:]*:(.*)$')
+		redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[3], '*', 'body', body, 'attempt', attempt)
+	end
+end
+return #due
+`)
+)
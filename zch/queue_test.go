@@ -2,20 +2,15 @@ package zch
 
 import (
 	"context"
+	"errors"
 	"github.com/zohu/zlog"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestTopic(t *testing.T) {
-	NewL2(&Options{
-		Expiration:    time.Minute,
-		CleanInterval: time.Minute,
-		Addrs:         []string{"localhost:8011"},
-		Database:      0,
-		Password:      "JCFkQYex4f",
-		Prefix:        "cs",
-	})
+	testRedis(t)
 	var prefix Prefix = "test_queue"
 	topic := NewTopic(prefix)
 
@@ -33,3 +28,113 @@ func TestTopic(t *testing.T) {
 
 	time.Sleep(time.Second * 20)
 }
+
+func TestTopicReliable(t *testing.T) {
+	s := testRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var prefix Prefix = "test_reliable"
+	topic := NewTopic(prefix, &TopicOptions{
+		MaxAttempts: 2,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+	})
+	// 旧版本list中的消息会被迁移
+	s.Lpush(prefix.Key(), "legacy")
+
+	got := make(chan string, 10)
+	go topic.Subscribe(ctx, func(msg string) error {
+		got <- msg
+		if msg == "bad" {
+			return errors.New("always fail")
+		}
+		return nil
+	})
+	_ = topic.Publish(ctx, "hello")
+	_ = topic.Publish(ctx, "later", time.Millisecond*100)
+	_ = topic.Publish(ctx, "bad")
+
+	seen := map[string]int{}
+	timeout := time.After(time.Second * 8)
+	for seen["hello"] == 0 || seen["later"] == 0 || seen["legacy"] == 0 || seen["bad"] < 2 {
+		select {
+		case msg := <-got:
+			seen[msg]++
+		case <-timeout:
+			t.Fatalf("messages not delivered: %v", seen)
+		}
+	}
+	time.Sleep(time.Millisecond * 200)
+	if seen["hello"] != 1 || seen["later"] != 1 || seen["bad"] != 2 {
+		t.Fatalf("unexpected deliveries: %v", seen)
+	}
+	if n := R().XLen(ctx, topic.stream()).Val(); n != 0 {
+		t.Fatalf("acked messages not deleted: %d", n)
+	}
+	dead, err := topic.DeadLetters(ctx, 10)
+	if err != nil || len(dead) != 1 || dead[0].Body != "bad" || dead[0].Attempt != 2 {
+		t.Fatalf("dead letters: %v %+v", err, dead)
+	}
+	if n, _ := topic.Redrive(ctx, 10); n != 1 {
+		t.Fatalf("redrive %d", n)
+	}
+	select {
+	case msg := <-got:
+		if msg != "bad" {
+			t.Fatalf("redrive delivered %q", msg)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("redrive not delivered")
+	}
+}
+
+func TestTopicKeysSameSlot(t *testing.T) {
+	topic := &Topic{prefix: "test:slot"}
+	tag := func(k string) string {
+		if i := strings.Index(k, "{"); i >= 0 {
+			if j := strings.Index(k[i+1:], "}"); j > 0 {
+				return k[i+1 : i+1+j]
+			}
+		}
+		return k
+	}
+	for _, k := range []string{topic.delayed(), topic.dead()} {
+		if tag(k) != tag(topic.stream()) || tag(k) != "test:slot" {
+			t.Fatalf("%s and %s in different slots", k, topic.stream())
+		}
+	}
+}
+
+func TestTopicLegacyDelayed(t *testing.T) {
+	s := testRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 旧版本延迟消息存放在zset，score为秒
+	var prefix Prefix = "test_legacy_delayed"
+	now := time.Now().Unix()
+	_, _ = s.ZAdd(prefix.Key(), float64(now-1), "due")
+	_, _ = s.ZAdd(prefix.Key(), float64(now+3600), "future")
+	topic := NewTopic(prefix)
+
+	got := make(chan string, 10)
+	go topic.Subscribe(ctx, func(msg string) error {
+		got <- msg
+		return nil
+	})
+	select {
+	case msg := <-got:
+		if msg != "due" {
+			t.Fatalf("unexpected message %q", msg)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("legacy delayed message not delivered")
+	}
+	if s.Exists(prefix.Key()) {
+		t.Fatal("legacy zset not drained")
+	}
+	zs, _ := R().ZRangeWithScores(ctx, topic.delayed(), 0, -1).Result()
+	if len(zs) != 1 || !strings.HasSuffix(zs[0].Member.(string), ":future") || int64(zs[0].Score) != (now+3600)*1000 {
+		t.Fatalf("future message not moved to delayed: %+v", zs)
+	}
+}
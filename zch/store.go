@@ -0,0 +1,148 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotFound 缓存未命中，加载函数返回该错误时会写入空值缓存
+var ErrNotFound = errors.New("zch: not found")
+
+// Store
+// @Description: 字符串缓存后端，L2可直接使用，Memory、Redis分别通过StoreMemory、StoreRedis适配
+type Store interface {
+	Get(ctx context.Context, k string) (string, error)
+	Set(ctx context.Context, k, v string, exp time.Duration) error
+	Del(ctx context.Context, ks ...string) error
+}
+
+// BatchStore
+// @Description: 支持批量读写的后端，未实现时Cache逐个读写
+type BatchStore interface {
+	Store
+	// MGet 只返回命中的key
+	MGet(ctx context.Context, ks ...string) (map[string]string, error)
+	MSet(ctx context.Context, kvs map[string]string, exp time.Duration) error
+}
+
+// IsNotFound
+// @Description: 是否为未命中，兼容redis.Nil
+// @param err
+// @return bool
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, redis.Nil)
+}
+
+// StoreMemory
+// @Description: 仅内存的后端，适合单机或测试
+// @param m
+// @return BatchStore
+func StoreMemory(m *Memory) BatchStore {
+	return &memoryStore{m: m}
+}
+
+// StoreRedis
+// @Description: 仅redis的后端
+// @param r
+// @return BatchStore
+func StoreRedis(r *Redis) BatchStore {
+	return &redisStore{r: r}
+}
+
+type memoryStore struct {
+	m *Memory
+}
+
+func (s *memoryStore) Get(_ context.Context, k string) (string, error) {
+	if v, ok := s.m.Get(k); ok {
+		return v, nil
+	}
+	return "", ErrNotFound
+}
+func (s *memoryStore) Set(_ context.Context, k, v string, exp time.Duration) error {
+	s.m.Set(k, v, exp)
+	return nil
+}
+func (s *memoryStore) Del(_ context.Context, ks ...string) error {
+	for _, k := range ks {
+		s.m.Delete(k)
+	}
+	return nil
+}
+func (s *memoryStore) MGet(_ context.Context, ks ...string) (map[string]string, error) {
+	res := make(map[string]string, len(ks))
+	for _, k := range ks {
+		if v, ok := s.m.Get(k); ok {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+func (s *memoryStore) MSet(_ context.Context, kvs map[string]string, exp time.Duration) error {
+	for k, v := range kvs {
+		s.m.Set(k, v, exp)
+	}
+	return nil
+}
+
+type redisStore struct {
+	r *Redis
+}
+
+func (s *redisStore) Get(ctx context.Context, k string) (string, error) {
+	v, err := s.r.Get(ctx, k).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+func (s *redisStore) Set(ctx context.Context, k, v string, exp time.Duration) error {
+	return s.r.Set(ctx, k, v, exp).Err()
+}
+func (s *redisStore) Del(ctx context.Context, ks ...string) error {
+	if len(ks) == 0 {
+		return nil
+	}
+	// 集群下多key命令要求同一slot，逐个删除
+	pipe := s.r.Pipeline()
+	for _, k := range ks {
+		pipe.Del(ctx, k)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+func (s *redisStore) MGet(ctx context.Context, ks ...string) (map[string]string, error) {
+	res := make(map[string]string, len(ks))
+	if len(ks) == 0 {
+		return res, nil
+	}
+	// 集群下多key命令要求同一slot，逐个读取；单key的MGET未命中时不返回redis.Nil，避免污染pipeline中其余命令
+	pipe := s.r.Pipeline()
+	gets := make([]*redis.SliceCmd, len(ks))
+	for i, k := range ks {
+		gets[i] = pipe.MGet(ctx, k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, k := range ks {
+		if v, ok := gets[i].Val()[0].(string); ok {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+func (s *redisStore) MSet(ctx context.Context, kvs map[string]string, exp time.Duration) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	pipe := s.r.Pipeline()
+	for k, v := range kvs {
+		pipe.Set(ctx, k, v, exp)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,317 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * 基于redis stream的事件总线
+ *  - 每个服务使用自己的消费组，都能收到全部事件；同一服务的多个实例共享消费组，分摊处理
+ *  - handler返回nil时ack，返回err时保留在pending中，超过ClaimIdle后被任一实例重新领取
+ *  - 发布时按MaxLen、MaxAge近似裁剪
+ * 与Topic的区别：Topic是单消费组的工作队列，带重试和死信；Stream面向多服务广播
+ */
+
+type StreamOptions struct {
+	Codec         Codec         // 载荷编码，默认CodecJSON
+	MaxLen        int64         // 按长度近似裁剪，0不限制
+	MaxAge        time.Duration // 按时间近似裁剪，0不限制
+	Count         int64         // 每次读取条数，默认10
+	Block         time.Duration // 阻塞读取时长，默认2s
+	ClaimIdle     time.Duration // pending超过该时长会被重新领取，默认1min
+	FromBeginning bool          // 新建消费组时从头消费，默认只消费之后的事件
+}
+
+func (o *StreamOptions) Validate() {
+	if o.Codec == nil {
+		o.Codec = CodecJSON
+	}
+	o.Count = zutil.FirstTruth(o.Count, 10)
+	o.Block = zutil.FirstTruth(o.Block, time.Second*2)
+	o.ClaimIdle = zutil.FirstTruth(o.ClaimIdle, time.Minute)
+}
+
+type Event[T any] struct {
+	ID      string
+	Stream  string
+	Group   string
+	Payload T
+	Time    time.Time
+}
+
+type EventHandler[T any] func(ctx context.Context, e *Event[T]) error
+type EventMiddleware[T any] func(next EventHandler[T]) EventHandler[T]
+
+type streamEntry struct {
+	ID      string
+	Payload string
+}
+
+type streamBackend interface {
+	add(ctx context.Context, stream, payload string, maxLen int64, minID string) (string, error)
+	group(ctx context.Context, stream, group, start string) error
+	read(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]streamEntry, error)
+	ack(ctx context.Context, stream, group string, ids ...string) error
+	claim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]streamEntry, error)
+}
+
+type Stream[T any] struct {
+	name        string
+	opts        *StreamOptions
+	backend     streamBackend
+	middlewares []EventMiddleware[T]
+}
+
+// NewStream
+// @Description: 基于redis的事件流
+// @param name
+// @param opts
+// @return *Stream[T]
+func NewStream[T any](name string, opts *StreamOptions) *Stream[T] {
+	return newStream[T](name, opts, redisStreams{})
+}
+
+// NewMemoryStream
+// @Description: 进程内的事件流，语义与NewStream一致，用于测试
+// @param name
+// @param opts
+// @return *Stream[T]
+func NewMemoryStream[T any](name string, opts *StreamOptions) *Stream[T] {
+	return newStream[T](name, opts, newMemoryStreams())
+}
+
+func newStream[T any](name string, opts *StreamOptions, backend streamBackend) *Stream[T] {
+	// 拷贝一份，同一配置可用于多个Stream
+	o := *zutil.FirstTruth(opts, &StreamOptions{})
+	o.Validate()
+	return &Stream[T]{
+		name:    PrefixStream.Key(name),
+		opts:    &o,
+		backend: backend,
+	}
+}
+
+// Use
+// @Description: 添加handler中间件，先添加的在外层
+// @receiver s
+// @param m
+func (s *Stream[T]) Use(m ...EventMiddleware[T]) {
+	s.middlewares = append(s.middlewares, m...)
+}
+
+// Publish
+// @Description: 发布事件
+// @receiver s
+// @param ctx
+// @param payload
+// @return string 事件ID
+// @return error
+func (s *Stream[T]) Publish(ctx context.Context, payload T) (string, error) {
+	data, err := s.opts.Codec.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("stream %s marshal err: %w", s.name, err)
+	}
+	var minID string
+	if s.opts.MaxAge > 0 {
+		minID = strconv.FormatInt(time.Now().Add(-s.opts.MaxAge).UnixMilli(), 10)
+	}
+	return s.backend.add(ctx, s.name, string(data), s.opts.MaxLen, minID)
+}
+
+// Subscribe
+// @Description: 以消费组group订阅，阻塞到ctx结束
+// @receiver s
+// @param ctx
+// @param group 通常为服务名
+// @param handler
+// @return error 只有创建消费组失败时返回
+func (s *Stream[T]) Subscribe(ctx context.Context, group string, handler EventHandler[T]) error {
+	start := zutil.When(s.opts.FromBeginning, "0", "$")
+	if err := s.backend.group(ctx, s.name, group, start); err != nil {
+		return fmt.Errorf("stream %s create group %s err: %w", s.name, group, err)
+	}
+	h := StreamRecover[T]()(handler)
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		h = s.middlewares[i](h)
+	}
+	host, _ := os.Hostname()
+	consumer := fmt.Sprintf("%s-%s", host, zutil.RandomStr(6))
+	lastClaim := time.Now()
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) > s.opts.ClaimIdle/2 {
+			lastClaim = time.Now()
+			entries, err := s.backend.claim(ctx, s.name, group, consumer, s.opts.ClaimIdle, s.opts.Count)
+			if err != nil {
+				zlog.Warnf("stream %s claim err: %v", s.name, err)
+			}
+			s.dispatch(ctx, group, entries, h)
+		}
+		entries, err := s.backend.read(ctx, s.name, group, consumer, s.opts.Count, s.opts.Block)
+		if err != nil {
+			if ctx.Err() == nil {
+				zlog.Warnf("stream %s read err: %v", s.name, err)
+				time.Sleep(time.Second * 3)
+			}
+			continue
+		}
+		s.dispatch(ctx, group, entries, h)
+	}
+	return nil
+}
+
+func (s *Stream[T]) dispatch(ctx context.Context, group string, entries []streamEntry, h EventHandler[T]) {
+	for _, entry := range entries {
+		e := &Event[T]{ID: entry.ID, Stream: s.name, Group: group, Time: streamTime(entry.ID)}
+		if err := s.opts.Codec.Unmarshal([]byte(entry.Payload), &e.Payload); err != nil {
+			// 无法解码的事件重试也没有意义，直接ack
+			zlog.Warnf("stream %s unmarshal %s err: %v", s.name, entry.ID, err)
+		} else if err = h(ctx, e); err != nil {
+			continue
+		}
+		if err := s.backend.ack(context.WithoutCancel(ctx), s.name, group, entry.ID); err != nil {
+			zlog.Warnf("stream %s ack %s err: %v", s.name, entry.ID, err)
+		}
+	}
+}
+
+func streamTime(id string) time.Time {
+	ms, _ := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return time.UnixMilli(ms)
+}
+
+// StreamRecover
+// @Description: panic转为错误，事件保留在pending中等待重新领取，Subscribe默认在最内层启用
+// @return EventMiddleware[T]
+func StreamRecover[T any]() EventMiddleware[T] {
+	return func(next EventHandler[T]) EventHandler[T] {
+		return func(ctx context.Context, e *Event[T]) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return next(ctx, e)
+		}
+	}
+}
+
+// StreamLogger
+// @Description: 记录处理失败和慢处理
+// @param slow 超过该时长记录日志，0不记录
+// @return EventMiddleware[T]
+func StreamLogger[T any](slow time.Duration) EventMiddleware[T] {
+	return func(next EventHandler[T]) EventHandler[T] {
+		return func(ctx context.Context, e *Event[T]) error {
+			start := time.Now()
+			err := next(ctx, e)
+			cost := time.Since(start)
+			if err != nil {
+				zlog.Warnf("stream %s group %s event %s err: %v (%s)", e.Stream, e.Group, e.ID, err, cost)
+			} else if slow > 0 && cost > slow {
+				zlog.Warnf("stream %s group %s event %s slow: %s", e.Stream, e.Group, e.ID, cost)
+			}
+			return err
+		}
+	}
+}
+
+// StreamMetrics
+// @Description: 处理完成后回调，用于上报耗时、失败数、延迟(e.Time到处理完成)
+// @param observe
+// @return EventMiddleware[T]
+func StreamMetrics[T any](observe func(e *Event[T], cost time.Duration, err error)) EventMiddleware[T] {
+	return func(next EventHandler[T]) EventHandler[T] {
+		return func(ctx context.Context, e *Event[T]) error {
+			start := time.Now()
+			err := next(ctx, e)
+			observe(e, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+/**
+ * redis
+ */
+
+type redisStreams struct{}
+
+func (redisStreams) add(ctx context.Context, stream, payload string, maxLen int64, minID string) (string, error) {
+	pipe := R().Pipeline()
+	id := pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLen,
+		Approx: true,
+		Values: []any{"payload", payload},
+	})
+	if minID != "" {
+		pipe.XTrimMinIDApprox(ctx, stream, minID, 0)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return id.Val(), nil
+}
+func (redisStreams) group(ctx context.Context, stream, group, start string) error {
+	err := R().XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+func (redisStreams) read(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]streamEntry, error) {
+	res, err := R().XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []streamEntry
+	for _, s := range res {
+		entries = append(entries, toStreamEntries(s.Messages)...)
+	}
+	return entries, nil
+}
+func (redisStreams) ack(ctx context.Context, stream, group string, ids ...string) error {
+	return R().XAck(ctx, stream, group, ids...).Err()
+}
+func (redisStreams) claim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]streamEntry, error) {
+	msgs, _, err := R().XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return toStreamEntries(msgs), nil
+}
+
+func toStreamEntries(msgs []redis.XMessage) []streamEntry {
+	entries := make([]streamEntry, 0, len(msgs))
+	for _, m := range msgs {
+		payload, _ := m.Values["payload"].(string)
+		entries = append(entries, streamEntry{ID: m.ID, Payload: payload})
+	}
+	return entries
+}
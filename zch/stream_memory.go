@@ -0,0 +1,199 @@
+package zch
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * 进程内的stream实现，同名的NewMemoryStream共享数据
+ */
+
+type streamID struct {
+	ms  int64
+	seq int64
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+func (id streamID) less(o streamID) bool {
+	return id.ms < o.ms || (id.ms == o.ms && id.seq < o.seq)
+}
+
+type memoryStreamEntry struct {
+	id      streamID
+	payload string
+}
+
+type memoryStreamPending struct {
+	entry     memoryStreamEntry
+	consumer  string
+	delivered time.Time
+}
+
+type memoryStreamGroup struct {
+	last    streamID
+	pending map[streamID]*memoryStreamPending
+}
+
+type memoryStream struct {
+	entries []memoryStreamEntry
+	last    streamID
+	groups  map[string]*memoryStreamGroup
+	notify  chan struct{}
+}
+
+type memoryStreamTable struct {
+	mu      sync.Mutex
+	streams map[string]*memoryStream
+}
+
+var memoryStreams = &memoryStreamTable{streams: make(map[string]*memoryStream)}
+
+func newMemoryStreams() *memoryStreamTable {
+	return memoryStreams
+}
+
+func (t *memoryStreamTable) get(stream string) *memoryStream {
+	s, ok := t.streams[stream]
+	if !ok {
+		s = &memoryStream{groups: make(map[string]*memoryStreamGroup), notify: make(chan struct{})}
+		t.streams[stream] = s
+	}
+	return s
+}
+
+func (t *memoryStreamTable) add(_ context.Context, stream, payload string, maxLen int64, minID string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(stream)
+	id := streamID{ms: time.Now().UnixMilli()}
+	if id.ms <= s.last.ms {
+		id = streamID{ms: s.last.ms, seq: s.last.seq + 1}
+	}
+	s.last = id
+	s.entries = append(s.entries, memoryStreamEntry{id: id, payload: payload})
+	if maxLen > 0 && int64(len(s.entries)) > maxLen {
+		s.entries = slices.Clone(s.entries[int64(len(s.entries))-maxLen:])
+	}
+	if minID != "" {
+		ms, _ := strconv.ParseInt(minID, 10, 64)
+		i := 0
+		for i < len(s.entries) && s.entries[i].id.ms < ms {
+			i++
+		}
+		s.entries = s.entries[i:]
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+	return id.String(), nil
+}
+
+func (t *memoryStreamTable) group(_ context.Context, stream, group, start string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(stream)
+	if _, ok := s.groups[group]; ok {
+		return nil
+	}
+	g := &memoryStreamGroup{pending: make(map[streamID]*memoryStreamPending)}
+	if start == "$" {
+		g.last = s.last
+	}
+	s.groups[group] = g
+	return nil
+}
+
+func (t *memoryStreamTable) read(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]streamEntry, error) {
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	for {
+		t.mu.Lock()
+		s := t.get(stream)
+		g, ok := s.groups[group]
+		if !ok {
+			t.mu.Unlock()
+			return nil, fmt.Errorf("NOGROUP no such consumer group %s", group)
+		}
+		var res []streamEntry
+		for _, e := range s.entries {
+			if int64(len(res)) >= count {
+				break
+			}
+			if g.last.less(e.id) {
+				g.last = e.id
+				g.pending[e.id] = &memoryStreamPending{entry: e, consumer: consumer, delivered: time.Now()}
+				res = append(res, streamEntry{ID: e.id.String(), Payload: e.payload})
+			}
+		}
+		notify := s.notify
+		t.mu.Unlock()
+		if len(res) > 0 {
+			return res, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case <-notify:
+		}
+	}
+}
+
+func (t *memoryStreamTable) ack(_ context.Context, stream, group string, ids ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, ok := t.get(stream).groups[group]
+	if !ok {
+		return nil
+	}
+	for _, id := range ids {
+		delete(g.pending, parseStreamID(id))
+	}
+	return nil
+}
+
+func (t *memoryStreamTable) claim(_ context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]streamEntry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, ok := t.get(stream).groups[group]
+	if !ok {
+		return nil, nil
+	}
+	var idle []*memoryStreamPending
+	for _, p := range g.pending {
+		if time.Since(p.delivered) >= minIdle {
+			idle = append(idle, p)
+		}
+	}
+	slices.SortFunc(idle, func(a, b *memoryStreamPending) int {
+		if a.entry.id.less(b.entry.id) {
+			return -1
+		}
+		return 1
+	})
+	var res []streamEntry
+	for _, p := range idle[:min(int64(len(idle)), count)] {
+		p.consumer = consumer
+		p.delivered = time.Now()
+		res = append(res, streamEntry{ID: p.entry.id.String(), Payload: p.entry.payload})
+	}
+	return res, nil
+}
+
+func parseStreamID(id string) streamID {
+	arr := strings.SplitN(id, "-", 2)
+	var sid streamID
+	sid.ms, _ = strconv.ParseInt(arr[0], 10, 64)
+	if len(arr) == 2 {
+		sid.seq, _ = strconv.ParseInt(arr[1], 10, 64)
+	}
+	return sid
+}
@@ -0,0 +1,94 @@
+package zch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type streamOrder struct {
+	Id int `json:"id"`
+}
+
+func TestStream(t *testing.T) {
+	testRedis(t)
+	backends := map[string]func(string, *StreamOptions) *Stream[streamOrder]{
+		"redis":  NewStream[streamOrder],
+		"memory": NewMemoryStream[streamOrder],
+	}
+	for name, newStream := range backends {
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &StreamOptions{Block: time.Millisecond * 50, ClaimIdle: time.Millisecond * 200}
+
+		// 两个服务各自收到全部事件，同一服务的两个实例分摊
+		var mu sync.Mutex
+		got := map[string]map[int]int{"billing": {}, "notify": {}}
+		var failed atomic.Bool
+		var metrics atomic.Int32
+		for _, group := range []string{"billing", "billing", "notify"} {
+			s := newStream("order", opts)
+			s.Use(StreamLogger[streamOrder](0), StreamMetrics(func(e *Event[streamOrder], cost time.Duration, err error) {
+				metrics.Add(1)
+			}))
+			ready := make(chan struct{})
+			go func() {
+				close(ready)
+				_ = s.Subscribe(ctx, group, func(ctx context.Context, e *Event[streamOrder]) error {
+					// 第一次处理3号事件失败，之后由pending重新领取
+					if group == "notify" && e.Payload.Id == 3 && failed.CompareAndSwap(false, true) {
+						return errors.New("fail once")
+					}
+					if e.Payload.Id == 4 && group == "billing" && !failed.Load() {
+						panic("should be recovered")
+					}
+					mu.Lock()
+					got[group][e.Payload.Id]++
+					mu.Unlock()
+					return nil
+				})
+			}()
+			<-ready
+		}
+		time.Sleep(time.Millisecond * 100)
+
+		pub := newStream("order", opts)
+		for i := 1; i <= 10; i++ {
+			if _, err := pub.Publish(ctx, streamOrder{Id: i}); err != nil {
+				t.Fatalf("%s publish: %v", name, err)
+			}
+		}
+		eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(got["billing"]) == 10 && len(got["notify"]) == 10
+		})
+		cancel()
+		mu.Lock()
+		for group, ids := range got {
+			for id, n := range ids {
+				if n != 1 {
+					t.Fatalf("%s group %s event %d handled %d times", name, group, id, n)
+				}
+			}
+		}
+		mu.Unlock()
+		if metrics.Load() < 20 {
+			t.Fatalf("%s metrics observed %d", name, metrics.Load())
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func TestMemoryStreamTrim(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStream[int]("trim", &StreamOptions{MaxLen: 3})
+	for i := 0; i < 10; i++ {
+		_, _ = s.Publish(ctx, i)
+	}
+	if n := len(memoryStreams.get(s.name).entries); n != 3 {
+		t.Fatalf("entries %d", n)
+	}
+}
@@ -0,0 +1,395 @@
+package zcron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/zohu/zgin/zants"
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+)
+
+/**
+ * 基于zch的分布式定时任务
+ *  - 每个执行点在redis中按单调递增的时间戳抢占，集群内只有一个实例执行
+ *  - 执行期间持有zch.Lock，上一次未结束时本次记为跳过，任务不会重叠
+ *  - 注册时按Missed处理停机期间错过的执行点
+ *  - 任务提交到zants池执行，需先zants.New
+ */
+
+type Missed string
+
+const (
+	MissedSkip Missed = "skip" // 跳过错过的执行点
+	MissedOnce Missed = "once" // 补跑最近的一次
+	MissedAll  Missed = "all"  // 逐个补跑，最多MaxCatchUp次
+)
+
+type Options struct {
+	Timezone    string `yaml:"timezone" note:"cron表达式的时区，默认本地时区"`
+	HistorySize int64  `yaml:"history_size" note:"每个任务保留的执行历史条数，默认50"`
+}
+
+func (o *Options) Validate() error {
+	o.HistorySize = zutil.FirstTruth(o.HistorySize, 50)
+	if o.Timezone != "" {
+		if _, err := time.LoadLocation(o.Timezone); err != nil {
+			return err
+		}
+	}
+	return validator.New().Struct(o)
+}
+
+type JobOptions struct {
+	Missed     Missed        // 错过执行点的处理，默认MissedSkip
+	MaxCatchUp int           // MissedAll时最多补跑次数，默认10
+	Timeout    time.Duration // 单次执行超时，0不限制
+	Local      bool          // 每个实例都执行，不抢占执行点
+}
+
+func (o *JobOptions) Validate() {
+	o.Missed = zutil.FirstTruth(o.Missed, MissedSkip)
+	o.MaxCatchUp = zutil.FirstTruth(o.MaxCatchUp, 10)
+}
+
+type Job func(ctx context.Context) error
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	fn       Job
+	opts     *JobOptions
+	next     atomic.Int64
+	running  atomic.Bool
+	cancel   context.CancelFunc
+}
+
+type Scheduler struct {
+	opts   *Options
+	loc    *time.Location
+	host   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+var scheduler *Scheduler
+
+// New
+// @Description: 初始化全局调度器，依赖zch和zants
+// @param options
+// @return *Scheduler
+func New(options *Options) *Scheduler {
+	options = zutil.FirstTruth(options, &Options{})
+	if err := options.Validate(); err != nil {
+		zlog.Fatalf("options is invalid: %v", err)
+		return nil
+	}
+	if scheduler == nil {
+		scheduler = newScheduler(options)
+	}
+	zlog.Infof("init zcron success")
+	return scheduler
+}
+
+func S() *Scheduler {
+	return scheduler
+}
+
+func newScheduler(options *Options) *Scheduler {
+	s := &Scheduler{
+		opts: options,
+		loc:  time.Local,
+		jobs: make(map[string]*job),
+	}
+	if options.Timezone != "" {
+		s.loc, _ = time.LoadLocation(options.Timezone)
+	}
+	host, _ := os.Hostname()
+	s.host = fmt.Sprintf("%s-%d", host, os.Getpid())
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Cron
+// @Description: 按cron表达式注册任务，同名任务会被替换
+// @receiver s
+// @param name 集群内唯一
+// @param spec 见Parse
+// @param fn
+// @param opts
+// @return error
+func (s *Scheduler) Cron(name, spec string, fn Job, opts ...*JobOptions) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now().In(s.loc)).IsZero() {
+		return fmt.Errorf("invalid spec %s: never fires", spec)
+	}
+	s.add(name, spec, schedule, fn, opts...)
+	return nil
+}
+
+// Every
+// @Description: 按固定间隔注册任务，执行点按间隔对齐
+// @receiver s
+// @param name 集群内唯一
+// @param d
+// @param fn
+// @param opts
+func (s *Scheduler) Every(name string, d time.Duration, fn Job, opts ...*JobOptions) {
+	s.add(name, "@every "+d.String(), Every(d), fn, opts...)
+}
+
+// Remove
+// @Description: 移除任务，不影响正在进行的执行
+// @receiver s
+// @param name
+func (s *Scheduler) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[name]; ok {
+		j.cancel()
+		delete(s.jobs, name)
+	}
+}
+
+// Close
+// @Description: 停止调度并等待执行中的任务结束，可注册到zgin.App.WithShutdown
+// @receiver s
+func (s *Scheduler) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) add(name, spec string, schedule Schedule, fn Job, opts ...*JobOptions) {
+	o := *zutil.FirstTruth(append(opts, &JobOptions{})[0], &JobOptions{})
+	o.Validate()
+	j := &job{
+		name:     name,
+		spec:     spec,
+		schedule: schedule,
+		fn:       fn,
+		opts:     &o,
+	}
+	var ctx context.Context
+	ctx, j.cancel = context.WithCancel(s.ctx)
+
+	s.mu.Lock()
+	if old, ok := s.jobs[name]; ok {
+		old.cancel()
+	}
+	s.jobs[name] = j
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.loop(ctx, j)
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	defer s.wg.Done()
+	if !j.opts.Local {
+		s.catchUp(ctx, j)
+	}
+	for {
+		next := j.schedule.Next(time.Now().In(s.loc))
+		if next.IsZero() {
+			zlog.Warnf("zcron %s has no next run, stopped", j.name)
+			return
+		}
+		j.next.Store(next.UnixMilli())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.fire(ctx, j, false, next)
+	}
+}
+
+// catchUp
+// @Description: 根据上次抢占到的执行点补跑停机期间错过的执行
+// @receiver s
+// @param ctx
+// @param j
+func (s *Scheduler) catchUp(ctx context.Context, j *job) {
+	if j.opts.Missed == MissedSkip {
+		return
+	}
+	last, err := lastTick(ctx, j.name)
+	if err != nil {
+		zlog.Warnf("zcron %s get last tick err: %v", j.name, err)
+		return
+	}
+	if last.IsZero() {
+		return
+	}
+	now := time.Now().In(s.loc)
+	var missed []time.Time
+	for t := j.schedule.Next(last.In(s.loc)); !t.IsZero() && t.Before(now); t = j.schedule.Next(t) {
+		missed = append(missed, t)
+		if j.opts.Missed == MissedOnce {
+			missed = missed[len(missed)-1:]
+		} else if len(missed) >= j.opts.MaxCatchUp {
+			break
+		}
+	}
+	s.fire(ctx, j, true, missed...)
+}
+
+// fire
+// @Description: 抢占执行点，成功的按顺序在zants中执行
+// @receiver s
+// @param ctx
+// @param j
+// @param catchUp
+// @param ticks
+func (s *Scheduler) fire(ctx context.Context, j *job, catchUp bool, ticks ...time.Time) {
+	var claimed []time.Time
+	for _, tick := range ticks {
+		if !j.opts.Local {
+			ok, err := claimTick(ctx, j.name, tick)
+			if err != nil {
+				zlog.Warnf("zcron %s claim %s err: %v", j.name, tick.Format(time.DateTime), err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+		}
+		claimed = append(claimed, tick)
+	}
+	if len(claimed) == 0 {
+		return
+	}
+	s.wg.Add(1)
+	err := zants.Submit(func() {
+		defer s.wg.Done()
+		for _, tick := range claimed {
+			s.run(j, &Run{Job: j.name, Tick: tick, Host: s.host, CatchUp: catchUp})
+		}
+	})
+	if err == nil {
+		return
+	}
+	// 提交失败时fn不会执行，需在此Done，否则Close会一直等待
+	s.wg.Done()
+	for _, tick := range claimed {
+		r := &Run{Job: j.name, Tick: tick, Host: s.host, CatchUp: catchUp, Start: time.Now(), Status: RunSkipped, Err: fmt.Sprintf("submit err: %v", err)}
+		if err := s.record(context.WithoutCancel(s.ctx), r); err != nil {
+			zlog.Warnf("zcron %s record err: %v", j.name, err)
+		}
+	}
+}
+
+func (s *Scheduler) run(j *job, r *Run) {
+	ctx := context.WithoutCancel(s.ctx)
+	r.Start = time.Now()
+	defer func() {
+		r.Cost = time.Since(r.Start)
+		if err := s.record(ctx, r); err != nil {
+			zlog.Warnf("zcron %s record err: %v", j.name, err)
+		}
+	}()
+	// Lock可重入，每次执行单独创建
+	lock := zutil.When(j.opts.Local, zch.NewMemoryLock, zch.NewLock)(zch.PrefixCron.Key(j.name), nil)
+	if ok, err := lock.TryLock(ctx, 0); err != nil || !ok {
+		r.Status = RunSkipped
+		r.Err = zutil.When(err != nil, fmt.Sprint(err), "previous run is still running")
+		return
+	}
+	defer func() {
+		_ = lock.Unlock(ctx)
+	}()
+	j.running.Store(true)
+	defer j.running.Store(false)
+
+	if j.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.opts.Timeout)
+		defer cancel()
+	}
+	if err := call(ctx, j.fn); err != nil {
+		r.Status = RunFailed
+		r.Err = err.Error()
+		zlog.Warnf("zcron %s run %s err: %v", j.name, r.Tick.Format(time.DateTime), err)
+		return
+	}
+	r.Status = RunSuccess
+}
+
+func call(ctx context.Context, fn Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			zlog.Errorf("zcron panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// Status
+// @Description: 所有任务的状态，按名称排序
+// @receiver s
+// @param ctx
+// @return []*JobStatus
+// @return error
+func (s *Scheduler) Status(ctx context.Context) ([]*JobStatus, error) {
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(jobs, func(a, b *job) int {
+		return strings.Compare(a.name, b.name)
+	})
+	res := make([]*JobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		st, err := status(ctx, j.name)
+		if err != nil {
+			return nil, err
+		}
+		st.Spec = j.spec
+		st.Next = time.UnixMilli(j.next.Load()).In(s.loc)
+		st.Running = j.running.Load()
+		res = append(res, st)
+	}
+	return res, nil
+}
+
+// History
+// @Description: 任务最近的执行记录，新的在前
+// @receiver s
+// @param ctx
+// @param name
+// @param n 最多返回条数，不超过Options.HistorySize
+// @return []*Run
+// @return error
+func (s *Scheduler) History(ctx context.Context, name string, n int64) ([]*Run, error) {
+	if n <= 0 {
+		return nil, errors.New("n must be greater than 0")
+	}
+	return history(ctx, name, n)
+}
+
+func (s *Scheduler) record(ctx context.Context, r *Run) error {
+	return record(ctx, r, s.opts.HistorySize)
+}
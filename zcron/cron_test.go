@@ -0,0 +1,158 @@
+package zcron
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zohu/zgin/zants"
+	"github.com/zohu/zgin/zch"
+)
+
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	var err error
+	if mr, err = miniredis.Run(); err != nil {
+		panic(err)
+	}
+	zch.NewL2(&zch.Options{Addrs: []string{mr.Addr()}, Invalidation: zch.InvalidationNone})
+	zants.New(nil)
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{"*/5 * * * *", "0 */5 * * * *", "@daily", "CRON_TZ=Asia/Shanghai 0 3 * * *", "@every 1m"} {
+		if _, err := Parse(spec); err != nil {
+			t.Fatalf("parse %s: %v", spec, err)
+		}
+	}
+	if _, err := Parse("* *"); err == nil {
+		t.Fatal("invalid spec parsed")
+	}
+	s := Every(time.Minute)
+	now := time.Date(2026, 1, 1, 10, 3, 20, 0, time.UTC)
+	if next := s.Next(now); !next.Equal(time.Date(2026, 1, 1, 10, 4, 0, 0, time.UTC)) {
+		t.Fatalf("every not aligned: %s", next)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	mr.FlushAll()
+	ctx := context.Background()
+
+	// 两个实例注册同一任务，每个执行点只执行一次
+	var calls atomic.Int64
+	fn := func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	}
+	a, b := newScheduler(&Options{HistorySize: 50}), newScheduler(&Options{HistorySize: 50})
+	a.Every("once", time.Millisecond*200, fn)
+	b.Every("once", time.Millisecond*200, fn)
+
+	// 失败和panic计入统计
+	var n atomic.Int64
+	_ = a.Cron("fail", "@every 200ms", func(ctx context.Context) error {
+		if n.Add(1)%2 == 0 {
+			panic("boom")
+		}
+		return errors.New("failed")
+	})
+
+	// 上次执行未结束时跳过
+	a.Every("slow", time.Millisecond*100, func(ctx context.Context) error {
+		time.Sleep(time.Millisecond * 250)
+		return nil
+	}, &JobOptions{Local: true})
+
+	time.Sleep(time.Millisecond * 1100)
+	a.Close()
+	b.Close()
+
+	runs, err := a.History(ctx, "once", 100)
+	if err != nil || len(runs) < 4 || int64(len(runs)) != calls.Load() {
+		t.Fatalf("history: %v %d runs, %d calls", err, len(runs), calls.Load())
+	}
+	seen := make(map[int64]bool)
+	for i, r := range runs {
+		if seen[r.Tick.UnixMilli()] || (i > 0 && !r.Tick.Before(runs[i-1].Tick)) {
+			t.Fatalf("tick %s ran twice or history out of order", r.Tick)
+		}
+		seen[r.Tick.UnixMilli()] = true
+	}
+
+	st, err := a.Status(ctx)
+	if err != nil || len(st) != 3 {
+		t.Fatalf("status: %v %d", err, len(st))
+	}
+	if st[0].Name != "fail" || st[0].Failed < 4 || st[0].Success != 0 || st[0].Last == nil || st[0].Last.Status != RunFailed {
+		t.Fatalf("fail status: %+v", st[0])
+	}
+	if st[1].Name != "once" || st[1].Success != calls.Load() || st[1].Next.IsZero() {
+		t.Fatalf("once status: %+v", st[1])
+	}
+	if st[2].Skipped == 0 || st[2].Success == 0 {
+		t.Fatalf("slow status: %+v", st[2])
+	}
+}
+
+func TestSchedulerMissed(t *testing.T) {
+	mr.FlushAll()
+	ctx := context.Background()
+
+	last := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for name, want := range map[Missed]int64{MissedSkip: 0, MissedOnce: 1, MissedAll: 3} {
+		zch.R().Set(ctx, zch.PrefixCron.Key("missed", string(name), "tick"), strconv.FormatInt(last.UnixMilli(), 10), 0)
+		sc := newScheduler(&Options{HistorySize: 50})
+		sc.Every("missed:"+string(name), time.Second*10, func(ctx context.Context) error {
+			return nil
+		}, &JobOptions{Missed: name, MaxCatchUp: 3})
+		time.Sleep(time.Millisecond * 200)
+		sc.Close()
+
+		// 只统计补跑，等待期间可能正好赶上一次正常调度
+		runs, _ := sc.History(ctx, "missed:"+string(name), 10)
+		var got int64
+		for _, r := range runs {
+			if r.Status != RunSuccess {
+				t.Fatalf("%s: %+v", name, r)
+			}
+			if r.CatchUp {
+				got++
+			}
+		}
+		if got != want {
+			t.Fatalf("%s: want %d catch up runs, got %d", name, want, got)
+		}
+	}
+}
+
+type never struct{}
+
+func (never) Next(time.Time) time.Time { return time.Time{} }
+
+func TestSchedulerNeverFires(t *testing.T) {
+	s := newScheduler(&Options{})
+	defer s.Close()
+	if err := s.Cron("feb30", "0 0 30 2 *", func(ctx context.Context) error { return nil }); err == nil {
+		t.Fatal("spec that never fires accepted")
+	}
+	// 没有下次执行时间时退出，不会空转
+	var calls atomic.Int64
+	s.add("never", "never", never{}, func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	}, &JobOptions{Local: true})
+	time.Sleep(time.Millisecond * 100)
+	if calls.Load() != 0 {
+		t.Fatalf("never job fired %d times", calls.Load())
+	}
+}
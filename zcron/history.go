@@ -0,0 +1,136 @@
+package zcron
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+	"github.com/zohu/zgin/zch"
+)
+
+/**
+ * redis存储：
+ *  - cron:{name}:tick 最近一次被抢占的执行点(毫秒)，只增不减
+ *  - cron:{name}:history 执行历史，list，新的在前
+ *  - cron:{name}:stats 执行统计，hash
+ */
+
+type RunStatus string
+
+const (
+	RunSuccess RunStatus = "success"
+	RunFailed  RunStatus = "failed"
+	RunSkipped RunStatus = "skipped"
+)
+
+type Run struct {
+	Job     string        `json:"job" note:"任务名"`
+	Tick    time.Time     `json:"tick" note:"执行点"`
+	Start   time.Time     `json:"start" note:"开始时间"`
+	Cost    time.Duration `json:"cost" note:"耗时"`
+	Status  RunStatus     `json:"status" note:"结果"`
+	Err     string        `json:"err,omitempty" note:"错误信息"`
+	Host    string        `json:"host" note:"执行实例"`
+	CatchUp bool          `json:"catch_up" note:"是否补跑"`
+}
+
+type JobStatus struct {
+	Name     string    `json:"name" note:"任务名"`
+	Spec     string    `json:"spec" note:"执行计划"`
+	Next     time.Time `json:"next" note:"下次执行点"`
+	Running  bool      `json:"running" note:"本实例是否执行中"`
+	Success  int64     `json:"success" note:"成功次数"`
+	Failed   int64     `json:"failed" note:"失败次数"`
+	Skipped  int64     `json:"skipped" note:"跳过次数"`
+	Last     *Run      `json:"last,omitempty" note:"最近一次执行"`
+	LastTick time.Time `json:"last_tick" note:"集群内最近被抢占的执行点"`
+}
+
+var claimScript = redis.NewScript(`
+local last = tonumber(redis.call('GET', KEYS[1]) or '0')
+if last >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+// claimTick
+// @Description: 抢占执行点，执行点不大于已抢占的值时失败
+// @param ctx
+// @param name
+// @param tick
+// @return bool
+// @return error
+func claimTick(ctx context.Context, name string, tick time.Time) (bool, error) {
+	n, err := claimScript.Run(ctx, zch.R(), []string{zch.PrefixCron.Key(name, "tick")}, tick.UnixMilli()).Int()
+	return n == 1, err
+}
+
+func lastTick(ctx context.Context, name string) (time.Time, error) {
+	ms, err := zch.R().Get(ctx, zch.PrefixCron.Key(name, "tick")).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func record(ctx context.Context, r *Run, size int64) error {
+	data, err := sonic.MarshalString(r)
+	if err != nil {
+		return err
+	}
+	key := zch.PrefixCron.Key(r.Job, "history")
+	pipe := zch.R().Pipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, size-1)
+	pipe.HIncrBy(ctx, zch.PrefixCron.Key(r.Job, "stats"), string(r.Status), 1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func history(ctx context.Context, name string, n int64) ([]*Run, error) {
+	arr, err := zch.R().LRange(ctx, zch.PrefixCron.Key(name, "history"), 0, n-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*Run, 0, len(arr))
+	for _, v := range arr {
+		var r Run
+		if err := sonic.UnmarshalString(v, &r); err != nil {
+			continue
+		}
+		runs = append(runs, &r)
+	}
+	return runs, nil
+}
+
+func status(ctx context.Context, name string) (*JobStatus, error) {
+	pipe := zch.R().Pipeline()
+	stats := pipe.HGetAll(ctx, zch.PrefixCron.Key(name, "stats"))
+	tick := pipe.Get(ctx, zch.PrefixCron.Key(name, "tick"))
+	last := pipe.LIndex(ctx, zch.PrefixCron.Key(name, "history"), 0)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	st := &JobStatus{Name: name}
+	st.Success, _ = strconv.ParseInt(stats.Val()[string(RunSuccess)], 10, 64)
+	st.Failed, _ = strconv.ParseInt(stats.Val()[string(RunFailed)], 10, 64)
+	st.Skipped, _ = strconv.ParseInt(stats.Val()[string(RunSkipped)], 10, 64)
+	if ms, err := tick.Int64(); err == nil {
+		st.LastTick = time.UnixMilli(ms)
+	}
+	if v := last.Val(); v != "" {
+		var r Run
+		if sonic.UnmarshalString(v, &r) == nil {
+			st.Last = &r
+		}
+	}
+	return st, nil
+}
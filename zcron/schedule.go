@@ -0,0 +1,51 @@
+package zcron
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule
+// @Description: 计算下一次执行时间
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+var parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Parse
+// @Description: 解析cron表达式，支持可选的秒字段、@daily等描述符、CRON_TZ=前缀；@every按间隔对齐
+// @param spec
+// @return Schedule
+// @return error
+func Parse(spec string) (Schedule, error) {
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("invalid spec %s: %w", spec, err)
+		}
+		return Every(interval), nil
+	}
+	s, err := parser.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %w", spec, err)
+	}
+	return s, nil
+}
+
+type every time.Duration
+
+// Every
+// @Description: 固定间隔，按间隔的整数倍对齐，所有实例算出的执行时间一致
+// @param d 不足1ms按1ms
+// @return Schedule
+func Every(d time.Duration) Schedule {
+	return every(max(d, time.Millisecond))
+}
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Truncate(time.Duration(e)).Add(time.Duration(e))
+}
@@ -0,0 +1,629 @@
+package zdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zohu/zgin/zch"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * gorm缓存插件，缓存在zch.L2
+ *  - 主键查询: 模型实现Cacheable时，First/Take/Find按单个主键查询的结果缓存为 db:{table}:{gen}:{pk}
+ *  - 查询结果: 通过CacheQuery显式开启，按SQL缓存，表有写入或标签失效时过期
+ * 更新、删除及冲突时更新的新增后删除对应主键的缓存，无法确定主键时整表换代；事务中的失效在提交后执行，回滚则丢弃
+ * 缓存的是字段写入数据库的值，读取时按扫描数据库的方式还原，不受json标签影响
+ * 原生SQL(Exec)的写入不会自动失效，需调用CacheInvalidate
+ * 配置了副本时，未命中缓存的查询从主库读取回填，不会缓存副本上尚未同步的旧数据
+ */
+
+// Cacheable
+// @Description: 实现该接口的模型缓存主键查询，CacheTTL<=0时不缓存
+type Cacheable interface {
+	CacheTTL() time.Duration
+}
+
+const (
+	cacheQuerySetting = "zdb:cache:query"
+	cacheGenPrimary   = "pk"
+	cacheGenQuery     = "query"
+)
+
+type queryCache struct {
+	ttl  time.Duration
+	tags []string
+}
+
+type invalidation struct {
+	keys []string
+	gens []string
+}
+
+type cachePlugin struct{}
+
+// NewCachePlugin
+// @Description: gorm缓存插件，Options.Cache开启时自动注册，需先初始化zch
+// @return gorm.Plugin
+func NewCachePlugin() gorm.Plugin {
+	return &cachePlugin{}
+}
+
+func (p *cachePlugin) Name() string {
+	return "zdb:cache"
+}
+
+func (p *cachePlugin) Initialize(db *gorm.DB) error {
+	pool := &cachePool{ConnPool: db.ConnPool}
+	db.ConnPool = pool
+	db.Statement.ConnPool = pool
+	if err := db.Callback().Query().Replace("gorm:query", p.query); err != nil {
+		return err
+	}
+	if err := db.Callback().Create().After("gorm:create").Register("zdb:cache_create", p.written(false)); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("zdb:cache_update", p.written(true)); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("zdb:cache_delete", p.written(true))
+}
+
+// CacheQuery
+// @Description: 缓存本次查询的结果，表有写入或任一标签失效时过期；事务中不缓存
+// @param ttl
+// @param tags 自定义失效标签，通过CacheInvalidate失效
+// @return func(*gorm.DB) *gorm.DB 用于db.Scopes
+func CacheQuery(ttl time.Duration, tags ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.InstanceSet(cacheQuerySetting, &queryCache{ttl: ttl, tags: tags})
+	}
+}
+
+// CacheInvalidate
+// @Description: 失效标签下的查询缓存，标签为表名时同时失效该表的主键缓存
+// @param ctx
+// @param tags
+// @return error
+func CacheInvalidate(ctx context.Context, tags ...string) error {
+	inv := &invalidation{}
+	for _, tag := range tags {
+		inv.gens = append(inv.gens, cacheGenKey(tag, cacheGenQuery), cacheGenKey(tag, cacheGenPrimary))
+	}
+	return inv.apply(ctx)
+}
+
+func (p *cachePlugin) query(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || db.DryRun || zch.L() == nil || stmt.Schema == nil || inTx(stmt) {
+		callbacks.Query(db)
+		return
+	}
+	if v, ok := db.InstanceGet(cacheQuerySetting); ok {
+		p.cacheQuery(db, v.(*queryCache))
+		return
+	}
+	key, ttl, ok := primaryCacheKey(stmt)
+	if !ok {
+		callbacks.Query(db)
+		return
+	}
+	if data, err := zch.L().Get(stmt.Context, key); err == nil && decodeRows(db, data) == nil {
+		return
+	}
+	fillFromPrimary(db)
+	callbacks.Query(db)
+	if db.Error == nil && db.RowsAffected == 1 {
+		if data, err := encodeRows(stmt); err == nil {
+			if err = zch.L().Set(stmt.Context, key, data, ttl); err != nil {
+				zlog.Warnf("zdb cache %s err: %v", key, err)
+			}
+		}
+	}
+}
+
+func (p *cachePlugin) cacheQuery(db *gorm.DB, qc *queryCache) {
+	stmt := db.Statement
+	if qc.ttl <= 0 || len(stmt.Preloads) > 0 || len(stmt.Joins) > 0 || !modelDest(stmt) {
+		callbacks.Query(db)
+		return
+	}
+	callbacks.BuildQuerySQL(db)
+	if db.Error != nil {
+		return
+	}
+	tags := append([]string{stmt.Table}, qc.tags...)
+	parts := []string{db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...)}
+	for _, tag := range tags {
+		parts = append(parts, tag, cacheGeneration(stmt.Context, cacheGenKey(tag, cacheGenQuery)))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	key := zch.PrefixDBCache.Key("@query", hex.EncodeToString(sum[:16]))
+	if data, err := zch.L().Get(stmt.Context, key); err == nil && decodeRows(db, data) == nil {
+		return
+	}
+	fillFromPrimary(db)
+	callbacks.Query(db)
+	if db.Error == nil {
+		if data, err := encodeRows(stmt); err == nil {
+			if err = zch.L().Set(stmt.Context, key, data, qc.ttl); err != nil {
+				zlog.Warnf("zdb cache %s err: %v", key, err)
+			}
+		}
+	}
+}
+
+// fillFromPrimary
+// @Description: 未命中缓存时从主库读取回填，副本有复制延迟，读到的旧数据会在缓存中保留到过期
+// @param db
+func fillFromPrimary(db *gorm.DB) {
+	db.Statement.ConnPool = db.ConnPool
+}
+
+// written
+// @Description: 写入后失效缓存
+// @receiver p
+// @param primary 是否涉及已有记录，新增只失效查询缓存，带ON CONFLICT更新的新增视为涉及已有记录
+// @return func(*gorm.DB)
+func (p *cachePlugin) written(primary bool) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		if db.Error != nil || db.DryRun || zch.L() == nil || stmt.Schema == nil {
+			return
+		}
+		inv := &invalidation{gens: []string{cacheGenKey(stmt.Table, cacheGenQuery)}}
+		find := writtenPrimaryValues
+		if !primary {
+			primary, find = upserted(stmt), upsertPrimaryValues
+		}
+		if primary && cacheTTL(stmt) > 0 {
+			if values, ok := find(stmt); ok {
+				gen := cacheGeneration(stmt.Context, cacheGenKey(stmt.Table, cacheGenPrimary))
+				for _, v := range values {
+					if pk, ok := primaryString(v); ok {
+						inv.keys = append(inv.keys, zch.PrefixDBCache.Key(stmt.Table, gen, pk))
+					}
+				}
+			} else {
+				inv.gens = append(inv.gens, cacheGenKey(stmt.Table, cacheGenPrimary))
+			}
+		}
+		if tx, ok := stmt.ConnPool.(*cacheTx); ok {
+			tx.add(inv)
+			return
+		}
+		if err := inv.apply(stmt.Context); err != nil {
+			zlog.Warnf("zdb cache invalidate %s err: %v", stmt.Table, err)
+		}
+	}
+}
+
+func (inv *invalidation) apply(ctx context.Context) error {
+	if err := zch.L().Del(ctx, inv.keys...); err != nil {
+		return err
+	}
+	for _, gen := range inv.gens {
+		if err := zch.L().Set(ctx, gen, zutil.RandomStr(8), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cacheGenKey(name, kind string) string {
+	return zch.PrefixDBCache.Key("@gen", name, kind)
+}
+
+// cacheGeneration
+// @Description: 当前代，换代后旧的缓存不再命中
+// @param ctx
+// @param key
+// @return string
+func cacheGeneration(ctx context.Context, key string) string {
+	if v, err := zch.L().Get(ctx, key); err == nil {
+		return v
+	}
+	return "0"
+}
+
+func inTx(stmt *gorm.Statement) bool {
+	_, ok := stmt.ConnPool.(*cacheTx)
+	return ok
+}
+
+func cacheTTL(stmt *gorm.Statement) time.Duration {
+	if len(stmt.Schema.PrimaryFields) != 1 {
+		return 0
+	}
+	if c, ok := reflect.New(stmt.Schema.ModelType).Interface().(Cacheable); ok {
+		return c.CacheTTL()
+	}
+	return 0
+}
+
+// primaryCacheKey
+// @Description: 只有按单个主键查询整条记录时才使用主键缓存
+// @param stmt
+// @return string
+// @return time.Duration
+// @return bool
+func primaryCacheKey(stmt *gorm.Statement) (string, time.Duration, bool) {
+	ttl := cacheTTL(stmt)
+	if ttl <= 0 || stmt.SQL.Len() > 0 || stmt.Unscoped || stmt.Distinct || stmt.Table != stmt.Schema.Table ||
+		len(stmt.Selects)+len(stmt.Omits)+len(stmt.Joins)+len(stmt.Preloads) > 0 {
+		return "", 0, false
+	}
+	rv := stmt.ReflectValue
+	if rv.Kind() != reflect.Struct || rv.Type() != stmt.Schema.ModelType {
+		return "", 0, false
+	}
+	var pk any
+	for name, c := range stmt.Clauses {
+		switch name {
+		case "WHERE":
+			where, ok := c.Expression.(clause.Where)
+			if !ok || len(where.Exprs) != 1 {
+				return "", 0, false
+			}
+			values, ok := primaryValues(stmt, where.Exprs[0])
+			if !ok || len(values) != 1 {
+				return "", 0, false
+			}
+			pk = values[0]
+		case "ORDER BY":
+			order, ok := c.Expression.(clause.OrderBy)
+			if !ok || order.Expression != nil {
+				return "", 0, false
+			}
+			for _, col := range order.Columns {
+				if !isPrimary(stmt, col.Column) {
+					return "", 0, false
+				}
+			}
+		case "LIMIT":
+			limit, ok := c.Expression.(clause.Limit)
+			if !ok || limit.Offset != 0 || (limit.Limit != nil && *limit.Limit < 1) {
+				return "", 0, false
+			}
+		default:
+			return "", 0, false
+		}
+	}
+	if v, zero := stmt.Schema.PrimaryFields[0].ValueOf(stmt.Context, rv); !zero {
+		if pk != nil {
+			return "", 0, false
+		}
+		pk = v
+	}
+	s, ok := primaryString(pk)
+	if !ok {
+		return "", 0, false
+	}
+	gen := cacheGeneration(stmt.Context, cacheGenKey(stmt.Table, cacheGenPrimary))
+	return zch.PrefixDBCache.Key(stmt.Table, gen, s), ttl, true
+}
+
+// writtenPrimaryValues
+// @Description: 写入涉及的主键，AND条件中任一主键条件即可限定范围，有OR或没有主键条件时无法确定
+// @param stmt
+// @return []any
+// @return bool
+func writtenPrimaryValues(stmt *gorm.Statement) ([]any, bool) {
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return nil, false
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return nil, false
+	}
+	var res []any
+	found := false
+	for _, expr := range where.Exprs {
+		switch expr.(type) {
+		case clause.OrConditions, *clause.OrConditions:
+			return nil, false
+		}
+		if values, ok := primaryValues(stmt, expr); ok {
+			res = append(res, values...)
+			found = true
+		}
+	}
+	return res, found
+}
+
+// upserted
+// @Description: 是否为冲突时更新的新增，会改写已有记录
+// @param stmt
+// @return bool
+func upserted(stmt *gorm.Statement) bool {
+	c, ok := stmt.Clauses["ON CONFLICT"]
+	if !ok {
+		return false
+	}
+	oc, ok := c.Expression.(clause.OnConflict)
+	return ok && !oc.DoNothing
+}
+
+// upsertPrimaryValues
+// @Description: 冲突列为主键时各行的主键即可能被更新的记录，其他冲突列无法确定被更新的记录
+// @param stmt
+// @return []any
+// @return bool
+func upsertPrimaryValues(stmt *gorm.Statement) ([]any, bool) {
+	oc := stmt.Clauses["ON CONFLICT"].Expression.(clause.OnConflict)
+	if len(stmt.Schema.PrimaryFields) != 1 || len(oc.Columns) != 1 || !isPrimary(stmt, oc.Columns[0]) {
+		return nil, false
+	}
+	field := stmt.Schema.PrimaryFields[0]
+	var res []any
+	collect := func(rv reflect.Value) bool {
+		v, zero := field.ValueOf(stmt.Context, reflect.Indirect(rv))
+		res = append(res, v)
+		return !zero
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		if !collect(rv) {
+			return nil, false
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if !collect(rv.Index(i)) {
+				return nil, false
+			}
+		}
+	default:
+		return nil, false
+	}
+	return res, true
+}
+
+func primaryValues(stmt *gorm.Statement, expr clause.Expression) ([]any, bool) {
+	switch e := expr.(type) {
+	case clause.Eq:
+		if isPrimary(stmt, e.Column) {
+			return []any{e.Value}, true
+		}
+	case clause.IN:
+		if isPrimary(stmt, e.Column) {
+			return e.Values, true
+		}
+	case clause.AndConditions:
+		if len(e.Exprs) == 1 {
+			return primaryValues(stmt, e.Exprs[0])
+		}
+	}
+	return nil, false
+}
+
+func isPrimary(stmt *gorm.Statement, col any) bool {
+	field := stmt.Schema.PrimaryFields[0]
+	switch c := col.(type) {
+	case string:
+		return c == field.DBName
+	case clause.Column:
+		return !c.Raw && (c.Name == clause.PrimaryKey || c.Name == field.DBName) &&
+			(c.Table == "" || c.Table == clause.CurrentTable || c.Table == stmt.Table)
+	}
+	return false
+}
+
+func primaryString(v any) (string, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.String:
+		s := fmt.Sprint(rv.Interface())
+		return s, s != ""
+	}
+	return "", false
+}
+
+// modelDest
+// @Description: 查询结果是模型本身(或其切片)时才能按字段缓存
+// @param stmt
+// @return bool
+func modelDest(stmt *gorm.Statement) bool {
+	if !stmt.ReflectValue.IsValid() {
+		return false
+	}
+	t := stmt.ReflectValue.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t != stmt.Schema.ModelType {
+		return false
+	}
+	for _, f := range stmt.Schema.Fields {
+		if f.Serializer != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeRows(stmt *gorm.Statement) (string, error) {
+	if !modelDest(stmt) {
+		return "", fmt.Errorf("unsupported dest %s", stmt.ReflectValue.Type())
+	}
+	var rows []map[string]any
+	rv := stmt.ReflectValue
+	if rv.Kind() == reflect.Struct {
+		row, err := encodeRow(stmt, rv)
+		if err != nil {
+			return "", err
+		}
+		rows = append(rows, row)
+	} else {
+		for i := 0; i < rv.Len(); i++ {
+			row, err := encodeRow(stmt, reflect.Indirect(rv.Index(i)))
+			if err != nil {
+				return "", err
+			}
+			rows = append(rows, row)
+		}
+	}
+	data, err := msgpack.Marshal(rows)
+	return string(data), err
+}
+
+func encodeRow(stmt *gorm.Statement, rv reflect.Value) (map[string]any, error) {
+	row := make(map[string]any, len(stmt.Schema.DBNames))
+	for _, f := range stmt.Schema.Fields {
+		if f.DBName == "" {
+			continue
+		}
+		v, _ := f.ValueOf(stmt.Context, rv)
+		dv, err := driver.DefaultParameterConverter.ConvertValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		row[f.DBName] = dv
+	}
+	return row, nil
+}
+
+func decodeRows(db *gorm.DB, data string) error {
+	stmt := db.Statement
+	if !modelDest(stmt) {
+		return fmt.Errorf("unsupported dest %s", stmt.ReflectValue.Type())
+	}
+	var rows []map[string]any
+	dec := msgpack.NewDecoder(strings.NewReader(data))
+	dec.UseLooseInterfaceDecoding(true)
+	if err := dec.Decode(&rows); err != nil {
+		return err
+	}
+	rv := stmt.ReflectValue
+	if rv.Kind() == reflect.Struct {
+		if len(rows) != 1 {
+			return fmt.Errorf("want 1 row, got %d", len(rows))
+		}
+		if err := decodeRow(stmt, rv, rows[0]); err != nil {
+			return err
+		}
+	} else {
+		isPtr := rv.Type().Elem().Kind() == reflect.Pointer
+		res := reflect.MakeSlice(rv.Type(), 0, len(rows))
+		for _, row := range rows {
+			e := reflect.New(stmt.Schema.ModelType)
+			if err := decodeRow(stmt, e.Elem(), row); err != nil {
+				return err
+			}
+			res = reflect.Append(res, zutil.When(isPtr, e, e.Elem()))
+		}
+		rv.Set(res)
+	}
+	db.RowsAffected = int64(len(rows))
+	return nil
+}
+
+func decodeRow(stmt *gorm.Statement, rv reflect.Value, row map[string]any) error {
+	for _, f := range stmt.Schema.Fields {
+		if v, ok := row[f.DBName]; ok && f.DBName != "" {
+			if err := f.Set(stmt.Context, rv, v); err != nil {
+				return fmt.Errorf("field %s: %w", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// cachePool
+// @Description: 包装连接池，开启的事务在提交后执行缓存失效
+type cachePool struct {
+	gorm.ConnPool
+}
+
+func (p *cachePool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var tx gorm.ConnPool
+	var err error
+	switch b := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		tx, err = b.BeginTx(ctx, opts)
+	case gorm.ConnPoolBeginner:
+		tx, err = b.BeginTx(ctx, opts)
+	default:
+		err = gorm.ErrInvalidTransaction
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cacheTx{ConnPool: tx, ctx: context.WithoutCancel(ctx)}, nil
+}
+
+func (p *cachePool) GetDBConn() (*sql.DB, error) {
+	if db, ok := p.ConnPool.(*sql.DB); ok {
+		return db, nil
+	}
+	if c, ok := p.ConnPool.(gorm.GetDBConnector); ok {
+		return c.GetDBConn()
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+type cacheTx struct {
+	gorm.ConnPool
+	ctx     context.Context
+	mu      sync.Mutex
+	pending []*invalidation
+}
+
+func (t *cacheTx) add(inv *invalidation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, inv)
+}
+
+func (t *cacheTx) take() []*invalidation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+func (t *cacheTx) Commit() error {
+	committer, ok := t.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	if err := committer.Commit(); err != nil {
+		return err
+	}
+	for _, inv := range t.take() {
+		if err := inv.apply(t.ctx); err != nil {
+			zlog.Warnf("zdb cache invalidate after commit err: %v", err)
+		}
+	}
+	return nil
+}
+
+func (t *cacheTx) Rollback() error {
+	t.take()
+	committer, ok := t.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	return committer.Rollback()
+}
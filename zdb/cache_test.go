@@ -0,0 +1,222 @@
+package zdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zohu/zgin/zch"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type cacheUser struct {
+	ID        int64
+	Name      string
+	Password  string `json:"-"`
+	CreatedAt time.Time
+	DeletedAt gorm.DeletedAt
+}
+
+func (cacheUser) CacheTTL() time.Duration {
+	return time.Minute
+}
+
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	var err error
+	if mr, err = miniredis.Run(); err != nil {
+		panic(err)
+	}
+	zch.NewL2(&zch.Options{Addrs: []string{mr.Addr()}, Invalidation: zch.InvalidationNone})
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
+
+func TestCachePlugin(t *testing.T) {
+	mr.FlushAll()
+	zch.L().FlushMemory()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := db.DB()
+	d.SetMaxOpenConns(1)
+	defer d.Close()
+	if err = db.Use(NewCachePlugin()); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&cacheUser{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	first := func() *cacheUser {
+		var u cacheUser
+		if err := db.First(&u, 1).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			t.Fatal(err)
+		}
+		return &u
+	}
+	u := &cacheUser{ID: 1, Name: "alice", Password: "secret"}
+	if err = db.Create(u).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 原生SQL不经过插件，读到旧值说明命中缓存
+	first()
+	db.Exec("UPDATE cache_user SET name = 'raw' WHERE id = 1")
+	if got := first(); got.Name != "alice" || got.Password != "secret" || !got.CreatedAt.Equal(u.CreatedAt) {
+		t.Fatalf("cache miss: %+v", got)
+	}
+	if err = db.Model(u).Update("name", "bob").Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := first(); got.Name != "bob" {
+		t.Fatalf("update not invalidated: %+v", got)
+	}
+	// 无法确定主键的批量更新整表换代
+	db.Model(&cacheUser{}).Where("name = ?", "bob").Update("name", "carol")
+	if got := first(); got.Name != "carol" {
+		t.Fatalf("bulk update not invalidated: %+v", got)
+	}
+
+	// 事务提交后才失效，回滚不失效
+	key := zch.PrefixDBCache.Key("cache_user", cacheGeneration(ctx, cacheGenKey("cache_user", cacheGenPrimary)), "1")
+	tx := db.Begin()
+	tx.Model(u).Update("name", "dave")
+	if zch.R().Exists(ctx, key).Val() != 1 {
+		t.Fatal("invalidated before commit")
+	}
+	tx.Rollback()
+	if zch.R().Exists(ctx, key).Val() != 1 {
+		t.Fatal("invalidated after rollback")
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return tx.Save(&cacheUser{ID: 1, Name: "erin", CreatedAt: u.CreatedAt}).Error
+	})
+	if err != nil || zch.R().Exists(ctx, key).Val() != 0 {
+		t.Fatalf("not invalidated after commit: %v", err)
+	}
+	if got := first(); got.Name != "erin" {
+		t.Fatalf("commit: %+v", got)
+	}
+
+	// 冲突时更新的新增改写已有记录，按主键冲突失效对应主键，其他冲突列整表换代
+	err = db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "id"}}, DoUpdates: clause.AssignmentColumns([]string{"name"})}).
+		Create(&cacheUser{ID: 1, Name: "frank"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := first(); got.Name != "frank" {
+		t.Fatalf("upsert not invalidated: %+v", got)
+	}
+	err = db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&cacheUser{ID: 1, Name: "gina", CreatedAt: u.CreatedAt}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := first(); got.Name != "gina" {
+		t.Fatalf("upsert all not invalidated: %+v", got)
+	}
+	// DoNothing不改写已有记录，缓存保持
+	db.Clauses(clause.OnConflict{DoNothing: true}).Create(&cacheUser{ID: 1, Name: "hank"})
+	if got := first(); got.Name != "gina" {
+		t.Fatalf("do nothing: %+v", got)
+	}
+
+	if err = db.Delete(&cacheUser{}, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := first(); got != nil {
+		t.Fatalf("delete not invalidated: %+v", got)
+	}
+
+	// 查询结果缓存
+	list := func() int {
+		var users []*cacheUser
+		if err := db.Scopes(CacheQuery(time.Minute, "users")).Where("id > ?", 1).Find(&users).Error; err != nil {
+			t.Fatal(err)
+		}
+		return len(users)
+	}
+	db.Create(&cacheUser{ID: 2, Name: "frank"})
+	if n := list(); n != 1 {
+		t.Fatalf("list %d", n)
+	}
+	db.Exec("INSERT INTO cache_user (id, name) VALUES (3, 'grace')")
+	if n := list(); n != 1 {
+		t.Fatalf("query cache miss: %d", n)
+	}
+	if err = CacheInvalidate(ctx, "users"); err != nil {
+		t.Fatal(err)
+	}
+	if n := list(); n != 2 {
+		t.Fatalf("tag not invalidated: %d", n)
+	}
+	db.Create(&cacheUser{ID: 4, Name: "heidi"})
+	if n := list(); n != 3 {
+		t.Fatalf("table write not invalidated: %d", n)
+	}
+}
+
+func TestCacheReplica(t *testing.T) {
+	mr.FlushAll()
+	zch.L().FlushMemory()
+	open := func(dsn string) *gorm.DB {
+		db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+			NamingStrategy: schema.NamingStrategy{SingularTable: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, _ := db.DB()
+		d.SetMaxOpenConns(1)
+		t.Cleanup(func() { _ = d.Close() })
+		if err = db.AutoMigrate(&cacheUser{}); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	// 副本上是尚未同步的旧数据
+	primary := open("file:cache_primary?mode=memory&cache=shared")
+	replicaDB := open("file:cache_replica?mode=memory&cache=shared")
+	primary.Create(&cacheUser{ID: 1, Name: "new"})
+	replicaDB.Create(&cacheUser{ID: 1, Name: "stale"})
+	replicaPool, _ := replicaDB.DB()
+	r := newResolver(time.Second, time.Hour)
+	t.Cleanup(r.close)
+	r.add("replica", replicaPool)
+	if err := primary.Use(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Use(NewCachePlugin()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		var u cacheUser
+		if err := primary.First(&u, 1).Error; err != nil || u.Name != "new" {
+			t.Fatalf("cache filled from replica: %+v %v", u, err)
+		}
+	}
+	var list []*cacheUser
+	if err := primary.Scopes(CacheQuery(time.Minute)).Find(&list).Error; err != nil || len(list) != 1 || list[0].Name != "new" {
+		t.Fatalf("query cache filled from replica: %v", err)
+	}
+	// 不走缓存的查询仍读副本
+	var raw string
+	primary.Raw("SELECT name FROM cache_user WHERE id = 1").Scan(&raw)
+	if raw != "stale" {
+		t.Fatalf("uncached read should go to replica, got %q", raw)
+	}
+}
@@ -1,8 +1,10 @@
 package zdb
 
 import (
+	"database/sql"
 	"fmt"
 
+	"github.com/zohu/zgin/zutil"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
@@ -13,10 +15,11 @@ import (
 const DriverName = "pgx/v5"
 
 func newdb(o *Options, database string) (*gorm.DB, error) {
+	cluster := zutil.FirstTruth(o.Clusters[database], &Cluster{})
 	db, err := gorm.Open(
 		postgres.New(postgres.Config{
 			DriverName: DriverName,
-			DSN:        o.Dsn(database),
+			DSN:        o.NodeDsn(cluster.Primary, database),
 		}),
 		&gorm.Config{
 			NamingStrategy: schema.NamingStrategy{
@@ -28,12 +31,40 @@ func newdb(o *Options, database string) (*gorm.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("db %s open failed: %v", database, err)
 	}
+	if len(cluster.Replicas) > 0 {
+		r := newResolver(o.StickyWindow, o.HealthInterval)
+		for i, n := range cluster.Replicas {
+			replica, err := sql.Open(DriverName, o.NodeDsn(n, database))
+			if err != nil {
+				return nil, fmt.Errorf("db %s replica %d open failed: %v", database, i, err)
+			}
+			pool(o, replica)
+			r.add(fmt.Sprintf("%s#%d(%s)", database, i, zutil.FirstTruth(n.Host, o.Host)), replica)
+		}
+		if err = db.Use(r); err != nil {
+			return nil, fmt.Errorf("db %s resolver failed: %v", database, err)
+		}
+	}
+	if o.Tenant != nil {
+		if err = db.Use(newTenantPlugin(o.Tenant)); err != nil {
+			return nil, fmt.Errorf("db %s tenant plugin failed: %v", database, err)
+		}
+	}
+	if o.Cache {
+		if err = db.Use(NewCachePlugin()); err != nil {
+			return nil, fmt.Errorf("db %s cache plugin failed: %v", database, err)
+		}
+	}
 	d, _ := db.DB()
-	d.SetMaxIdleConns(o.MaxIdle)
-	d.SetMaxOpenConns(o.MaxAlive)
-	d.SetConnMaxLifetime(o.MaxAliveLife)
+	pool(o, d)
 	if o.Debug != nil && *o.Debug {
 		db = db.Debug()
 	}
 	return db, nil
 }
+
+func pool(o *Options, d *sql.DB) {
+	d.SetMaxIdleConns(o.MaxIdle)
+	d.SetMaxOpenConns(o.MaxAlive)
+	d.SetConnMaxLifetime(o.MaxAliveLife)
+}
@@ -33,6 +33,9 @@ func New(options *Options) {
 	}
 }
 
+// AutoMigrate
+// @Description: 同步表结构，只会新增表、列和索引，改名、回填数据、回滚等使用Migrator
+// @param dst
 func AutoMigrate(dst []any) {
 	if len(dst) > 0 {
 		if err := NewDB(context.Background()).AutoMigrate(dst...); err != nil {
@@ -43,12 +46,32 @@ func AutoMigrate(dst []any) {
 	}
 }
 
+// Close
+// @Description: 关闭所有数据库连接，并停止副本健康检查
+func Close() {
+	for _, database := range p.Keys() {
+		conn, ok := p.Pop(database)
+		if !ok {
+			continue
+		}
+		if r, ok := conn.Config.Plugins["zdb:resolver"].(*resolver); ok {
+			r.close()
+		}
+		if d, err := conn.DB(); err == nil {
+			_ = d.Close()
+		}
+	}
+}
+
 func NewDB(ctx context.Context, databases ...string) *gorm.DB {
 	if len(databases) == 0 {
-		databases = []string{o.DB}
+		databases = []string{database(ctx)}
+	}
+	if st := txFrom(ctx, databases[0]); st != nil {
+		return st.db.WithContext(ctx)
 	}
 	if conn, ok := p.Get(databases[0]); ok {
-		return conn
+		return conn.WithContext(ctx)
 	}
 	conn, err := newdb(o, databases[0])
 	if err != nil {
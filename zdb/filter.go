@@ -0,0 +1,300 @@
+package zdb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * 请求结构体转查询条件
+ *  - filter:"列,操作"，列用|分隔时为OR，如 filter:"name|nickname,like"；省略列时按字段名转蛇形
+ *  - 操作: eq(默认) ne gt gte lt lte like prefix suffix in nin between null
+ *  - 零值不过滤，需要按零值过滤时用指针；in/nin为切片，between为长度2的切片或数组，某一端为零值时只限定另一端，切片长度不为2时报ErrFilterTag
+ *  - null为bool，true为IS NULL，false为IS NOT NULL，需用*bool
+ *  - sort:"允许的字段" 标记排序字段，值如 "-created_at,id"，只允许白名单中的字段，字段可写为 别名=列
+ *  - 嵌入的结构体展开处理，没有filter/sort标签的字段忽略
+ */
+
+var (
+	ErrFilterTag = errors.New("invalid filter tag")
+	ErrSortField = errors.New("sort field not allowed")
+	identifier   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+	likeEscaper  = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	filterFields sync.Map // reflect.Type -> []*filterField
+)
+
+type filterField struct {
+	index   []int
+	columns []string
+	op      string
+	sort    []string
+}
+
+// Filter
+// @Description: 按请求结构体的filter/sort标签生成查询条件和排序
+// @param req 结构体或其指针，为nil时不处理
+// @return func(*gorm.DB) *gorm.DB 用于db.Scopes
+func Filter(req any) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		rv := reflect.Indirect(reflect.ValueOf(req))
+		if !rv.IsValid() || rv.Kind() != reflect.Struct {
+			return db
+		}
+		fields, err := parseFilter(rv.Type())
+		if err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+		for _, f := range fields {
+			v, ok := fieldValue(rv, f.index)
+			if !ok {
+				continue
+			}
+			if f.sort != nil {
+				db = Sort(fmt.Sprint(v.Interface()), f.sort...)(db)
+				continue
+			}
+			// 切片的长度来自请求，不足两个值时无法确定区间
+			if f.op == "between" && v.Len() != 2 {
+				_ = db.AddError(fmt.Errorf("%w: %s between needs 2 values, got %d", ErrFilterTag, strings.Join(f.columns, "|"), v.Len()))
+				return db
+			}
+			if expr, ok := f.expression(v); ok {
+				db = db.Where(expr)
+			}
+		}
+		return db
+	}
+}
+
+// Sort
+// @Description: 按白名单排序，spec如 "-created_at,id" 或 "created_at desc"
+// @param spec
+// @param allowed 允许的字段，可写为 别名=列
+// @return func(*gorm.DB) *gorm.DB 用于db.Scopes，字段不在白名单时报ErrSortField
+func Sort(spec string, allowed ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		columns := make(map[string]string, len(allowed))
+		for _, a := range allowed {
+			alias, column, ok := strings.Cut(a, "=")
+			if !ok {
+				column = alias
+			}
+			columns[strings.TrimSpace(alias)] = strings.TrimSpace(column)
+		}
+		for _, item := range strings.Split(spec, ",") {
+			name, dir, _ := strings.Cut(strings.TrimSpace(item), " ")
+			desc := strings.EqualFold(strings.TrimSpace(dir), "desc")
+			if strings.HasPrefix(name, "-") {
+				name, desc = name[1:], true
+			}
+			name = strings.TrimPrefix(name, "+")
+			if name == "" {
+				continue
+			}
+			column, ok := columns[name]
+			if !ok || !identifier.MatchString(column) {
+				_ = db.AddError(fmt.Errorf("%w: %s", ErrSortField, name))
+				return db
+			}
+			db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: desc})
+		}
+		return db
+	}
+}
+
+func parseFilter(t reflect.Type) ([]*filterField, error) {
+	if v, ok := filterFields.Load(t); ok {
+		return v.([]*filterField), nil
+	}
+	var fields []*filterField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && ft.Kind() == reflect.Struct && sf.Tag.Get("filter") == "" {
+			embedded, err := parseFilter(ft)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range embedded {
+				fields = append(fields, &filterField{index: append([]int{i}, f.index...), columns: f.columns, op: f.op, sort: f.sort})
+			}
+			continue
+		}
+		if tag, ok := sf.Tag.Lookup("sort"); ok {
+			fields = append(fields, &filterField{index: []int{i}, sort: strings.Split(tag, ",")})
+			continue
+		}
+		tag := sf.Tag.Get("filter")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		f, err := newFilterField(sf, tag)
+		if err != nil {
+			return nil, err
+		}
+		f.index = []int{i}
+		fields = append(fields, f)
+	}
+	filterFields.Store(t, fields)
+	return fields, nil
+}
+
+func newFilterField(sf reflect.StructField, tag string) (*filterField, error) {
+	cols, op, _ := strings.Cut(tag, ",")
+	f := &filterField{op: strings.ToLower(strings.TrimSpace(op))}
+	if f.op == "" {
+		f.op = "eq"
+	}
+	if cols == "" {
+		cols = snake(sf.Name)
+	}
+	for _, col := range strings.Split(cols, "|") {
+		col = strings.TrimSpace(col)
+		if !identifier.MatchString(col) {
+			return nil, fmt.Errorf("%w: %s column %q", ErrFilterTag, sf.Name, col)
+		}
+		f.columns = append(f.columns, col)
+	}
+	ft := sf.Type
+	if ft.Kind() == reflect.Pointer {
+		ft = ft.Elem()
+	}
+	switch f.op {
+	case "eq", "ne", "gt", "gte", "lt", "lte", "like", "prefix", "suffix":
+	case "in", "nin":
+		if ft.Kind() != reflect.Slice && ft.Kind() != reflect.Array {
+			return nil, fmt.Errorf("%w: %s %s needs slice", ErrFilterTag, sf.Name, f.op)
+		}
+	case "between":
+		if (ft.Kind() != reflect.Slice && ft.Kind() != reflect.Array) || (ft.Kind() == reflect.Array && ft.Len() != 2) {
+			return nil, fmt.Errorf("%w: %s between needs [2]", ErrFilterTag, sf.Name)
+		}
+	case "null":
+		if ft.Kind() != reflect.Bool {
+			return nil, fmt.Errorf("%w: %s null needs bool", ErrFilterTag, sf.Name)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s unknown op %q", ErrFilterTag, sf.Name, f.op)
+	}
+	return f, nil
+}
+
+// expression
+// @Description: 字段值转为条件，多列时为OR
+// @receiver f
+// @param v 已解引用的值
+// @return clause.Expression
+// @return bool 是否需要过滤
+func (f *filterField) expression(v reflect.Value) (clause.Expression, bool) {
+	var exprs []clause.Expression
+	for _, col := range f.columns {
+		column := clause.Column{Name: col}
+		val := v.Interface()
+		switch f.op {
+		case "eq":
+			exprs = append(exprs, clause.Eq{Column: column, Value: val})
+		case "ne":
+			exprs = append(exprs, clause.Neq{Column: column, Value: val})
+		case "gt":
+			exprs = append(exprs, clause.Gt{Column: column, Value: val})
+		case "gte":
+			exprs = append(exprs, clause.Gte{Column: column, Value: val})
+		case "lt":
+			exprs = append(exprs, clause.Lt{Column: column, Value: val})
+		case "lte":
+			exprs = append(exprs, clause.Lte{Column: column, Value: val})
+		case "like", "prefix", "suffix":
+			s := likeEscaper.Replace(fmt.Sprint(val))
+			pattern := map[string]func(string) string{"like": LikeBetween, "prefix": LikeLeft, "suffix": LikeRight}[f.op](s)
+			exprs = append(exprs, clause.Expr{SQL: "? LIKE ? ESCAPE ?", Vars: []any{column, pattern, `\`}})
+		case "in", "nin":
+			values := make([]any, v.Len())
+			for i := range values {
+				values[i] = v.Index(i).Interface()
+			}
+			var expr clause.Expression = clause.IN{Column: column, Values: values}
+			if f.op == "nin" {
+				expr = clause.Not(expr)
+			}
+			exprs = append(exprs, expr)
+		case "between":
+			var and []clause.Expression
+			if from := v.Index(0); !from.IsZero() {
+				and = append(and, clause.Gte{Column: column, Value: from.Interface()})
+			}
+			if to := v.Index(1); !to.IsZero() {
+				and = append(and, clause.Lte{Column: column, Value: to.Interface()})
+			}
+			if len(and) == 0 {
+				return nil, false
+			}
+			exprs = append(exprs, clause.And(and...))
+		case "null":
+			if v.Bool() {
+				exprs = append(exprs, clause.Eq{Column: column, Value: nil})
+			} else {
+				exprs = append(exprs, clause.Neq{Column: column, Value: nil})
+			}
+		}
+	}
+	if len(exprs) == 1 {
+		return exprs[0], true
+	}
+	return clause.Or(exprs...), true
+}
+
+// fieldValue
+// @Description: 按下标取字段值并解引用，nil指针、零值和空切片视为未设置
+// @param rv
+// @param index
+// @return reflect.Value
+// @return bool
+func fieldValue(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, idx := range index {
+		rv = rv.Field(idx)
+		if rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return rv, false
+			}
+			// 指针的零值也参与过滤，嵌入结构体指针除外
+			if i == len(index)-1 {
+				return rv.Elem(), true
+			}
+			rv = rv.Elem()
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Slice:
+		return rv, rv.Len() > 0
+	default:
+		return rv, !rv.IsZero()
+	}
+}
+
+func snake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 && (name[i-1] < 'A' || name[i-1] > 'Z' || (i+1 < len(name) && name[i+1] >= 'a' && name[i+1] <= 'z')) {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,485 @@
+package zdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zohu/zgin/ztenant"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+	"gorm.io/gorm"
+)
+
+/**
+ * 版本化迁移
+ *  - 迁移按Version升序执行，可以是SQL也可以是Go函数，SQL可通过LoadFS从embed.FS加载
+ *  - 文件名 {version}_{name}.up.sql / {version}_{name}.down.sql，version为数字，如 0001 或 20240101120000
+ *  - 执行记录保存在历史表(默认zdb_schema_history)，记录SQL的校验和，已执行的文件被修改时Status中标记
+ *  - 执行期间持有数据库锁(postgres advisory lock / mysql GET_LOCK)，多个副本同时启动时只有一个执行
+ *  - 每个迁移在独立事务中执行，SQL首行为 -- zdb:notransaction 时不开启事务(如CREATE INDEX CONCURRENTLY)
+ */
+
+const migrateNoTx = "-- zdb:notransaction"
+
+var (
+	ErrMigrationVersion      = errors.New("migration version must be positive and unique")
+	ErrMigrationIrreversible = errors.New("migration has no down")
+	migrationFile            = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+type ZdbSchemaHistory struct {
+	Version   int64     `json:"version" gorm:"primaryKey;autoIncrement:false;comment:版本"`
+	Name      string    `json:"name" gorm:"comment:名称"`
+	Checksum  string    `json:"checksum" gorm:"comment:校验和"`
+	Cost      int64     `json:"cost" gorm:"comment:耗时，毫秒"`
+	AppliedAt time.Time `json:"applied_at" gorm:"comment:执行时间"`
+}
+
+// Migration
+// @Description: 一个版本的迁移，Up/UpFn二选一，Down/DownFn可为空(不可回滚)
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	UpFn    func(tx *gorm.DB) error
+	DownFn  func(tx *gorm.DB) error
+}
+
+func (m *Migration) checksum() string {
+	if m.UpFn != nil {
+		return "go"
+	}
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (m *Migration) reversible() bool {
+	return m.Down != "" || m.DownFn != nil
+}
+
+type MigrateOptions struct {
+	Table   string    `yaml:"table" note:"历史表，默认zdb_schema_history"`
+	LockKey int64     `yaml:"lock_key" note:"锁的key，默认由历史表名计算"`
+	DryRun  bool      `yaml:"dry_run" note:"只输出将要执行的迁移，不修改数据库"`
+	Out     io.Writer `yaml:"-" note:"Status等输出位置，默认os.Stdout"`
+}
+
+func (o *MigrateOptions) Validate() {
+	o.Table = zutil.FirstTruth(o.Table, "zdb_schema_history")
+	if o.LockKey == 0 {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(o.Table))
+		o.LockKey = int64(h.Sum64() >> 1)
+	}
+	o.Out = zutil.FirstTruth[io.Writer](o.Out, os.Stdout)
+}
+
+type MigrationStatus struct {
+	Version   int64      `json:"version" note:"版本"`
+	Name      string     `json:"name" note:"名称"`
+	Applied   bool       `json:"applied" note:"是否已执行"`
+	AppliedAt *time.Time `json:"applied_at,omitempty" note:"执行时间"`
+	Modified  bool       `json:"modified" note:"执行后SQL被修改"`
+	Missing   bool       `json:"missing" note:"已执行但代码中不存在"`
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	opts       *MigrateOptions
+	migrations map[int64]*Migration
+}
+
+// NewMigrator
+// @Description: 迁移器，db为空时使用默认库
+// @param db
+// @param opts
+// @return *Migrator
+func NewMigrator(db *gorm.DB, opts ...*MigrateOptions) *Migrator {
+	if db == nil {
+		db = NewDB(context.Background())
+	}
+	o := &MigrateOptions{}
+	if len(opts) > 0 && opts[0] != nil {
+		*o = *opts[0]
+	}
+	o.Validate()
+	return &Migrator{db: db, opts: o, migrations: make(map[int64]*Migration)}
+}
+
+// Add
+// @Description: 注册迁移，版本重复时返回错误
+// @receiver m
+// @param migrations
+// @return error
+func (m *Migrator) Add(migrations ...*Migration) error {
+	for _, mg := range migrations {
+		if mg.Version <= 0 || m.migrations[mg.Version] != nil {
+			return fmt.Errorf("%w: %d", ErrMigrationVersion, mg.Version)
+		}
+		if (mg.Up == "") == (mg.UpFn == nil) {
+			return fmt.Errorf("migration %d: exactly one of Up and UpFn is required", mg.Version)
+		}
+		m.migrations[mg.Version] = mg
+	}
+	return nil
+}
+
+// LoadFS
+// @Description: 加载目录下的 {version}_{name}.up.sql / .down.sql，可配合embed.FS
+// @receiver m
+// @param fsys
+// @param dir
+// @return error
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	found := make(map[int64]*Migration)
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		mg := found[version]
+		if mg == nil {
+			mg = &Migration{Version: version, Name: match[2]}
+			found[version] = mg
+		} else if mg.Name != match[2] {
+			return fmt.Errorf("%w: %d (%s, %s)", ErrMigrationVersion, version, mg.Name, match[2])
+		}
+		if match[3] == "up" {
+			mg.Up = string(body)
+		} else {
+			mg.Down = string(body)
+		}
+	}
+	for _, mg := range found {
+		if err = m.Add(mg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Up
+// @Description: 执行未执行的迁移
+// @receiver m
+// @param ctx
+// @param target 执行到的版本(含)，0为全部
+// @return error
+func (m *Migrator) Up(ctx context.Context, target int64) error {
+	return m.locked(ctx, func(db *gorm.DB, applied map[int64]*ZdbSchemaHistory) error {
+		for _, mg := range m.sorted() {
+			if applied[mg.Version] != nil || (target > 0 && mg.Version > target) {
+				continue
+			}
+			if err := m.apply(db, mg, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down
+// @Description: 按版本倒序回滚最近执行的迁移
+// @receiver m
+// @param ctx
+// @param steps 回滚个数，<=0时为1
+// @return error
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	steps = max(steps, 1)
+	return m.locked(ctx, func(db *gorm.DB, applied map[int64]*ZdbSchemaHistory) error {
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		for _, v := range versions[:min(steps, len(versions))] {
+			mg := m.migrations[v]
+			if mg == nil {
+				return fmt.Errorf("migration %d not found", v)
+			}
+			if !mg.reversible() {
+				return fmt.Errorf("%w: %d_%s", ErrMigrationIrreversible, mg.Version, mg.Name)
+			}
+			if err := m.apply(db, mg, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status
+// @Description: 迁移状态，按版本升序
+// @receiver m
+// @param ctx
+// @return []*MigrationStatus
+// @return error
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	applied, err := m.history(m.session(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var list []*MigrationStatus
+	for _, mg := range m.sorted() {
+		s := &MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if h := applied[mg.Version]; h != nil {
+			s.Applied, s.AppliedAt, s.Modified = true, &h.AppliedAt, h.Checksum != mg.checksum()
+		}
+		list = append(list, s)
+	}
+	for v, h := range applied {
+		if m.migrations[v] == nil {
+			list = append(list, &MigrationStatus{Version: v, Name: h.Name, Applied: true, AppliedAt: &h.AppliedAt, Missing: true})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Command
+// @Description: 命令行入口，如 app migrate up / down 2 / status / redo，支持 -dry-run
+// @receiver m
+// @param ctx
+// @param args 子命令及参数，如 os.Args[2:]
+// @return error
+func (m *Migrator) Command(ctx context.Context, args ...string) error {
+	set := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	set.SetOutput(m.opts.Out)
+	set.BoolVar(&m.opts.DryRun, "dry-run", m.opts.DryRun, "只输出将要执行的迁移")
+	set.Usage = func() {
+		_, _ = fmt.Fprintln(m.opts.Out, "usage: migrate [-dry-run] up [version] | down [steps] | redo | status")
+	}
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	var n int64
+	if set.NArg() > 1 {
+		var err error
+		if n, err = strconv.ParseInt(set.Arg(1), 10, 64); err != nil {
+			return fmt.Errorf("invalid argument %q: %v", set.Arg(1), err)
+		}
+	}
+	switch set.Arg(0) {
+	case "up":
+		return m.Up(ctx, n)
+	case "down":
+		return m.Down(ctx, int(n))
+	case "redo":
+		if err := m.Down(ctx, 1); err != nil {
+			return err
+		}
+		return m.Up(ctx, 0)
+	case "status", "":
+		list, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(m.opts.Out, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range list {
+			state, at := "pending", ""
+			if s.Applied {
+				state, at = "applied", s.AppliedAt.Format(time.DateTime)
+			}
+			if s.Modified {
+				state += " (modified)"
+			}
+			if s.Missing {
+				state += " (missing)"
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, at)
+		}
+		return w.Flush()
+	default:
+		set.Usage()
+		return fmt.Errorf("unknown migrate command %q", set.Arg(0))
+	}
+}
+
+// Migrate
+// @Description: 在默认库上执行fsys中dir目录及额外的迁移，失败时退出
+// @param fsys 为nil时只执行migrations
+// @param dir
+// @param migrations
+func Migrate(fsys fs.FS, dir string, migrations ...*Migration) {
+	m := NewMigrator(nil)
+	if fsys != nil {
+		if err := m.LoadFS(fsys, dir); err != nil {
+			zlog.Fatalf("load migrations error: %v", err)
+			return
+		}
+	}
+	if err := m.Add(migrations...); err != nil {
+		zlog.Fatalf("add migrations error: %v", err)
+		return
+	}
+	if err := m.Up(context.Background(), 0); err != nil {
+		zlog.Fatalf("migrate error: %v", err)
+		return
+	}
+}
+
+func (m *Migrator) sorted() []*Migration {
+	list := make([]*Migration, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		list = append(list, mg)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// session
+// @Description: 迁移始终在主库上执行，不经过读写分离，也不限定租户
+// @receiver m
+// @param ctx
+// @return *gorm.DB
+func (m *Migrator) session(ctx context.Context) *gorm.DB {
+	return m.db.WithContext(ztenant.Bypass(context.WithValue(ctx, primaryKey, true)))
+}
+
+func (m *Migrator) history(db *gorm.DB) (map[int64]*ZdbSchemaHistory, error) {
+	applied := make(map[int64]*ZdbSchemaHistory)
+	if !db.Migrator().HasTable(m.opts.Table) {
+		return applied, nil
+	}
+	var list []*ZdbSchemaHistory
+	if err := db.Table(m.opts.Table).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	for _, h := range list {
+		applied[h.Version] = h
+	}
+	return applied, nil
+}
+
+// locked
+// @Description: 在同一个连接上加锁后读取历史并执行fn，dry-run时不加锁
+// @receiver m
+// @param ctx
+// @param fn
+// @return error
+func (m *Migrator) locked(ctx context.Context, fn func(db *gorm.DB, applied map[int64]*ZdbSchemaHistory) error) error {
+	if m.opts.DryRun {
+		applied, err := m.history(m.session(ctx))
+		if err != nil {
+			return err
+		}
+		return fn(m.session(ctx), applied)
+	}
+	return m.session(ctx).Connection(func(db *gorm.DB) error {
+		unlock, err := m.lock(db)
+		if err != nil {
+			return fmt.Errorf("migrate lock failed: %v", err)
+		}
+		defer unlock()
+		if err = db.Table(m.opts.Table).AutoMigrate(&ZdbSchemaHistory{}); err != nil {
+			return fmt.Errorf("migrate history table failed: %v", err)
+		}
+		applied, err := m.history(db)
+		if err != nil {
+			return err
+		}
+		return fn(db, applied)
+	})
+}
+
+func (m *Migrator) lock(db *gorm.DB) (func(), error) {
+	var lock, unlock string
+	switch db.Dialector.Name() {
+	case "postgres":
+		lock, unlock = "SELECT pg_advisory_lock(?)", "SELECT pg_advisory_unlock(?)"
+	case "mysql":
+		lock, unlock = "SELECT GET_LOCK(CAST(? AS CHAR), -1)", "SELECT RELEASE_LOCK(CAST(? AS CHAR))"
+	default:
+		// sqlite等单机库没有会话锁
+		return func() {}, nil
+	}
+	if err := db.Exec(lock, m.opts.LockKey).Error; err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := db.Exec(unlock, m.opts.LockKey).Error; err != nil {
+			zlog.Warnf("migrate unlock failed: %v", err)
+		}
+	}, nil
+}
+
+// apply
+// @Description: 执行一个迁移并更新历史，dry-run时只输出，Go迁移以DryRun会话调用，生成的SQL见日志
+// @receiver m
+// @param db
+// @param mg
+// @param up
+// @return error
+func (m *Migrator) apply(db *gorm.DB, mg *Migration, up bool) error {
+	direction, script, fn := "up", mg.Up, mg.UpFn
+	if !up {
+		direction, script, fn = "down", mg.Down, mg.DownFn
+	}
+	if m.opts.DryRun {
+		_, _ = fmt.Fprintf(m.opts.Out, "-- %s %d_%s\n", direction, mg.Version, mg.Name)
+		if fn != nil {
+			return fn(db.Session(&gorm.Session{DryRun: true}))
+		}
+		_, _ = fmt.Fprintln(m.opts.Out, strings.TrimSpace(script))
+		return nil
+	}
+	start := time.Now()
+	run := func(tx *gorm.DB) error {
+		var err error
+		if fn != nil {
+			err = fn(tx)
+		} else {
+			err = tx.Exec(script).Error
+		}
+		if err != nil {
+			return fmt.Errorf("migrate %s %d_%s failed: %w", direction, mg.Version, mg.Name, err)
+		}
+		if !up {
+			return tx.Table(m.opts.Table).Delete(&ZdbSchemaHistory{}, mg.Version).Error
+		}
+		return tx.Table(m.opts.Table).Create(&ZdbSchemaHistory{
+			Version:   mg.Version,
+			Name:      mg.Name,
+			Checksum:  mg.checksum(),
+			Cost:      time.Since(start).Milliseconds(),
+			AppliedAt: time.Now(),
+		}).Error
+	}
+	var err error
+	if fn == nil && strings.HasPrefix(strings.TrimSpace(script), migrateNoTx) {
+		err = run(db)
+	} else {
+		err = db.Transaction(run)
+	}
+	if err != nil {
+		return err
+	}
+	zlog.Infof("migrate %s %d_%s success, cost %s", direction, mg.Version, mg.Name, time.Since(start))
+	return nil
+}
@@ -0,0 +1,126 @@
+package zdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMigrator(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:migrate?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := db.DB()
+	d.SetMaxOpenConns(1)
+	defer d.Close()
+	fsys := fstest.MapFS{
+		"migrations/0001_create_user.up.sql":   {Data: []byte("CREATE TABLE mg_user (id INTEGER PRIMARY KEY, name TEXT);")},
+		"migrations/0001_create_user.down.sql": {Data: []byte("DROP TABLE mg_user;")},
+		"migrations/0002_rename.up.sql":        {Data: []byte("ALTER TABLE mg_user RENAME COLUMN name TO nickname;")},
+		"migrations/0002_rename.down.sql":      {Data: []byte("ALTER TABLE mg_user RENAME COLUMN nickname TO name;")},
+		"migrations/README.md":                 {Data: []byte("ignored")},
+	}
+	out := &bytes.Buffer{}
+	m := NewMigrator(db, &MigrateOptions{Out: out})
+	if err = m.LoadFS(fsys, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	err = m.Add(&Migration{
+		Version: 3,
+		Name:    "backfill",
+		UpFn: func(tx *gorm.DB) error {
+			return tx.Exec("INSERT INTO mg_user (id, nickname) VALUES (1, 'alice')").Error
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Add(&Migration{Version: 3, Up: "SELECT 1"}); !errors.Is(err, ErrMigrationVersion) {
+		t.Fatalf("duplicate version: %v", err)
+	}
+	ctx := context.Background()
+
+	// dry-run不建历史表也不执行
+	if err = m.Command(ctx, "-dry-run", "up"); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable("mg_user") || db.Migrator().HasTable("zdb_schema_history") {
+		t.Fatal("dry run modified database")
+	}
+	if !strings.Contains(out.String(), "-- up 2_rename") || !strings.Contains(out.String(), "RENAME COLUMN") {
+		t.Fatalf("dry run output: %s", out)
+	}
+	m.opts.DryRun = false
+
+	if err = m.Up(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasColumn("mg_user", "nickname") {
+		t.Fatal("version 2 not applied")
+	}
+	if err = m.Command(ctx, "up"); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	db.Raw("SELECT nickname FROM mg_user WHERE id = 1").Scan(&name)
+	if name != "alice" {
+		t.Fatalf("backfill not applied: %q", name)
+	}
+	// 重复执行无变化
+	if err = m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// 失败的迁移整体回滚，不记录历史
+	m.migrations[4] = &Migration{Version: 4, Name: "broken", Up: "ALTER TABLE mg_user ADD COLUMN age INTEGER; SELECT * FROM nope;"}
+	if err = m.Up(ctx, 0); err == nil {
+		t.Fatal("broken migration should fail")
+	}
+	if db.Migrator().HasColumn("mg_user", "age") {
+		t.Fatal("failed migration not rolled back")
+	}
+	delete(m.migrations, 4)
+
+	// 版本3不可回滚
+	if err = m.Down(ctx, 1); !errors.Is(err, ErrMigrationIrreversible) {
+		t.Fatalf("irreversible: %v", err)
+	}
+	fsys["migrations/0001_create_user.up.sql"].Data = []byte("CREATE TABLE mg_user (id BIGINT);")
+	m2 := NewMigrator(db, &MigrateOptions{Out: out})
+	if err = m2.LoadFS(fsys, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := m2.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || !list[0].Modified || list[1].Modified || !list[2].Missing || !list[2].Applied {
+		t.Fatalf("status: %+v %+v %+v", list[0], list[1], list[2])
+	}
+	out.Reset()
+	if err = m2.Command(ctx, "status"); err != nil || !strings.Contains(out.String(), "applied (modified)") {
+		t.Fatalf("status output %v: %s", err, out)
+	}
+
+	delete(m.migrations, 3)
+	db.Exec("DELETE FROM zdb_schema_history WHERE version = 3")
+	if err = m.Command(ctx, "down", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable("mg_user") {
+		t.Fatal("down not applied")
+	}
+	if list, _ = m.Status(ctx); list[0].Applied || list[1].Applied {
+		t.Fatalf("history not removed: %+v", list)
+	}
+	if err = m.Command(ctx, "bogus"); err == nil {
+		t.Fatal("unknown command should fail")
+	}
+}
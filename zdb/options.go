@@ -2,6 +2,7 @@ package zdb
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -9,19 +10,75 @@ import (
 )
 
 type Options struct {
-	Host              string        `yaml:"host" binding:"required" note:"数据库地址"`
-	Port              string        `yaml:"port" binding:"required" note:"数据库端口"`
-	User              string        `yaml:"user" binding:"required" note:"数据库用户"`
-	Pass              string        `yaml:"pass" binding:"required" note:"数据库密码"`
-	DB                string        `yaml:"db" binding:"required" note:"数据库名"`
-	Config            string        `yaml:"config" note:"数据库配置"`
-	MaxIdle           int           `yaml:"max_idle" note:"最大闲置连接数"`
-	MaxAlive          int           `yaml:"max_alive" note:"最大存活连接数"`
-	MaxAliveLife      time.Duration `yaml:"max_alive_life" note:"最大存活时间"`
-	LogSlow           time.Duration `yaml:"log_slow" note:"慢阈值，秒"`
-	LogIgnoreNotFound string        `yaml:"log_ignore_not_found" note:"忽略无记录错误,yes/no"`
-	Debug             *bool         `yaml:"debug" note:"是否开启debug日志"`
-	Extension         []string      `yaml:"extension" note:"扩展配置"`
+	Host              string              `yaml:"host" binding:"required" note:"数据库地址"`
+	Port              string              `yaml:"port" binding:"required" note:"数据库端口"`
+	User              string              `yaml:"user" binding:"required" note:"数据库用户"`
+	Pass              string              `yaml:"pass" binding:"required" note:"数据库密码"`
+	DB                string              `yaml:"db" binding:"required" note:"数据库名"`
+	Config            string              `yaml:"config" note:"数据库配置"`
+	MaxIdle           int                 `yaml:"max_idle" note:"最大闲置连接数"`
+	MaxAlive          int                 `yaml:"max_alive" note:"最大存活连接数"`
+	MaxAliveLife      time.Duration       `yaml:"max_alive_life" note:"最大存活时间"`
+	LogSlow           time.Duration       `yaml:"log_slow" note:"慢阈值，秒"`
+	LogIgnoreNotFound string              `yaml:"log_ignore_not_found" note:"忽略无记录错误,yes/no"`
+	Debug             *bool               `yaml:"debug" note:"是否开启debug日志"`
+	Extension         []string            `yaml:"extension" note:"扩展配置"`
+	Cache             bool                `yaml:"cache" note:"开启缓存插件(主键查询、CacheQuery)，需先初始化zch"`
+	Clusters          map[string]*Cluster `yaml:"clusters" note:"按逻辑库名配置主从，未配置的库连接Host上的同名库"`
+	StickyWindow      time.Duration       `yaml:"sticky_window" note:"同一会话写入后读主库的时长，默认5s"`
+	HealthInterval    time.Duration       `yaml:"health_interval" note:"副本健康检查间隔，默认10s"`
+	Tenant            *TenantOptions      `yaml:"tenant" note:"多租户，为空时不启用"`
+}
+
+type TenantMode string
+
+const (
+	TenantColumn   TenantMode = "column"
+	TenantSchema   TenantMode = "schema"
+	TenantDatabase TenantMode = "database"
+)
+
+type TenantOptions struct {
+	Mode   TenantMode `yaml:"mode" note:"column按租户列过滤，schema每个租户一个schema，database每个租户一个库"`
+	Column string     `yaml:"column" note:"租户列，默认tenant_id"`
+	Name   string     `yaml:"name" note:"schema或库名格式，%s为租户ID，默认tenant_%s"`
+	Shared []string   `yaml:"shared" note:"不区分租户的表"`
+}
+
+func (t *TenantOptions) Validate() error {
+	t.Column = zutil.FirstTruth(t.Column, "tenant_id")
+	t.Name = zutil.FirstTruth(t.Name, "tenant_%s")
+	switch t.Mode {
+	case TenantColumn, TenantSchema, TenantDatabase:
+	default:
+		return fmt.Errorf("unknown tenant mode %q", t.Mode)
+	}
+	if strings.Count(t.Name, "%s") != 1 {
+		return fmt.Errorf("tenant name %q must contain one %%s", t.Name)
+	}
+	return nil
+}
+
+// NameOf
+// @Description: 租户的schema或库名
+// @receiver t
+// @param id
+// @return string
+func (t *TenantOptions) NameOf(id string) string {
+	return fmt.Sprintf(t.Name, id)
+}
+
+type Cluster struct {
+	Primary  Node   `yaml:"primary" note:"主库"`
+	Replicas []Node `yaml:"replicas" note:"只读副本"`
+}
+
+type Node struct {
+	Host string `yaml:"host" note:"地址，为空时使用Options.Host"`
+	Port string `yaml:"port" note:"端口，为空时使用Options.Port"`
+	User string `yaml:"user" note:"用户，为空时使用Options.User"`
+	Pass string `yaml:"pass" note:"密码，为空时使用Options.Pass"`
+	DB   string `yaml:"db" note:"实际库名，为空时使用逻辑库名"`
 }
 
 func (o *Options) Validate() error {
@@ -31,16 +88,33 @@ func (o *Options) Validate() error {
 	o.MaxAliveLife = zutil.FirstTruth(o.MaxAliveLife, time.Hour)
 	o.LogSlow = zutil.FirstTruth(o.LogSlow, time.Second*5)
 	o.LogIgnoreNotFound = zutil.FirstTruth(o.LogIgnoreNotFound, "yes")
+	o.StickyWindow = zutil.FirstTruth(o.StickyWindow, time.Second*5)
+	o.HealthInterval = zutil.FirstTruth(o.HealthInterval, time.Second*10)
+	if o.Tenant != nil {
+		if err := o.Tenant.Validate(); err != nil {
+			return err
+		}
+	}
 	return validator.New().Struct(o)
 }
 func (o *Options) Dsn(database string) string {
+	return o.NodeDsn(Node{}, database)
+}
+
+// NodeDsn
+// @Description: 节点的连接串，节点未配置的项使用Options中的值
+// @receiver o
+// @param n
+// @param database 逻辑库名
+// @return string
+func (o *Options) NodeDsn(n Node, database string) string {
 	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s %s",
-		o.Host,
-		o.Port,
-		o.User,
-		o.Pass,
-		database,
+		zutil.FirstTruth(n.Host, o.Host),
+		zutil.FirstTruth(n.Port, o.Port),
+		zutil.FirstTruth(n.User, o.User),
+		zutil.FirstTruth(n.Pass, o.Pass),
+		zutil.FirstTruth(n.DB, database),
 		o.Config,
 	)
 }
@@ -0,0 +1,268 @@
+package zdb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/zohu/zgin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * 泛型仓储
+ *  - 每次操作通过NewDB(ctx)取连接，自动使用ctx中的事务、租户和读写分离
+ *  - Find/First/Count/Page的req为带filter/sort标签的请求结构体，见Filter；Page的req嵌入zgin.Pages时按其分页，指针和值均可
+ *  - Upsert按冲突列批量插入或更新，ON CONFLICT(postgres、sqlite)或ON DUPLICATE KEY(mysql)
+ */
+
+var ErrNoPrimaryKey = errors.New("model has no primary key")
+
+const defaultBatchSize = 500
+
+type pager interface {
+	PageSizes() (int, int)
+}
+
+type Repo[T any] struct {
+	databases []string
+	batchSize int
+}
+
+// NewRepo
+// @Description: T的仓储
+// @param databases 逻辑库名，默认同NewDB(ctx)
+// @return *Repo[T]
+func NewRepo[T any](databases ...string) *Repo[T] {
+	return &Repo[T]{databases: databases, batchSize: defaultBatchSize}
+}
+
+// WithBatchSize
+// @Description: 批量创建和Upsert每批的条数，默认500
+// @receiver r
+// @param size
+// @return *Repo[T]
+func (r *Repo[T]) WithBatchSize(size int) *Repo[T] {
+	if size > 0 {
+		r.batchSize = size
+	}
+	return r
+}
+
+// DB
+// @Description: T模型的连接
+// @receiver r
+// @param ctx
+// @return *gorm.DB
+func (r *Repo[T]) DB(ctx context.Context) *gorm.DB {
+	return NewDB(ctx, r.databases...).Model(new(T))
+}
+
+// Get
+// @Description: 按主键查询，不存在时为gorm.ErrRecordNotFound
+// @receiver r
+// @param ctx
+// @param id
+// @return *T
+// @return error
+func (r *Repo[T]) Get(ctx context.Context, id any) (*T, error) {
+	db, err := r.primary(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	v := new(T)
+	if err = db.Take(v).Error; err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// First
+// @Description: 按条件查询第一条，不存在时为gorm.ErrRecordNotFound
+// @receiver r
+// @param ctx
+// @param req 请求结构体，见Filter
+// @param scopes
+// @return *T
+// @return error
+func (r *Repo[T]) First(ctx context.Context, req any, scopes ...func(*gorm.DB) *gorm.DB) (*T, error) {
+	v := new(T)
+	if err := r.query(ctx, req, scopes...).First(v).Error; err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Find
+// @Description: 按条件查询全部
+// @receiver r
+// @param ctx
+// @param req 请求结构体，见Filter
+// @param scopes
+// @return []T
+// @return error
+func (r *Repo[T]) Find(ctx context.Context, req any, scopes ...func(*gorm.DB) *gorm.DB) ([]T, error) {
+	var list []T
+	if err := r.query(ctx, req, scopes...).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Count
+// @Description: 按条件计数
+// @receiver r
+// @param ctx
+// @param req 请求结构体，见Filter
+// @param scopes
+// @return int64
+// @return error
+func (r *Repo[T]) Count(ctx context.Context, req any, scopes ...func(*gorm.DB) *gorm.DB) (int64, error) {
+	var total int64
+	err := r.query(ctx, req, scopes...).Count(&total).Error
+	return total, err
+}
+
+// Page
+// @Description: 按条件分页查询，req嵌入zgin.Pages时按其分页（指针或值），否则为第1页50条
+// @receiver r
+// @param ctx
+// @param req 请求结构体，见Filter
+// @param scopes
+// @return *zgin.RespListBean[T]
+// @return error
+func (r *Repo[T]) Page(ctx context.Context, req any, scopes ...func(*gorm.DB) *gorm.DB) (*zgin.RespListBean[T], error) {
+	page, size := pagerOf(req).PageSizes()
+	resp := &zgin.RespListBean[T]{Page: page, Size: size, List: []T{}}
+	db := r.query(ctx, req, scopes...).Session(&gorm.Session{})
+	if err := db.Count(&resp.Total).Error; err != nil {
+		return nil, err
+	}
+	if resp.Total == 0 || int64((page-1)*size) >= resp.Total {
+		return resp, nil
+	}
+	if err := db.Offset((page - 1) * size).Limit(size).Find(&resp.List).Error; err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// pagerOf
+// @Description: 取req的分页参数，PageSizes是指针方法，值类型的req复制到新指针上判断
+// @param req
+// @return pager 未嵌入zgin.Pages时为默认分页
+func pagerOf(req any) pager {
+	if p, ok := req.(pager); ok {
+		return p
+	}
+	if rv := reflect.ValueOf(req); rv.IsValid() && rv.Kind() != reflect.Pointer {
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		if p, ok := ptr.Interface().(pager); ok {
+			return p
+		}
+	}
+	return &zgin.Pages{}
+}
+
+// Create
+// @Description: 创建，多条时分批插入
+// @receiver r
+// @param ctx
+// @param values
+// @return error
+func (r *Repo[T]) Create(ctx context.Context, values ...*T) error {
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		return r.DB(ctx).Create(values[0]).Error
+	}
+	return r.DB(ctx).CreateInBatches(values, r.batchSize).Error
+}
+
+// Upsert
+// @Description: 批量插入，conflict冲突时更新
+// @receiver r
+// @param ctx
+// @param values
+// @param conflict 冲突的列，需有唯一索引；mysql按表上的唯一索引判断，忽略该参数
+// @param updates 冲突时更新的列，为空时更新全部非主键列
+// @return error
+func (r *Repo[T]) Upsert(ctx context.Context, values []*T, conflict []string, updates ...string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	onConflict := clause.OnConflict{UpdateAll: len(updates) == 0}
+	for _, column := range conflict {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: column})
+	}
+	if len(updates) > 0 {
+		onConflict.DoUpdates = clause.AssignmentColumns(updates)
+	}
+	return r.DB(ctx).Clauses(onConflict).CreateInBatches(values, r.batchSize).Error
+}
+
+// Update
+// @Description: 按主键更新，未指定columns时只更新非零值字段
+// @receiver r
+// @param ctx
+// @param value 需有主键值
+// @param columns 要更新的列，可更新为零值
+// @return int64 影响行数
+// @return error
+func (r *Repo[T]) Update(ctx context.Context, value *T, columns ...string) (int64, error) {
+	db := NewDB(ctx, r.databases...).Model(value)
+	if len(columns) > 0 {
+		db = db.Select(columns)
+	}
+	db = db.Updates(value)
+	return db.RowsAffected, db.Error
+}
+
+// Delete
+// @Description: 按主键删除，模型有gorm.DeletedAt时为软删除
+// @receiver r
+// @param ctx
+// @param ids
+// @return int64 影响行数
+// @return error
+func (r *Repo[T]) Delete(ctx context.Context, ids ...any) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	db, err := r.primary(ctx, ids...)
+	if err != nil {
+		return 0, err
+	}
+	db = db.Delete(new(T))
+	return db.RowsAffected, db.Error
+}
+
+func (r *Repo[T]) query(ctx context.Context, req any, scopes ...func(*gorm.DB) *gorm.DB) *gorm.DB {
+	return r.DB(ctx).Scopes(Filter(req)).Scopes(scopes...)
+}
+
+// primary
+// @Description: 按主键限定，不把id当作SQL条件拼接
+// @receiver r
+// @param ctx
+// @param ids
+// @return *gorm.DB
+// @return error
+func (r *Repo[T]) primary(ctx context.Context, ids ...any) (*gorm.DB, error) {
+	db := r.DB(ctx)
+	if err := db.Statement.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return nil, ErrNoPrimaryKey
+	}
+	column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+	if len(ids) == 1 {
+		return db.Where(clause.Eq{Column: column, Value: ids[0]}), nil
+	}
+	return db.Where(clause.IN{Column: column, Values: ids}), nil
+}
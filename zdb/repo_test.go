@@ -0,0 +1,191 @@
+package zdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zohu/zgin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type repoItem struct {
+	ID        int64
+	Code      string `gorm:"uniqueIndex"`
+	Name      string
+	Status    int
+	Remark    *string
+	CreatedAt time.Time
+}
+
+type repoQuery struct {
+	zgin.Pages
+	Name    string      `filter:"name|code,like"`
+	Code    string      `filter:"code,prefix"`
+	Status  *int        `filter:"status"`
+	In      []int       `filter:"status,in"`
+	Created []time.Time `filter:"created_at,between"`
+	NoMark  *bool       `filter:"remark,null"`
+	Sort    string      `sort:"id,name,created=created_at"`
+	Ignored string
+}
+
+func TestRepo(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:repo?mode=memory&cache=shared"), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := db.DB()
+	d.SetMaxOpenConns(1)
+	defer d.Close()
+	if err = db.AutoMigrate(&repoItem{}); err != nil {
+		t.Fatal(err)
+	}
+	old := o
+	o = &Options{DB: "repo"}
+	p.Set("repo", db)
+	t.Cleanup(func() {
+		o = old
+		p.Remove("repo")
+	})
+	ctx := context.Background()
+	repo := NewRepo[repoItem]().WithBatchSize(2)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	remark := "r"
+	items := []*repoItem{
+		{Code: "a1", Name: "apple", Status: 1, CreatedAt: base},
+		{Code: "a2", Name: "100%_off", Status: 2, CreatedAt: base.Add(time.Hour), Remark: &remark},
+		{Code: "b1", Name: "banana", Status: 0, CreatedAt: base.Add(2 * time.Hour)},
+	}
+	if err = repo.Create(ctx, items...); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.Get(ctx, items[1].ID)
+	if err != nil || got.Code != "a2" {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+	if _, err = repo.Get(ctx, "1 OR 1=1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("get with raw condition: %v", err)
+	}
+
+	zero, yes := 0, true
+	cases := []struct {
+		name string
+		req  *repoQuery
+		want []string
+	}{
+		{"empty", &repoQuery{Ignored: "x"}, []string{"a1", "a2", "b1"}},
+		{"like or", &repoQuery{Name: "b1"}, []string{"b1"}},
+		{"like escaped", &repoQuery{Name: "%_"}, []string{"a2"}},
+		{"prefix", &repoQuery{Code: "a"}, []string{"a1", "a2"}},
+		{"zero by pointer", &repoQuery{Status: &zero}, []string{"b1"}},
+		{"in", &repoQuery{In: []int{1, 2}}, []string{"a1", "a2"}},
+		{"between", &repoQuery{Created: []time.Time{base.Add(time.Minute), base.Add(3 * time.Hour)}}, []string{"a2", "b1"}},
+		{"between open", &repoQuery{Created: []time.Time{{}, base.Add(time.Minute)}}, []string{"a1"}},
+		{"null", &repoQuery{NoMark: &yes}, []string{"a1", "b1"}},
+		{"sort", &repoQuery{Sort: "-created"}, []string{"b1", "a2", "a1"}},
+		{"sort multi", &repoQuery{Sort: "name desc, +id"}, []string{"b1", "a1", "a2"}},
+	}
+	for _, c := range cases {
+		list, err := repo.Find(ctx, c.req, func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var codes []string
+		for _, v := range list {
+			codes = append(codes, v.Code)
+		}
+		if len(codes) != len(c.want) {
+			t.Fatalf("%s: %v want %v", c.name, codes, c.want)
+		}
+		for i := range codes {
+			if codes[i] != c.want[i] {
+				t.Fatalf("%s: %v want %v", c.name, codes, c.want)
+			}
+		}
+	}
+
+	// 排序白名单
+	for _, spec := range []string{"status", "id;drop table repo_item", "created_at"} {
+		if _, err = repo.Find(ctx, &repoQuery{Sort: spec}); !errors.Is(err, ErrSortField) {
+			t.Fatalf("sort %q: %v", spec, err)
+		}
+	}
+	type badQuery struct {
+		Name string `filter:"name) OR (1=1,like"`
+	}
+	if _, err = repo.Find(ctx, &badQuery{Name: "x"}); !errors.Is(err, ErrFilterTag) {
+		t.Fatalf("bad tag: %v", err)
+	}
+	for _, created := range [][]time.Time{{base}, {base, base, base}} {
+		if _, err = repo.Find(ctx, &repoQuery{Created: created}); !errors.Is(err, ErrFilterTag) {
+			t.Fatalf("between %d values: %v", len(created), err)
+		}
+	}
+
+	// 分页
+	page, err := repo.Page(ctx, &repoQuery{Pages: zgin.Pages{Page: 2, Size: 2}, Sort: "id"})
+	if err != nil || page.Total != 3 || page.Page != 2 || len(page.List) != 1 || page.List[0].Code != "b1" {
+		t.Fatalf("page: %+v %v", page, err)
+	}
+	page, err = repo.Page(ctx, &repoQuery{Pages: zgin.Pages{Page: 5}, Code: "a"})
+	if err != nil || page.Total != 2 || page.Size != 50 || page.List == nil || len(page.List) != 0 {
+		t.Fatalf("page out of range: %+v %v", page, err)
+	}
+	// 值类型的req同样按其分页
+	page, err = repo.Page(ctx, repoQuery{Pages: zgin.Pages{Page: 2, Size: 2}, Sort: "id"})
+	if err != nil || page.Page != 2 || page.Size != 2 || len(page.List) != 1 || page.List[0].Code != "b1" {
+		t.Fatalf("page by value: %+v %v", page, err)
+	}
+	if n, err := repo.Count(ctx, &repoQuery{Code: "a"}); err != nil || n != 2 {
+		t.Fatalf("count: %d %v", n, err)
+	}
+
+	// 更新
+	items[0].Name = ""
+	items[0].Status = 9
+	if n, err := repo.Update(ctx, items[0]); err != nil || n != 1 {
+		t.Fatalf("update: %d %v", n, err)
+	}
+	if got, _ = repo.Get(ctx, items[0].ID); got.Name != "apple" || got.Status != 9 {
+		t.Fatalf("update non-zero: %+v", got)
+	}
+	if _, err = repo.Update(ctx, items[0], "name"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = repo.Get(ctx, items[0].ID); got.Name != "" {
+		t.Fatalf("update columns: %+v", got)
+	}
+
+	// Upsert
+	err = repo.Upsert(ctx, []*repoItem{
+		{Code: "a1", Name: "apricot", Status: 5},
+		{Code: "c1", Name: "cherry", Status: 5},
+	}, []string{"code"}, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err = repo.First(ctx, &repoQuery{Code: "a1"}); err != nil || got.Name != "apricot" || got.Status != 9 {
+		t.Fatalf("upsert update: %+v %v", got, err)
+	}
+	if n, _ := repo.Count(ctx, nil); n != 4 {
+		t.Fatalf("upsert insert: %d", n)
+	}
+
+	// 删除
+	if n, err := repo.Delete(ctx, items[1].ID, items[2].ID); err != nil || n != 2 {
+		t.Fatalf("delete: %d %v", n, err)
+	}
+	if n, err := repo.Delete(ctx); err != nil || n != 0 {
+		t.Fatalf("delete none: %d %v", n, err)
+	}
+	if n, _ := repo.Count(ctx, nil); n != 2 {
+		t.Fatalf("after delete: %d", n)
+	}
+}
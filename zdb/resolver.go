@@ -0,0 +1,210 @@
+package zdb
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zohu/zlog"
+	"gorm.io/gorm"
+)
+
+/**
+ * 读写分离
+ *  - 事务外的查询按轮询发往健康的副本，写入、事务、加锁查询、原生写SQL走主库
+ *  - WithSession标记一次请求，请求内写入后StickyWindow内的读也走主库，避免读不到自己刚写的数据
+ *  - Primary强制走主库
+ *  - 定期ping副本，失败的副本移出轮询，恢复后加回；没有健康的副本时读主库，Close时停止
+ */
+
+// SessionKey 请求上下文中保存会话的key，gin.Context可直接c.Set(SessionKey, ...)
+const SessionKey = "__ZDB_SESSION__"
+
+const primaryKey = "__ZDB_PRIMARY__"
+
+var lockingRead = regexp.MustCompile(`(?i)\sFOR\s+(NO\s+KEY\s+UPDATE|UPDATE|KEY\s+SHARE|SHARE)\b`)
+
+type session struct {
+	until atomic.Int64
+}
+
+// WithSession
+// @Description: 为请求上下文开启读写分离会话，已开启时原样返回
+// @param ctx
+// @return context.Context
+func WithSession(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(SessionKey).(*session); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, SessionKey, &session{})
+}
+
+// Primary
+// @Description: 强制使用主库
+// @param ctx
+// @param databases
+// @return *gorm.DB
+func Primary(ctx context.Context, databases ...string) *gorm.DB {
+	return NewDB(context.WithValue(ctx, primaryKey, true), databases...)
+}
+
+type replica struct {
+	name    string
+	pool    *sql.DB
+	healthy atomic.Bool
+}
+
+type resolver struct {
+	replicas []*replica
+	sticky   time.Duration
+	interval time.Duration
+	next     atomic.Uint64
+	stop     chan struct{}
+	once     sync.Once
+}
+
+func newResolver(sticky, interval time.Duration) *resolver {
+	return &resolver{sticky: sticky, interval: interval, stop: make(chan struct{})}
+}
+
+func (r *resolver) add(name string, pool *sql.DB) {
+	rep := &replica{name: name, pool: pool}
+	rep.healthy.Store(true)
+	r.replicas = append(r.replicas, rep)
+}
+
+func (r *resolver) Name() string {
+	return "zdb:resolver"
+}
+
+func (r *resolver) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Query().Before("gorm:query").Register("zdb:resolver_query", r.read); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("zdb:resolver_row", r.read); err != nil {
+		return err
+	}
+	if err := db.Callback().Create().Before("*").Register("zdb:resolver_create", r.write); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("*").Register("zdb:resolver_update", r.write); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("*").Register("zdb:resolver_delete", r.write); err != nil {
+		return err
+	}
+	if err := db.Callback().Raw().Before("*").Register("zdb:resolver_raw", r.write); err != nil {
+		return err
+	}
+	r.check()
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.check()
+			}
+		}
+	}()
+	return nil
+}
+
+// close
+// @Description: 停止健康检查并关闭副本连接池
+// @receiver r
+func (r *resolver) close() {
+	r.once.Do(func() {
+		close(r.stop)
+		for _, rep := range r.replicas {
+			_ = rep.pool.Close()
+		}
+	})
+}
+
+// read
+// @Description: 满足条件时把本次查询的连接池换成副本
+// @receiver r
+// @param db
+func (r *resolver) read(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || !r.readable(stmt) {
+		return
+	}
+	if rep := r.pick(); rep != nil {
+		stmt.ConnPool = rep.pool
+	}
+}
+
+func (r *resolver) readable(stmt *gorm.Statement) bool {
+	if _, ok := stmt.ConnPool.(gorm.TxCommitter); ok {
+		return false
+	}
+	ctx := stmt.Context
+	if v, _ := ctx.Value(primaryKey).(bool); v {
+		return false
+	}
+	if s, ok := ctx.Value(SessionKey).(*session); ok && time.Now().UnixNano() < s.until.Load() {
+		return false
+	}
+	if _, ok := stmt.Clauses["FOR"]; ok {
+		return false
+	}
+	if stmt.SQL.Len() > 0 {
+		sql := strings.TrimSpace(stmt.SQL.String())
+		return len(sql) >= 6 && strings.EqualFold(sql[:6], "SELECT") && !lockingRead.MatchString(sql)
+	}
+	return true
+}
+
+// write
+// @Description: 链式调用中先读后写时换回主库，并开启会话的粘滞窗口
+// @receiver r
+// @param db
+func (r *resolver) write(db *gorm.DB) {
+	for _, rep := range r.replicas {
+		if db.Statement.ConnPool == rep.pool {
+			db.Statement.ConnPool = db.ConnPool
+			break
+		}
+	}
+	if s, ok := db.Statement.Context.Value(SessionKey).(*session); ok {
+		s.until.Store(time.Now().Add(r.sticky).UnixNano())
+	}
+}
+
+// pick
+// @Description: 轮询健康的副本
+// @receiver r
+// @return *replica 没有健康的副本时为nil
+func (r *resolver) pick() *replica {
+	n := uint64(len(r.replicas))
+	start := r.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if rep := r.replicas[(start+i)%n]; rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+func (r *resolver) check() {
+	for _, rep := range r.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+		err := rep.pool.PingContext(ctx)
+		cancel()
+		if healthy := err == nil; rep.healthy.Swap(healthy) != healthy {
+			if healthy {
+				zlog.Infof("db replica %s recovered", rep.name)
+			} else {
+				zlog.Warnf("db replica %s is down: %v", rep.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package zdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type resolverItem struct {
+	ID   int64
+	Name string
+}
+
+func openSqlite(t *testing.T, dsn string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := db.DB()
+	d.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = d.Close() })
+	if err = db.AutoMigrate(&resolverItem{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestResolver(t *testing.T) {
+	// 主库和副本是两个独立的库，通过读到的数据判断路由
+	primary := openSqlite(t, "file:primary?mode=memory&cache=shared")
+	replicaDB := openSqlite(t, "file:replica?mode=memory&cache=shared")
+	replicaDB.Create(&resolverItem{ID: 1, Name: "replica"})
+	replicaPool, _ := replicaDB.DB()
+
+	r := newResolver(time.Millisecond*200, time.Hour)
+	r.add("replica", replicaPool)
+	if err := primary.Use(r); err != nil {
+		t.Fatal(err)
+	}
+	name := func(db *gorm.DB) string {
+		var item resolverItem
+		if err := db.First(&item, 1).Error; err != nil {
+			return ""
+		}
+		return item.Name
+	}
+
+	ctx := WithSession(context.Background())
+	if got := name(primary.WithContext(ctx)); got != "replica" {
+		t.Fatalf("read should go to replica, got %q", got)
+	}
+	primary.WithContext(ctx).Create(&resolverItem{ID: 1, Name: "primary"})
+	if got := name(primary.WithContext(ctx)); got != "primary" {
+		t.Fatalf("read after write should stick to primary, got %q", got)
+	}
+	if got := name(primary.WithContext(context.Background())); got != "replica" {
+		t.Fatalf("other sessions are not sticky, got %q", got)
+	}
+	time.Sleep(time.Millisecond * 250)
+	if got := name(primary.WithContext(ctx)); got != "replica" {
+		t.Fatalf("sticky window should expire, got %q", got)
+	}
+
+	forced := primary.WithContext(context.WithValue(context.Background(), primaryKey, true))
+	if got := name(forced); got != "primary" {
+		t.Fatalf("forced primary, got %q", got)
+	}
+	_ = primary.Transaction(func(tx *gorm.DB) error {
+		if got := name(tx); got != "primary" {
+			t.Fatalf("transaction reads primary, got %q", got)
+		}
+		return nil
+	})
+	// 同一链上先读后写，写入回到主库
+	var item resolverItem
+	chain := primary.Where("id = ?", 1)
+	if chain.Find(&item); item.Name != "replica" {
+		t.Fatalf("chained read should go to replica, got %q", item.Name)
+	}
+	if err := chain.Create(&resolverItem{ID: 2, Name: "chained"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := forced.First(&resolverItem{}, 2).Error; err != nil {
+		t.Fatalf("chained write should go to primary: %v", err)
+	}
+	var raw string
+	primary.Raw("SELECT name FROM resolver_item WHERE id = 1").Scan(&raw)
+	if raw != "replica" {
+		t.Fatalf("raw select should go to replica, got %q", raw)
+	}
+	for sql, locking := range map[string]bool{
+		"SELECT * FROM t WHERE id = 1 FOR UPDATE":       true,
+		"select * from t for no key update skip locked": true,
+		"SELECT * FROM t FOR SHARE":                     true,
+		"SELECT for_update FROM t":                      false,
+	} {
+		if lockingRead.MatchString(sql) != locking {
+			t.Fatalf("locking read %q", sql)
+		}
+	}
+
+	// 副本不可用时移出轮询
+	_ = replicaPool.Close()
+	r.check()
+	if got := name(primary); got != "primary" {
+		t.Fatalf("unhealthy replica should be skipped, got %q", got)
+	}
+	r.close()
+	r.close()
+	select {
+	case <-r.stop:
+	default:
+		t.Fatal("health check not stopped")
+	}
+}
+
+func TestNodeDsn(t *testing.T) {
+	o := &Options{Host: "h", Port: "5432", User: "u", Pass: "p", Config: "sslmode=disable"}
+	if dsn := o.NodeDsn(Node{Host: "r1", DB: "real"}, "logical"); dsn != "host=r1 port=5432 user=u password=p dbname=real sslmode=disable" {
+		t.Fatalf("dsn: %s", dsn)
+	}
+	if o.Dsn("x") != "host=h port=5432 user=u password=p dbname=x sslmode=disable" {
+		t.Fatalf("dsn: %s", o.Dsn("x"))
+	}
+}
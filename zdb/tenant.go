@@ -0,0 +1,274 @@
+package zdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/zohu/zgin/ztenant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * 多租户，租户来自ctx(ztenant)，Options.Tenant开启
+ *  - column: 有租户列的表，查询、更新、删除自动加 tenant_id = ?，创建时自动填充，填了其他租户时报错
+ *  - schema: 每个租户一个schema，表名限定为 {schema}.{table}；Tx中同时设置search_path，事务内原生SQL也落在租户schema
+ *  - database: 每个租户一个库，NewDB(ctx)未指定库时连接租户库
+ *  - 没有租户的ctx访问租户表时报ErrTenantRequired，跨租户操作需ztenant.Bypass
+ *  - column模式下db.Table(...)等没有模型的语句首次访问时检查表结构，无法判断的表报ErrTenantRequired
+ *  - Shared中的表不区分租户；原生SQL(Raw/Exec)和Joins的关联表不做限定
+ */
+
+var (
+	ErrTenantRequired = errors.New("tenant required")
+	ErrTenantMismatch = errors.New("tenant mismatch")
+)
+
+type tenantPlugin struct {
+	opts   *TenantOptions
+	shared map[string]bool
+	tables sync.Map // column模式下有租户列的表
+}
+
+func newTenantPlugin(opts *TenantOptions) *tenantPlugin {
+	p := &tenantPlugin{opts: opts, shared: make(map[string]bool)}
+	for _, table := range opts.Shared {
+		p.shared[table] = true
+	}
+	return p
+}
+
+func (p *tenantPlugin) Name() string {
+	return "zdb:tenant"
+}
+
+func (p *tenantPlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Query().Before("gorm:query").Register("zdb:tenant_query", p.scope(false)); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("zdb:tenant_row", p.scope(false)); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("zdb:tenant_update", p.scope(true)); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:delete").Register("zdb:tenant_delete", p.scope(true)); err != nil {
+		return err
+	}
+	return db.Callback().Create().Before("gorm:create").Register("zdb:tenant_create", p.create)
+}
+
+// tenant
+// @Description: 本次语句的租户
+// @receiver p
+// @param db
+// @return id
+// @return scoped 是否为需要限定的租户表
+func (p *tenantPlugin) tenant(db *gorm.DB) (id string, scoped bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Table == "" || ztenant.Bypassed(stmt.Context) {
+		return "", false
+	}
+	table := stmt.Table
+	if p.opts.Mode == TenantSchema {
+		if i := strings.LastIndex(table, "."); i >= 0 {
+			table = table[i+1:]
+		}
+	}
+	if p.shared[table] {
+		return "", false
+	}
+	if has, err := p.tenantTable(db, table); err != nil || !has {
+		if err != nil {
+			_ = db.AddError(err)
+		}
+		return "", false
+	}
+	id, ok := ztenant.From(stmt.Context)
+	if !ok {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrTenantRequired, table))
+		return "", false
+	}
+	if !ztenant.Valid(id) {
+		_ = db.AddError(fmt.Errorf("%w: %q", ztenant.ErrInvalid, id))
+		return "", false
+	}
+	return id, true
+}
+
+// tenantTable
+// @Description: 是否为有租户列的表，有模型时按模型判断，db.Table(...)等没有模型的查询检查一次表结构
+// @receiver p
+// @param db
+// @param table
+// @return bool
+// @return error 无法判断时报错，不放行未限定的查询
+func (p *tenantPlugin) tenantTable(db *gorm.DB, table string) (bool, error) {
+	if p.opts.Mode != TenantColumn {
+		return true, nil
+	}
+	stmt := db.Statement
+	if stmt.Schema != nil && stmt.Schema.Table == table {
+		has := stmt.Schema.LookUpField(p.opts.Column) != nil
+		p.tables.Store(table, has)
+		return has, nil
+	}
+	if has, ok := p.tables.Load(table); ok {
+		return has.(bool), nil
+	}
+	if !identifier.MatchString(table) {
+		return false, fmt.Errorf("%w: can not scope table %q", ErrTenantRequired, table)
+	}
+	m := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Migrator()
+	if !m.HasTable(table) {
+		return false, fmt.Errorf("%w: unknown table %q", ErrTenantRequired, table)
+	}
+	has := m.HasColumn(table, p.opts.Column)
+	p.tables.Store(table, has)
+	return has, nil
+}
+
+// scope
+// @Description: 查询、更新、删除限定租户
+// @receiver p
+// @param write 更新和删除没有条件时交给gorm报ErrMissingWhereClause，不因租户条件变成整个租户的更新
+// @return func(db *gorm.DB)
+func (p *tenantPlugin) scope(write bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		id, ok := p.tenant(db)
+		if !ok {
+			return
+		}
+		stmt := db.Statement
+		switch p.opts.Mode {
+		case TenantColumn:
+			if _, where := stmt.Clauses["WHERE"]; write && !where && !db.AllowGlobalUpdate && !hasPrimaryValue(stmt) {
+				return
+			}
+			stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+				clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: p.opts.Column}, Value: id},
+			}})
+		case TenantSchema:
+			p.qualify(stmt, id)
+		}
+	}
+}
+
+// create
+// @Description: 创建时填充租户列，已填其他租户时报错
+// @receiver p
+// @param db
+func (p *tenantPlugin) create(db *gorm.DB) {
+	id, ok := p.tenant(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+	switch p.opts.Mode {
+	case TenantSchema:
+		p.qualify(stmt, id)
+		return
+	case TenantDatabase:
+		return
+	}
+	switch dest := stmt.Dest.(type) {
+	case map[string]any:
+		dest[p.opts.Column] = id
+		return
+	case *map[string]any:
+		(*dest)[p.opts.Column] = id
+		return
+	case []map[string]any:
+		for _, row := range dest {
+			row[p.opts.Column] = id
+		}
+		return
+	}
+	if stmt.Schema == nil {
+		return
+	}
+	field := stmt.Schema.LookUpField(p.opts.Column)
+	fill := func(rv reflect.Value) {
+		if v, zero := field.ValueOf(stmt.Context, rv); !zero && fmt.Sprint(v) != id {
+			_ = db.AddError(fmt.Errorf("%w: %v != %s", ErrTenantMismatch, v, id))
+			return
+		}
+		if err := field.Set(stmt.Context, rv, id); err != nil {
+			_ = db.AddError(err)
+		}
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		fill(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			fill(reflect.Indirect(rv.Index(i)))
+		}
+	}
+}
+
+// qualify
+// @Description: 表名限定到租户schema，db.Table指定了schema或表达式时不处理
+// @receiver p
+// @param stmt
+// @param id
+func (p *tenantPlugin) qualify(stmt *gorm.Statement, id string) {
+	if strings.Contains(stmt.Table, ".") || (stmt.TableExpr != nil && stmt.TableExpr.SQL != stmt.Quote(stmt.Table)) {
+		return
+	}
+	stmt.Table = p.opts.NameOf(id) + "." + stmt.Table
+	if stmt.TableExpr != nil {
+		stmt.TableExpr = &clause.Expr{SQL: stmt.Quote(stmt.Table)}
+	}
+}
+
+func hasPrimaryValue(stmt *gorm.Statement) bool {
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) == 0 {
+		return false
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		for _, f := range stmt.Schema.PrimaryFields {
+			if _, zero := f.ValueOf(stmt.Context, rv); !zero {
+				return true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		return rv.Len() > 0
+	}
+	return false
+}
+
+// database
+// @Description: NewDB未指定库时使用的库，database模式下为租户库
+// @param ctx
+// @return string
+func database(ctx context.Context) string {
+	if o.Tenant == nil || o.Tenant.Mode != TenantDatabase || ztenant.Bypassed(ctx) {
+		return o.DB
+	}
+	if id, ok := ztenant.From(ctx); ok && ztenant.Valid(id) {
+		return o.Tenant.NameOf(id)
+	}
+	return o.DB
+}
+
+// searchPath
+// @Description: schema模式下把事务的search_path设为租户schema
+// @param ctx
+// @param tx
+// @return error
+func searchPath(ctx context.Context, tx *gorm.DB) error {
+	if o == nil || o.Tenant == nil || o.Tenant.Mode != TenantSchema || ztenant.Bypassed(ctx) || tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	id, ok := ztenant.From(ctx)
+	if !ok || !ztenant.Valid(id) {
+		return nil
+	}
+	return tx.Exec("SELECT set_config('search_path', ?, true)", `"`+o.Tenant.NameOf(id)+`", public`).Error
+}
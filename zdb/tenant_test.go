@@ -0,0 +1,179 @@
+package zdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zohu/zgin/ztenant"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type tenantItem struct {
+	ID       int64
+	TenantID string
+	Name     string
+}
+
+type tenantShared struct {
+	ID   int64
+	Name string
+}
+
+func openTenant(t *testing.T, dsn string, opts *TenantOptions) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := db.DB()
+	d.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = d.Close() })
+	if err = opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Use(newTenantPlugin(opts)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestTenantColumn(t *testing.T) {
+	db := openTenant(t, "file:tenant_column?mode=memory&cache=shared", &TenantOptions{Mode: TenantColumn})
+	bg := context.Background()
+	if err := db.WithContext(ztenant.Bypass(bg)).AutoMigrate(&tenantItem{}, &tenantShared{}); err != nil {
+		t.Fatal(err)
+	}
+	a, b := db.WithContext(ztenant.With(bg, "a")), db.WithContext(ztenant.With(bg, "b"))
+	count := func(db *gorm.DB) int {
+		var items []tenantItem
+		if err := db.Find(&items).Error; err != nil {
+			t.Fatal(err)
+		}
+		return len(items)
+	}
+
+	if err := db.WithContext(bg).Create(&tenantItem{ID: 9}).Error; !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("unscoped create: %v", err)
+	}
+	a.Create(&[]tenantItem{{ID: 1, Name: "a1"}, {ID: 2, Name: "a2"}})
+	b.Create(&tenantItem{ID: 3, Name: "b1"})
+	if err := a.Create(&tenantItem{ID: 4, TenantID: "b"}).Error; !errors.Is(err, ErrTenantMismatch) {
+		t.Fatalf("mismatch: %v", err)
+	}
+	if err := db.WithContext(bg).Find(&[]tenantItem{}).Error; !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("unscoped query: %v", err)
+	}
+	if count(a) != 2 || count(b) != 1 || count(db.WithContext(ztenant.Bypass(bg))) != 3 {
+		t.Fatalf("scoped query: a=%d b=%d", count(a), count(b))
+	}
+	var item tenantItem
+	if err := b.First(&item, 1).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("cross tenant first: %v %+v", err, item)
+	}
+	var rows []map[string]any
+	a.Table("tenant_item").Find(&rows)
+	if len(rows) != 2 {
+		t.Fatalf("table query: %d", len(rows))
+	}
+
+	// 跨租户的更新、删除不生效，没有条件时仍需AllowGlobalUpdate
+	if n := b.Model(&tenantItem{ID: 1}).Update("name", "x").RowsAffected; n != 0 {
+		t.Fatalf("cross tenant update: %d", n)
+	}
+	if n := b.Where("1 = 1").Updates(&tenantItem{Name: "bb"}).RowsAffected; n != 1 {
+		t.Fatalf("scoped update: %d", n)
+	}
+	if err := a.Model(&tenantItem{}).Update("name", "x").Error; !errors.Is(err, gorm.ErrMissingWhereClause) {
+		t.Fatalf("global update: %v", err)
+	}
+	if n := b.Delete(&tenantItem{}, 2).RowsAffected; n != 0 {
+		t.Fatalf("cross tenant delete: %d", n)
+	}
+	if n := a.Delete(&tenantItem{ID: 2}).RowsAffected; n != 1 {
+		t.Fatalf("scoped delete: %d", n)
+	}
+
+	// 没有租户列的表不受影响
+	if err := db.WithContext(bg).Create(&tenantShared{ID: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 新进程中没有模型解析过的表同样限定，无法判断的表报错
+	fresh := openTenant(t, "file:tenant_column?mode=memory&cache=shared", &TenantOptions{Mode: TenantColumn})
+	rows = nil
+	fresh.WithContext(ztenant.With(bg, "b")).Table("tenant_item").Find(&rows)
+	if len(rows) != 1 {
+		t.Fatalf("fresh table query: %d", len(rows))
+	}
+	if err := fresh.WithContext(bg).Table("tenant_item").Find(&rows).Error; !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("fresh unscoped table query: %v", err)
+	}
+	if err := fresh.WithContext(bg).Table("tenant_shared").Find(&rows).Error; err != nil {
+		t.Fatalf("table without tenant column: %v", err)
+	}
+	for _, table := range []string{"missing", "tenant_item AS i"} {
+		if err := fresh.WithContext(bg).Table(table).Find(&rows).Error; !errors.Is(err, ErrTenantRequired) {
+			t.Fatalf("unknown table %q: %v", table, err)
+		}
+	}
+}
+
+func TestTenantSchema(t *testing.T) {
+	db := openTenant(t, "file:tenant_schema?mode=memory&cache=shared", &TenantOptions{Mode: TenantSchema, Shared: []string{"tenant_shared"}})
+	bg := context.Background()
+	for _, id := range []string{"a", "b"} {
+		db.Exec("ATTACH DATABASE 'file:tenant_schema_" + id + "?mode=memory&cache=shared' AS tenant_" + id)
+		if err := db.WithContext(ztenant.With(bg, id)).AutoMigrate(&tenantShared{}); err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("CREATE TABLE tenant_" + id + ".tenant_item (id INTEGER PRIMARY KEY, tenant_id TEXT, name TEXT)")
+	}
+	a, b := db.WithContext(ztenant.With(bg, "a")), db.WithContext(ztenant.With(bg, "b"))
+	if err := a.Create(&tenantItem{ID: 1, Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var items []tenantItem
+	if b.Find(&items); len(items) != 0 {
+		t.Fatalf("schema leak: %+v", items)
+	}
+	if a.Find(&items); len(items) != 1 {
+		t.Fatalf("schema query: %+v", items)
+	}
+	var rows []map[string]any
+	if b.Table("tenant_item").Find(&rows); len(rows) != 0 {
+		t.Fatalf("table query: %+v", rows)
+	}
+	if err := db.WithContext(bg).Find(&items).Error; !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("unscoped query: %v", err)
+	}
+	if err := db.WithContext(bg).Find(&[]tenantShared{}).Error; err != nil {
+		t.Fatalf("shared table: %v", err)
+	}
+}
+
+func TestTenantDatabase(t *testing.T) {
+	old := o
+	t.Cleanup(func() { o = old })
+	o = &Options{DB: "main", Tenant: &TenantOptions{Mode: TenantDatabase, Name: "app_%s"}}
+	if err := o.Tenant.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bg := context.Background()
+	for ctx, want := range map[context.Context]string{
+		bg:                                    "main",
+		ztenant.With(bg, "a"):                 "app_a",
+		ztenant.Bypass(ztenant.With(bg, "a")): "main",
+		ztenant.With(bg, "a;drop"):            "main",
+	} {
+		if got := database(ctx); got != want {
+			t.Fatalf("database: want %s, got %s", want, got)
+		}
+	}
+	if err := (&TenantOptions{Mode: "unknown"}).Validate(); err == nil {
+		t.Fatal("unknown mode should fail")
+	}
+}
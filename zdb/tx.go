@@ -0,0 +1,163 @@
+package zdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+	"gorm.io/gorm"
+)
+
+/**
+ * 事务
+ *  - Tx把事务放进ctx，fn内NewDB(ctx)自动使用该事务，仓储函数无需传*gorm.DB
+ *  - 同一个库的嵌套Tx使用保存点，内层失败只回滚到保存点；保存点无法改变隔离级别和只读，与外层不一致时返回ErrTxMismatch
+ *  - 序列化失败(40001)、死锁(40P01)时整体重试最外层事务，fn需可重复执行
+ *  - AfterCommit注册的函数在最外层事务提交后执行，回滚或重试时丢弃
+ */
+
+const txKey = "__ZDB_TX__"
+
+var ErrTxMismatch = errors.New("nested tx isolation or read only differs from outer tx")
+
+var retryableStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+}
+
+type TxOptions struct {
+	Database  string             `yaml:"database" note:"逻辑库名，默认同NewDB(ctx)"`
+	Retries   int                `yaml:"retries" note:"序列化失败或死锁时的重试次数，默认3，<0不重试"`
+	Isolation sql.IsolationLevel `yaml:"isolation" note:"隔离级别，默认数据库默认值"`
+	ReadOnly  bool               `yaml:"read_only" note:"只读事务"`
+}
+
+func (t *TxOptions) Validate(ctx context.Context) {
+	if t.Database == "" && o != nil {
+		t.Database = database(ctx)
+	}
+	t.Retries = zutil.FirstTruth(t.Retries, 3)
+}
+
+type txState struct {
+	parent    *txState
+	database  string
+	isolation sql.IsolationLevel
+	readOnly  bool
+	db        *gorm.DB
+	hooks     []func(ctx context.Context)
+}
+
+// Tx
+// @Description: 在事务中执行fn，已在同库事务中时使用保存点
+// @param ctx
+// @param fn 通过参数中的ctx调用NewDB即使用该事务
+// @param opts
+// @return error
+func Tx(ctx context.Context, fn func(ctx context.Context) error, opts ...*TxOptions) error {
+	opt := &TxOptions{}
+	if len(opts) > 0 && opts[0] != nil {
+		*opt = *opts[0]
+	}
+	opt.Validate(ctx)
+	current, _ := ctx.Value(txKey).(*txState)
+	if parent := txFrom(ctx, opt.Database); parent != nil {
+		if (opt.Isolation != sql.LevelDefault && opt.Isolation != parent.isolation) || (opt.ReadOnly && !parent.readOnly) {
+			return fmt.Errorf("%w: %s", ErrTxMismatch, opt.Database)
+		}
+		return parent.db.Transaction(func(tx *gorm.DB) error {
+			st := &txState{parent: current, database: opt.Database, isolation: parent.isolation, readOnly: parent.readOnly, db: tx}
+			if err := fn(context.WithValue(ctx, txKey, st)); err != nil {
+				return err
+			}
+			// 钩子随同库的外层事务提交，中间可能隔着其他库的事务
+			parent.hooks = append(parent.hooks, st.hooks...)
+			return nil
+		})
+	}
+	for attempt := 0; ; attempt++ {
+		st := &txState{parent: current, database: opt.Database, isolation: opt.Isolation, readOnly: opt.ReadOnly}
+		err := NewDB(ctx, opt.Database).Transaction(func(tx *gorm.DB) error {
+			st.db = tx
+			if err := searchPath(ctx, tx); err != nil {
+				return err
+			}
+			return fn(context.WithValue(ctx, txKey, st))
+		}, &sql.TxOptions{Isolation: opt.Isolation, ReadOnly: opt.ReadOnly})
+		if err == nil {
+			for _, hook := range st.hooks {
+				runHook(ctx, hook)
+			}
+			return nil
+		}
+		if attempt >= opt.Retries || !Retryable(err) {
+			return err
+		}
+		wait := time.Duration(attempt+1) * time.Duration(10+rand.IntN(40)) * time.Millisecond
+		zlog.Warnf("db tx retry %d after %s: %v", attempt+1, wait, err)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// AfterCommit
+// @Description: 最外层事务提交后执行fn，不在事务中时立即执行，适合缓存失效、发布事件
+// @param ctx
+// @param fn
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	if st, ok := ctx.Value(txKey).(*txState); ok {
+		st.hooks = append(st.hooks, fn)
+		return
+	}
+	runHook(ctx, fn)
+}
+
+// InTx
+// @Description: ctx是否在事务中
+// @param ctx
+// @return bool
+func InTx(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey).(*txState)
+	return ok
+}
+
+// Retryable
+// @Description: 是否为可重试的序列化失败或死锁
+// @param err
+// @return bool
+func Retryable(err error) bool {
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && retryableStates[state.SQLState()]
+}
+
+// txFrom
+// @Description: ctx中指定库的事务，沿parent查找，外层可能是其他库的事务
+// @param ctx
+// @param database
+// @return *txState
+func txFrom(ctx context.Context, database string) *txState {
+	st, _ := ctx.Value(txKey).(*txState)
+	for ; st != nil; st = st.parent {
+		if st.database == database {
+			return st
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, fn func(ctx context.Context)) {
+	defer func() {
+		if r := recover(); r != nil {
+			zlog.Errorf("db after commit hook panic: %v", r)
+		}
+	}()
+	fn(ctx)
+}
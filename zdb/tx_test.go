@@ -0,0 +1,180 @@
+package zdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type txItem struct {
+	ID   int64
+	Name string
+}
+
+type sqlStateErr string
+
+func (e sqlStateErr) Error() string    { return "sqlstate " + string(e) }
+func (e sqlStateErr) SQLState() string { return string(e) }
+
+func TestTx(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:tx?mode=memory&cache=shared"), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := db.DB()
+	d.SetMaxOpenConns(1)
+	defer d.Close()
+	if err = db.AutoMigrate(&txItem{}); err != nil {
+		t.Fatal(err)
+	}
+	old := o
+	o = &Options{DB: "tx"}
+	p.Set("tx", db)
+	t.Cleanup(func() {
+		o = old
+		p.Remove("tx")
+	})
+	ctx := context.Background()
+	count := func() int64 {
+		var n int64
+		NewDB(ctx).Model(&txItem{}).Count(&n)
+		return n
+	}
+	create := func(ctx context.Context, id int64) error {
+		return NewDB(ctx).Create(&txItem{ID: id, Name: fmt.Sprint(id)}).Error
+	}
+
+	// 内层失败只回滚保存点，钩子在提交后执行
+	var hooks []string
+	err = Tx(ctx, func(ctx context.Context) error {
+		if !InTx(ctx) {
+			t.Fatal("not in tx")
+		}
+		AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "outer") })
+		if err := create(ctx, 1); err != nil {
+			return err
+		}
+		inner := Tx(ctx, func(ctx context.Context) error {
+			AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "discarded") })
+			_ = create(ctx, 2)
+			return errors.New("inner")
+		})
+		if inner == nil {
+			t.Fatal("inner error lost")
+		}
+		if err := Tx(ctx, func(ctx context.Context) error {
+			AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "nested") })
+			return create(ctx, 3)
+		}); err != nil {
+			return err
+		}
+		if len(hooks) > 0 {
+			t.Fatal("hook before commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("savepoint: %d rows", n)
+	}
+	if fmt.Sprint(hooks) != "[outer nested]" {
+		t.Fatalf("hooks: %v", hooks)
+	}
+
+	// 回滚丢弃钩子
+	hooks = nil
+	_ = Tx(ctx, func(ctx context.Context) error {
+		AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "rollback") })
+		_ = create(ctx, 4)
+		return errors.New("rollback")
+	})
+	if count() != 2 || len(hooks) != 0 {
+		t.Fatalf("rollback: %d rows, hooks %v", count(), hooks)
+	}
+
+	// 序列化失败重试，非重试错误直接返回
+	attempts := 0
+	err = Tx(ctx, func(ctx context.Context) error {
+		attempts++
+		AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "retry") })
+		if attempts < 3 {
+			return fmt.Errorf("wrapped: %w", sqlStateErr("40001"))
+		}
+		return create(ctx, 5)
+	})
+	if err != nil || attempts != 3 || count() != 3 || len(hooks) != 1 {
+		t.Fatalf("retry: %v attempts %d hooks %v", err, attempts, hooks)
+	}
+	attempts = 0
+	err = Tx(ctx, func(ctx context.Context) error {
+		attempts++
+		return sqlStateErr("23505")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("non retryable: %v attempts %d", err, attempts)
+	}
+	attempts = 0
+	_ = Tx(ctx, func(ctx context.Context) error {
+		attempts++
+		return sqlStateErr("40P01")
+	}, &TxOptions{Retries: -1})
+	if attempts != 1 {
+		t.Fatalf("retries disabled: attempts %d", attempts)
+	}
+
+	// 隔着其他库的事务嵌套时，钩子随同库的外层事务，外层回滚时丢弃
+	other, err := gorm.Open(sqlite.Open("file:tx2?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	od, _ := other.DB()
+	defer od.Close()
+	p.Set("tx2", other)
+	t.Cleanup(func() { p.Remove("tx2") })
+	hooks = nil
+	_ = Tx(ctx, func(ctx context.Context) error {
+		err := Tx(ctx, func(ctx context.Context) error {
+			return Tx(ctx, func(ctx context.Context) error {
+				AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "cross") })
+				return create(ctx, 6)
+			})
+		}, &TxOptions{Database: "tx2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hooks) > 0 {
+			t.Fatal("hook ran with other database commit")
+		}
+		return errors.New("rollback")
+	})
+	if count() != 3 || len(hooks) != 0 {
+		t.Fatalf("cross database: %d rows, hooks %v", count(), hooks)
+	}
+
+	// 保存点无法改变隔离级别和只读
+	_ = Tx(ctx, func(ctx context.Context) error {
+		for _, opt := range []*TxOptions{{Isolation: sql.LevelSerializable}, {ReadOnly: true}} {
+			if err := Tx(ctx, func(ctx context.Context) error { return nil }, opt); !errors.Is(err, ErrTxMismatch) {
+				t.Fatalf("nested %+v: %v", opt, err)
+			}
+		}
+		return Tx(ctx, func(ctx context.Context) error { return nil })
+	})
+
+	// 不在事务中立即执行
+	ran := false
+	AfterCommit(ctx, func(context.Context) { ran = true })
+	if !ran || InTx(ctx) {
+		t.Fatal("after commit outside tx")
+	}
+}
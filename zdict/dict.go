@@ -0,0 +1,157 @@
+package zdict
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/z18n"
+	"github.com/zohu/zgin/zch"
+	"golang.org/x/text/language"
+)
+
+/**
+ * zch字典面向前端的封装：按请求语言翻译名称、转为zgin.Option、提供带ETag的路由
+ * 名称翻译的消息ID为 z18n:dict:{name}:{code}，未配置翻译时使用字典中的名称
+ */
+
+// Label
+// @Description: 字典条目在指定语言下的名称
+// @param lang
+// @param name
+// @param item
+// @return string
+func Label(lang language.Tag, name zch.DictName, item zch.DictItem) string {
+	id := zch.PrefixI18n.Key("dict", name.String(), item.Code)
+	if v := z18n.LocalizeWithTag(lang, id); v != "" && v != id {
+		return v
+	}
+	return item.Label
+}
+
+// All
+// @Description: 平铺的字典选项
+// @param c
+// @param name
+// @return []zgin.Option[string, E] Extra按json解析为E
+// @return error
+func All[E any](c *gin.Context, name zch.DictName) ([]zgin.Option[string, E], error) {
+	set, err := zch.DictAll(c.Request.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	lang := z18n.Language(c)
+	res := make([]zgin.Option[string, E], 0, len(set.Items))
+	for _, item := range set.Items {
+		res = append(res, option[E](lang, name, item))
+	}
+	return res, nil
+}
+
+// Tree
+// @Description: 树形的字典选项，按DictItem.Parent组织
+// @param c
+// @param name
+// @return []zgin.Option[string, E]
+// @return error
+func Tree[E any](c *gin.Context, name zch.DictName) ([]zgin.Option[string, E], error) {
+	set, err := zch.DictAll(c.Request.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	return tree[E](z18n.Language(c), set, ""), nil
+}
+
+func tree[E any](lang language.Tag, set *zch.DictSet, parent string) []zgin.Option[string, E] {
+	children := set.Children(parent)
+	if len(children) == 0 {
+		return nil
+	}
+	res := make([]zgin.Option[string, E], 0, len(children))
+	for _, item := range children {
+		o := option[E](lang, set.Name, item)
+		o.Children = tree[E](lang, set, item.Code)
+		res = append(res, o)
+	}
+	return res
+}
+
+func option[E any](lang language.Tag, name zch.DictName, item zch.DictItem) zgin.Option[string, E] {
+	o := zgin.Option[string, E]{
+		Label: Label(lang, name, item),
+		Value: item.Code,
+	}
+	if item.Extra != "" {
+		_ = sonic.UnmarshalString(item.Extra, &o.Extra)
+	}
+	return o
+}
+
+// Reverse
+// @Description: 按请求语言下的名称反查编码，找不到时再按字典中的名称反查
+// @param c
+// @param name
+// @param label
+// @return string
+// @return error
+func Reverse(c *gin.Context, name zch.DictName, label string) (string, error) {
+	return reverse(c.Request.Context(), z18n.Language(c), name, label)
+}
+
+func reverse(ctx context.Context, lang language.Tag, name zch.DictName, label string) (string, error) {
+	set, err := zch.DictAll(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range set.Items {
+		if Label(lang, name, item) == label {
+			return item.Code, nil
+		}
+	}
+	if code, ok := set.Code(label); ok {
+		return code, nil
+	}
+	return "", fmt.Errorf("not found: %s", label)
+}
+
+// Route
+// @Description: GET dict/:name 返回字典选项，?tree=true时返回树；按版本和语言生成ETag
+// @param g
+// @param names 允许访问的字典，为空时允许所有NewDictLoader注册的字典
+func Route(g *gin.RouterGroup, names ...zch.DictName) {
+	g.GET("dict/:name", func(c *gin.Context) {
+		name := zch.DictName(c.Param("name"))
+		if len(names) > 0 && !slices.Contains(names, name) {
+			zgin.AbortHttpCode(c, http.StatusNotFound, zgin.MessagePathInvalid.Resp(c))
+			return
+		}
+		set, err := zch.DictAll(c.Request.Context(), name)
+		if err != nil {
+			zgin.AbortHttpCode(c, http.StatusNotFound, zgin.MessagePathInvalid.Resp(c))
+			return
+		}
+		lang := z18n.Language(c)
+		isTree := c.Query("tree") == "true"
+		etag := fmt.Sprintf(`"%s-%s-%t"`, set.Version, lang, isTree)
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", "no-cache")
+		if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, etag) {
+			c.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		var options []zgin.Option[string, any]
+		if isTree {
+			options = tree[any](lang, set, "")
+		} else {
+			for _, item := range set.Items {
+				options = append(options, option[any](lang, name, item))
+			}
+		}
+		c.JSON(http.StatusOK, zgin.NewRespWithData(c, options))
+	})
+}
@@ -0,0 +1,83 @@
+package zdict
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/z18n"
+	"github.com/zohu/zgin/zch"
+	"golang.org/x/text/language"
+)
+
+func TestRoute(t *testing.T) {
+	s := miniredis.RunT(t)
+	zch.NewL2(&zch.Options{Addrs: []string{s.Addr()}, Invalidation: zch.InvalidationNone})
+	zch.NewDictLoader("gender", func(ctx context.Context) ([]zch.DictItem, error) {
+		return []zch.DictItem{
+			{Code: "1", Label: "男", Extra: `{"color":"blue"}`},
+			{Code: "2", Label: "女", Extra: `{"color":"red"}`},
+			{Code: "9", Label: "其他", Parent: "2"},
+		}, nil
+	})
+	z18n.AddLocalizer(func(ctx context.Context, lang language.Tag, id string) string {
+		if lang == language.English && id == zch.PrefixI18n.Key("dict", "gender", "1") {
+			return "Male"
+		}
+		return ""
+	})
+	defer z18n.AddLocalizer(nil)
+
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	Route(e.Group(""), "gender")
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		e.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/dict/gender?tree=true", "Accept-Language", "en")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var resp struct {
+		Data []zgin.Option[string, map[string]string] `json:"data"`
+	}
+	if err := sonic.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 2 || resp.Data[0].Label != "Male" || resp.Data[1].Label != "女" ||
+		resp.Data[0].Extra["color"] != "blue" || len(resp.Data[1].Children) != 1 {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("etag missing")
+	}
+	if w = get("/dict/gender?tree=true", "Accept-Language", "en", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("want 304, got %d", w.Code)
+	}
+	if w = get("/dict/gender", "Accept-Language", "zh", "If-None-Match", etag); w.Code != http.StatusOK {
+		t.Fatalf("etag must vary by language and shape, got %d", w.Code)
+	}
+	if w = get("/dict/other"); w.Code != http.StatusNotFound {
+		t.Fatalf("want 404, got %d", w.Code)
+	}
+
+	if code, err := reverse(context.Background(), language.English, "gender", "Male"); err != nil || code != "1" {
+		t.Fatalf("reverse: %v %s", err, code)
+	}
+	if code, err := reverse(context.Background(), language.English, "gender", "女"); err != nil || code != "2" {
+		t.Fatalf("reverse fallback: %v %s", err, code)
+	}
+}
@@ -11,11 +11,15 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/dromara/carbon/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/h2non/filetype"
 	"github.com/zohu/zgin/zdb"
+	"github.com/zohu/zgin/ztenant"
 	"github.com/zohu/zgin/zutil"
 	"github.com/zohu/zid"
 	"github.com/zohu/zlog"
@@ -24,7 +28,12 @@ import (
 
 var opts *Options
 var svr iService
-var useDatabase bool
+
+// 是否记录到数据库，表未创建时每分钟重新检查一次，New之后才执行迁移也能启用
+var (
+	useDatabase atomic.Bool
+	checkedAt   atomic.Int64
+)
 
 func New(options *Options) {
 	if err := validator.New().Struct(options); err != nil {
@@ -33,9 +42,9 @@ func New(options *Options) {
 	}
 	opts = options
 
-	// 如果可能，同步数据库表
-	if err := zdb.NewDB(context.TODO()).AutoMigrate(&ZfileRecord{}); err == nil {
-		useDatabase = true
+	// 表通过zdb迁移创建(见Migration)，存在时才记录到数据库
+	if !recording() {
+		zlog.Warnf("table of ZfileRecord not found, file records disabled until zfile.Migration is applied")
 	}
 	switch opts.Provider {
 	case ProviderTypeOss:
@@ -90,15 +99,16 @@ func Upload(ctx context.Context, h *ReqUpload, rs io.ReadSeeker) (*RespUpload, e
 		_, _ = rs.Seek(0, io.SeekStart)
 	}
 
-	name := opts.FullName(h.Path, h.Fid, ext)
-	if useDatabase {
-		// 检查文件是否已存在
+	name := opts.TenantName(ctx, h.Path, h.Fid, ext)
+	tenant, _ := ztenant.From(ctx)
+	if recording() {
+		// 检查文件是否已存在，按租户去重
 		var exist ZfileRecord
-		zdb.NewDB(ctx).Where("md5=?", md5).First(&exist)
+		zdb.NewDB(ctx).Where("tenant_id=? AND md5=?", tenant, md5).First(&exist)
 		if exist.Fid != "" {
 			return &RespUpload{
 				Fid:  exist.Fid,
-				Name: opts.FullName(h.Path, exist.Fid, ext),
+				Name: opts.TenantName(ctx, h.Path, exist.Fid, ext),
 				Url:  opts.HTTPDomain(exist.Name),
 				Md5:  md5,
 			}, nil
@@ -108,13 +118,14 @@ func Upload(ctx context.Context, h *ReqUpload, rs io.ReadSeeker) (*RespUpload, e
 	if err := svr.upload(ctx, rs, name, h.Progress); err != nil {
 		return nil, err
 	}
-	if useDatabase {
+	if recording() {
 		zdb.NewDB(ctx).Create(&ZfileRecord{
-			Fid:    h.Fid,
-			Md5:    md5,
-			Bucket: opts.Bucket,
-			Name:   name,
-			Expire: zutil.FirstTruth(h.IdleDays, opts.IdleDays),
+			Fid:      h.Fid,
+			TenantID: tenant,
+			Md5:      md5,
+			Bucket:   opts.Bucket,
+			Name:     name,
+			Expire:   zutil.FirstTruth(h.IdleDays, opts.IdleDays),
 		})
 	}
 	return &RespUpload{
@@ -136,6 +147,38 @@ func Delete(ctx context.Context, id, uri string) {
 	zdb.NewDB(ctx).Where("fid = ?", id).Delete(&ZfileRecord{})
 }
 
+// CleanExpired
+// @Description: 删除超过保存天数未使用的文件，可注册为定时任务：zcron.S().Cron("zfile:expire", "@daily", zfile.CleanExpired)
+// @param ctx 没有租户时清理所有租户
+// @return error
+func CleanExpired(ctx context.Context) error {
+	if !recording() {
+		return nil
+	}
+	ctx = allTenants(ctx)
+	var count int
+	var records []ZfileRecord
+	err := zdb.NewDB(ctx).Where("expire > 0").FindInBatches(&records, 500, func(tx *gorm.DB, batch int) error {
+		for _, record := range records {
+			last := zutil.FirstTruth(record.UpdatedAt, record.CreatedAt)
+			if last == nil || last.AddDays(int(record.Expire)).Gt(carbon.Now()) {
+				continue
+			}
+			if err := svr.delete(ctx, record.Name); err != nil {
+				zlog.Warnf("delete expired file %s err: %v", record.Name, err)
+				continue
+			}
+			zdb.NewDB(ctx).Where("fid = ?", record.Fid).Delete(&ZfileRecord{})
+			count++
+		}
+		return nil
+	}).Error
+	if count > 0 {
+		zlog.Infof("clean expired files: %d", count)
+	}
+	return err
+}
+
 func UploadTransfer(ctx context.Context, h *ReqUpload, uri string) (*RespUpload, error) {
 	tmpFile, err := os.CreateTemp("", "zfile-url-*.tmp")
 	if err != nil {
@@ -168,12 +211,14 @@ func RouteForward(g *gin.RouterGroup) {
 		arr := strings.Split(fids, "/")
 		fid := strings.Split(arr[len(arr)-1], ".")[0]
 		var ext ZfileRecord
-		err := zdb.NewDB(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		// fid全局唯一，公开访问时可能没有租户
+		ctx := allTenants(c.Request.Context())
+		err := zdb.NewDB(ctx).Transaction(func(tx *gorm.DB) error {
 			if err := tx.Where("fid=?", fid).First(&ext).Error; err != nil {
 				return err
 			}
 			ext.Pv += 1
-			zdb.NewDB(c.Request.Context()).Updates(&ext)
+			zdb.NewDB(ctx).Updates(&ext)
 			return nil
 		})
 		if err != nil {
@@ -183,3 +228,30 @@ func RouteForward(g *gin.RouterGroup) {
 		c.Redirect(http.StatusFound, opts.HTTPDomain(ext.Name))
 	})
 }
+
+// recording
+// @Description: 文件记录表是否存在，存在后不再检查
+// @return bool
+func recording() bool {
+	if useDatabase.Load() {
+		return true
+	}
+	now, last := time.Now().UnixNano(), checkedAt.Load()
+	if (last > 0 && now-last < int64(time.Minute)) || !checkedAt.CompareAndSwap(last, now) {
+		return false
+	}
+	ok := zdb.NewDB(context.TODO()).Migrator().HasTable(&ZfileRecord{})
+	useDatabase.Store(ok)
+	return ok
+}
+
+// allTenants
+// @Description: ctx中没有租户时按跨租户访问文件记录
+// @param ctx
+// @return context.Context
+func allTenants(ctx context.Context) context.Context {
+	if _, ok := ztenant.From(ctx); ok {
+		return ctx
+	}
+	return ztenant.Bypass(ctx)
+}
@@ -8,7 +8,10 @@ import (
 
 	"github.com/dromara/carbon/v2"
 	"github.com/go-playground/validator/v10"
+	"github.com/zohu/zgin/zdb"
+	"github.com/zohu/zgin/ztenant"
 	"github.com/zohu/zgin/zutil"
+	"gorm.io/gorm"
 )
 
 type ProviderType string
@@ -29,6 +32,7 @@ type Options struct {
 	Prefix       string       `json:"prefix" yaml:"prefix" note:"存储桶前缀"`
 	IdleDays     int64        `json:"idle_days" yaml:"idle_days"  gorm:"comment:最长闲置时间，不设置则永久"`
 	MaxRetry     int          `json:"max_retry" yaml:"max_retry" note:"最大重试次数"`
+	TenantPath   string       `json:"tenant_path" yaml:"tenant_path" note:"ctx中有租户时的存储目录，%s为租户ID，默认tenant/%s"`
 }
 
 func (c *Options) Validate() error {
@@ -39,11 +43,25 @@ func (c *Options) Validate() error {
 	c.Prefix = strings.TrimPrefix(c.Prefix, "/")
 	c.Prefix = strings.TrimSuffix(c.Prefix, "/")
 	c.MaxRetry = zutil.FirstTruth(c.MaxRetry, 3)
+	c.TenantPath = strings.Trim(zutil.FirstTruth(c.TenantPath, "tenant/%s"), "/")
 	return validator.New().Struct(c)
 }
 func (c *Options) HTTPDomain(filename string) string {
 	return fmt.Sprintf("%s/%s", c.Domain, strings.TrimPrefix(filename, "/"))
 }
+
+// TenantName
+// @Description: 存储路径，ctx中有租户时在租户目录下
+// @receiver c
+// @param ctx
+// @param args
+// @return string
+func (c *Options) TenantName(ctx context.Context, args ...string) string {
+	if id, ok := ztenant.From(ctx); ok {
+		args = append([]string{fmt.Sprintf(c.TenantPath, id)}, args...)
+	}
+	return c.FullName(args...)
+}
 func (c *Options) FullName(args ...string) string {
 	path := c.Prefix
 	for _, arg := range args {
@@ -63,7 +81,8 @@ func (c *Options) FullName(args ...string) string {
 type ZfileRecord struct {
 	Id        uint64         `json:"id" gorm:"->;primarykey"`
 	Fid       string         `json:"fid" gorm:"unique;comment:文件ID"`
-	Md5       string         `json:"md5" gorm:"unique;comment:文件MD5"`
+	TenantID  string         `json:"tenant_id" gorm:"uniqueIndex:idx_zfile_record_md5;comment:租户"`
+	Md5       string         `json:"md5" gorm:"uniqueIndex:idx_zfile_record_md5;comment:文件MD5，同租户内去重"`
 	Bucket    string         `json:"bucket" gorm:"comment:存储桶"`
 	Name      string         `json:"name" gorm:"comment:桶内名称"`
 	Pv        int64          `json:"pv" gorm:"comment:访问次数"`
@@ -71,6 +90,24 @@ type ZfileRecord struct {
 	CreatedAt *carbon.Carbon `json:"created_at,omitempty" gorm:"autoCreateTime"`
 	UpdatedAt *carbon.Carbon `json:"updated_at,omitempty" gorm:"autoUpdateTime"`
 }
+
+// Migration
+// @Description: 创建ZfileRecord表的迁移，注册到zdb.Migrator后启用文件记录；表已存在(如旧版本自动建表)时补齐缺少的列和索引
+// @param version 在业务迁移中的版本号
+// @return *zdb.Migration
+func Migration(version int64) *zdb.Migration {
+	return &zdb.Migration{
+		Version: version,
+		Name:    "zfile_record",
+		UpFn: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ZfileRecord{})
+		},
+		DownFn: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&ZfileRecord{})
+		},
+	}
+}
+
 type Progress func(increment, transferred, total int64)
 
 // iService
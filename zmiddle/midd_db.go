@@ -0,0 +1,21 @@
+package zmiddle
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin/zdb"
+	"github.com/zohu/zlog"
+)
+
+// NewDBSession
+// @Description: 每个请求开启zdb读写分离会话，请求内写入后的读走主库
+// @return gin.HandlerFunc
+func NewDBSession() gin.HandlerFunc {
+	zlog.Infof("middleware db session enabled")
+	return func(c *gin.Context) {
+		ctx := zdb.WithSession(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		// 直接以gin.Context作为ctx时也能取到会话
+		c.Set(zdb.SessionKey, ctx.Value(zdb.SessionKey))
+		c.Next()
+	}
+}
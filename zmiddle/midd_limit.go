@@ -3,12 +3,16 @@ package zmiddle
 import (
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/didip/tollbooth/v8"
 	"github.com/didip/tollbooth/v8/limiter"
 	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/zch"
 	"github.com/zohu/zgin/zutil"
 	"github.com/zohu/zlog"
 )
@@ -48,6 +52,43 @@ func NewLimit(options *LimitOptions) gin.HandlerFunc {
 	}
 }
 
+// NewRateLimit
+// @Description: 基于zch.Limiter的集群限流，可挂在单个路由或路由组上；redis出错时放行
+// @param l
+// @param key 限流维度，如用户ID，返回空时不限流，默认客户端IP
+// @return gin.HandlerFunc
+func NewRateLimit(l *zch.Limiter, key ...func(c *gin.Context) string) gin.HandlerFunc {
+	keyFn := append(key, func(c *gin.Context) string { return c.ClientIP() })[0]
+	return func(c *gin.Context) {
+		k := keyFn(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+		res, err := l.Allow(c.Request.Context(), k)
+		if err != nil {
+			zlog.Warnf("rate limit %s err: %v", k, err)
+			c.Next()
+			return
+		}
+		c.Header("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(seconds(res.ResetAfter), 10))
+		if !res.Allowed {
+			if res.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.FormatInt(seconds(res.RetryAfter), 10))
+			}
+			zgin.AbortHttpCode(c, http.StatusTooManyRequests, zgin.MessageTooManyRequests.Resp(c))
+			return
+		}
+		c.Next()
+	}
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
 type limitBody struct {
 	ctx       *gin.Context
 	r         io.ReadCloser
@@ -35,7 +35,7 @@ var mLogger = zlog.NewZLogger(&zlog.Options{
 })
 
 /**
- * format: method status ip latency request_id path userid-username query body >>> data error
+ * format: method status ip latency request_id path userid-username by:actor query body >>> data error
  */
 
 type LoggerItem struct {
@@ -49,6 +49,7 @@ type LoggerItem struct {
 	OS        string `json:"os"`
 	Userid    string `json:"userid"`
 	Username  string `json:"username"`
+	Actor     string `json:"actor"`
 	Query     string `json:"query"`
 	Body      string `json:"body"`
 	Data      string `json:"data"`
@@ -67,6 +68,7 @@ func (l *LoggerItem) Print() {
 	_, _ = buf.WriteStringIf(l.RequestId != "", fmt.Sprintf("%s ", l.RequestId))
 	_, _ = buf.WriteStringIf(l.Path != "", fmt.Sprintf("%s ", l.Path))
 	_, _ = buf.WriteStringIf(l.Userid != "", fmt.Sprintf("%s-%s ", l.Userid, l.Username))
+	_, _ = buf.WriteStringIf(l.Actor != "", fmt.Sprintf("by:%s ", l.Actor))
 	_, _ = buf.WriteStringIf(l.OS != "", fmt.Sprintf("%s ", l.OS))
 	_, _ = buf.WriteStringIf(l.Browser != "", fmt.Sprintf("%s ", l.Browser))
 	_, _ = buf.WriteStringIf(l.Query != "", fmt.Sprintf("%s ", l.Query))
@@ -110,10 +112,6 @@ func NewLogger(options *LoggerOptions) gin.HandlerFunc {
 			Query:     c.Request.URL.Query().Encode(),
 		}
 
-		if u, ok := zauth.Auth(c); ok {
-			item.Userid, item.Username = u.Userid(), u.UserName()
-		}
-
 		buf := zbuff.New()
 		defer buf.Free()
 		blw := &bodyWriter{body: buf, ResponseWriter: c.Writer}
@@ -143,6 +141,14 @@ func NewLogger(options *LoggerOptions) gin.HandlerFunc {
 
 		c.Next()
 
+		// 认证中间件可能在日志之后执行，c.Next后再取用户
+		if u, ok := zauth.Auth(c); ok {
+			item.Userid, item.Username = u.Userid(), u.UserName()
+		}
+		if a, ok := zauth.Actor(c); ok {
+			item.Actor = fmt.Sprintf("%s-%s", a.Userid, a.UserName)
+		}
+
 		// data
 		{
 			data := blw.body.Bytes()
@@ -0,0 +1,75 @@
+package zmiddle
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zohu/zgin"
+	"github.com/zohu/zgin/ztenant"
+	"github.com/zohu/zgin/zutil"
+	"github.com/zohu/zlog"
+)
+
+type TenantOptions struct {
+	Header string   `yaml:"header" note:"租户请求头，默认X-Tenant-ID，为-时不从请求头解析"`
+	Domain string   `yaml:"domain" note:"主域名，如example.com时a.example.com解析为租户a，为空时不从子域名解析"`
+	Ignore []string `yaml:"ignore" note:"不作为租户的子域名，默认www、api"`
+}
+
+func (o *TenantOptions) Validate() {
+	o.Header = zutil.FirstTruth(o.Header, "X-Tenant-ID")
+	o.Domain = strings.TrimPrefix(o.Domain, ".")
+	o.Ignore = zutil.FirstTruth(o.Ignore, []string{"www", "api"})
+}
+
+// NewTenant
+// @Description: 从请求头或子域名解析租户写入请求上下文，登录态中的租户由zauth中间件解析并校验一致，
+// 登录用户不属于任何租户时zauth拒绝请求头、子域名指定的租户，未登录的接口需自行校验租户是否可访问
+// @param options
+// @return gin.HandlerFunc
+func NewTenant(options *TenantOptions) gin.HandlerFunc {
+	zlog.Infof("middleware tenant enabled")
+	options = zutil.FirstTruth(options, &TenantOptions{})
+	options.Validate()
+	return func(c *gin.Context) {
+		id := options.resolve(c)
+		if id == "" {
+			c.Next()
+			return
+		}
+		if !ztenant.Valid(id) {
+			zgin.AbortHttpCode(c, http.StatusBadRequest, zgin.MessageTenantInvalid.Resp(c))
+			return
+		}
+		ztenant.Set(c, id)
+		c.Next()
+	}
+}
+
+// resolve
+// @Description: 请求头优先，其次子域名
+// @receiver o
+// @param c
+// @return string
+func (o *TenantOptions) resolve(c *gin.Context) string {
+	if o.Header != "-" {
+		if id := strings.TrimSpace(c.GetHeader(o.Header)); id != "" {
+			return id
+		}
+	}
+	if o.Domain == "" {
+		return ""
+	}
+	host := c.Request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(o.Domain))
+	if !ok || strings.Contains(sub, ".") || slices.Contains(o.Ignore, sub) {
+		return ""
+	}
+	return sub
+}
@@ -5,4 +5,5 @@ type Options struct {
 	Limit   *LimitOptions   `yaml:"limit"`
 	Logger  *LoggerOptions  `yaml:"logger"`
 	Timeout *TimeoutOptions `yaml:"timeout"`
+	Tenant  *TenantOptions  `yaml:"tenant"`
 }
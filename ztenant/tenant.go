@@ -0,0 +1,106 @@
+package ztenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**
+ * 租户上下文
+ *  - 由zmiddle.NewTenant(请求头、子域名)或zauth(登录用户)写入请求ctx，gin.Context中同时c.Set
+ *  - zdb按租户限定查询，zch按租户隔离key，zfile按租户隔离存储路径
+ *  - Bypass显式声明跨租户操作，如定时任务、后台管理、迁移
+ */
+
+// ContextKey 请求上下文中保存租户ID的key，gin.Context可直接c.Set(ContextKey, id)
+const ContextKey = "__TENANT__"
+
+const bypassKey = "__TENANT_BYPASS__"
+
+var (
+	ErrInvalid = errors.New("invalid tenant id")
+	// 租户ID会用作schema名、库名和存储路径，只允许字母数字下划线和中划线
+	valid = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,62}$`)
+)
+
+// Member
+// @Description: 属于某个租户的用户，zauth中的Userinfo实现该接口时从登录态解析租户
+type Member interface {
+	TenantID() string
+}
+
+// Valid
+// @Description: 租户ID是否合法
+// @param id
+// @return bool
+func Valid(id string) bool {
+	return valid.MatchString(id)
+}
+
+// With
+// @Description: ctx中写入租户
+// @param ctx
+// @param id
+// @return context.Context
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ContextKey, id)
+}
+
+// Set
+// @Description: 写入请求的租户，同时写入gin.Context和c.Request的ctx
+// @param c
+// @param id
+func Set(c *gin.Context, id string) {
+	c.Set(ContextKey, id)
+	c.Request = c.Request.WithContext(With(c.Request.Context(), id))
+}
+
+// From
+// @Description: ctx中的租户，不存在时为空
+// @param ctx
+// @return string
+// @return bool
+func From(ctx context.Context) (string, bool) {
+	id, _ := ctx.Value(ContextKey).(string)
+	return id, id != ""
+}
+
+// Bypass
+// @Description: 标记ctx为跨租户操作，zdb不再限定租户
+// @param ctx
+// @return context.Context
+func Bypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey, true)
+}
+
+// Bypassed
+// @Description: ctx是否为跨租户操作
+// @param ctx
+// @return bool
+func Bypassed(ctx context.Context) bool {
+	v, _ := ctx.Value(bypassKey).(bool)
+	return v
+}
+
+// Key
+// @Description: 带租户的key，格式 t:{id}:{key}，没有租户时原样返回
+// @param ctx
+// @param key
+// @return string
+func Key(ctx context.Context, key string) string {
+	if id, ok := From(ctx); ok {
+		return Prefix(id) + ":" + key
+	}
+	return key
+}
+
+// Prefix
+// @Description: 租户的key前缀
+// @param id
+// @return string
+func Prefix(id string) string {
+	return "t:" + id
+}
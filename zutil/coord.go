@@ -106,3 +106,19 @@ func transform(lon, lat float64) (x, y float64) {
 func isOutOFChina(lon, lat float64) bool {
 	return !(lon > 72.004 && lon < 135.05 && lat > 3.86 && lat < 53.55)
 }
+
+// Distance
+// @Description: 球面距离(haversine)，单位米，坐标需同一坐标系
+// @param lon1
+// @param lat1
+// @param lon2
+// @param lat2
+// @return float64
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	const radius = 6371000.0
+	rad := math.Pi / 180.0
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * radius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -12,6 +12,7 @@ type WebsocketHome[T any] interface {
 	Load(ID string) (WebsocketServer[T], bool)
 	LoadFunc(func(T) bool) (WebsocketServer[T], bool)
 	Remove(ID string)
+	RemoveFunc(func(ID string, data T) bool) int
 	Broadcast(func(ID string, data T) *Message)
 	OnlineSize() int
 }
@@ -55,6 +56,24 @@ func (h *Home[T]) Remove(ID string) {
 		h.serves.Remove(ID)
 	}
 }
+
+// RemoveFunc
+// @Description: 断开所有满足条件的连接，如用户登出时断开其全部长连接
+// @receiver h
+// @param fn
+// @return int 断开数量
+func (h *Home[T]) RemoveFunc(fn func(ID string, data T) bool) int {
+	var ids []string
+	h.serves.IterCb(func(ID string, s WebsocketServer[T]) {
+		if fn(ID, s.GetData()) {
+			ids = append(ids, ID)
+		}
+	})
+	for _, ID := range ids {
+		h.Remove(ID)
+	}
+	return len(ids)
+}
 func (h *Home[T]) Broadcast(fn func(ID string, data T) *Message) {
 	if h.serves.Count() == 0 {
 		return